@@ -0,0 +1,75 @@
+package analytics
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultPeriod = 30 * 24 * time.Hour
+
+var errFieldRequired = errors.New("field is required")
+
+// JournalAnalytics Статистика по полю журнала
+// @Summary Статистика по полю журнала
+// @Description Минимум, максимум, среднее и стандартное отклонение числового поля журнала по интервалам, доля непройденных вычисляемых проверок этого поля и количество корректирующих действий
+// @Tags Analytics
+// @Accept  json
+// @Produce  json
+// @Param scheme path string true "Имя схемы журнала"
+// @Param field query string true "Имя поля"
+// @Param item query string false "Позиции через запятую"
+// @Param from query string false "Начало периода (2006-01-02 или RFC3339), по умолчанию 30 дней назад"
+// @Param to query string false "Конец периода, по умолчанию текущее время"
+// @Param bucket query string false "Интервал: day, week, month" default(day)
+// @Success 200 {object} model.Analytics
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /analytics/journal/{scheme} [get]
+func JournalAnalytics(ctx *gin.Context) {
+	query := model.AnalyticsQuery{
+		Scheme: ctx.Param("scheme"),
+		Field:  ctx.Query("field"),
+		Bucket: ctx.DefaultQuery("bucket", model.BucketDay),
+	}
+
+	if len(query.Field) == 0 {
+		httputils.NewError(ctx, http.StatusBadRequest, errFieldRequired)
+		return
+	}
+
+	if items := ctx.Query("item"); len(items) != 0 {
+		query.Items = strings.Split(items, ",")
+	}
+
+	var err error
+	if query.To, err = httputils.ParseDate(ctx.Query("to"), time.Now()); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if query.From, err = httputils.ParseDate(ctx.Query("from"), query.To.Add(-defaultPeriod)); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	analytics, err := model.JournalAnalytics(httputils.Tenant(ctx), query)
+
+	if err == model.ErrBucketInvalid || err == model.ErrPeriodInvalid {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, analytics)
+}
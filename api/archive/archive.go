@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+// ListArchives Список архивов журналов
+// @Summary Список архивов
+// @Description Получение архивов журналов без списков журналов. Фильтры: scheme, month, status, created_at, verified_at
+// @Tags Archive
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /archive [get]
+func ListArchives(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	archives, err := model.JournalArchivesAll(httputils.Tenant(ctx), query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, archives)
+}
+
+// ShowArchive Один архив
+// @Summary Один архив
+// @Description Получение архива со списком журналов и их версий
+// @Tags Archive
+// @Accept  json
+// @Produce  json
+// @Param archive_id path string true "Archive id"
+// @Success 200 {object} model.JournalArchive
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /archive/file/{archive_id} [get]
+func ShowArchive(ctx *gin.Context) {
+	archive, err := model.JournalArchiveOne(httputils.Tenant(ctx), ctx.Param("archive_id"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, archive)
+}
+
+// VerifyArchive Проверка архива
+// @Summary Проверить архив
+// @Description Проверка файла архива: контрольная сумма и что в нем ровно журналы индекса с их версиями.
+// @Description Результат сохраняется в verified_at и error, error пустой - архив цел
+// @Tags Archive
+// @Accept  json
+// @Produce  json
+// @Param archive_id path string true "Archive id"
+// @Success 200 {object} model.JournalArchive
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /archive/file/{archive_id}/verify [post]
+func VerifyArchive(ctx *gin.Context) {
+	archive, err := model.VerifyArchive(httputils.Tenant(ctx), ctx.Param("archive_id"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, archive)
+}
+
+// RunArchive Архивирование журналов
+// @Summary Архивировать журналы
+// @Description Перенос в архив журналов старше срока архивирования их схемы (retention.archive_after_days,
+// @Description для схем без него - ARCHIVE_AFTER_DAYS). Сначала доводятся архивы, прерванные ранее
+// @Tags Archive
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} model.ArchiveRun
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /archive/run [post]
+func RunArchive(ctx *gin.Context) {
+	run, err := model.ArchiveJournals(httputils.Tenant(ctx), time.Now(), model.ArchiveAfterDays())
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, run)
+}
@@ -10,17 +10,73 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// SessionKey ключ, под которым сессия текущего пользователя хранится в gin.Context
+const SessionKey = "session"
+
+// RequireAuthorization пропускает запросы с действующим токеном пользователя с ролью requiredRole.
+// Администратору доступны все группы эндпоинтов, кроме управления площадками.
+// Запросы работают с данными площадки сессии
 func RequireAuthorization(srv *service.SessionService, requiredRole user.Role) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		token := ctx.GetHeader("X-Auth-Token")
-		if len(token) == 0 {
-			httputils.NewError(ctx, http.StatusUnauthorized, errors.New("X-Auth-Token header is required"))
-			ctx.Abort()
+		session, ok := requestSession(ctx, srv)
+		if !ok {
+			return
 		}
-		if srv.VerifyToken(token) {
-			httputils.NewError(ctx, http.StatusUnauthorized, errors.New("Token is expired or invalid"))
+		if !session.Role.Grants(requiredRole) {
+			httputils.NewError(ctx, http.StatusForbidden, errors.New("Role "+session.Role.String()+" has no access"))
 			ctx.Abort()
+			return
 		}
+		ctx.Set(SessionKey, session)
+		httputils.SetTenant(ctx, session.Tenant)
 		ctx.Next()
 	}
 }
+
+// RequireSession пропускает запросы с действующим токеном пользователя любой роли
+func RequireSession(srv *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session, ok := requestSession(ctx, srv)
+		if !ok {
+			return
+		}
+		ctx.Set(SessionKey, session)
+		httputils.SetTenant(ctx, session.Tenant)
+		ctx.Next()
+	}
+}
+
+// requestSession сессия по заголовку X-Auth-Token. Если сессии нет, отвечает 401 и прерывает запрос
+func requestSession(ctx *gin.Context, srv *service.SessionService) (*service.Session, bool) {
+	token := ctx.GetHeader("X-Auth-Token")
+	if len(token) == 0 {
+		httputils.NewError(ctx, http.StatusUnauthorized, errors.New("X-Auth-Token header is required"))
+		ctx.Abort()
+		return nil, false
+	}
+	session, ok := srv.Session(token)
+	if !ok {
+		httputils.NewError(ctx, http.StatusUnauthorized, errors.New("Token is expired or invalid"))
+		ctx.Abort()
+		return nil, false
+	}
+	return session, true
+}
+
+// CurrentSession возвращает сессию, сохраненную RequireAuthorization
+func CurrentSession(ctx *gin.Context) (*service.Session, bool) {
+	value, ok := ctx.Get(SessionKey)
+	if !ok {
+		return nil, false
+	}
+	session, ok := value.(*service.Session)
+	return session, ok
+}
+
+// CurrentUsername возвращает имя пользователя текущей сессии или пустую строку
+func CurrentUsername(ctx *gin.Context) string {
+	if session, ok := CurrentSession(ctx); ok {
+		return session.Username
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/Oxynger/JournalApp/model/user"
+	"github.com/Oxynger/JournalApp/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ConfirmationKey ключ, под которым подтверждение критического действия хранится в gin.Context
+const ConfirmationKey = "confirmation"
+
+// Errors godoc
+var (
+	ErrConfirmationRequired = errors.New("X-Confirmation-Token header is required")
+	ErrConfirmationInvalid  = errors.New("confirmation token is expired, used or issued for another session")
+	ErrConfirmationIdentity = errors.New("credentials do not belong to the current user")
+)
+
+// ConfirmationRequest повторный ввод учетных данных перед критическим действием
+type ConfirmationRequest struct {
+	Credentials user.Credentials `json:"credentials" binding:"required"`
+	// Meaning смысл действия: authored, reviewed, approved, responsible
+	Meaning string `json:"meaning" binding:"required" example:"approved"`
+}
+
+// ConfirmationToken одноразовый токен подтверждения
+type ConfirmationToken struct {
+	Token    string `json:"token"`
+	Meaning  string `json:"meaning" example:"approved"`
+	ExpireAt int64  `json:"expiresAt"`
+}
+
+// Confirm выдает токен подтверждения критического действия
+// @Summary Подтверждение критического действия
+// @Description Повторный ввод учетных данных текущего пользователя и смысла действия. Выданный токен одноразовый, действует 5 минут
+// @Description и только в текущей сессии. Его нужно передать в заголовке X-Confirmation-Token закрытия журнала или изменения схемы
+// @Accept json
+// @Produce json
+// @Param confirmation body auth.ConfirmationRequest true "confirmation json"
+// @Success 200 {object} auth.ConfirmationToken
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 401 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /login/confirm [post]
+func Confirm(users *service.UserService, sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request ConfirmationRequest
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		if err := model.CheckMeaning(request.Meaning); err != nil {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		session, ok := CurrentSession(ctx)
+		if !ok {
+			httputils.NewError(ctx, http.StatusUnauthorized, ErrConfirmationIdentity)
+			return
+		}
+		if request.Credentials.Username != session.Username {
+			httputils.NewError(ctx, http.StatusForbidden, ErrConfirmationIdentity)
+			return
+		}
+
+		// Контроллеры подтверждают паролем учетной записи площадки, пользователи - своим паролем
+		if session.Role == user.Operator {
+			if err := model.CheckOperatorPassword(session.Tenant, session.Username, request.Credentials.Password); err != nil {
+				operatorAuthError(ctx, err)
+				return
+			}
+		} else if _, err := users.Authenticate(request.Credentials); err != nil {
+			httputils.NewError(ctx, http.StatusUnauthorized, err)
+			return
+		}
+
+		confirmation, err := sessions.CreateConfirmation(session, request.Meaning)
+		if err != nil {
+			httputils.NewError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, ConfirmationToken{
+			Token:    confirmation.Token,
+			Meaning:  confirmation.Meaning,
+			ExpireAt: confirmation.ExpireAt,
+		})
+	}
+}
+
+// RequireConfirmation пропускает запросы с действующим токеном подтверждения X-Confirmation-Token текущей сессии
+// и погашает его. Успешно выполненное действие action записывается в журнал подтвержденных действий.
+// Ставится после RequireAuthorization
+func RequireConfirmation(srv *service.SessionService, action string) gin.HandlerFunc {
+	return confirmation(srv, action, true)
+}
+
+// AcceptConfirmation как RequireConfirmation, но пропускает и запросы без X-Confirmation-Token.
+// Для действий, которым подтверждение нужно не всегда: обработчик проверяет CurrentConfirmation сам
+func AcceptConfirmation(srv *service.SessionService, action string) gin.HandlerFunc {
+	return confirmation(srv, action, false)
+}
+
+func confirmation(srv *service.SessionService, action string, required bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.GetHeader("X-Confirmation-Token")
+		if len(token) == 0 && !required {
+			ctx.Next()
+			return
+		}
+		if len(token) == 0 {
+			httputils.NewError(ctx, http.StatusForbidden, ErrConfirmationRequired)
+			ctx.Abort()
+			return
+		}
+
+		session, ok := CurrentSession(ctx)
+		if !ok {
+			httputils.NewError(ctx, http.StatusUnauthorized, ErrConfirmationInvalid)
+			ctx.Abort()
+			return
+		}
+
+		used, ok := srv.UseConfirmation(token, session)
+		if !ok {
+			httputils.NewError(ctx, http.StatusForbidden, ErrConfirmationInvalid)
+			ctx.Abort()
+			return
+		}
+
+		confirmed := model.Confirmation{
+			Meaning: used.Meaning,
+			By:      used.Username,
+			At:      used.ConfirmedAt,
+		}
+		ctx.Set(ConfirmationKey, &confirmed)
+		ctx.Next()
+
+		if ctx.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		if err := model.RecordConfirmedAction(session.Tenant, action, ctx.Request.URL.Path, confirmed); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// CurrentConfirmation возвращает подтверждение, сохраненное RequireConfirmation или AcceptConfirmation
+func CurrentConfirmation(ctx *gin.Context) (*model.Confirmation, bool) {
+	value, ok := ctx.Get(ConfirmationKey)
+	if !ok {
+		return nil, false
+	}
+	confirmation, ok := value.(*model.Confirmation)
+	return confirmation, ok
+}
@@ -22,9 +22,10 @@ type Token struct {
 // @Param credentials body user.Credentials true "credentials json"
 // @Success 200 {string} Token
 // @Failure 401 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Router /login [post]
-func LogIn(users *service.UserService, sessions *service.SessionService) gin.HandlerFunc {
+func LogIn(users *service.UserService, tenants *service.TenantService, sessions *service.SessionService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var creds user.Credentials
 		if err := ctx.ShouldBind(&creds); err != nil {
@@ -35,11 +36,21 @@ func LogIn(users *service.UserService, sessions *service.SessionService) gin.Han
 		usr, err := users.Authenticate(creds)
 		if err != nil {
 			httputils.NewError(ctx, http.StatusUnauthorized, err)
+			return
+		}
+
+		// Суперадминистратор входит и при заблокированной площадке, чтобы ее разблокировать
+		if usr.Role != user.SuperAdmin {
+			if err := tenants.Active(usr.Tenant); err != nil {
+				httputils.NewError(ctx, http.StatusForbidden, err)
+				return
+			}
 		}
 
 		session, err := sessions.CreateSession(usr)
 		if err != nil {
 			httputils.NewError(ctx, http.StatusUnauthorized, err)
+			return
 		}
 
 		ctx.JSON(http.StatusOK, Token{
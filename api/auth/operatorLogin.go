@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/Oxynger/JournalApp/model/user"
+	"github.com/Oxynger/JournalApp/service"
+	"github.com/gin-gonic/gin"
+)
+
+// OperatorToken токен сессии контроллера
+type OperatorToken struct {
+	Token    string `json:"token"`
+	ExpireAt int64  `json:"expiresAt"`
+}
+
+// PasswordChange смена пароля контроллера
+type PasswordChange struct {
+	Tenant   db.Tenant `json:"tenant,omitempty" example:"default"`
+	Login    string    `json:"login" binding:"required" example:"olegov"`
+	Current  string    `json:"current" binding:"required" example:"04718253"`
+	Password string    `json:"password" binding:"required" example:"qwerty12"`
+}
+
+// operatorTenant площадка контроллера. Без указания площадки используется площадка по умолчанию
+func operatorTenant(tenant db.Tenant) db.Tenant {
+	if len(tenant) == 0 {
+		return db.DefaultTenant
+	}
+	return tenant
+}
+
+func operatorAuthError(ctx *gin.Context, err error) {
+	if err == model.ErrOperatorSuspended || err == model.ErrOperatorArchived || err == model.ErrOperatorMustChangePassword || err == service.ErrTenantDisabled {
+		httputils.NewError(ctx, http.StatusForbidden, err)
+		return
+	}
+	httputils.NewError(ctx, http.StatusUnauthorized, err)
+}
+
+// OperatorLogIn используется для авторизации контроллера на планшете
+// @Summary Авторизация контроллера
+// @Description Авторизация контроллера по площадке, логину и паролю. Если площадка не указана, используется default.
+// @Description С кодом сброса или паролем, выданным администратором, сессия не выдается (403): сначала пароль меняется через /login/operator/password
+// @Accept json
+// @Produce json
+// @Param credentials body user.Credentials true "credentials json"
+// @Success 200 {object} auth.OperatorToken
+// @Failure 401 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Router /login/operator [post]
+func OperatorLogIn(tenants *service.TenantService, sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var creds user.Credentials
+		if err := ctx.ShouldBind(&creds); err != nil {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		tenant := operatorTenant(creds.Tenant)
+		if err := tenants.Active(tenant); err != nil {
+			operatorAuthError(ctx, err)
+			return
+		}
+
+		operator, err := model.AuthenticateOperator(tenant, creds.Username, creds.Password)
+		if err != nil {
+			operatorAuthError(ctx, err)
+			return
+		}
+
+		session, err := sessions.CreateSession(&user.User{
+			Username: operator.Login,
+			Role:     user.Operator,
+			Tenant:   tenant,
+		})
+		if err != nil {
+			httputils.NewError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, OperatorToken{
+			Token:    session.Token,
+			ExpireAt: session.ExpireAt,
+		})
+	}
+}
+
+// ChangeOperatorPassword смена пароля контроллером
+// @Summary Смена пароля контроллера
+// @Description Смена пароля по текущему паролю или одноразовому коду сброса. Новый пароль должен соответствовать политике паролей
+// @Accept json
+// @Produce json
+// @Param password body auth.PasswordChange true "password json"
+// @Success 200 {string} string ""
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 401 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Router /login/operator/password [post]
+func ChangeOperatorPassword(ctx *gin.Context) {
+	var change PasswordChange
+	if err := ctx.ShouldBindJSON(&change); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err := model.ChangeOperatorPassword(operatorTenant(change.Tenant), change.Login, change.Current, change.Password)
+
+	if err == model.ErrPasswordPolicy {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err != nil {
+		operatorAuthError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// KeyRotation создание ключа подписи контроллера
+type KeyRotation struct {
+	Tenant   db.Tenant `json:"tenant,omitempty" example:"default"`
+	Login    string    `json:"login" binding:"required" example:"olegov"`
+	Password string    `json:"password" binding:"required" example:"qwerty12"`
+	// PIN если задан, закрытый ключ защищается PIN-кодом из 4-8 цифр, иначе паролем
+	PIN string `json:"pin,omitempty" example:"2580"`
+}
+
+// RotateOperatorKey создание или замена ключа подписи контроллером
+// @Summary Ключ подписи контроллера
+// @Description Создание нового ключа подписи росписей. Закрытый ключ хранится зашифрованным паролем или PIN-кодом и без них недоступен.
+// @Description Прежний ключ заменяется, росписи, сделанные им, остаются действительными. Ключ, защищенный паролем, создается и при первой смене пароля
+// @Accept json
+// @Produce json
+// @Param key body auth.KeyRotation true "key json"
+// @Success 200 {object} model.OperatorKey
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 401 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Router /login/operator/key [post]
+func RotateOperatorKey(ctx *gin.Context) {
+	var rotation KeyRotation
+	if err := ctx.ShouldBindJSON(&rotation); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	key, err := model.RotateOperatorKey(operatorTenant(rotation.Tenant), rotation.Login, rotation.Password, rotation.PIN)
+
+	switch err {
+	case nil:
+	case model.ErrPINPolicy:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	case model.ErrOperatorCredentials, model.ErrOperatorSuspended, model.ErrOperatorArchived:
+		operatorAuthError(ctx, err)
+		return
+	default:
+		httputils.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, key)
+}
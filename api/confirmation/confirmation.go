@@ -0,0 +1,43 @@
+package confirmation
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+// ListConfirmedActions Журнал подтвержденных действий
+// @Summary Подтвержденные действия
+// @Description Критические действия, выполненные с повторным вводом учетных данных: кто подтвердил, когда, с каким смыслом
+// @Description и каким запросом. Фильтры: action, meaning, by, at, performed_at
+// @Tags Confirmation
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /confirmation [get]
+func ListConfirmedActions(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	actions, err := model.ConfirmedActions(httputils.Tenant(ctx), query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, actions)
+}
@@ -3,6 +3,8 @@ package itemScheme
 import (
 	"net/http"
 
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
 	"github.com/Oxynger/JournalApp/httputils"
 	"github.com/Oxynger/JournalApp/model"
 	"github.com/gin-gonic/gin"
@@ -10,19 +12,29 @@ import (
 
 // GetItemSchemes Получить все схемы объектов
 // @Summary Список схем объектов
-// @Description Метод, который получает все списки объектов
+// @Description Метод, который получает все списки объектов. Фильтры: name, title
 // @Tags ItemScheme
 // @Accept  json
 // @Produce  json
-// @Success 200 {array} model.ItemScheme
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /scheme/item [get]
 func GetItemSchemes(ctx *gin.Context) {
-	schemes, err := model.ItemSchemeAll()
+	query, err := httputils.ParseQuery(ctx)
 	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
+		httputils.ListError(ctx, err)
+		return
+	}
+	schemes, err := model.ItemSchemeAll(httputils.Tenant(ctx), query)
+	if err != nil {
+		httputils.ListError(ctx, err)
 		return
 	}
 	ctx.JSON(http.StatusOK, schemes)
@@ -35,7 +47,9 @@ func GetItemSchemes(ctx *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param itemscheme_id path string true "ItemSheme id"
+// @Param If-None-Match header string false "ETag"
 // @Success 200 {object} model.ItemScheme
+// @Success 304 {string} string ""
 // @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
@@ -43,11 +57,14 @@ func GetItemSchemes(ctx *gin.Context) {
 // @Router /scheme/item/{itemscheme_id} [get]
 func GetItemScheme(ctx *gin.Context) {
 	id := ctx.Param("itemscheme_id")
-	scheme, err := model.ItemSchemeOne(id)
+	scheme, err := model.ItemSchemeOne(httputils.Tenant(ctx), id)
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
+	if httputils.NotModified(ctx, scheme.Version) {
+		return
+	}
 	ctx.JSON(http.StatusOK, scheme)
 }
 
@@ -58,8 +75,10 @@ func GetItemScheme(ctx *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param NewItemScheme body model.NewItemScheme true "New Item Scheme"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
 // @Success 200 {object} model.NewItemScheme
 // @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
@@ -75,7 +94,7 @@ func NewItemScheme(ctx *gin.Context) {
 		return
 	}
 
-	err := newItemScheme.Insert()
+	err := newItemScheme.Insert(httputils.Tenant(ctx))
 	if err != nil {
 		httputils.NewError(ctx, http.StatusBadRequest, err)
 		return
@@ -85,15 +104,22 @@ func NewItemScheme(ctx *gin.Context) {
 
 // UpdateItemScheme Изменить схему объектов с id
 // @Summary Изменить схему объектов с id
-// @Description Метод, который изменяет схему объекта с заданным id
+// @Description Метод, который изменяет схему объекта с заданным id.
+// @Description Схему, которую используют схемы журналов, нельзя переименовать и нельзя удалить из нее поля, указанные в их item_info
 // @Tags ItemScheme
 // @Accept  json
 // @Produce  json
 // @Param itemscheme_id path string true "ItemSheme id"
+// @Param If-Match header string true "ETag"
 // @Param UpdateItemScheme body model.ItemScheme true "Update Item Scheme"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
 // @Success 200 {object} model.ItemScheme
 // @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPErrorDetails
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /scheme/item/{itemscheme_id} [put]
@@ -101,6 +127,11 @@ func UpdateItemScheme(ctx *gin.Context) {
 	id := ctx.Param("itemscheme_id")
 
 	var updateItemScheme model.UpdateItemScheme
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
 	if err := ctx.ShouldBindJSON(&updateItemScheme); err != nil {
 		httputils.NewError(ctx, http.StatusBadRequest, err)
 		return
@@ -110,7 +141,17 @@ func UpdateItemScheme(ctx *gin.Context) {
 		return
 	}
 
-	err := updateItemScheme.Update(id)
+	if err := updateItemScheme.CheckReferences(httputils.Tenant(ctx), id); err != nil {
+		schemeReferenceError(ctx, err)
+		return
+	}
+
+	err := updateItemScheme.Update(httputils.Tenant(ctx), id, version)
+
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
 
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
@@ -121,23 +162,68 @@ func UpdateItemScheme(ctx *gin.Context) {
 
 // DeleteItemScheme Удалить схему объектов с id
 // @Summary Удалить схему объектов с id
-// @Description Метод, который удаляет схему объектов с заданным id
+// @Description Метод, который удаляет схему объектов с заданным id. Если схему используют схемы журналов,
+// @Description возвращается 409 со списком зависимых схем; с cascade=true зависимые схемы журналов и отчетов удаляются вместе с ней
 // @Tags ItemScheme
 // @Accept  json
 // @Produce  json
 // @Param itemscheme_id path string true "ItemSheme id"
+// @Param If-Match header string true "ETag"
+// @Param cascade query bool false "Delete dependent journal and report schemes too"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
 // @Success 200 {object} model.ItemScheme
 // @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPErrorDetails
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /scheme/item/{itemscheme_id} [delete]
 func DeleteItemScheme(ctx *gin.Context) {
 	id := ctx.Param("itemscheme_id")
-	err := model.DeleteSchemeOne(id)
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+	err := model.DeleteSchemeOne(httputils.Tenant(ctx), id, version, auth.CurrentUsername(ctx), ctx.Query("cascade") == "true")
 	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
+		schemeReferenceError(ctx, err)
 		return
 	}
 	ctx.JSON(http.StatusOK, id)
 }
+
+// ItemSchemeDependents Схемы, которые используют схему объектов с id
+// @Summary Схемы, которые используют схему объектов с id
+// @Description Метод, который возвращает схемы журналов, ссылающиеся на схему объектов через Item, и схемы отчетов по этим журналам
+// @Tags ItemScheme
+// @Produce  json
+// @Param itemscheme_id path string true "ItemSheme id"
+// @Success 200 {object} model.SchemeDependents
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/dependents/item/{itemscheme_id} [get]
+func ItemSchemeDependents(ctx *gin.Context) {
+	dependents, err := model.ItemSchemeDependents(httputils.Tenant(ctx), ctx.Param("itemscheme_id"))
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, dependents)
+}
+
+// schemeReferenceError отвечает на ошибку проверки ссылок между схемами
+func schemeReferenceError(ctx *gin.Context, err error) {
+	if dependentsErr, ok := err.(*model.DependentsError); ok {
+		httputils.NewErrorDetails(ctx, http.StatusConflict, dependentsErr, dependentsErr.Dependents)
+		return
+	}
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
+	httputils.NewError(ctx, http.StatusNotFound, err)
+}
@@ -0,0 +1,257 @@
+package journal
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+func attachmentError(ctx *gin.Context, err error) {
+	switch err {
+	case db.ErrVersionMismatch:
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+	case model.ErrJournalOutOfScope:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+	case model.ErrJournalClosed:
+		httputils.NewError(ctx, http.StatusConflict, err)
+	case model.ErrLegalHold:
+		httputils.NewError(ctx, http.StatusLocked, err)
+	case model.ErrAttachmentTooLarge:
+		httputils.NewError(ctx, http.StatusRequestEntityTooLarge, err)
+	case model.ErrAttachmentTypeInvalid:
+		httputils.NewError(ctx, http.StatusUnsupportedMediaType, err)
+	case model.ErrAttachmentEmpty, model.ErrAttachmentFieldInvalid:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+	}
+}
+
+// sendAttachment отдает содержимое вложения или его миниатюру
+func sendAttachment(ctx *gin.Context, thumbnail bool) {
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	attachment, err := model.AttachmentOne(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), ctx.Param("attachment_id"))
+	if err != nil {
+		attachmentError(ctx, err)
+		return
+	}
+
+	content, err := model.OpenAttachment(httputils.Tenant(ctx), *attachment, thumbnail)
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	defer content.Close()
+
+	contentType := attachment.ContentType
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})
+	if thumbnail {
+		contentType = "image/jpeg"
+		disposition = "inline"
+	}
+
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", disposition)
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Status(http.StatusOK)
+	io.Copy(ctx.Writer, content)
+}
+
+// UploadAttachment Прикрепить файл к журналу
+// @Summary Добавление вложения
+// @Description Прикрепление фото или документа к полю журнала с типом Attachment или Photo (только изображения). Файл передается в multipart/form-data.
+// @Description Тип определяется по содержимому: jpeg, png, gif, pdf, текст. Размер ограничен настройкой ATTACHMENT_MAX_SIZE.
+// @Description Для изображений сохраняется миниатюра. Контроллер может прикреплять файлы только к журналам своих узлов
+// @Tags Journal
+// @Accept  multipart/form-data
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param field formData string true "Поле схемы журнала"
+// @Param file formData file true "Файл"
+// @Success 200 {object} model.Attachment
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 413 {object} httputils.HTTPError
+// @Failure 415 {object} httputils.HTTPError
+// @Failure 423 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/attachment [post]
+func UploadAttachment(ctx *gin.Context) {
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	// Запас на заголовки multipart, сам файл проверяется в model.AddAttachment
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, model.AttachmentMaxSize()+1<<20)
+
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	defer file.Close()
+
+	var by string
+	if session, ok := auth.CurrentSession(ctx); ok {
+		by = session.Username
+	}
+
+	attachment, err := model.AddAttachment(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), ctx.PostForm("field"), header.Filename, by, file)
+
+	if err != nil {
+		attachmentError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, attachment.Version)
+	ctx.JSON(http.StatusOK, attachment)
+}
+
+// ListAttachments Вложения журнала
+// @Summary Список вложений журнала
+// @Description Получение списка вложений журнала
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Success 200 {array} model.Attachment
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/attachment [get]
+func ListAttachments(ctx *gin.Context) {
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	attachments, err := model.AttachmentsOf(httputils.Tenant(ctx), scope, ctx.Param("journal_id"))
+
+	if err != nil {
+		attachmentError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, attachments)
+}
+
+// DownloadAttachment Скачать вложение
+// @Summary Скачать вложение
+// @Description Получение содержимого вложения
+// @Tags Journal
+// @Produce  octet-stream
+// @Param journal_id path string true "Journal id"
+// @Param attachment_id path string true "Attachment id"
+// @Success 200 {file} file "Содержимое вложения"
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/attachment/{attachment_id} [get]
+func DownloadAttachment(ctx *gin.Context) {
+	sendAttachment(ctx, false)
+}
+
+// DownloadThumbnail Миниатюра вложения
+// @Summary Миниатюра вложения
+// @Description Получение миниатюры изображения в jpeg. Для вложений, которые не являются изображениями, и изображений больше 40 мегапикселей миниатюры нет
+// @Tags Journal
+// @Produce  jpeg
+// @Param journal_id path string true "Journal id"
+// @Param attachment_id path string true "Attachment id"
+// @Success 200 {file} file "Миниатюра"
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/attachment/{attachment_id}/thumbnail [get]
+func DownloadThumbnail(ctx *gin.Context) {
+	sendAttachment(ctx, true)
+}
+
+// DeleteAttachment Удаление вложения
+// @Summary Удалить вложение
+// @Description Удаление вложения. Установление deleted_at и deleted_by
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param attachment_id path string true "Attachment id"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.Attachment
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 423 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/attachment/{attachment_id} [delete]
+func DeleteAttachment(ctx *gin.Context) {
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	attachment, err := model.AttachmentDelete(httputils.Tenant(ctx), ctx.Param("journal_id"), ctx.Param("attachment_id"), version, auth.CurrentUsername(ctx))
+
+	if err != nil {
+		attachmentError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, attachment)
+}
+
+// ExportJournal Выгрузка журнала
+// @Summary Выгрузить журнал
+// @Description Zip-архив журнала: journal.json с результатом проверки подписей росписей, keys.json с открытыми ключами контроллеров,
+// @Description discussions.json с решенными обсуждениями, capa.json с корректирующими действиями, attachments.json и содержимое вложений в каталоге attachments/
+// @Tags Journal
+// @Produce  application/zip
+// @Param journal_id path string true "Journal id"
+// @Success 200 {file} file "Архив журнала"
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/export [get]
+func ExportJournal(ctx *gin.Context) {
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	// Архив собирается целиком, чтобы ошибку можно было вернуть с кодом ответа
+	var archive bytes.Buffer
+	if err := model.ExportJournal(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), &archive); err != nil {
+		attachmentError(ctx, err)
+		return
+	}
+
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "journal_" + ctx.Param("journal_id") + ".zip"}))
+	ctx.Header("Content-Length", strconv.Itoa(archive.Len()))
+	ctx.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
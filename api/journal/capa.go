@@ -0,0 +1,307 @@
+package journal
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+func capaError(ctx *gin.Context, err error) {
+	switch err {
+	case db.ErrVersionMismatch:
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+	case model.ErrJournalOutOfScope:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+	case model.ErrCAPAClosed, model.ErrCAPAIncomplete:
+		httputils.NewError(ctx, http.StatusConflict, err)
+	case model.ErrCAPAAction, model.ErrCAPAVerification:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+	}
+}
+
+// ListCAPAs Корректирующие действия
+// @Summary Список корректирующих действий
+// @Description Корректирующие и предупреждающие действия (CAPA), открытые по не пройденным проверкам журналов: одно на проверку в смену или день.
+// @Description Фильтры: journal, scheme, item, date, check, occurrence, shift, field, status, owner, due_date, closed_at, created_at, updated_at.
+// @Description overdue отмечает открытые действия после срока. Контроллер видит только действия по журналам своих узлов: позиция узла и схема, которая в нем ведется
+// @Tags CAPA
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /capa [get]
+func ListCAPAs(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	capas, err := model.CAPAs(httputils.Tenant(ctx), scope, query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, capas)
+}
+
+// ListOverdueCAPAs Просроченные корректирующие действия
+// @Summary Просроченные корректирующие действия
+// @Description Открытые корректирующие действия, срок которых прошел. Фильтры как у списка корректирующих действий
+// @Tags CAPA
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /capa/overdue [get]
+func ListOverdueCAPAs(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	capas, err := model.OverdueCAPAs(httputils.Tenant(ctx), scope, query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, capas)
+}
+
+// ShowCAPA Корректирующее действие
+// @Summary Корректирующее действие по id
+// @Tags CAPA
+// @Accept  json
+// @Produce  json
+// @Param capa_id path string true "CAPA id"
+// @Success 200 {object} model.CAPA
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /capa/record/{capa_id} [get]
+func ShowCAPA(ctx *gin.Context) {
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	capa, err := model.CAPAOne(httputils.Tenant(ctx), scope, ctx.Param("capa_id"))
+
+	if err != nil {
+		capaError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, capa.Version)
+	ctx.JSON(http.StatusOK, capa)
+}
+
+// UpdateCAPA Назначить ответственного, срок и первопричину
+// @Summary Изменить корректирующее действие
+// @Description Назначает ответственного (owner), срок (due_date) и первопричину (root_cause) открытого действия. Пустые поля не меняются
+// @Tags CAPA
+// @Accept  json
+// @Produce  json
+// @Param capa_id path string true "CAPA id"
+// @Param capa body model.CAPAUpdate true "capa json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.CAPA
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /capa/record/{capa_id} [put]
+func UpdateCAPA(ctx *gin.Context) {
+	var change model.CAPAUpdate
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&change); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	capa, err := model.UpdateCAPA(httputils.Tenant(ctx), ctx.Param("capa_id"), version, change)
+
+	if err != nil {
+		capaError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, capa.Version)
+	ctx.JSON(http.StatusOK, capa)
+}
+
+// AddCAPAAction Записать выполненное действие
+// @Summary Выполненное действие
+// @Description Записывает выполненное корректирующее или предупреждающее действие. Сбрасывает проверку эффективности
+// @Tags CAPA
+// @Accept  json
+// @Produce  json
+// @Param capa_id path string true "CAPA id"
+// @Param action body model.NewCAPAAction true "action json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.CAPA
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /capa/record/{capa_id}/action [post]
+func AddCAPAAction(ctx *gin.Context) {
+	var action model.NewCAPAAction
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&action); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	capa, err := model.AddCAPAAction(httputils.Tenant(ctx), scope, ctx.Param("capa_id"), version, auth.CurrentUsername(ctx), action)
+
+	if err != nil {
+		capaError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, capa.Version)
+	ctx.JSON(http.StatusOK, capa)
+}
+
+// VerifyCAPA Проверка эффективности
+// @Summary Проверить эффективность действий
+// @Description Записывает результат проверки эффективности выполненных действий: effective или ineffective
+// @Tags CAPA
+// @Accept  json
+// @Produce  json
+// @Param capa_id path string true "CAPA id"
+// @Param verification body model.CAPAVerify true "verification json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.CAPA
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /capa/record/{capa_id}/verification [post]
+func VerifyCAPA(ctx *gin.Context) {
+	var verify model.CAPAVerify
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&verify); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	capa, err := model.VerifyCAPA(httputils.Tenant(ctx), ctx.Param("capa_id"), version, auth.CurrentUsername(ctx), verify)
+
+	if err != nil {
+		capaError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, capa.Version)
+	ctx.JSON(http.StatusOK, capa)
+}
+
+// CloseCAPA Закрыть корректирующее действие
+// @Summary Закрыть корректирующее действие
+// @Description Закрывает действие с первопричиной, выполненными действиями и эффективной проверкой.
+// @Description Когда закрыты все действия журнала, его accepted становится 1. Подтверждение из X-Confirmation-Token сохраняется в записи
+// @Tags CAPA
+// @Accept  json
+// @Produce  json
+// @Param capa_id path string true "CAPA id"
+// @Param closure body model.CAPAClose true "closure json"
+// @Param If-Match header string true "ETag"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
+// @Success 200 {object} model.CAPA
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /capa/record/{capa_id}/close [post]
+func CloseCAPA(ctx *gin.Context) {
+	var close model.CAPAClose
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&close); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	confirmation, _ := auth.CurrentConfirmation(ctx)
+
+	capa, err := model.CloseCAPA(httputils.Tenant(ctx), ctx.Param("capa_id"), version, auth.CurrentUsername(ctx), close, confirmation)
+
+	if err != nil {
+		capaError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, capa.Version)
+	ctx.JSON(http.StatusOK, capa)
+}
@@ -0,0 +1,313 @@
+package journal
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+func commentError(ctx *gin.Context, err error) {
+	switch err {
+	case model.ErrJournalOutOfScope:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+	case model.ErrThreadResolved:
+		httputils.NewError(ctx, http.StatusConflict, err)
+	case model.ErrCommentEmpty, model.ErrCommentField, model.ErrCommentParent, model.ErrCommentMention:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+	}
+}
+
+// ListCommentThreads Обсуждения журнала
+// @Summary Список обсуждений журнала
+// @Description Обсуждения журнала, его дней и значений полей с числом непрочитанных текущим пользователем сообщений в unread.
+// @Description Контроллер видит только журналы своих узлов
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/comment [get]
+func ListCommentThreads(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	threads, err := model.CommentThreads(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), auth.CurrentUsername(ctx), query)
+
+	switch err {
+	case nil:
+	case model.ErrJournalOutOfScope:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+		return
+	default:
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, threads)
+}
+
+// StartCommentThread Начать обсуждение
+// @Summary Новое обсуждение
+// @Description Обсуждение всего журнала, дня (date) или значения поля (field). Значение поля сохраняется в обсуждении на момент его начала.
+// @Description Упоминания @login в тексте и в mentions попадают в список обсуждений упомянутых пользователей и контроллеров.
+// @Description Упоминания в тексте без такого пользователя или контроллера пропускаются, неизвестный логин в mentions - ошибка 400.
+// @Description Обсуждение не меняет значения журнала
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param thread body model.NewCommentThread true "thread json"
+// @Success 201 {object} model.CommentThread
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/comment [post]
+func StartCommentThread(ctx *gin.Context) {
+	var newThread model.NewCommentThread
+
+	if err := ctx.ShouldBindJSON(&newThread); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	thread, err := model.StartCommentThread(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), auth.CurrentUsername(ctx), newThread)
+
+	if err != nil {
+		commentError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, thread)
+}
+
+// ShowCommentThread Обсуждение журнала
+// @Summary Обсуждение по id
+// @Description Обсуждение со всеми сообщениями, отметками о прочтении и числом непрочитанных текущим пользователем сообщений
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param thread_id path string true "Thread id"
+// @Success 200 {object} model.CommentThread
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/comment/{thread_id} [get]
+func ShowCommentThread(ctx *gin.Context) {
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	thread, err := model.CommentThreadOne(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), ctx.Param("thread_id"), auth.CurrentUsername(ctx))
+
+	if err != nil {
+		commentError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, thread)
+}
+
+// ReplyComment Ответ в обсуждении
+// @Summary Ответить в обсуждении
+// @Description Ответ на обсуждение в целом или на сообщение parent. В решенном обсуждении отвечать нельзя.
+// @Description Упоминания проверяются так же, как в новом обсуждении
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param thread_id path string true "Thread id"
+// @Param comment body model.NewComment true "comment json"
+// @Success 200 {object} model.CommentThread
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/comment/{thread_id}/reply [post]
+func ReplyComment(ctx *gin.Context) {
+	var reply model.NewComment
+
+	if err := ctx.ShouldBindJSON(&reply); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	thread, err := model.ReplyComment(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), ctx.Param("thread_id"), auth.CurrentUsername(ctx), reply)
+
+	if err != nil {
+		commentError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, thread)
+}
+
+// ReadCommentThread Отметить обсуждение прочитанным
+// @Summary Прочитать обсуждение
+// @Description Отмечает все сообщения обсуждения прочитанными текущим пользователем
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param thread_id path string true "Thread id"
+// @Success 200 {object} model.CommentThread
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/comment/{thread_id}/read [post]
+func ReadCommentThread(ctx *gin.Context) {
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	thread, err := model.ReadCommentThread(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), ctx.Param("thread_id"), auth.CurrentUsername(ctx))
+
+	if err != nil {
+		commentError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, thread)
+}
+
+// ResolveCommentThread Решить обсуждение
+// @Summary Решить обсуждение
+// @Description Закрывает обсуждение с решением. Решенное обсуждение не принимает ответов и входит в историю журнала
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param thread_id path string true "Thread id"
+// @Param resolution body model.ThreadResolution true "resolution json"
+// @Success 200 {object} model.CommentThread
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/comment/{thread_id}/resolve [post]
+func ResolveCommentThread(ctx *gin.Context) {
+	var resolution model.ThreadResolution
+
+	if err := ctx.ShouldBindJSON(&resolution); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	thread, err := model.ResolveCommentThread(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), ctx.Param("thread_id"), auth.CurrentUsername(ctx), resolution)
+
+	if err != nil {
+		commentError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, thread)
+}
+
+// ListMentions Обсуждения с моими упоминаниями
+// @Summary Мои упоминания
+// @Description Открытые обсуждения, в которых упомянут текущий пользователь, с числом непрочитанных сообщений.
+// @Description Контроллер видит только журналы своих узлов
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /comment/mentions [get]
+func ListMentions(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	threads, err := model.MentionedThreads(httputils.Tenant(ctx), scope, auth.CurrentUsername(ctx), query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, threads)
+}
+
+// ShowJournalAudit История журнала
+// @Summary История журнала
+// @Description События журнала по времени: росписи и их отмены, переходы проверки, решенные обсуждения со всеми сообщениями и закрытые корректирующие действия
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Success 200 {array} model.AuditEntry
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/audit [get]
+func ShowJournalAudit(ctx *gin.Context) {
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	entries, err := model.JournalAudit(httputils.Tenant(ctx), scope, ctx.Param("journal_id"))
+
+	if err != nil {
+		commentError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, entries)
+}
@@ -3,27 +3,63 @@ package journal
 import (
 	"net/http"
 
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
 	"github.com/Oxynger/JournalApp/httputils"
 	"github.com/Oxynger/JournalApp/model"
+	"github.com/Oxynger/JournalApp/model/user"
 	"github.com/gin-gonic/gin"
 )
 
+// operatorScope узлы организационной структуры контроллера. Для остальных ролей nil - доступны все журналы
+func operatorScope(ctx *gin.Context) (*model.OrgScope, bool) {
+	session, ok := auth.CurrentSession(ctx)
+	if !ok || session.Role != user.Operator {
+		return nil, true
+	}
+
+	scope, err := model.OperatorOrgScope(httputils.Tenant(ctx), session.Username)
+	if err != nil {
+		httputils.NewError(ctx, http.StatusForbidden, err)
+		return nil, false
+	}
+	return scope, true
+}
+
 // ListJournals Получить все журналы
 // @Summary Список журналов
-// @Description Получение списка журналов
+// @Description Получение списка журналов. Фильтры: scheme, item, date, status, review, created_at, updated_at.
+// @Description Контроллер видит только журналы позиций и схем узлов организационной структуры, за которыми он закреплен, и нижестоящих узлов
 // @Tags Journal
 // @Accept  json
 // @Produce  json
-// @Success 200 {array} model.Journal
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /journal [get]
 func ListJournals(ctx *gin.Context) {
-	journals, err := model.JournalsAll()
+	query, err := httputils.ParseQuery(ctx)
 
 	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	journals, err := model.JournalsAll(httputils.Tenant(ctx), scope, query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
 		return
 	}
 
@@ -32,12 +68,16 @@ func ListJournals(ctx *gin.Context) {
 
 // ShowJournal Получение кокретного журнала
 // @Summary Один журнал
-// @Description Получение кокретного журнала
+// @Description Получение кокретного журнала.
+// @Description У каждой росписи verification - результат проверки подписи: valid, invalid, unsigned, unknown_key, revoked,
+// @Description values_changed - подпись верна, но значения закрытого журнала изменены после росписи
 // @Tags Journal
 // @Accept  json
 // @Produce  json
 // @Param journal_id path string true "Journal id"
+// @Param If-None-Match header string false "ETag"
 // @Success 200 {object} model.Journal
+// @Success 304 {string} string ""
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
@@ -45,20 +85,25 @@ func ListJournals(ctx *gin.Context) {
 func ShowJournal(ctx *gin.Context) {
 	id := ctx.Param("journal_id")
 
-	journal, err := model.JournalOne(id)
+	journal, err := model.JournalOne(httputils.Tenant(ctx), id)
 
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
 
+	if httputils.NotModified(ctx, journal.Version) {
+		return
+	}
+
 	ctx.JSON(http.StatusOK, journal)
 
 }
 
 // AddJournal Добавление журнала
 // @Summary Добавить журнал
-// @Description Добавление журнала.
+// @Description Добавление журнала. Значения проверяются по типам и ограничениям полей схемы, пустые поля получают значения по умолчанию.
+// @Description Схема журнала должна существовать
 // @Tags Journal
 // @Accept  json
 // @Produce  json
@@ -77,35 +122,61 @@ func AddJournal(ctx *gin.Context) {
 		return
 	}
 
-	resaultJournal, err := model.AddJournal(journal)
+	resaultJournal, err := model.AddJournal(httputils.Tenant(ctx), journal)
+	if _, ok := err.(*model.FieldError); ok || err == model.ErrJournalSchemeMissing {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
 
+	httputils.SetETag(ctx, resaultJournal.Version)
 	ctx.JSON(http.StatusOK, resaultJournal)
 
 }
 
 // DeleteJournal Удаление журнала
 // @Summary Удлить журнал
-// @Description Удаление журнала. Установление deleted true
+// @Description Удаление журнала. Установление deleted_at и deleted_by, журнал попадает в корзину
 // @Tags Journal
 // @Accept  json
 // @Produce  json
 // @Param journal_id path string true "Journal id"
+// @Param If-Match header string true "ETag"
 // @Success 200 {object} model.Journal
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 423 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /journal/{journal_id} [delete]
 func DeleteJournal(ctx *gin.Context) {
 	id := ctx.Param("journal_id")
 
-	journal, err := model.JournalDelete(id)
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	journal, err := model.JournalDelete(httputils.Tenant(ctx), id, version, auth.CurrentUsername(ctx))
+
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	if err == model.ErrLegalHold {
+		httputils.NewError(ctx, http.StatusLocked, err)
+		return
+	}
 
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
 	}
 
 	ctx.JSON(http.StatusOK, journal)
@@ -113,15 +184,21 @@ func DeleteJournal(ctx *gin.Context) {
 
 // UpdateJournal Изменеие журнала
 // @Summary Изменить журнал
-// @Description Изменение журнала.
+// @Description Изменение журнала. Значения проверяются по типам и ограничениям полей схемы, пустые поля получают значения по умолчанию.
+// @Description Журнал на проверке (submitted, reviewed) или утвержденный изменить нельзя
 // @Tags Journal
 // @Accept  json
 // @Produce  json
 // @Param journal body model.Journal true "journal json"
 // @Param journal_id path string true "Journal id"
+// @Param If-Match header string true "ETag"
 // @Success 200 {object} model.Journal
 // @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 423 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /journal/{journal_id} [put]
@@ -129,34 +206,146 @@ func UpdateJournal(ctx *gin.Context) {
 	id := ctx.Param("journal_id")
 	var journal model.Journal
 
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
 	if err := ctx.ShouldBindJSON(&journal); err != nil {
 		httputils.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
 
-	resaultJournal, err := model.JournalUpdate(id, journal)
+	resaultJournal, err := model.JournalUpdate(httputils.Tenant(ctx), id, version, journal)
+
+	if _, ok := err.(*model.FieldError); ok || err == model.ErrJournalSchemeMissing {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	if err == model.ErrLegalHold {
+		httputils.NewError(ctx, http.StatusLocked, err)
+		return
+	}
+
+	if err == model.ErrJournalInReview {
+		httputils.NewError(ctx, http.StatusConflict, err)
+		return
+	}
 
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
 	}
 
+	httputils.SetETag(ctx, resaultJournal.Version)
 	ctx.JSON(http.StatusOK, resaultJournal)
 
 }
 
 // CloseJournal Добавить роспись
 // @Summary Добавление росписи
-// @Description Добавление росписи контролера для закрытия журнала на день. Данная функция доступна только для ежедневных журналов (daily == 0). Роспись - это файл, в формате png размером 250х125.
+// @Description Добавление росписи контролера для закрытия журнала. Роспись - это файл в формате png размером 250х125, закодированный в base64.
+// @Description Ежедневный журнал закрывается за текущий день или, если per_shift, за текущую смену и остается открытым для следующих периодов.
+// @Description Остальные журналы закрываются окончательно. Контроллер закрывает только журналы своих узлов.
+// @Description Роспись подписывается ключом контроллера, secret - пароль или PIN-код, которым защищен ключ. Если ключа нет, его нужно создать через /login/operator/key
 // @Tags Journal
 // @Accept  json
 // @Produce  json
 // @Param journal_id path string true "Journal id"
-// @Success 200 {string} string "answer"
+// @Param closing body model.JournalClose true "closing json"
+// @Param If-Match header string true "ETag"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
+// @Success 200 {object} model.Journal
 // @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 423 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /journal/{journal_id}/signature [POST]
 func CloseJournal(ctx *gin.Context) {
-	httputils.Blank(ctx)
+	var closing model.JournalClose
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&closing); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var by string
+	if session, ok := auth.CurrentSession(ctx); ok {
+		by = session.Username
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	confirmation, _ := auth.CurrentConfirmation(ctx)
+
+	resaultJournal, err := model.CloseJournal(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), version, by, closing, confirmation)
+
+	switch err {
+	case nil:
+	case model.ErrSignatureInvalid:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	case model.ErrJournalClosed, model.ErrJournalAlreadyClosed, model.ErrNoActiveShift, model.ErrOperatorKeyMissing:
+		httputils.NewError(ctx, http.StatusConflict, err)
+		return
+	case model.ErrOperatorKeySecret, model.ErrJournalOutOfScope:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+		return
+	case db.ErrVersionMismatch:
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	case model.ErrLegalHold:
+		httputils.NewError(ctx, http.StatusLocked, err)
+		return
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultJournal.Version)
+	ctx.JSON(http.StatusOK, resaultJournal)
+}
+
+// VerifyJournalChain Проверка цепочки хешей журнала
+// @Summary Проверить цепочку хешей
+// @Description Проход по цепочке хешей росписей журнала от первого звена. Каждое звено содержит хеш росписи,
+// @Description изображения росписи, значений журнала на момент закрытия и хеш предыдущего звена, в values - сами значения на момент закрытия.
+// @Description В breaks перечислены разрывы: digest, previous, missing, closing, closing_removed, unchained, values, snapshot, journal_missing
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Success 200 {object} model.ChainReport
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/chain [get]
+func VerifyJournalChain(ctx *gin.Context) {
+	report, err := model.VerifyJournalChain(httputils.Tenant(ctx), ctx.Param("journal_id"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
 }
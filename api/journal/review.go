@@ -0,0 +1,134 @@
+package journal
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+// ReviewJournal Переход журнала по порядку проверки
+// @Summary Проверка журнала
+// @Description Переход журнала в состояние to по порядку проверки его схемы: draft, submitted, reviewed, approved, rejected.
+// @Description Переход должен быть разрешен роли или пользователю. Отклонение требует комментария и открывает журнал для исправления,
+// @Description комментарий виден контроллеру в review.reject_reason. У закрытого журнала отклонение отменяет последнюю роспись (closings.voided),
+// @Description после исправления журнал закрывается заново. Если переход требует подтверждения, нужен X-Confirmation-Token.
+// @Description Журнал на проверке или утвержденный изменить нельзя
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param journal_id path string true "Journal id"
+// @Param review body model.ReviewRequest true "review json"
+// @Param If-Match header string true "ETag"
+// @Param X-Confirmation-Token header string false "Токен подтверждения из /login/confirm"
+// @Success 200 {object} model.Journal
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 423 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /journal/{journal_id}/review [post]
+func ReviewJournal(ctx *gin.Context) {
+	var review model.ReviewRequest
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&review); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	session, ok := auth.CurrentSession(ctx)
+	if !ok {
+		httputils.NewError(ctx, http.StatusForbidden, model.ErrReviewTransition)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	confirmation, _ := auth.CurrentConfirmation(ctx)
+
+	resaultJournal, err := model.ReviewJournal(httputils.Tenant(ctx), scope, ctx.Param("journal_id"), version, session.Username, session.Role, review, confirmation)
+
+	switch err {
+	case nil:
+	case model.ErrReviewComment:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	case model.ErrReviewTransition, model.ErrReviewConfirmation, model.ErrJournalOutOfScope:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+		return
+	case model.ErrReviewNoWorkflow:
+		httputils.NewError(ctx, http.StatusConflict, err)
+		return
+	case db.ErrVersionMismatch:
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	case model.ErrLegalHold:
+		httputils.NewError(ctx, http.StatusLocked, err)
+		return
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultJournal.Version)
+	ctx.JSON(http.StatusOK, resaultJournal)
+}
+
+// ListAwaitingReview Журналы, ожидающие моего решения
+// @Summary Ожидают моей проверки
+// @Description Журналы, которые текущий пользователь может проверить, утвердить или отклонить по порядку проверки их схем.
+// @Description Контроллер видит только журналы своих узлов организационной структуры. Фильтры как у списка журналов
+// @Tags Journal
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /review/awaiting [get]
+func ListAwaitingReview(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	session, ok := auth.CurrentSession(ctx)
+	if !ok {
+		httputils.NewError(ctx, http.StatusForbidden, model.ErrReviewTransition)
+		return
+	}
+
+	scope, ok := operatorScope(ctx)
+	if !ok {
+		return
+	}
+
+	journals, err := model.JournalsAwaitingReview(httputils.Tenant(ctx), scope, session.Username, session.Role, query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, journals)
+}
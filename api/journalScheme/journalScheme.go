@@ -0,0 +1,257 @@
+package journalScheme
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+// GetJournalSchemes Получить все схемы журналов
+// @Summary Список схем журналов
+// @Description Метод, который получает все списки журналов. Фильтры: name, title, item
+// @Tags JournalScheme
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/journal [get]
+func GetJournalSchemes(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+	schemes, err := model.JournalSchemeAll(httputils.Tenant(ctx), query)
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, schemes)
+}
+
+// GetJournalScheme Получить схему журнала с id
+// @Summary Схему журнала с id
+// @Description Метод, который получает схему журнала с заданным id
+// @Tags JournalScheme
+// @Accept  json
+// @Produce  json
+// @Param journalscheme_id path string true "JournalScheme id"
+// @Param If-None-Match header string false "ETag"
+// @Success 200 {object} model.JournalScheme
+// @Success 304 {string} string ""
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/journal/{journalscheme_id} [get]
+func GetJournalScheme(ctx *gin.Context) {
+	id := ctx.Param("journalscheme_id")
+	scheme, err := model.JournalSchemeOne(httputils.Tenant(ctx), id)
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	if httputils.NotModified(ctx, scheme.Version) {
+		return
+	}
+	ctx.JSON(http.StatusOK, scheme)
+}
+
+// NewJournalScheme Создать новую схему журналов
+// @Summary Новая схема журналов
+// @Description Метод, который создает новую схему журналов. Item должен ссылаться на существующую схему объектов, item_info - на ее поля
+// @Tags JournalScheme
+// @Accept  json
+// @Produce  json
+// @Param NewJournalScheme body model.NewJournalScheme true "New Journal Scheme"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
+// @Success 200 {object} model.NewJournalScheme
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/journal [post]
+func NewJournalScheme(ctx *gin.Context) {
+	var newJournalScheme model.NewJournalScheme
+	if err := ctx.ShouldBindJSON(&newJournalScheme); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := newJournalScheme.Validation(); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := newJournalScheme.CheckReferences(httputils.Tenant(ctx)); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err := newJournalScheme.Insert(httputils.Tenant(ctx))
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, newJournalScheme)
+}
+
+// UpdateJournalScheme Изменить схему журнала с id
+// @Summary Изменить схему журнала с id
+// @Description Метод, который изменяет схему журнала с заданным id. Item должен ссылаться на существующую схему объектов,
+// @Description item_info - на ее поля. Схему, которую используют схемы отчетов, нельзя переименовать
+// @Tags JournalScheme
+// @Accept  json
+// @Produce  json
+// @Param journalscheme_id path string true "JournalScheme id"
+// @Param If-Match header string true "ETag"
+// @Param UpdateJournalScheme body model.JournalScheme true "Update Journal Scheme"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
+// @Success 200 {object} model.JournalScheme
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPErrorDetails
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/journal/{journalscheme_id} [put]
+func UpdateJournalScheme(ctx *gin.Context) {
+	id := ctx.Param("journalscheme_id")
+
+	var updateJournalScheme model.UpdateJournalScheme
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&updateJournalScheme); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := updateJournalScheme.Validation(); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := updateJournalScheme.CheckReferences(httputils.Tenant(ctx), id); err != nil {
+		schemeReferenceError(ctx, err)
+		return
+	}
+
+	err := updateJournalScheme.Update(httputils.Tenant(ctx), id, version)
+
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, updateJournalScheme)
+}
+
+// DeleteJournalScheme Удалить схему журналов с id
+// @Summary Удалить схему журналов с id
+// @Description Метод, который удаляет схему журналов с заданным id. Если схему используют схемы отчетов,
+// @Description возвращается 409 со списком зависимых схем; с cascade=true они удаляются вместе с ней
+// @Tags JournalScheme
+// @Accept  json
+// @Produce  json
+// @Param journalscheme_id path string true "JournalScheme id"
+// @Param If-Match header string true "ETag"
+// @Param cascade query bool false "Delete dependent report schemes too"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
+// @Success 200 {string} string    "5ca10d9d015c736a72b7b3ba"
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPErrorDetails
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/journal/{journalscheme_id} [delete]
+func DeleteJournalScheme(ctx *gin.Context) {
+	id := ctx.Param("journalscheme_id")
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+	err := model.DeleteJournalSchemeOne(httputils.Tenant(ctx), id, version, auth.CurrentUsername(ctx), ctx.Query("cascade") == "true")
+	if err != nil {
+		schemeReferenceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, id)
+}
+
+// PreviewJournalScheme Предпросмотр схемы журнала
+// @Summary Предпросмотр схемы журнала
+// @Description Метод, который проверяет несохраненную схему журнала и применяет ее к примеру информации о позиции и значений:
+// @Description возвращает поля с учетом условий If, вычисленные значения и ошибки проверки. Ничего не сохраняет
+// @Tags JournalScheme
+// @Accept  json
+// @Produce  json
+// @Param SchemePreview body model.SchemePreview true "Scheme, item info and values"
+// @Success 200 {object} model.SchemePreviewResult
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/journal/preview [post]
+func PreviewJournalScheme(ctx *gin.Context) {
+	var preview model.SchemePreview
+	if err := ctx.ShouldBindJSON(&preview); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, model.PreviewJournalScheme(preview))
+}
+
+// JournalSchemeDependents Схемы, которые используют схему журналов с id
+// @Summary Схемы, которые используют схему журналов с id
+// @Description Метод, который возвращает схемы отчетов, ссылающиеся на схему журналов через Journal
+// @Tags JournalScheme
+// @Produce  json
+// @Param journalscheme_id path string true "JournalScheme id"
+// @Success 200 {object} model.SchemeDependents
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/dependents/journal/{journalscheme_id} [get]
+func JournalSchemeDependents(ctx *gin.Context) {
+	dependents, err := model.JournalSchemeDependents(httputils.Tenant(ctx), ctx.Param("journalscheme_id"))
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, dependents)
+}
+
+// schemeReferenceError отвечает на ошибку проверки ссылок между схемами
+func schemeReferenceError(ctx *gin.Context, err error) {
+	if dependentsErr, ok := err.(*model.DependentsError); ok {
+		httputils.NewErrorDetails(ctx, http.StatusConflict, dependentsErr, dependentsErr.Dependents)
+		return
+	}
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
+	httputils.NewError(ctx, http.StatusNotFound, err)
+}
@@ -0,0 +1,187 @@
+package legalHold
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+// ListLegalHolds Список удержаний
+// @Summary Список удержаний
+// @Description Получение действующих и снятых удержаний. Фильтры: scheme, item, placed_by, placed_at, released_at
+// @Tags LegalHold
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /legal-hold [get]
+func ListLegalHolds(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	holds, err := model.LegalHoldsAll(httputils.Tenant(ctx), query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, holds)
+}
+
+// ShowLegalHold Одно удержание
+// @Summary Одно удержание
+// @Description Получение удержания
+// @Tags LegalHold
+// @Accept  json
+// @Produce  json
+// @Param hold_id path string true "Legal hold id"
+// @Success 200 {object} model.LegalHold
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /legal-hold/hold/{hold_id} [get]
+func ShowLegalHold(ctx *gin.Context) {
+	hold, err := model.LegalHoldOne(httputils.Tenant(ctx), ctx.Param("hold_id"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	httputils.SetETag(ctx, hold.Version)
+	ctx.JSON(http.StatusOK, hold)
+}
+
+// PlaceLegalHold Установка удержания
+// @Summary Поставить журналы на удержание
+// @Description Журналы, которые подходят под все заданные условия (scheme, item, from-to по дате журнала, journals), в том числе удаленные в корзину,
+// @Description нельзя изменить, закрыть, удалить или удалить окончательно, пока удержание не снято. Журналы, созданные позже, под удержание не попадают
+// @Tags LegalHold
+// @Accept  json
+// @Produce  json
+// @Param hold body model.NewLegalHold true "legal hold json"
+// @Success 200 {object} model.LegalHold
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /legal-hold [post]
+func PlaceLegalHold(ctx *gin.Context) {
+	var newHold model.NewLegalHold
+
+	if err := ctx.ShouldBindJSON(&newHold); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	hold, err := model.PlaceLegalHold(httputils.Tenant(ctx), newHold, auth.CurrentUsername(ctx))
+
+	switch err {
+	case nil:
+	case model.ErrLegalHoldCriteria, model.ErrLegalHoldRange, model.ErrLegalHoldNoJournal:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	default:
+		httputils.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	httputils.SetETag(ctx, hold.Version)
+	ctx.JSON(http.StatusOK, hold)
+}
+
+// ReleaseLegalHold Снятие удержания
+// @Summary Снять удержание
+// @Description Снятие удержания с указанием основания. Журналы, которые находятся и под другими удержаниями, остаются защищенными
+// @Tags LegalHold
+// @Accept  json
+// @Produce  json
+// @Param hold_id path string true "Legal hold id"
+// @Param release body model.LegalHoldRelease true "release json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.LegalHold
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /legal-hold/hold/{hold_id}/release [post]
+func ReleaseLegalHold(ctx *gin.Context) {
+	var release model.LegalHoldRelease
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&release); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	hold, err := model.ReleaseLegalHold(httputils.Tenant(ctx), ctx.Param("hold_id"), version, auth.CurrentUsername(ctx), release)
+
+	switch err {
+	case nil:
+	case model.ErrLegalHoldReleased:
+		httputils.NewError(ctx, http.StatusConflict, err)
+		return
+	case db.ErrVersionMismatch:
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	httputils.SetETag(ctx, hold.Version)
+	ctx.JSON(http.StatusOK, hold)
+}
+
+// ListLegalHoldEvents Журнал аудита удержаний
+// @Summary Аудит удержаний
+// @Description Кто, когда и на каком основании ставил и снимал удержания. Фильтры: hold, action, by, at
+// @Tags LegalHold
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /legal-hold/audit [get]
+func ListLegalHoldEvents(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	events, err := model.LegalHoldEvents(httputils.Tenant(ctx), query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, events)
+}
@@ -2,28 +2,44 @@ package operator
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
 	"github.com/Oxynger/JournalApp/httputils"
 	"github.com/Oxynger/JournalApp/model"
+	"github.com/Oxynger/JournalApp/service"
 	"github.com/gin-gonic/gin"
 )
 
 // ListOperators Получить всех контроллеров
 // @Summary Список контроллеров
-// @Description Получение списка контроллеров
+// @Description Получение списка контроллеров. Фильтры: first_name, middle_name, last_name, created_at
 // @Tags Operator
 // @Accept  json
 // @Produce  json
-// @Success 200 {array} model.Operator
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /controller [get]
 func ListOperators(ctx *gin.Context) {
-	operators, err := model.OperatorsAll()
+	query, err := httputils.ParseQuery(ctx)
 
 	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	operators, err := model.OperatorsAll(httputils.Tenant(ctx), query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
 		return
 	}
 
@@ -45,13 +61,14 @@ func ListOperators(ctx *gin.Context) {
 func ShowOperator(ctx *gin.Context) {
 	id := ctx.Param("operator_id")
 
-	operator, err := model.OperatorOne(id)
+	operator, err := model.OperatorOne(httputils.Tenant(ctx), id)
 
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
 
+	httputils.SetETag(ctx, operator.Version)
 	ctx.JSON(http.StatusOK, operator)
 
 }
@@ -84,7 +101,7 @@ func AddOperator(ctx *gin.Context) {
 		return
 	}
 
-	resaultOperator, err := model.AddOperator(operator)
+	resaultOperator, err := model.AddOperator(httputils.Tenant(ctx), operator)
 
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
@@ -97,39 +114,60 @@ func AddOperator(ctx *gin.Context) {
 
 // DeleteOperator Удаление контроллера
 // @Summary Удлить контроллер
-// @Description Удаление контроллера. Установление deleted true
+// @Description Удаление контроллера. Установление deleted_at и deleted_by, контроллер попадает в корзину, его сессии завершаются
 // @Tags Operator
 // @Accept  json
 // @Produce  json
 // @Param operator_id path string true "Operator id"
+// @Param If-Match header string true "ETag"
 // @Success 200 {object} model.Operator
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /controller/{operator_id} [delete]
-func DeleteOperator(ctx *gin.Context) {
-	id := ctx.Param("operator_id")
+func DeleteOperator(sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.Param("operator_id")
 
-	operator, err := model.OperatorDelete(id)
+		version, ok := httputils.IfMatch(ctx)
+		if !ok {
+			return
+		}
 
-	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
-	}
+		operator, err := model.OperatorDelete(httputils.Tenant(ctx), id, version, auth.CurrentUsername(ctx))
 
-	ctx.JSON(http.StatusOK, operator)
+		if err == db.ErrVersionMismatch {
+			httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+			return
+		}
+
+		if err != nil {
+			httputils.NewError(ctx, http.StatusNotFound, err)
+			return
+		}
+
+		sessions.InvalidateUser(httputils.Tenant(ctx), operator.Login)
+		ctx.JSON(http.StatusOK, operator)
+	}
 }
 
 // UpdateOperator Изменеие котнроллера
 // @Summary Изменить котнроллер
-// @Description Изменение котнроллера.
+// @Description Изменение котнроллера. password не меняется: пароль меняет сам контроллер через /login/operator/password
+// @Description или сбрасывает администратор через /controller/{operator_id}/password-reset
 // @Tags Operator
 // @Accept  json
 // @Produce  json
 // @Param operator body model.Operator true "operator json"
 // @Param operator_id path string true "Operator id"
+// @Param If-Match header string true "ETag"
 // @Success 200 {object} model.Operator
 // @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
 // @Security Authorization
 // @Router /controller/{operator_id} [put]
@@ -137,23 +175,165 @@ func UpdateOperator(ctx *gin.Context) {
 	id := ctx.Param("operator_id")
 	var operator model.Operator
 
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
 	if err := ctx.ShouldBindJSON(&operator); err != nil {
 		httputils.NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
 
-	err := operator.HashPassword()
+	resaultOperator, err := model.OperatorUpdate(httputils.Tenant(ctx), id, version, operator)
+
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
 
 	if err != nil {
-		httputils.NewError(ctx, http.StatusOK, err)
+		httputils.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
 
-	resaultOperator, err := model.OperatorUpdate(id, operator)
+	httputils.SetETag(ctx, resaultOperator.Version)
+	ctx.JSON(http.StatusOK, resaultOperator)
+}
+
+// OperatorStatus новое состояние учетной записи контроллера
+type OperatorStatus struct {
+	Status string `json:"status" binding:"required" example:"suspended"`
+}
+
+// ResetCode одноразовый код сброса пароля
+type ResetCode struct {
+	Code     string    `json:"code" example:"04718253"`
+	ExpireAt time.Time `json:"expire_at"`
+}
+
+// SetOperatorStatus Изменение состояния контроллера
+// @Summary Изменить состояние контроллера
+// @Description Активация, приостановка или архивирование контроллера. Приостановленные и архивные контроллеры не могут войти в систему,
+// @Description их сессии завершаются. Архивные остаются в старых журналах
+// @Tags Operator
+// @Accept  json
+// @Produce  json
+// @Param operator_id path string true "Operator id"
+// @Param status body operator.OperatorStatus true "status json"
+// @Success 200 {object} model.ResponseOperator
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /controller/{operator_id}/status [put]
+func SetOperatorStatus(sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.Param("operator_id")
+		var status OperatorStatus
+
+		if err := ctx.ShouldBindJSON(&status); err != nil {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		resaultOperator, err := model.SetOperatorStatus(httputils.Tenant(ctx), id, status.Status)
+
+		if err == model.ErrOperatorStatusInvalid {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		if err != nil {
+			httputils.NewError(ctx, http.StatusNotFound, err)
+			return
+		}
+
+		if resaultOperator.Status != model.OperatorActive {
+			sessions.InvalidateUser(httputils.Tenant(ctx), resaultOperator.Login)
+		}
+		ctx.JSON(http.StatusOK, resaultOperator)
+	}
+}
+
+// ResetOperatorPassword Сброс пароля контроллера
+// @Summary Сбросить пароль контроллера
+// @Description Создание одноразового кода, с которым контроллер входит и задает новый пароль. Код показывается только в этом ответе
+// @Tags Operator
+// @Accept  json
+// @Produce  json
+// @Param operator_id path string true "Operator id"
+// @Success 200 {object} operator.ResetCode
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /controller/{operator_id}/password-reset [post]
+func ResetOperatorPassword(ctx *gin.Context) {
+	id := ctx.Param("operator_id")
+
+	code, err := model.ResetOperatorPassword(httputils.Tenant(ctx), id)
 
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
 	}
 
-	ctx.JSON(http.StatusOK, resaultOperator)
+	ctx.JSON(http.StatusOK, ResetCode{
+		Code:     code,
+		ExpireAt: time.Now().Add(model.ResetCodeTTL),
+	})
+}
+
+// ListOperatorKeys Ключи подписи контроллера
+// @Summary Ключи подписи контроллера
+// @Description Действующий, замененные и отозванные ключи подписи контроллера, начиная с последнего. Закрытые ключи не возвращаются
+// @Tags Operator
+// @Accept  json
+// @Produce  json
+// @Param operator_id path string true "Operator id"
+// @Success 200 {array} model.OperatorKey
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /controller/{operator_id}/keys [get]
+func ListOperatorKeys(ctx *gin.Context) {
+	keys, err := model.OperatorKeys(httputils.Tenant(ctx), ctx.Param("operator_id"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, keys)
+}
+
+// RevokeOperatorKeys Отзыв ключей подписи контроллера
+// @Summary Отозвать ключи подписи контроллера
+// @Description Отзыв всех ключей подписи контроллера, например при увольнении. Росписи, сделанные до отзыва, остаются действительными.
+// @Description Ключи архивных и удаленных контроллеров отзываются автоматически
+// @Tags Operator
+// @Accept  json
+// @Produce  json
+// @Param operator_id path string true "Operator id"
+// @Param revoke body model.OperatorKeyRevoke true "revoke json"
+// @Success 200 {array} model.OperatorKey
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /controller/{operator_id}/keys/revoke [post]
+func RevokeOperatorKeys(ctx *gin.Context) {
+	var revoke model.OperatorKeyRevoke
+
+	if err := ctx.ShouldBindJSON(&revoke); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	keys, err := model.RevokeOperatorKeys(httputils.Tenant(ctx), ctx.Param("operator_id"), auth.CurrentUsername(ctx), revoke)
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, keys)
 }
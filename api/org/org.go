@@ -0,0 +1,312 @@
+package org
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OperatorAssignment контроллеры узла
+type OperatorAssignment struct {
+	Operators []primitive.ObjectID `json:"operators"`
+}
+
+// SchemeAssignment схемы журналов узла
+type SchemeAssignment struct {
+	Schemes []string `json:"schemes" example:"scales_calibration"`
+}
+
+func orgError(ctx *gin.Context, err error) {
+	switch err {
+	case db.ErrVersionMismatch:
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+	case model.ErrOrgHasChildren:
+		httputils.NewError(ctx, http.StatusConflict, err)
+	case model.ErrOrgKindInvalid, model.ErrOrgParentInvalid, model.ErrOrgItemsInvalid, model.ErrOrgOperatorUnknown, model.ErrNameInvalid:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+	}
+}
+
+// ListOrgUnits Получить все узлы организационной структуры
+// @Summary Список узлов организационной структуры
+// @Description Получение списка площадок, цехов и групп позиций. Фильтры: kind, parent, name, title
+// @Tags Org
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /org [get]
+func ListOrgUnits(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	units, err := model.OrgUnitsAll(httputils.Tenant(ctx), query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, units)
+}
+
+// ShowOrgTree Дерево организационной структуры
+// @Summary Дерево организационной структуры
+// @Description Площадки с вложенными цехами и группами позиций
+// @Tags Org
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} model.OrgNode
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /org/tree [get]
+func ShowOrgTree(ctx *gin.Context) {
+	tree, err := model.OrgTree(httputils.Tenant(ctx))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tree)
+}
+
+// ShowOrgUnit Получение конкретного узла
+// @Summary Один узел организационной структуры
+// @Description Получение конкретного узла
+// @Tags Org
+// @Accept  json
+// @Produce  json
+// @Param unit_id path string true "Org unit id"
+// @Param If-None-Match header string false "ETag"
+// @Success 200 {object} model.OrgUnit
+// @Success 304 {string} string ""
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /org/unit/{unit_id} [get]
+func ShowOrgUnit(ctx *gin.Context) {
+	unit, err := model.OrgUnitOne(httputils.Tenant(ctx), ctx.Param("unit_id"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	if httputils.NotModified(ctx, unit.Version) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, unit)
+}
+
+// AddOrgUnit Добавление узла
+// @Summary Добавить узел организационной структуры
+// @Description Добавление площадки (kind = site, без parent), цеха (kind = workshop, parent - площадка)
+// @Description или группы позиций (kind = item_group, parent - цех, items - позиции группы)
+// @Tags Org
+// @Accept  json
+// @Produce  json
+// @Param unit body model.OrgUnit true "org unit json"
+// @Success 200 {object} model.OrgUnit
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /org [post]
+func AddOrgUnit(ctx *gin.Context) {
+	var unit model.OrgUnit
+
+	if err := ctx.ShouldBindJSON(&unit); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	resaultUnit, err := model.AddOrgUnit(httputils.Tenant(ctx), unit)
+
+	if err != nil {
+		orgError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultUnit.Version)
+	ctx.JSON(http.StatusOK, resaultUnit)
+}
+
+// UpdateOrgUnit Изменение узла
+// @Summary Изменить узел организационной структуры
+// @Description Изменение name, title и items узла. Уровень и родитель узла не меняются
+// @Tags Org
+// @Accept  json
+// @Produce  json
+// @Param unit_id path string true "Org unit id"
+// @Param unit body model.OrgUnit true "org unit json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.OrgUnit
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /org/unit/{unit_id} [put]
+func UpdateOrgUnit(ctx *gin.Context) {
+	var unit model.OrgUnit
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&unit); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	resaultUnit, err := model.OrgUnitUpdate(httputils.Tenant(ctx), ctx.Param("unit_id"), version, unit)
+
+	if err != nil {
+		orgError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultUnit.Version)
+	ctx.JSON(http.StatusOK, resaultUnit)
+}
+
+// AssignOperators Закрепление контроллеров за узлом
+// @Summary Закрепить контроллеров
+// @Description Закрепление контроллеров за узлом. Список заменяет прежний.
+// @Description Контроллер видит журналы узла и всех нижестоящих узлов
+// @Tags Org
+// @Accept  json
+// @Produce  json
+// @Param unit_id path string true "Org unit id"
+// @Param operators body org.OperatorAssignment true "operators json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.OrgUnit
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /org/unit/{unit_id}/operators [put]
+func AssignOperators(ctx *gin.Context) {
+	var assignment OperatorAssignment
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&assignment); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	resaultUnit, err := model.AssignOperators(httputils.Tenant(ctx), ctx.Param("unit_id"), version, assignment.Operators)
+
+	if err != nil {
+		orgError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultUnit.Version)
+	ctx.JSON(http.StatusOK, resaultUnit)
+}
+
+// AssignSchemes Закрепление схем журналов за узлом
+// @Summary Закрепить схемы журналов
+// @Description Закрепление схем журналов (по name) за узлом. Список заменяет прежний
+// @Tags Org
+// @Accept  json
+// @Produce  json
+// @Param unit_id path string true "Org unit id"
+// @Param schemes body org.SchemeAssignment true "schemes json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.OrgUnit
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /org/unit/{unit_id}/schemes [put]
+func AssignSchemes(ctx *gin.Context) {
+	var assignment SchemeAssignment
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&assignment); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	resaultUnit, err := model.AssignSchemes(httputils.Tenant(ctx), ctx.Param("unit_id"), version, assignment.Schemes)
+
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultUnit.Version)
+	ctx.JSON(http.StatusOK, resaultUnit)
+}
+
+// DeleteOrgUnit Удаление узла
+// @Summary Удалить узел организационной структуры
+// @Description Удаление узла. Установление deleted_at. Узел с подчиненными узлами удалить нельзя
+// @Tags Org
+// @Accept  json
+// @Produce  json
+// @Param unit_id path string true "Org unit id"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.OrgUnit
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /org/unit/{unit_id} [delete]
+func DeleteOrgUnit(ctx *gin.Context) {
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	unit, err := model.OrgUnitDelete(httputils.Tenant(ctx), ctx.Param("unit_id"), version)
+
+	if err != nil {
+		orgError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, unit)
+}
@@ -1,8 +1,10 @@
-package controller
+package reportScheme
 
 import (
 	"net/http"
 
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
 	"github.com/Oxynger/JournalApp/httputils"
 	"github.com/Oxynger/JournalApp/model"
 	"github.com/gin-gonic/gin"
@@ -10,22 +12,29 @@ import (
 
 // GetReportSchemes Получить все схемы отчетов
 // @Summary Список схем отчетов
-// @Description Метод, который получает все списки отчетов
+// @Description Метод, который получает все списки отчетов. Фильтры: name, title, journal
 // @Tags ReportScheme
 // @Accept  json
 // @Produce  json
-// @Param offset path string true "Offset"
-// @Param limit path string true "Limit"
-// @Success 200 {array} model.ReportScheme
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
-// @Router /scheme/report/getall/{offset}/{limit} [get]
-func (c *Controller) GetReportSchemes(ctx *gin.Context) {
-	offset := ctx.Param("offset")
-	limit := ctx.Param("limit")
-	schemes, err := model.ReportSchemeAll(offset, limit)
+// @Security Authorization
+// @Router /scheme/report [get]
+func GetReportSchemes(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
 	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
+		httputils.ListError(ctx, err)
+		return
+	}
+	schemes, err := model.ReportSchemeAll(httputils.Tenant(ctx), query)
+	if err != nil {
+		httputils.ListError(ctx, err)
 		return
 	}
 	ctx.JSON(http.StatusOK, schemes)
@@ -38,34 +47,43 @@ func (c *Controller) GetReportSchemes(ctx *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param reportscheme_id path string true "ReportScheme id"
+// @Param If-None-Match header string false "ETag"
 // @Success 200 {object} model.ReportScheme
+// @Success 304 {string} string ""
 // @Failure 400 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
-// @Router /scheme/report/getone/{reportscheme_id} [get]
-func (c *Controller) GetReportScheme(ctx *gin.Context) {
+// @Security Authorization
+// @Router /scheme/report/{reportscheme_id} [get]
+func GetReportScheme(ctx *gin.Context) {
 	id := ctx.Param("reportscheme_id")
-	scheme, err := model.ReportSchemeOne(id)
+	scheme, err := model.ReportSchemeOne(httputils.Tenant(ctx), id)
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
 		return
 	}
+	if httputils.NotModified(ctx, scheme.Version) {
+		return
+	}
 	ctx.JSON(http.StatusOK, scheme)
 }
 
 // NewReportScheme Создать новую схему отчетов
 // @Summary Новая схема отчетов
-// @Description Метод, который создает новую схему отчетов
+// @Description Метод, который создает новую схему отчетов. Journal должен ссылаться на существующую схему журналов
 // @Tags ReportScheme
 // @Accept  json
 // @Produce  json
 // @Param NewReportScheme body model.NewReportScheme true "New Report Scheme"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
 // @Success 200 {object} model.NewReportScheme
 // @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
 // @Router /scheme/report [post]
-func (c *Controller) NewReportScheme(ctx *gin.Context) {
+func NewReportScheme(ctx *gin.Context) {
 	var newReportScheme model.NewReportScheme
 	if err := ctx.ShouldBindJSON(&newReportScheme); err != nil {
 		httputils.NewError(ctx, http.StatusBadRequest, err)
@@ -76,7 +94,12 @@ func (c *Controller) NewReportScheme(ctx *gin.Context) {
 		return
 	}
 
-	err := newReportScheme.Insert()
+	if err := newReportScheme.CheckReferences(httputils.Tenant(ctx)); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err := newReportScheme.Insert(httputils.Tenant(ctx))
 	if err != nil {
 		httputils.NewError(ctx, http.StatusBadRequest, err)
 		return
@@ -86,21 +109,32 @@ func (c *Controller) NewReportScheme(ctx *gin.Context) {
 
 // UpdateReportScheme Изменить схему отчетов с id
 // @Summary Изменить схему отчетов с id
-// @Description Метод, который изменяет схему отчетов с заданным id
+// @Description Метод, который изменяет схему отчетов с заданным id. Journal должен ссылаться на существующую схему журналов
 // @Tags ReportScheme
 // @Accept  json
 // @Produce  json
 // @Param reportscheme_id path string true "ReportScheme id"
+// @Param If-Match header string true "ETag"
 // @Param UpdateReportScheme body model.ReportScheme true "Update Report Scheme"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
 // @Success 200 {object} model.ReportScheme
 // @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
 // @Router /scheme/report/{reportscheme_id} [put]
-func (c *Controller) UpdateReportScheme(ctx *gin.Context) {
+func UpdateReportScheme(ctx *gin.Context) {
 	id := ctx.Param("reportscheme_id")
 
 	var updateReportScheme model.UpdateReportScheme
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
 	if err := ctx.ShouldBindJSON(&updateReportScheme); err != nil {
 		httputils.NewError(ctx, http.StatusBadRequest, err)
 		return
@@ -110,7 +144,17 @@ func (c *Controller) UpdateReportScheme(ctx *gin.Context) {
 		return
 	}
 
-	err := updateReportScheme.Update(id)
+	if err := updateReportScheme.CheckReferences(httputils.Tenant(ctx)); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err := updateReportScheme.Update(httputils.Tenant(ctx), id, version)
+
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
 
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
@@ -119,21 +163,35 @@ func (c *Controller) UpdateReportScheme(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, updateReportScheme)
 }
 
-// DeleteReportScheme Удалить схему объектов с id
-// @Summary Удалить схему объектов с id
-// @Description Метод, который удаляет схему объектов с заданным id
+// DeleteReportScheme Удалить схему отчетов с id
+// @Summary Удалить схему отчетов с id
+// @Description Метод, который удаляет схему отчетов с заданным id
 // @Tags ReportScheme
 // @Accept  json
 // @Produce  json
-// @Param Reportscheme_id path string true "ReportSheme id"
+// @Param reportscheme_id path string true "ReportScheme id"
+// @Param If-Match header string true "ETag"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
 // @Success 200 {string} string    "5ca10d9d015c736a72b7b3ba"
 // @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
 // @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
 // @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
 // @Router /scheme/report/{reportscheme_id} [delete]
-func (c *Controller) DeleteReportScheme(ctx *gin.Context) {
+func DeleteReportScheme(ctx *gin.Context) {
 	id := ctx.Param("reportscheme_id")
-	err := model.DeleteReportSchemeOne(id)
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+	err := model.DeleteReportSchemeOne(httputils.Tenant(ctx), id, version, auth.CurrentUsername(ctx))
+	if err == db.ErrVersionMismatch {
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+		return
+	}
 	if err != nil {
 		httputils.NewError(ctx, http.StatusNotFound, err)
 		return
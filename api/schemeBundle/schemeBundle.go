@@ -0,0 +1,198 @@
+package schemeBundle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Форматы файла пакета
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+)
+
+// maxBundleSize ограничение на размер загружаемого пакета
+const maxBundleSize = 10 << 20
+
+// Errors godoc
+var (
+	ErrFormatInvalid = errors.New("format must be json or yaml")
+	ErrDryRunInvalid = errors.New("dry_run must be true or false")
+)
+
+// names разбирает список имен через запятую
+func names(value string) []string {
+	result := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); len(name) != 0 {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// plain приводит значения, разобранные из YAML, к виду, который можно записать в JSON
+func plain(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[fmt.Sprint(key)] = plain(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range v {
+			v[i] = plain(item)
+		}
+	}
+	return value
+}
+
+// decodeBundle читает пакет в JSON или YAML. YAML переводится в JSON, чтобы поля разбирались по тегам json
+func decodeBundle(data []byte, yamlBody bool) (model.SchemeBundle, error) {
+	var bundle model.SchemeBundle
+	if yamlBody {
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return bundle, err
+		}
+		var err error
+		if data, err = json.Marshal(plain(raw)); err != nil {
+			return bundle, err
+		}
+	}
+	err := json.Unmarshal(data, &bundle)
+	return bundle, err
+}
+
+// ExportSchemes Выгрузка пакета схем
+// @Summary Выгрузка пакета схем
+// @Description Метод, который выгружает схемы объектов, схемы журналов, которые на них ссылаются через Item,
+// @Description и схемы отчетов, которые ссылаются на эти схемы журналов через Journal, одним файлом.
+// @Description Без items и journals выгружаются все схемы площадки
+// @Tags SchemeBundle
+// @Produce  json
+// @Produce  application/x-yaml
+// @Param items query string false "Item scheme names, comma separated"
+// @Param journals query string false "Journal scheme names, comma separated"
+// @Param format query string false "json (default) or yaml"
+// @Success 200 {object} model.SchemeBundle
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/bundle [get]
+func ExportSchemes(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", formatJSON)
+	if format != formatJSON && format != formatYAML {
+		httputils.NewError(ctx, http.StatusBadRequest, ErrFormatInvalid)
+		return
+	}
+
+	bundle, err := model.ExportSchemes(httputils.Tenant(ctx), model.BundleSelection{
+		Items:    names(ctx.Query("items")),
+		Journals: names(ctx.Query("journals")),
+	})
+	if err != nil {
+		httputils.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		httputils.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	contentType := "application/json; charset=utf-8"
+
+	if format == formatYAML {
+		var raw interface{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			httputils.NewError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		if data, err = yaml.Marshal(raw); err != nil {
+			httputils.NewError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		contentType = "application/x-yaml; charset=utf-8"
+	}
+
+	filename := "schemes_" + bundle.ExportedAt.Format("20060102_150405") + "." + format
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	ctx.Data(http.StatusOK, contentType, data)
+}
+
+// ImportSchemes Загрузка пакета схем
+// @Summary Загрузка пакета схем
+// @Description Метод, который загружает пакет схем в JSON или YAML (Content-Type application/x-yaml или text/yaml).
+// @Description Схемы загружаются в порядке: объекты, журналы, отчеты. Ссылки Item и Journal разрешаются на схемы пакета или на схемы площадки.
+// @Description on_conflict задает, что делать со схемой, имя которой уже занято: skip - оставить существующую,
+// @Description overwrite - заменить ее, rename - сохранить под именем name_2, name_3... и исправить ссылки на нее в пакете.
+// @Description С dry_run=true ничего не сохраняется, возвращается отчет о том, что было бы сделано
+// @Tags SchemeBundle
+// @Accept  json
+// @Accept  application/x-yaml
+// @Produce  json
+// @Param SchemeBundle body model.SchemeBundle true "Scheme bundle"
+// @Param on_conflict query string false "skip (default), overwrite or rename"
+// @Param dry_run query bool false "Only report what would be done"
+// @Param X-Confirmation-Token header string true "Токен подтверждения из /login/confirm"
+// @Success 200 {object} model.ImportReport
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 413 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /scheme/bundle [post]
+func ImportSchemes(ctx *gin.Context) {
+	var dryRun bool
+	switch ctx.DefaultQuery("dry_run", "false") {
+	case "true", "1":
+		dryRun = true
+	case "false", "0":
+	default:
+		httputils.NewError(ctx, http.StatusBadRequest, ErrDryRunInvalid)
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBundleSize))
+	if err != nil {
+		httputils.NewError(ctx, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+	yamlBody := mediaType == "application/x-yaml" || mediaType == "application/yaml" || mediaType == "text/yaml"
+
+	bundle, err := decodeBundle(data, yamlBody)
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := model.ImportSchemes(httputils.Tenant(ctx), bundle, model.ImportOptions{
+		OnConflict: ctx.Query("on_conflict"),
+		DryRun:     dryRun,
+	})
+	if err != nil {
+		switch err {
+		case model.ErrBundleFormat, model.ErrBundleVersion, model.ErrConflictInvalid:
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+		default:
+			httputils.NewError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
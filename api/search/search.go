@@ -0,0 +1,56 @@
+package search
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultLimit = 20
+
+var errLimitInvalid = errors.New("limit must be a positive integer")
+
+// Search Полнотекстовый поиск
+// @Summary Поиск
+// @Description Поиск по журналам, схемам журналов и контроллерам. Результаты отсортированы по релевантности, совпадения выделены тегом <em>, остальной текст фрагментов экранирован для HTML
+// @Tags Search
+// @Accept  json
+// @Produce  json
+// @Param q query string true "Текст запроса"
+// @Param type query string false "Типы результатов через запятую: journal, journal_scheme, operator"
+// @Param limit query int false "Количество результатов"
+// @Success 200 {array} model.SearchResult
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /search [get]
+func Search(ctx *gin.Context) {
+	var types []string
+	if kinds := ctx.Query("type"); len(kinds) != 0 {
+		types = strings.Split(kinds, ",")
+	}
+
+	limit := int64(defaultLimit)
+	if value := ctx.Query("limit"); len(value) != 0 {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			httputils.NewError(ctx, http.StatusBadRequest, errLimitInvalid)
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := model.Search(httputils.Tenant(ctx), ctx.Query("q"), types, limit)
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
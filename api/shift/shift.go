@@ -0,0 +1,467 @@
+package shift
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/Oxynger/JournalApp/model/user"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OperatorAssignment контроллеры смены
+type OperatorAssignment struct {
+	Operators []primitive.ObjectID `json:"operators"`
+}
+
+// Handover открытые вопросы, которые уходящий контроллер передает следующей смене
+type Handover struct {
+	Issues []model.HandoverIssue `json:"issues"`
+}
+
+// Acknowledgement подтверждение приема смены
+type Acknowledgement struct {
+	Comment string `json:"comment" example:"Принял, весы 12 на поверке"`
+}
+
+func shiftError(ctx *gin.Context, err error) {
+	switch err {
+	case db.ErrVersionMismatch:
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+	case model.ErrNotOnShift, model.ErrHandoverSameOperator:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+	case model.ErrShiftHandedOver, model.ErrHandoverAcknowledged:
+		httputils.NewError(ctx, http.StatusConflict, err)
+	case model.ErrShiftStartInvalid, model.ErrShiftDurationInvalid, model.ErrShiftWeekdaysInvalid, model.ErrShiftWorkshopInvalid,
+		model.ErrShiftPeriodInvalid, model.ErrShiftOperatorsInvalid, model.ErrNameInvalid:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+	}
+}
+
+// sessionUser имя пользователя сессии и признак того, что это контроллер
+func sessionUser(ctx *gin.Context) (string, bool) {
+	session, ok := auth.CurrentSession(ctx)
+	if !ok {
+		return "", true
+	}
+	return session.Username, session.Role == user.Operator
+}
+
+// ListShiftTemplates Получить все шаблоны смен
+// @Summary Список шаблонов смен
+// @Description Получение списка шаблонов смен
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} model.ShiftTemplate
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/template [get]
+func ListShiftTemplates(ctx *gin.Context) {
+	templates, err := model.ShiftTemplatesAll(httputils.Tenant(ctx))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, templates)
+}
+
+// AddShiftTemplate Добавление шаблона смены
+// @Summary Добавить шаблон смены
+// @Description Добавление шаблона смены: время начала (HH:MM), продолжительность в минутах, дни недели и цех
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param template body model.ShiftTemplate true "shift template json"
+// @Success 200 {object} model.ShiftTemplate
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/template [post]
+func AddShiftTemplate(ctx *gin.Context) {
+	var template model.ShiftTemplate
+
+	if err := ctx.ShouldBindJSON(&template); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	resaultTemplate, err := model.AddShiftTemplate(httputils.Tenant(ctx), template)
+
+	if err != nil {
+		shiftError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultTemplate.Version)
+	ctx.JSON(http.StatusOK, resaultTemplate)
+}
+
+// UpdateShiftTemplate Изменение шаблона смены
+// @Summary Изменить шаблон смены
+// @Description Изменение шаблона смены. Уже составленный график не меняется
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param template_id path string true "Shift template id"
+// @Param template body model.ShiftTemplate true "shift template json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.ShiftTemplate
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/template/{template_id} [put]
+func UpdateShiftTemplate(ctx *gin.Context) {
+	var template model.ShiftTemplate
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&template); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	resaultTemplate, err := model.ShiftTemplateUpdate(httputils.Tenant(ctx), ctx.Param("template_id"), version, template)
+
+	if err != nil {
+		shiftError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultTemplate.Version)
+	ctx.JSON(http.StatusOK, resaultTemplate)
+}
+
+// DeleteShiftTemplate Удаление шаблона смены
+// @Summary Удалить шаблон смены
+// @Description Удаление шаблона смены. Установление deleted_at. Уже составленный график не меняется
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param template_id path string true "Shift template id"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.ShiftTemplate
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/template/{template_id} [delete]
+func DeleteShiftTemplate(ctx *gin.Context) {
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	template, err := model.ShiftTemplateDelete(httputils.Tenant(ctx), ctx.Param("template_id"), version)
+
+	if err != nil {
+		shiftError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, template)
+}
+
+// ListShifts Получить график смен
+// @Summary График смен
+// @Description Получение смен графика. Фильтры: template, workshop, start, end
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/calendar [get]
+func ListShifts(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	shifts, err := model.ShiftsAll(httputils.Tenant(ctx), query)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, shifts)
+}
+
+// GenerateShifts Составление графика смен
+// @Summary Составить график смен
+// @Description Составление графика смен по шаблону на дни [from, to), не больше 92 дней.
+// @Description Смены, которые уже есть в графике, не дублируются
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param calendar body model.ShiftCalendar true "calendar json"
+// @Success 200 {array} model.Shift
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/calendar [post]
+func GenerateShifts(ctx *gin.Context) {
+	var calendar model.ShiftCalendar
+
+	if err := ctx.ShouldBindJSON(&calendar); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	shifts, err := model.GenerateShifts(httputils.Tenant(ctx), calendar)
+
+	if err != nil {
+		shiftError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, shifts)
+}
+
+// ShowShift Получение конкретной смены
+// @Summary Одна смена
+// @Description Получение конкретной смены графика
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param shift_id path string true "Shift id"
+// @Param If-None-Match header string false "ETag"
+// @Success 200 {object} model.Shift
+// @Success 304 {string} string ""
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/calendar/{shift_id} [get]
+func ShowShift(ctx *gin.Context) {
+	shift, err := model.ShiftOne(httputils.Tenant(ctx), ctx.Param("shift_id"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	if httputils.NotModified(ctx, shift.Version) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, shift)
+}
+
+// SetShiftOperators Назначение контроллеров на смену
+// @Summary Назначить контроллеров на смену
+// @Description Назначение контроллеров на смену. Список заменяет прежний
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param shift_id path string true "Shift id"
+// @Param operators body shift.OperatorAssignment true "operators json"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.Shift
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/calendar/{shift_id}/operators [put]
+func SetShiftOperators(ctx *gin.Context) {
+	var assignment OperatorAssignment
+
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&assignment); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	resaultShift, err := model.SetShiftOperators(httputils.Tenant(ctx), ctx.Param("shift_id"), version, assignment.Operators)
+
+	if err != nil {
+		shiftError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultShift.Version)
+	ctx.JSON(http.StatusOK, resaultShift)
+}
+
+// DeleteShift Удаление смены из графика
+// @Summary Удалить смену
+// @Description Удаление смены из графика. Установление deleted_at
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param shift_id path string true "Shift id"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.Shift
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/calendar/{shift_id} [delete]
+func DeleteShift(ctx *gin.Context) {
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	shift, err := model.ShiftDelete(httputils.Tenant(ctx), ctx.Param("shift_id"), version)
+
+	if err != nil {
+		shiftError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, shift)
+}
+
+// CurrentShifts Текущие смены
+// @Summary Текущие смены
+// @Description Смены, которые идут сейчас
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} model.Shift
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/current [get]
+func CurrentShifts(ctx *gin.Context) {
+	shifts, err := model.ActiveShifts(httputils.Tenant(ctx), time.Now())
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, shifts)
+}
+
+// HandOverShift Передача смены
+// @Summary Передать смену
+// @Description Уходящий контроллер подписывает передачу смены и перечисляет открытые вопросы.
+// @Description Журналы смены без росписи за смену или за ее день добавляются в вопросы автоматически.
+// @Description Контроллер может передать только смену, в которой работает
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param shift_id path string true "Shift id"
+// @Param handover body shift.Handover true "handover json"
+// @Success 200 {object} model.ShiftHandover
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/calendar/{shift_id}/handover [post]
+func HandOverShift(ctx *gin.Context) {
+	var handover Handover
+
+	if err := ctx.ShouldBindJSON(&handover); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	by, operatorOnly := sessionUser(ctx)
+	resaultHandover, err := model.HandOverShift(httputils.Tenant(ctx), ctx.Param("shift_id"), by, operatorOnly, handover.Issues)
+
+	if err != nil {
+		shiftError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultHandover.Version)
+	ctx.JSON(http.StatusOK, resaultHandover)
+}
+
+// ShowHandover Передача смены
+// @Summary Передача смены
+// @Description Получение передачи смены с открытыми вопросами
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param shift_id path string true "Shift id"
+// @Success 200 {object} model.ShiftHandover
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/calendar/{shift_id}/handover [get]
+func ShowHandover(ctx *gin.Context) {
+	handover, err := model.ShiftHandoverOf(httputils.Tenant(ctx), ctx.Param("shift_id"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	httputils.SetETag(ctx, handover.Version)
+	ctx.JSON(http.StatusOK, handover)
+}
+
+// AcknowledgeHandover Прием смены
+// @Summary Принять смену
+// @Description Приходящий контроллер подтверждает, что принял смену и открытые вопросы.
+// @Description Контроллер может принять только смену, в которой работает, и не может принять собственную передачу
+// @Tags Shift
+// @Accept  json
+// @Produce  json
+// @Param handover_id path string true "Handover id"
+// @Param acknowledgement body shift.Acknowledgement true "acknowledgement json"
+// @Success 200 {object} model.ShiftHandover
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /shift/handover/{handover_id}/acknowledge [post]
+func AcknowledgeHandover(ctx *gin.Context) {
+	var acknowledgement Acknowledgement
+
+	if err := ctx.ShouldBindJSON(&acknowledgement); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	by, operatorOnly := sessionUser(ctx)
+	resaultHandover, err := model.AcknowledgeHandover(httputils.Tenant(ctx), ctx.Param("handover_id"), by, operatorOnly, acknowledgement.Comment)
+
+	if err != nil {
+		shiftError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, resaultHandover.Version)
+	ctx.JSON(http.StatusOK, resaultHandover)
+}
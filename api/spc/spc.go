@@ -0,0 +1,117 @@
+package spc
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultPeriod = 30 * 24 * time.Hour
+
+// LimitsRequest параметры расчета контрольных границ
+type LimitsRequest struct {
+	Scheme string    `json:"scheme" binding:"required" example:"scales_calibration"`
+	Field  string    `json:"field" binding:"required" example:"result"`
+	Item   string    `json:"item" binding:"required" example:"scale"`
+	Chart  string    `json:"chart" binding:"required" example:"imr"`
+	From   time.Time `json:"from" binding:"required"`
+	To     time.Time `json:"to" binding:"required"`
+}
+
+// ComputeLimits Расчет контрольных границ
+// @Summary Рассчитать контрольные границы
+// @Description Расчет границ карты imr или xbar_r по значениям поля журналов позиции за базовый период. Ранее рассчитанные границы заменяются
+// @Tags SPC
+// @Accept  json
+// @Produce  json
+// @Param limits body spc.LimitsRequest true "limits json"
+// @Success 200 {object} model.ControlLimits
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /spc/limits [post]
+func ComputeLimits(ctx *gin.Context) {
+	var request LimitsRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	limits, err := model.ComputeControlLimits(httputils.Tenant(ctx), request.Scheme, request.Field, request.Item, request.Chart, request.From, request.To)
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, limits)
+}
+
+// ListLimits Список контрольных границ
+// @Summary Контрольные границы схемы журнала
+// @Description Получение рассчитанных контрольных границ схемы журнала
+// @Tags SPC
+// @Accept  json
+// @Produce  json
+// @Param scheme path string true "Имя схемы журнала"
+// @Param field query string false "Имя поля"
+// @Param item query string false "Позиция"
+// @Success 200 {array} model.ControlLimits
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /spc/limits/{scheme} [get]
+func ListLimits(ctx *gin.Context) {
+	limits, err := model.ControlLimitsAll(httputils.Tenant(ctx), ctx.Param("scheme"), ctx.Query("field"), ctx.Query("item"))
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, limits)
+}
+
+// ShowChart Контрольная карта
+// @Summary Контрольная карта
+// @Description Точки контрольной карты поля позиции за период с нарушениями правил Western Electric
+// @Tags SPC
+// @Accept  json
+// @Produce  json
+// @Param scheme path string true "Имя схемы журнала"
+// @Param field query string true "Имя поля"
+// @Param item query string true "Позиция"
+// @Param from query string false "Начало периода, по умолчанию 30 дней назад"
+// @Param to query string false "Конец периода, по умолчанию текущее время"
+// @Success 200 {object} model.SPCChart
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /spc/chart/{scheme} [get]
+func ShowChart(ctx *gin.Context) {
+	to, err := httputils.ParseDate(ctx.Query("to"), time.Now())
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	from, err := httputils.ParseDate(ctx.Query("from"), to.Add(-defaultPeriod))
+	if err != nil {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	chart, err := model.ControlChart(httputils.Tenant(ctx), ctx.Param("scheme"), ctx.Query("field"), ctx.Query("item"), from, to)
+
+	if err != nil {
+		httputils.NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, chart)
+}
@@ -0,0 +1,189 @@
+package tenants
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model/tenant"
+	"github.com/Oxynger/JournalApp/service"
+	"github.com/gin-gonic/gin"
+)
+
+func tenantError(ctx *gin.Context, err error) {
+	switch err {
+	case service.ErrTenantInvalid:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+	case service.ErrTenantDisabled:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+	}
+}
+
+// ListTenants Получить все площадки
+// @Summary Список площадок
+// @Description Получение списка площадок. Доступно только суперадминистратору
+// @Tags Tenant
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} tenant.Tenant
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /tenants [get]
+func ListTenants(tenants *service.TenantService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		list, err := tenants.List()
+
+		if err != nil {
+			httputils.NewError(ctx, http.StatusNotFound, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, list)
+	}
+}
+
+// ShowTenant Получение конкретной площадки
+// @Summary Одна площадка
+// @Description Получение конкретной площадки
+// @Tags Tenant
+// @Accept  json
+// @Produce  json
+// @Param tenant_id path string true "Tenant id"
+// @Success 200 {object} tenant.Tenant
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /tenants/{tenant_id} [get]
+func ShowTenant(tenants *service.TenantService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		t, err := tenants.Find(db.Tenant(ctx.Param("tenant_id")))
+
+		if err != nil {
+			tenantError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, t)
+	}
+}
+
+// AddTenant Добавление площадки
+// @Summary Добавить площадку
+// @Description Добавление площадки. id - 2-32 строчные латинские буквы, цифры или _, начинается с буквы.
+// @Description В режиме TENANCY=database для площадки создается отдельная база
+// @Tags Tenant
+// @Accept  json
+// @Produce  json
+// @Param tenant body tenant.Tenant true "tenant json"
+// @Success 200 {object} tenant.Tenant
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /tenants [post]
+func AddTenant(tenants *service.TenantService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var t tenant.Tenant
+
+		if err := ctx.ShouldBindJSON(&t); err != nil {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		created, err := tenants.Create(t)
+
+		if err != nil {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, created)
+	}
+}
+
+// DisableTenant Блокировка площадки
+// @Summary Заблокировать площадку
+// @Description Блокировка площадки. Пользователи и контроллеры площадки не могут войти, их сессии завершаются
+// @Tags Tenant
+// @Accept  json
+// @Produce  json
+// @Param tenant_id path string true "Tenant id"
+// @Success 200 {object} tenant.Tenant
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /tenants/{tenant_id}/disable [post]
+func DisableTenant(tenants *service.TenantService, sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		t, err := tenants.SetDisabled(db.Tenant(ctx.Param("tenant_id")), true)
+
+		if err != nil {
+			tenantError(ctx, err)
+			return
+		}
+
+		sessions.InvalidateTenant(t.ID)
+		ctx.JSON(http.StatusOK, t)
+	}
+}
+
+// EnableTenant Разблокировка площадки
+// @Summary Разблокировать площадку
+// @Description Разблокировка площадки
+// @Tags Tenant
+// @Accept  json
+// @Produce  json
+// @Param tenant_id path string true "Tenant id"
+// @Success 200 {object} tenant.Tenant
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /tenants/{tenant_id}/enable [post]
+func EnableTenant(tenants *service.TenantService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		t, err := tenants.SetDisabled(db.Tenant(ctx.Param("tenant_id")), false)
+
+		if err != nil {
+			tenantError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, t)
+	}
+}
+
+// SwitchTenant Переключение сессии на площадку
+// @Summary Работать с площадкой
+// @Description Переключение текущей сессии суперадминистратора на площадку. Дальнейшие запросы с этим токеном работают с данными площадки
+// @Tags Tenant
+// @Accept  json
+// @Produce  json
+// @Param tenant_id path string true "Tenant id"
+// @Success 200 {object} tenant.Tenant
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /tenants/{tenant_id}/switch [post]
+func SwitchTenant(tenants *service.TenantService, sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := db.Tenant(ctx.Param("tenant_id"))
+
+		if err := tenants.Active(id); err != nil {
+			tenantError(ctx, err)
+			return
+		}
+
+		t, err := tenants.Find(id)
+
+		if err != nil {
+			tenantError(ctx, err)
+			return
+		}
+
+		sessions.SwitchTenant(ctx.GetHeader("X-Auth-Token"), t.ID)
+		ctx.JSON(http.StatusOK, t)
+	}
+}
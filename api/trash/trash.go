@@ -0,0 +1,137 @@
+package trash
+
+import (
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/gin-gonic/gin"
+)
+
+// trashError отвечает на ошибку восстановления или окончательного удаления
+func trashError(ctx *gin.Context, err error) {
+	switch err {
+	case model.ErrTrashResourceInvalid:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+	case db.ErrVersionMismatch:
+		httputils.NewError(ctx, http.StatusPreconditionFailed, err)
+	case model.ErrTrashNameTaken, model.ErrTrashReferenced, model.ErrItemSchemeMissing, model.ErrJournalSchemeMissing, model.ErrRetentionPeriod:
+		httputils.NewError(ctx, http.StatusConflict, err)
+	case model.ErrLegalHold:
+		httputils.NewError(ctx, http.StatusLocked, err)
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+	}
+}
+
+// ListTrash Содержимое корзины
+// @Summary Корзина
+// @Description Получение удаленных объектов ресурса: journal, controller, item_scheme, journal_scheme или report_scheme.
+// @Description Фильтры: deleted_at, deleted_by, name, scheme, login
+// @Tags Trash
+// @Accept  json
+// @Produce  json
+// @Param resource path string true "journal, controller, item_scheme, journal_scheme or report_scheme"
+// @Param filter query string false "Фильтр: filter[field]=op:value"
+// @Param sort query string false "Сортировка: sort=-field,field"
+// @Param limit query int false "Количество записей на странице"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Success 200 {object} db.Page
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /trash/{resource} [get]
+func ListTrash(ctx *gin.Context) {
+	query, err := httputils.ParseQuery(ctx)
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	entries, err := model.TrashAll(httputils.Tenant(ctx), ctx.Param("resource"), query)
+
+	if err == model.ErrTrashResourceInvalid {
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err != nil {
+		httputils.ListError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, entries)
+}
+
+// RestoreTrash Восстановление из корзины
+// @Summary Восстановить объект
+// @Description Восстановление удаленного объекта. Схему нельзя восстановить, если ее имя занято или схема, на которую она ссылается, удалена
+// @Tags Trash
+// @Accept  json
+// @Produce  json
+// @Param resource path string true "journal, controller, item_scheme, journal_scheme or report_scheme"
+// @Param id path string true "Object id"
+// @Param If-Match header string true "ETag"
+// @Success 200 {object} model.TrashEntry
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /trash/{resource}/{id}/restore [post]
+func RestoreTrash(ctx *gin.Context) {
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	entry, err := model.TrashRestore(httputils.Tenant(ctx), ctx.Param("resource"), ctx.Param("id"), version)
+
+	if err != nil {
+		trashError(ctx, err)
+		return
+	}
+
+	httputils.SetETag(ctx, entry.Version)
+	ctx.JSON(http.StatusOK, entry)
+}
+
+// PurgeTrash Окончательное удаление
+// @Summary Удалить из корзины
+// @Description Окончательное удаление объекта из корзины. У журнала удаляются и вложения. Журнал на удержании или в пределах минимального срока хранения схемы удалить нельзя.
+// @Description Контроллер с росписями, ключами или корректирующими действиями и схему, которую используют журналы, схемы или корректирующие действия, удалить нельзя
+// @Tags Trash
+// @Accept  json
+// @Produce  json
+// @Param resource path string true "journal, controller, item_scheme, journal_scheme or report_scheme"
+// @Param id path string true "Object id"
+// @Param If-Match header string true "ETag"
+// @Success 200
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 412 {object} httputils.HTTPError
+// @Failure 423 {object} httputils.HTTPError
+// @Failure 428 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /trash/{resource}/{id} [delete]
+func PurgeTrash(ctx *gin.Context) {
+	version, ok := httputils.IfMatch(ctx)
+	if !ok {
+		return
+	}
+
+	err := model.TrashPurge(httputils.Tenant(ctx), ctx.Param("resource"), ctx.Param("id"), version)
+
+	if err != nil {
+		trashError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
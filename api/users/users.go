@@ -0,0 +1,264 @@
+package users
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/httputils"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/Oxynger/JournalApp/model/user"
+	"github.com/Oxynger/JournalApp/service"
+	"github.com/gin-gonic/gin"
+)
+
+// RoleUpdate новая роль пользователя
+type RoleUpdate struct {
+	Role user.Role `json:"role" example:"1"`
+}
+
+// errSuperAdminRole godoc
+var errSuperAdminRole = errors.New("only super administrator can grant SuperAdmin role")
+
+// grantsRole проверяет, что текущий пользователь может выдать роль. SuperAdmin выдает только суперадминистратор
+func grantsRole(ctx *gin.Context, role user.Role) bool {
+	if role != user.SuperAdmin {
+		return true
+	}
+	session, ok := auth.CurrentSession(ctx)
+	return ok && session.Role == user.SuperAdmin
+}
+
+// currentSession сессия пользователя, который меняет учетную запись
+func currentSession(ctx *gin.Context) *service.Session {
+	session, _ := auth.CurrentSession(ctx)
+	return session
+}
+
+func userError(ctx *gin.Context, err error) {
+	switch err {
+	case service.ErrSuperAdminTarget:
+		httputils.NewError(ctx, http.StatusForbidden, err)
+	case service.ErrUsernameInvalid, model.ErrPasswordPolicy:
+		httputils.NewError(ctx, http.StatusBadRequest, err)
+	case service.ErrUsernameTaken, service.ErrSelfChange, service.ErrLastAdministrator:
+		httputils.NewError(ctx, http.StatusConflict, err)
+	default:
+		httputils.NewError(ctx, http.StatusNotFound, err)
+	}
+}
+
+// ListUsers Получить всех пользователей
+// @Summary Список пользователей
+// @Description Получение списка пользователей площадки текущей сессии. Хеши паролей не возвращаются
+// @Tags User
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} user.User
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /users [get]
+func ListUsers(users *service.UserService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		list, err := users.List(httputils.Tenant(ctx))
+
+		if err != nil {
+			httputils.NewError(ctx, http.StatusNotFound, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, list)
+	}
+}
+
+// ShowUser Получение конкретного пользователя
+// @Summary Один пользователь
+// @Description Получение конкретного пользователя
+// @Tags User
+// @Accept  json
+// @Produce  json
+// @Param user_id path string true "User id"
+// @Success 200 {object} user.User
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /users/{user_id} [get]
+func ShowUser(users *service.UserService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		usr, err := users.FindByID(httputils.Tenant(ctx), ctx.Param("user_id"))
+
+		if err != nil {
+			httputils.NewError(ctx, http.StatusNotFound, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, usr)
+	}
+}
+
+// AddUser Добавление пользователя
+// @Summary Добавить пользователя
+// @Description Добавление пользователя на площадку текущей сессии. Пароль должен соответствовать политике паролей, имя пользователя - быть свободным
+// @Tags User
+// @Accept  json
+// @Produce  json
+// @Param user body user.User true "user json"
+// @Success 200 {object} user.User
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /users [post]
+func AddUser(users *service.UserService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var usr user.User
+
+		if err := ctx.ShouldBindJSON(&usr); err != nil {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		if !grantsRole(ctx, usr.Role) {
+			httputils.NewError(ctx, http.StatusForbidden, errSuperAdminRole)
+			return
+		}
+
+		usr.Tenant = httputils.Tenant(ctx)
+		if err := users.Create(usr); err != nil {
+			userError(ctx, err)
+			return
+		}
+
+		usr.Password = ""
+		ctx.JSON(http.StatusOK, usr)
+	}
+}
+
+// UpdateUserRole Изменение роли пользователя
+// @Summary Изменить роль пользователя
+// @Description Изменение роли пользователя: 0 - Operator, 1 - Administrator, 2 - Helpdesk, 3 - SuperAdmin. Сессии пользователя завершаются.
+// @Description Понизить себя или последнего действующего администратора площадки нельзя. Роль суперадминистратора меняет только суперадминистратор
+// @Tags User
+// @Accept  json
+// @Produce  json
+// @Param user_id path string true "User id"
+// @Param role body users.RoleUpdate true "role json"
+// @Success 200 {object} user.User
+// @Failure 400 {object} httputils.HTTPError
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /users/{user_id}/role [put]
+func UpdateUserRole(users *service.UserService, sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var update RoleUpdate
+
+		if err := ctx.ShouldBindJSON(&update); err != nil {
+			httputils.NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		if !grantsRole(ctx, update.Role) {
+			httputils.NewError(ctx, http.StatusForbidden, errSuperAdminRole)
+			return
+		}
+
+		usr, err := users.UpdateRole(httputils.Tenant(ctx), ctx.Param("user_id"), update.Role, currentSession(ctx))
+
+		if err != nil {
+			userError(ctx, err)
+			return
+		}
+
+		sessions.InvalidateUser(httputils.Tenant(ctx), usr.Username)
+		ctx.JSON(http.StatusOK, usr)
+	}
+}
+
+// DisableUser Блокировка пользователя
+// @Summary Заблокировать пользователя
+// @Description Блокировка пользователя. Заблокированный пользователь не может войти, его сессии завершаются.
+// @Description Заблокировать себя или последнего действующего администратора площадки нельзя. Суперадминистратора блокирует только суперадминистратор
+// @Tags User
+// @Accept  json
+// @Produce  json
+// @Param user_id path string true "User id"
+// @Success 200 {object} user.User
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /users/{user_id}/disable [post]
+func DisableUser(users *service.UserService, sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		usr, err := users.SetDisabled(httputils.Tenant(ctx), ctx.Param("user_id"), true, currentSession(ctx))
+
+		if err != nil {
+			userError(ctx, err)
+			return
+		}
+
+		sessions.InvalidateUser(httputils.Tenant(ctx), usr.Username)
+		ctx.JSON(http.StatusOK, usr)
+	}
+}
+
+// EnableUser Разблокировка пользователя
+// @Summary Разблокировать пользователя
+// @Description Разблокировка пользователя. Суперадминистратора разблокирует только суперадминистратор
+// @Tags User
+// @Accept  json
+// @Produce  json
+// @Param user_id path string true "User id"
+// @Success 200 {object} user.User
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /users/{user_id}/enable [post]
+func EnableUser(users *service.UserService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		usr, err := users.SetDisabled(httputils.Tenant(ctx), ctx.Param("user_id"), false, currentSession(ctx))
+
+		if err != nil {
+			userError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, usr)
+	}
+}
+
+// DeleteUser Удаление пользователя
+// @Summary Удалить пользователя
+// @Description Удаление пользователя. Установление deleted_at, сессии пользователя завершаются.
+// @Description Удалить себя или последнего действующего администратора площадки нельзя. Суперадминистратора удаляет только суперадминистратор
+// @Tags User
+// @Accept  json
+// @Produce  json
+// @Param user_id path string true "User id"
+// @Success 200 {object} user.User
+// @Failure 403 {object} httputils.HTTPError
+// @Failure 404 {object} httputils.HTTPError
+// @Failure 409 {object} httputils.HTTPError
+// @Failure 500 {object} httputils.HTTPError
+// @Security Authorization
+// @Router /users/{user_id} [delete]
+func DeleteUser(users *service.UserService, sessions *service.SessionService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		usr, err := users.Delete(httputils.Tenant(ctx), ctx.Param("user_id"), currentSession(ctx))
+
+		if err != nil {
+			userError(ctx, err)
+			return
+		}
+
+		sessions.InvalidateUser(httputils.Tenant(ctx), usr.Username)
+		ctx.JSON(http.StatusOK, usr)
+	}
+}
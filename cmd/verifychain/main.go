@@ -0,0 +1,77 @@
+// Команда verifychain проверяет цепочки хешей росписей журналов и печатает разрывы.
+// Завершается с кодом 1, если хотя бы одна цепочка нарушена.
+//
+//	verifychain [-tenant plant_1] [-journal 5ca10d9d015c736a72b7b3ba]
+//
+// Подключение к базе берется из тех же переменных среды, что и у сервера
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/Oxynger/JournalApp/service"
+	"github.com/spf13/viper"
+)
+
+func main() {
+	tenantID := flag.String("tenant", "", "площадка; по умолчанию все площадки")
+	journalID := flag.String("journal", "", "журнал; по умолчанию все журналы площадки")
+	flag.Parse()
+
+	viper.AutomaticEnv()
+	viper.SetDefault("mongodb_uri", "mongodb://localhost:27017")
+	db.Connect(viper.GetString("mongodb_uri"))
+
+	tenants := []db.Tenant{db.Tenant(*tenantID)}
+	if len(*tenantID) == 0 {
+		list, err := service.NewTenantService().List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tenants = tenants[:0]
+		for _, t := range list {
+			tenants = append(tenants, t.ID)
+		}
+	}
+	if len(*journalID) != 0 && len(tenants) != 1 {
+		log.Fatal("-journal requires -tenant")
+	}
+
+	var checked, broken int
+	print := func(tenant db.Tenant) func(model.ChainReport) {
+		return func(report model.ChainReport) {
+			checked++
+			if report.Valid {
+				return
+			}
+			broken++
+			for _, b := range report.Breaks {
+				fmt.Printf("%s\t%s\tseq %d\t%s\n", tenant, report.Journal.Hex(), b.Seq, b.Reason)
+			}
+		}
+	}
+
+	for _, tenant := range tenants {
+		if len(*journalID) != 0 {
+			report, err := model.VerifyJournalChain(tenant, *journalID)
+			if err != nil {
+				log.Fatal(err)
+			}
+			print(tenant)(*report)
+			continue
+		}
+		if err := model.VerifyChains(tenant, print(tenant)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Printf("checked %d journals, %d broken\n", checked, broken)
+	if broken != 0 {
+		os.Exit(1)
+	}
+}
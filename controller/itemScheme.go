@@ -1,142 +0,0 @@
-package controller
-
-import (
-	"net/http"
-
-	"github.com/Oxynger/JournalApp/httputils"
-	"github.com/Oxynger/JournalApp/model"
-	"github.com/gin-gonic/gin"
-)
-
-// GetItemSchemes Получить все схемы объектов
-// @Summary Список схем объектов
-// @Description Метод, который получает все списки объектов
-// @Tags ItemScheme
-// @Accept  json
-// @Produce  json
-// @Param offset path string true "Offset"
-// @Param limit path string true "Limit"
-// @Success 200 {array} model.ItemScheme
-// @Failure 404 {object} httputils.HTTPError
-// @Failure 500 {object} httputils.HTTPError
-// @Router /scheme/item/getall/{offset}/{limit} [get]
-func (c *Controller) GetItemSchemes(ctx *gin.Context) {
-	offset := ctx.Param("offset")
-	limit := ctx.Param("limit")
-	schemes, err := model.ItemSchemeAll(offset, limit)
-	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
-		return
-	}
-	ctx.JSON(http.StatusOK, schemes)
-}
-
-// GetItemScheme Получить схему объекта с id
-// @Summary Схему объекта с id
-// @Description Метод, который получает схему объекта с заданным id
-// @Tags ItemScheme
-// @Accept  json
-// @Produce  json
-// @Param itemscheme_id path string true "ItemScheme id"
-// @Success 200 {object} model.ItemScheme
-// @Failure 400 {object} httputils.HTTPError
-// @Failure 404 {object} httputils.HTTPError
-// @Failure 500 {object} httputils.HTTPError
-// @Router /scheme/item/getone/{itemscheme_id} [get]
-func (c *Controller) GetItemScheme(ctx *gin.Context) {
-	id := ctx.Param("itemscheme_id")
-	scheme, err := model.ItemSchemeOne(id)
-	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
-		return
-	}
-	ctx.JSON(http.StatusOK, scheme)
-}
-
-// NewItemScheme Создать новую схему объектов
-// @Summary Новая схема объектов
-// @Description Метод, который создает новую схему объектов
-// @Tags ItemScheme
-// @Accept  json
-// @Produce  json
-// @Param NewItemScheme body model.NewItemScheme true "New Item Scheme"
-// @Success 200 {object} model.NewItemScheme
-// @Failure 400 {object} httputils.HTTPError
-// @Failure 404 {object} httputils.HTTPError
-// @Failure 500 {object} httputils.HTTPError
-// @Router /scheme/item [post]
-func (c *Controller) NewItemScheme(ctx *gin.Context) {
-	var newItemScheme model.NewItemScheme
-	if err := ctx.ShouldBindJSON(&newItemScheme); err != nil {
-		httputils.NewError(ctx, http.StatusBadRequest, err)
-		return
-	}
-	if err := newItemScheme.Validation(); err != nil {
-		httputils.NewError(ctx, http.StatusBadRequest, err)
-		return
-	}
-
-	err := newItemScheme.Insert()
-	if err != nil {
-		httputils.NewError(ctx, http.StatusBadRequest, err)
-		return
-	}
-	ctx.JSON(http.StatusOK, newItemScheme)
-}
-
-// UpdateItemScheme Изменить схему объектов с id
-// @Summary Изменить схему объектов с id
-// @Description Метод, который изменяет схему объекта с заданным id
-// @Tags ItemScheme
-// @Accept  json
-// @Produce  json
-// @Param itemscheme_id path string true "ItemScheme id"
-// @Param UpdateItemScheme body model.ItemScheme true "Update Item Scheme"
-// @Success 200 {object} model.ItemScheme
-// @Failure 400 {object} httputils.HTTPError
-// @Failure 404 {object} httputils.HTTPError
-// @Failure 500 {object} httputils.HTTPError
-// @Router /scheme/item/{itemscheme_id} [put]
-func (c *Controller) UpdateItemScheme(ctx *gin.Context) {
-	id := ctx.Param("itemscheme_id")
-
-	var updateItemScheme model.UpdateItemScheme
-	if err := ctx.ShouldBindJSON(&updateItemScheme); err != nil {
-		httputils.NewError(ctx, http.StatusBadRequest, err)
-		return
-	}
-	if err := updateItemScheme.Validation(); err != nil {
-		httputils.NewError(ctx, http.StatusBadRequest, err)
-		return
-	}
-
-	err := updateItemScheme.Update(id)
-
-	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
-		return
-	}
-	ctx.JSON(http.StatusOK, updateItemScheme)
-}
-
-// DeleteItemScheme Удалить схему объектов с id
-// @Summary Удалить схему объектов с id
-// @Description Метод, который удаляет схему объектов с заданным id
-// @Tags ItemScheme
-// @Accept  json
-// @Produce  json
-// @Param itemscheme_id path string true "ItemScheme id"
-// @Success 200 {string} string    "5ca10d9d015c736a72b7b3ba"
-// @Failure 400 {object} httputils.HTTPError
-// @Failure 404 {object} httputils.HTTPError
-// @Failure 500 {object} httputils.HTTPError
-// @Router /scheme/item/{itemscheme_id} [delete]
-func (c *Controller) DeleteItemScheme(ctx *gin.Context) {
-	id := ctx.Param("itemscheme_id")
-	err := model.DeleteSchemeOne(id)
-	if err != nil {
-		httputils.NewError(ctx, http.StatusNotFound, err)
-		return
-	}
-	ctx.JSON(http.StatusOK, id)
-}
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"errors"
 	"time"
 )
 
+// ErrVersionMismatch возвращается, если версия документа в базе отличается от ожидаемой клиентом
+var ErrVersionMismatch = errors.New("document version mismatch")
+
 // Model базовая модел для всех структур который будут записанны в бд.
 // Пример:
 // type Example struct {
@@ -16,4 +20,9 @@ type Model struct {
 
 	// DeletedAt может быть nil. В таком случае считается что объект не удален
 	DeletedAt *time.Time `bson:"deleted_at" json:"deleted_at"`
+	// DeletedBy пользователь, удаливший объект
+	DeletedBy string `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+
+	// Version увеличивается при каждом изменении документа и отдается клиенту в ETag
+	Version int64 `bson:"version" json:"version"`
 }
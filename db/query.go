@@ -0,0 +1,399 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultLimit количество документов на странице, если limit не указан
+	DefaultLimit int64 = 50
+	// MaxLimit максимальное количество документов на странице
+	MaxLimit int64 = 500
+)
+
+// FieldType тип значения поля, по которому разрешена фильтрация
+type FieldType int
+
+// Типы полей
+const (
+	StringField FieldType = iota
+	IntField
+	BoolField
+	TimeField
+	ObjectIDField
+)
+
+// Field описывает поле документа, доступное в фильтрах и сортировке
+type Field struct {
+	// Key имя поля в документе
+	Key  string
+	Type FieldType
+	// Sort разрешена ли сортировка по полю
+	Sort bool
+}
+
+// Fields белый список полей списка. Ключ - имя поля в параметрах запроса
+type Fields map[string]Field
+
+// Query параметры запроса списка: ?filter[field]=op:value&sort=-field&limit=50&cursor=...
+// Операторы фильтра: eq (по умолчанию), ne, gt, gte, lt, lte, in (значения через |), like.
+// Несколько условий на одно поле перечисляются через запятую: filter[date]=gte:2019-05-01,lt:2019-06-01
+type Query struct {
+	Filter map[string]string
+	Sort   string
+	Limit  int64
+	Cursor string
+}
+
+// Page страница списка
+type Page struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Total      int64       `json:"total"`
+}
+
+// QueryError ошибка в параметрах запроса списка
+type QueryError struct {
+	Reason string
+}
+
+func (e QueryError) Error() string {
+	return "invalid query: " + e.Reason
+}
+
+type sortKey struct {
+	Key       string
+	Ascending bool
+}
+
+type cursorValues struct {
+	Values []interface{} `bson:"v"`
+}
+
+var filterOperators = []string{"eq", "ne", "gt", "gte", "lt", "lte", "in", "like"}
+
+// FindPage возвращает страницу документов коллекции.
+// base - обязательные условия (например, отбор неудаленных), fields - белый список полей запроса,
+// out - указатель на слайс, в который будут декодированы документы
+func FindPage(coll *Collection, base bson.D, fields Fields, q Query, projection bson.D, out interface{}) (Page, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := q.filter(fields)
+	if err != nil {
+		return Page{}, err
+	}
+	filter = and(base, filter)
+
+	sort, err := q.sort(fields)
+	if err != nil {
+		return Page{}, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	total, err := coll.CountDocuments(timeout, filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	pageFilter := filter
+	if len(q.Cursor) != 0 {
+		after, err := cursorFilter(q.Cursor, sort)
+		if err != nil {
+			return Page{}, err
+		}
+		pageFilter = and(filter, after)
+	}
+
+	order := bson.D{}
+	for _, key := range sort {
+		direction := -1
+		if key.Ascending {
+			direction = 1
+		}
+		order = append(order, bson.E{Key: key.Key, Value: direction})
+	}
+
+	findOptions := options.Find().SetSort(order).SetLimit(limit + 1)
+	if projection != nil {
+		findOptions.SetProjection(projection)
+	}
+
+	cur, err := coll.Find(timeout, pageFilter, findOptions)
+	if err != nil {
+		return Page{}, err
+	}
+	defer cur.Close(timeout)
+
+	items := reflect.ValueOf(out).Elem()
+	items.Set(reflect.MakeSlice(items.Type(), 0, int(limit)))
+
+	var last bson.Raw
+	var count int64
+	hasMore := false
+	for cur.Next(timeout) {
+		if count == limit {
+			hasMore = true
+			break
+		}
+
+		item := reflect.New(items.Type().Elem())
+		if err := cur.Decode(item.Interface()); err != nil {
+			return Page{}, err
+		}
+		items.Set(reflect.Append(items, item.Elem()))
+
+		last = append(bson.Raw{}, cur.Current...)
+		count++
+	}
+
+	if err := cur.Err(); err != nil {
+		return Page{}, err
+	}
+
+	page := Page{
+		Items: items.Interface(),
+		Total: total,
+	}
+
+	if hasMore {
+		page.NextCursor, err = encodeCursor(last, sort)
+		if err != nil {
+			return Page{}, err
+		}
+	}
+
+	return page, nil
+}
+
+func (q Query) filter(fields Fields) (bson.D, error) {
+	filter := bson.D{}
+
+	for name, raw := range q.Filter {
+		field, ok := fields[name]
+		if !ok {
+			return nil, QueryError{"filter by " + name + " is not allowed"}
+		}
+
+		conditions := bson.D{}
+		for _, part := range splitConditions(raw) {
+			operator, text := "eq", part
+			for _, op := range filterOperators {
+				if strings.HasPrefix(part, op+":") {
+					operator, text = op, strings.TrimPrefix(part, op+":")
+					break
+				}
+			}
+
+			var value interface{}
+			switch operator {
+			case "in":
+				values := bson.A{}
+				for _, item := range strings.Split(text, "|") {
+					v, err := field.parse(item)
+					if err != nil {
+						return nil, QueryError{"filter " + name + ": " + err.Error()}
+					}
+					values = append(values, v)
+				}
+				value = values
+			case "like":
+				if field.Type != StringField {
+					return nil, QueryError{"filter " + name + ": like is allowed only for strings"}
+				}
+				value = primitive.Regex{Pattern: regexp.QuoteMeta(text), Options: "i"}
+				operator = "regex"
+			default:
+				v, err := field.parse(text)
+				if err != nil {
+					return nil, QueryError{"filter " + name + ": " + err.Error()}
+				}
+				value = v
+			}
+
+			conditions = append(conditions, bson.E{Key: "$" + operator, Value: value})
+		}
+
+		if len(conditions) == 1 && conditions[0].Key == "$eq" {
+			filter = append(filter, bson.E{Key: field.Key, Value: conditions[0].Value})
+		} else {
+			filter = append(filter, bson.E{Key: field.Key, Value: conditions})
+		}
+	}
+
+	return filter, nil
+}
+
+// splitConditions разбивает значение фильтра вида "gte:1,lt:5" на условия.
+// Запятая считается разделителем, только если каждая часть начинается с оператора
+func splitConditions(raw string) []string {
+	parts := strings.Split(raw, ",")
+	if len(parts) == 1 {
+		return parts
+	}
+
+	for _, part := range parts {
+		hasOperator := false
+		for _, op := range filterOperators {
+			if strings.HasPrefix(part, op+":") {
+				hasOperator = true
+				break
+			}
+		}
+		if !hasOperator {
+			return []string{raw}
+		}
+	}
+
+	return parts
+}
+
+func (q Query) sort(fields Fields) ([]sortKey, error) {
+	keys := []sortKey{}
+
+	if len(q.Sort) != 0 {
+		for _, name := range strings.Split(q.Sort, ",") {
+			key := sortKey{Ascending: !strings.HasPrefix(strings.TrimSpace(name), "-")}
+			name = strings.TrimLeft(strings.TrimSpace(name), "+-")
+
+			field, ok := fields[name]
+			if !ok || !field.Sort {
+				return nil, QueryError{"sort by " + name + " is not allowed"}
+			}
+			key.Key = field.Key
+
+			keys = append(keys, key)
+		}
+	}
+
+	// _id делает порядок однозначным, без него курсор может пропускать документы
+	ascending := true
+	if len(keys) != 0 {
+		ascending = keys[len(keys)-1].Ascending
+	}
+
+	return append(keys, sortKey{Key: "_id", Ascending: ascending}), nil
+}
+
+func (f Field) parse(text string) (interface{}, error) {
+	switch f.Type {
+	case IntField:
+		return strconv.ParseInt(text, 10, 64)
+	case BoolField:
+		return strconv.ParseBool(text)
+	case TimeField:
+		if t, err := time.Parse(time.RFC3339, text); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02", text)
+	case ObjectIDField:
+		return primitive.ObjectIDFromHex(text)
+	default:
+		return text, nil
+	}
+}
+
+func encodeCursor(last bson.Raw, sort []sortKey) (string, error) {
+	values := cursorValues{}
+
+	for _, key := range sort {
+		var value interface{}
+		raw := last.Lookup(strings.Split(key.Key, ".")...)
+		if raw.Type != 0 {
+			if err := raw.Unmarshal(&value); err != nil {
+				return "", err
+			}
+		}
+		values.Values = append(values.Values, value)
+	}
+
+	data, err := bson.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// and объединяет условия через $and, чтобы одинаковые ключи разных условий (например, два $or) не перекрывали друг друга
+func and(conditions ...bson.D) bson.D {
+	parts := bson.A{}
+	for _, condition := range conditions {
+		if len(condition) != 0 {
+			parts = append(parts, condition)
+		}
+	}
+
+	switch len(parts) {
+	case 0:
+		return bson.D{}
+	case 1:
+		return parts[0].(bson.D)
+	}
+	return bson.D{{Key: "$and", Value: parts}}
+}
+
+// cursorFilter условие "после документа из курсора" с учетом направления сортировки.
+// null и отсутствующее поле Mongo сортирует раньше любых значений, а $gt и $lt их не находят,
+// поэтому для них условия отдельные
+func cursorFilter(cursor string, sort []sortKey) (bson.D, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, QueryError{"cursor is malformed"}
+	}
+
+	values := cursorValues{}
+	if err := bson.Unmarshal(data, &values); err != nil || len(values.Values) != len(sort) {
+		return nil, QueryError{"cursor is malformed"}
+	}
+
+	or := bson.A{}
+	for i, key := range sort {
+		condition := bson.D{}
+		for j := 0; j < i; j++ {
+			condition = append(condition, bson.E{Key: sort[j].Key, Value: values.Values[j]})
+		}
+
+		value := values.Values[i]
+		switch {
+		case value == nil && key.Ascending:
+			condition = append(condition, bson.E{Key: key.Key, Value: bson.D{{Key: "$ne", Value: nil}}})
+		case value == nil:
+			// При убывании после null идут только null, их отбирают следующие ключи
+			continue
+		case key.Ascending:
+			condition = append(condition, bson.E{Key: key.Key, Value: bson.D{{Key: "$gt", Value: value}}})
+		case key.Key == "_id":
+			condition = append(condition, bson.E{Key: key.Key, Value: bson.D{{Key: "$lt", Value: value}}})
+		default:
+			condition = append(condition, bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: key.Key, Value: bson.D{{Key: "$lt", Value: value}}}},
+				bson.D{{Key: key.Key, Value: nil}},
+			}})
+		}
+
+		or = append(or, condition)
+	}
+	if len(or) == 0 {
+		return nil, QueryError{"cursor is malformed"}
+	}
+
+	return bson.D{{Key: "$or", Value: or}}, nil
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestQuerySort(t *testing.T) {
+	fields := Fields{
+		"name":       {Key: "name", Type: StringField, Sort: true},
+		"created_at": {Key: "created_at", Type: TimeField, Sort: true},
+		"status":     {Key: "status", Type: StringField},
+	}
+
+	tests := []struct {
+		sort string
+		want []sortKey
+		err  bool
+	}{
+		{"", []sortKey{{Key: "_id", Ascending: true}}, false},
+		{"name", []sortKey{{Key: "name", Ascending: true}, {Key: "_id", Ascending: true}}, false},
+		{"-created_at", []sortKey{{Key: "created_at"}, {Key: "_id"}}, false},
+		{"+name, -created_at", []sortKey{{Key: "name", Ascending: true}, {Key: "created_at"}, {Key: "_id"}}, false},
+		{"status", nil, true},
+		{"unknown", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := Query{Sort: test.sort}.sort(fields)
+		if test.err {
+			if _, ok := err.(QueryError); !ok {
+				t.Errorf("sort %q: error = %v, want a query error", test.sort, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("sort %q: %v", test.sort, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sort %q = %v, want %v", test.sort, got, test.want)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	id := primitive.NewObjectID()
+	last, err := bson.Marshal(bson.D{
+		{Key: "_id", Value: id},
+		{Key: "name", Value: "scale"},
+		{Key: "values", Value: bson.D{{Key: "weight", Value: 2.5}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		sort []sortKey
+		want bson.D
+	}{
+		{
+			"by id",
+			[]sortKey{{Key: "_id", Ascending: true}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: id}}}},
+			}}},
+		},
+		{
+			"descending with tie breaker",
+			[]sortKey{{Key: "name"}, {Key: "_id"}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "$or", Value: bson.A{
+					bson.D{{Key: "name", Value: bson.D{{Key: "$lt", Value: "scale"}}}},
+					bson.D{{Key: "name", Value: nil}},
+				}}},
+				bson.D{{Key: "name", Value: "scale"}, {Key: "_id", Value: bson.D{{Key: "$lt", Value: id}}}},
+			}}},
+		},
+		{
+			"nested and missing keys",
+			[]sortKey{{Key: "values.weight", Ascending: true}, {Key: "missing", Ascending: true}, {Key: "_id", Ascending: true}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "values.weight", Value: bson.D{{Key: "$gt", Value: 2.5}}}},
+				bson.D{{Key: "values.weight", Value: 2.5}, {Key: "missing", Value: bson.D{{Key: "$ne", Value: nil}}}},
+				bson.D{{Key: "values.weight", Value: 2.5}, {Key: "missing", Value: nil}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: id}}}},
+			}}},
+		},
+		{
+			"descending missing key",
+			[]sortKey{{Key: "missing"}, {Key: "_id"}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "missing", Value: nil}, {Key: "_id", Value: bson.D{{Key: "$lt", Value: id}}}},
+			}}},
+		},
+	}
+
+	for _, test := range tests {
+		cursor, err := encodeCursor(bson.Raw(last), test.sort)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got, err := cursorFilter(cursor, test.sort)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: cursorFilter = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCursorMalformed(t *testing.T) {
+	sort := []sortKey{{Key: "name", Ascending: true}, {Key: "_id", Ascending: true}}
+
+	short, err := encodeCursor(bson.Raw(mustMarshal(t, bson.D{{Key: "_id", Value: 1}})), sort[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cursor := range []string{"not base64!", "bm90IGJzb24", short} {
+		if _, err := cursorFilter(cursor, sort); err == nil {
+			t.Errorf("cursorFilter(%q) succeeded, want an error", cursor)
+		} else if _, ok := err.(QueryError); !ok {
+			t.Errorf("cursorFilter(%q) error = %v, want a query error", cursor, err)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, doc bson.D) []byte {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAnd(t *testing.T) {
+	scope := bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "a", Value: 1}}}}}
+	after := bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "b", Value: 2}}}}}
+
+	tests := []struct {
+		name       string
+		conditions []bson.D
+		want       bson.D
+	}{
+		{"none", nil, bson.D{}},
+		{"empty", []bson.D{{}, nil}, bson.D{}},
+		{"one", []bson.D{{}, scope}, scope},
+		{"two $or", []bson.D{scope, after}, bson.D{{Key: "$and", Value: bson.A{scope, after}}}},
+	}
+
+	for _, test := range tests {
+		if got := and(test.conditions...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: and = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
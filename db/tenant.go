@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Tenant идентификатор площадки (завода). Контроллеры, схемы и журналы разных площадок не пересекаются
+type Tenant string
+
+// DefaultTenant площадка, которой принадлежат данные, созданные до разделения на площадки.
+// Ее данные хранятся в основной базе и в режиме TenancyDatabase
+const DefaultTenant Tenant = "default"
+
+// TenantKey поле документа, в котором хранится площадка
+const TenantKey = "tenant"
+
+// Режимы хранения данных площадок
+const (
+	// TenancyShared все площадки в одной базе, документы отбираются по полю tenant
+	TenancyShared = "shared"
+	// TenancyDatabase у каждой площадки своя база <основная база>_<площадка>
+	TenancyDatabase = "database"
+)
+
+var tenantPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// Valid проверяет, что идентификатор площадки можно использовать в имени базы
+func (t Tenant) Valid() bool {
+	return tenantPattern.MatchString(string(t))
+}
+
+// Name имя основной базы данных из настройки MONGODB_DATABASE. По умолчанию test
+func Name() string {
+	if name := viper.GetString("mongodb_database"); len(name) != 0 {
+		return name
+	}
+	return "test"
+}
+
+// TenancyMode режим хранения данных площадок из настройки TENANCY. По умолчанию TenancyShared
+func TenancyMode() string {
+	if viper.GetString("tenancy") == TenancyDatabase {
+		return TenancyDatabase
+	}
+	return TenancyShared
+}
+
+// Global коллекция основной базы, общая для всех площадок (пользователи, список площадок)
+func Global(name string) *mongo.Collection {
+	return client.Database(Name()).Collection(name)
+}
+
+// Database база, в которой хранятся данные площадки
+func (t Tenant) Database() *mongo.Database {
+	if TenancyMode() == TenancyDatabase && t != DefaultTenant {
+		return client.Database(Name() + "_" + string(t))
+	}
+	return client.Database(Name())
+}
+
+// Collection коллекция площадки. Запросы через нее видят только документы площадки
+func (t Tenant) Collection(name string) *Collection {
+	return &Collection{
+		coll:   t.Database().Collection(name),
+		tenant: t,
+		shared: TenancyMode() == TenancyShared,
+	}
+}
+
+// Collection коллекция, которая сама дополняет фильтры условием на площадку
+// и проставляет площадку в новые документы. Методы повторяют методы mongo.Collection.
+// Исходная коллекция не доступна снаружи, чтобы запрос без площадки нельзя было сделать по ошибке
+type Collection struct {
+	coll   *mongo.Collection
+	tenant Tenant
+	shared bool
+}
+
+// Tenant площадка коллекции
+func (c *Collection) Tenant() Tenant {
+	return c.tenant
+}
+
+// scope добавляет к фильтру условие на площадку. В режиме TenancyDatabase фильтр не меняется
+func (c *Collection) scope(filter interface{}) interface{} {
+	if !c.shared {
+		return filter
+	}
+
+	tenant := bson.E{Key: TenantKey, Value: c.tenant}
+	switch f := filter.(type) {
+	case nil:
+		return bson.D{tenant}
+	case bson.D:
+		// Свое условие на площадку в фильтре не должно заменить отбор: ключи документа не повторяются
+		for _, e := range f {
+			if e.Key == TenantKey {
+				return bson.D{{Key: "$and", Value: bson.A{bson.D{tenant}, f}}}
+			}
+		}
+		return append(bson.D{tenant}, f...)
+	default:
+		return bson.D{{Key: "$and", Value: bson.A{bson.D{tenant}, f}}}
+	}
+}
+
+// stamp возвращает документ с проставленной площадкой
+func (c *Collection) stamp(document interface{}) (bson.D, error) {
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	for i := range doc {
+		if doc[i].Key == TenantKey {
+			doc[i].Value = c.tenant
+			return doc, nil
+		}
+	}
+
+	return append(doc, bson.E{Key: TenantKey, Value: c.tenant}), nil
+}
+
+// Find godoc
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return c.coll.Find(ctx, c.scope(filter), opts...)
+}
+
+// FindOne godoc
+func (c *Collection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return c.coll.FindOne(ctx, c.scope(filter), opts...)
+}
+
+// CountDocuments godoc
+func (c *Collection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.coll.CountDocuments(ctx, c.scope(filter), opts...)
+}
+
+// InsertOne godoc
+func (c *Collection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc, err := c.stamp(document)
+	if err != nil {
+		return nil, err
+	}
+	return c.coll.InsertOne(ctx, doc, opts...)
+}
+
+// UpdateOne godoc
+func (c *Collection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateOne(ctx, c.scope(filter), update, opts...)
+}
+
+// UpdateMany godoc
+func (c *Collection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateMany(ctx, c.scope(filter), update, opts...)
+}
+
+// DeleteMany godoc
+func (c *Collection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteMany(ctx, c.scope(filter), opts...)
+}
+
+// Distinct godoc
+func (c *Collection) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	return c.coll.Distinct(ctx, fieldName, c.scope(filter), opts...)
+}
+
+// ClaimUntenanted относит к площадке коллекции документы, созданные до разделения на площадки.
+// Это единственный запрос мимо отбора по площадке: у этих документов площадки еще нет
+func (c *Collection) ClaimUntenanted(ctx context.Context) error {
+	filter := bson.D{{Key: TenantKey, Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: TenantKey, Value: c.tenant}}}}
+	_, err := c.coll.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// FindOneAndReplace godoc
+func (c *Collection) FindOneAndReplace(ctx context.Context, filter interface{}, replacement interface{}, opts ...*options.FindOneAndReplaceOptions) *mongo.SingleResult {
+	doc, err := c.stamp(replacement)
+	if err != nil {
+		// Драйвер вернет ту же ошибку сериализации в SingleResult
+		return c.coll.FindOneAndReplace(ctx, c.scope(filter), replacement, opts...)
+	}
+	return c.coll.FindOneAndReplace(ctx, c.scope(filter), doc, opts...)
+}
+
+// Aggregate godoc
+func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	if c.shared {
+		match := bson.D{{Key: "$match", Value: c.scope(nil)}}
+		switch p := pipeline.(type) {
+		case bson.A:
+			pipeline = append(bson.A{match}, p...)
+		case mongo.Pipeline:
+			pipeline = append(mongo.Pipeline{match}, p...)
+		}
+	}
+	return c.coll.Aggregate(ctx, pipeline, opts...)
+}
+
+// CreateIndexes создает индексы коллекции. В режиме TenancyShared площадка становится первым ключом индекса,
+// поэтому уникальные индексы работают в пределах площадки
+func (c *Collection) CreateIndexes(ctx context.Context, models ...mongo.IndexModel) error {
+	if c.shared {
+		if err := c.dropUnscoped(ctx); err != nil {
+			return err
+		}
+		for i := range models {
+			if keys, ok := models[i].Keys.(bson.D); ok {
+				models[i].Keys = append(bson.D{{Key: TenantKey, Value: 1}}, keys...)
+			}
+		}
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	_, err := c.coll.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+type indexSpec struct {
+	Name   string `bson:"name"`
+	Key    bson.D `bson:"key"`
+	Unique bool   `bson:"unique"`
+}
+
+// dropUnscoped удаляет уникальные и текстовые индексы без площадки, созданные до разделения на площадки.
+// Уникальные мешают одинаковым значениям на разных площадках, а текстовый индекс у коллекции может быть только один
+func (c *Collection) dropUnscoped(ctx context.Context) error {
+	cur, err := c.coll.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	names := []string{}
+	for cur.Next(ctx) {
+		var index indexSpec
+		if err := cur.Decode(&index); err != nil {
+			return err
+		}
+
+		if len(index.Key) == 0 || index.Key[0].Key == TenantKey {
+			continue
+		}
+		if index.Unique || index.Key[0].Key == "_fts" {
+			names = append(names, index.Name)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, err := c.coll.Indexes().DropOne(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
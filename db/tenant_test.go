@@ -0,0 +1,100 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTenantValid(t *testing.T) {
+	tests := []struct {
+		tenant Tenant
+		valid  bool
+	}{
+		{"default", true},
+		{"plant_2", true},
+		{"a", false},
+		{"2plant", false},
+		{"Plant", false},
+		{"plant-2", false},
+		{"plant.2", false},
+		{"", false},
+		{"abcdefghijklmnopqrstuvwxyz_01234", true},
+		{"abcdefghijklmnopqrstuvwxyz_012345", false},
+	}
+
+	for _, test := range tests {
+		if got := test.tenant.Valid(); got != test.valid {
+			t.Errorf("Tenant(%q).Valid() = %v, want %v", test.tenant, got, test.valid)
+		}
+	}
+}
+
+func TestCollectionScope(t *testing.T) {
+	shared := &Collection{tenant: "plant", shared: true}
+	separate := &Collection{tenant: "plant"}
+	tenant := bson.E{Key: TenantKey, Value: Tenant("plant")}
+	or := bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "a", Value: 1}}, bson.D{{Key: "b", Value: 2}}}}}
+
+	tests := []struct {
+		name   string
+		coll   *Collection
+		filter interface{}
+		want   interface{}
+	}{
+		{"nil filter", shared, nil, bson.D{tenant}},
+		{"empty filter", shared, bson.D{}, bson.D{tenant}},
+		{"document filter", shared, bson.D{{Key: "name", Value: "x"}}, bson.D{tenant, {Key: "name", Value: "x"}}},
+		{"filter with $or", shared, or, append(bson.D{tenant}, or...)},
+		{"map filter", shared, bson.M{"name": "x"}, bson.D{{Key: "$and", Value: bson.A{bson.D{tenant}, bson.M{"name": "x"}}}}},
+		{"filter naming another tenant", shared, bson.D{{Key: TenantKey, Value: "other"}}, bson.D{{Key: "$and", Value: bson.A{bson.D{tenant}, bson.D{{Key: TenantKey, Value: "other"}}}}}},
+		{"database per tenant", separate, bson.D{{Key: "name", Value: "x"}}, bson.D{{Key: "name", Value: "x"}}},
+		{"database per tenant, nil filter", separate, nil, nil},
+	}
+
+	for _, test := range tests {
+		if got := test.coll.scope(test.filter); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: scope(%v) = %v, want %v", test.name, test.filter, got, test.want)
+		}
+	}
+}
+
+func TestCollectionStamp(t *testing.T) {
+	coll := &Collection{tenant: "plant", shared: true}
+
+	tests := []struct {
+		name     string
+		document interface{}
+		want     bson.D
+	}{
+		{
+			"without tenant",
+			bson.D{{Key: "name", Value: "x"}},
+			bson.D{{Key: "name", Value: "x"}, {Key: TenantKey, Value: Tenant("plant")}},
+		},
+		{
+			"with another tenant",
+			bson.D{{Key: TenantKey, Value: "other"}, {Key: "name", Value: "x"}},
+			bson.D{{Key: TenantKey, Value: Tenant("plant")}, {Key: "name", Value: "x"}},
+		},
+		{
+			"struct",
+			struct {
+				Name string `bson:"name"`
+			}{"x"},
+			bson.D{{Key: "name", Value: "x"}, {Key: TenantKey, Value: Tenant("plant")}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := coll.stamp(test.document)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: stamp = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
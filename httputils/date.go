@@ -0,0 +1,15 @@
+package httputils
+
+import "time"
+
+// ParseDate разбирает дату из параметра запроса в формате 2006-01-02 или RFC3339.
+// Для пустого значения возвращается fallback
+func ParseDate(value string, fallback time.Time) (time.Time, error) {
+	if len(value) == 0 {
+		return fallback, nil
+	}
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
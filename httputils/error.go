@@ -19,3 +19,21 @@ type HTTPError struct {
 	Code    int    `json:"code" example:"400"`
 	Message string `json:"message" example:"status bad request"`
 }
+
+// NewErrorDetails Конструктор ошибки с подробностями, например списком объектов, из-за которых запрос отклонен
+func NewErrorDetails(ctx *gin.Context, status int, err error, details interface{}) {
+	er := HTTPErrorDetails{
+		Code:    status,
+		Message: err.Error(),
+		Details: details,
+	}
+
+	ctx.JSON(status, er)
+}
+
+// HTTPErrorDetails Объект ошибки с подробностями
+type HTTPErrorDetails struct {
+	Code    int         `json:"code" example:"409"`
+	Message string      `json:"message" example:"scheme is used by other schemes"`
+	Details interface{} `json:"details"`
+}
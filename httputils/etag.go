@@ -0,0 +1,60 @@
+package httputils
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errIfMatchRequired = errors.New("If-Match header is required")
+	errETagInvalid     = errors.New("If-Match header is invalid")
+)
+
+// ETag возвращает значение заголовка ETag для версии документа
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// SetETag устанавливает заголовок ETag ответа
+func SetETag(ctx *gin.Context, version int64) {
+	ctx.Header("ETag", ETag(version))
+}
+
+// IfMatch возвращает версию документа из заголовка If-Match.
+// Если заголовка нет или он некорректен, ответ с ошибкой уже отправлен и возвращается false
+func IfMatch(ctx *gin.Context) (int64, bool) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if len(header) == 0 {
+		NewError(ctx, http.StatusPreconditionRequired, errIfMatchRequired)
+		return 0, false
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), 10, 64)
+	if err != nil {
+		NewError(ctx, http.StatusPreconditionFailed, errETagInvalid)
+		return 0, false
+	}
+
+	return version, true
+}
+
+// NotModified устанавливает ETag и, если он совпадает с If-None-Match, отвечает 304.
+// Возвращает true, если ответ уже отправлен
+func NotModified(ctx *gin.Context, version int64) bool {
+	SetETag(ctx, version)
+
+	etag := ETag(version)
+	for _, tag := range strings.Split(ctx.GetHeader("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			ctx.Status(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package httputils
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/gin-gonic/gin"
+)
+
+// ParseQuery читает параметры списка ?filter[...], ?sort, ?limit и ?cursor
+func ParseQuery(ctx *gin.Context) (db.Query, error) {
+	query := db.Query{
+		Filter: ctx.QueryMap("filter"),
+		Sort:   ctx.Query("sort"),
+		Cursor: ctx.Query("cursor"),
+	}
+
+	if limit := ctx.Query("limit"); len(limit) != 0 {
+		value, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || value < 0 {
+			return db.Query{}, db.QueryError{Reason: "limit must be a non-negative integer"}
+		}
+		query.Limit = value
+	}
+
+	return query, nil
+}
+
+// ListError отправляет ошибку получения списка: 400 для неверных параметров запроса, иначе 404
+func ListError(ctx *gin.Context, err error) {
+	if _, ok := err.(db.QueryError); ok {
+		NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	NewError(ctx, http.StatusNotFound, err)
+}
@@ -0,0 +1,24 @@
+package httputils
+
+import (
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/gin-gonic/gin"
+)
+
+// TenantKey ключ, под которым площадка текущего запроса хранится в gin.Context
+const TenantKey = "tenant"
+
+// Tenant площадка текущего запроса. Ее выставляет проверка авторизации по сессии пользователя
+func Tenant(ctx *gin.Context) db.Tenant {
+	if tenant, ok := ctx.Get(TenantKey); ok {
+		if t, ok := tenant.(db.Tenant); ok {
+			return t
+		}
+	}
+	return db.DefaultTenant
+}
+
+// SetTenant godoc
+func SetTenant(ctx *gin.Context, tenant db.Tenant) {
+	ctx.Set(TenantKey, tenant)
+}
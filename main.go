@@ -44,9 +44,21 @@ func init() {
 }
 
 func main() {
+	tenants := service.NewTenantService()
+	if err := tenants.Prepare(); err != nil {
+		log.Fatal(err)
+	}
+
+	go service.NewRetentionService(tenants).Run()
+	go service.NewArchiveService(tenants).Run()
+
 	users := service.NewUserService()
+	if err := users.Bootstrap(viper.GetString("admin_username"), viper.GetString("admin_password")); err != nil {
+		log.Fatal(err)
+	}
+
 	sessions := service.NewSessionService()
-	router.V1(app.Group("/api/v1"), users, sessions)
+	router.V1(app.Group("/api/v1"), users, tenants, sessions)
 	app.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	if err := app.Run(); err != nil {
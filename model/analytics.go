@@ -0,0 +1,205 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Интервалы группировки статистики
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+var bucketFormats = map[string]string{
+	BucketDay:   "%Y-%m-%d",
+	BucketWeek:  "%G-W%V",
+	BucketMonth: "%Y-%m",
+}
+
+// Errors godoc
+var (
+	ErrBucketInvalid = errors.New("bucket must be day, week or month")
+	ErrPeriodInvalid = errors.New("from must be before to")
+)
+
+// AnalyticsQuery параметры расчета статистики по полю журнала
+type AnalyticsQuery struct {
+	Scheme string
+	Field  string
+	// Items позиции, по которым считается статистика. Пустой список - все позиции схемы
+	Items  []string
+	From   time.Time
+	To     time.Time
+	Bucket string
+}
+
+// AnalyticsBucket статистика за интервал
+type AnalyticsBucket struct {
+	Bucket string   `bson:"_id" json:"bucket" example:"2019-05-01"`
+	Count  int64    `bson:"count" json:"count" example:"30"`
+	Min    *float64 `bson:"min" json:"min" example:"1.98"`
+	Max    *float64 `bson:"max" json:"max" example:"2.03"`
+	Mean   *float64 `bson:"mean" json:"mean" example:"2.001"`
+	StdDev *float64 `bson:"stddev" json:"stddev" example:"0.012"`
+
+	// Checks количество журналов с вычисленными проверками по полю
+	Checks int64 `bson:"checks" json:"checks" example:"30"`
+	// Failures количество журналов, в которых хотя бы одна проверка не пройдена
+	Failures    int64   `bson:"failures" json:"failures" example:"1"`
+	FailureRate float64 `bson:"-" json:"failure_rate" example:"0.033"`
+
+	// CorrectiveActions количество журналов, закрытых с корректирующими действиями (accepted = -1)
+	CorrectiveActions int64 `bson:"corrective_actions" json:"corrective_actions" example:"1"`
+}
+
+// Analytics статистика по полю схемы журнала
+type Analytics struct {
+	Scheme  string            `json:"scheme" example:"scales_calibration"`
+	Field   string            `json:"field" example:"result"`
+	Items   []string          `json:"items"`
+	From    time.Time         `json:"from"`
+	To      time.Time         `json:"to"`
+	Bucket  string            `json:"bucket" example:"day"`
+	Checks  []string          `json:"checks"`
+	Buckets []AnalyticsBucket `json:"buckets"`
+}
+
+// journalSchemeByName godoc
+func journalSchemeByName(tenant db.Tenant, name string) (JournalScheme, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var scheme JournalScheme
+	filter := bson.D{{Key: "name", Value: name}, {Key: "deleted_at", Value: nil}}
+	err := JournalSchemeCollection(tenant).FindOne(timeout, filter).Decode(&scheme)
+
+	return scheme, err
+}
+
+// checksOf имена вычисляемых полей схемы, которые проверяют поле field
+func checksOf(scheme JournalScheme, field string) []string {
+	checks := []string{}
+	for _, schemeField := range scheme.Fields {
+		if schemeField.Computed != nil && schemeField.Computed.Field == field {
+			checks = append(checks, schemeField.Name)
+		}
+	}
+	return checks
+}
+
+// JournalAnalytics считает по интервалам min/max/среднее/отклонение числового поля журнала,
+// долю непройденных проверок и количество корректирующих действий
+func JournalAnalytics(tenant db.Tenant, query AnalyticsQuery) (*Analytics, error) {
+	format, ok := bucketFormats[query.Bucket]
+	if !ok {
+		return nil, ErrBucketInvalid
+	}
+
+	if !query.From.Before(query.To) {
+		return nil, ErrPeriodInvalid
+	}
+
+	scheme, err := journalSchemeByName(tenant, query.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := checksOf(scheme, query.Field)
+
+	match := bson.D{
+		{Key: "scheme", Value: query.Scheme},
+		{Key: "deleted_at", Value: nil},
+		{Key: "date", Value: bson.D{{Key: "$gte", Value: query.From}, {Key: "$lt", Value: query.To}}},
+	}
+	if len(query.Items) != 0 {
+		match = append(match, bson.E{Key: "item", Value: bson.D{{Key: "$in", Value: query.Items}}})
+	}
+
+	// Значения полей приходят с планшета и строками, и числами
+	value := bson.D{{Key: "$convert", Value: bson.D{
+		{Key: "input", Value: "$values." + query.Field},
+		{Key: "to", Value: "double"},
+		{Key: "onError", Value: nil},
+		{Key: "onNull", Value: nil},
+	}}}
+
+	checked := bson.A{}
+	failed := bson.A{}
+	for _, check := range checks {
+		path := "$values." + check
+		checked = append(checked, bson.D{{Key: "$ne", Value: bson.A{bson.D{{Key: "$type", Value: path}}, "missing"}}})
+		failed = append(failed, bson.D{{Key: "$eq", Value: bson.A{path, false}}})
+	}
+
+	countIf := func(condition bson.D) bson.D {
+		return bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{condition, 1, 0}}}}}
+	}
+
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: match}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "bucket", Value: bson.D{{Key: "$dateToString", Value: bson.D{{Key: "format", Value: format}, {Key: "date", Value: "$date"}}}}},
+			{Key: "value", Value: value},
+			{Key: "checked", Value: bson.D{{Key: "$or", Value: append(bson.A{false}, checked...)}}},
+			{Key: "failed", Value: bson.D{{Key: "$or", Value: append(bson.A{false}, failed...)}}},
+			{Key: "corrective", Value: bson.D{{Key: "$eq", Value: bson.A{"$accepted", -1}}}},
+		}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$bucket"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{"$value", nil}}}, 0, 1}}}}}},
+			{Key: "min", Value: bson.D{{Key: "$min", Value: "$value"}}},
+			{Key: "max", Value: bson.D{{Key: "$max", Value: "$value"}}},
+			{Key: "mean", Value: bson.D{{Key: "$avg", Value: "$value"}}},
+			{Key: "stddev", Value: bson.D{{Key: "$stdDevPop", Value: "$value"}}},
+			{Key: "checks", Value: countIf(bson.D{{Key: "$eq", Value: bson.A{"$checked", true}}})},
+			{Key: "failures", Value: countIf(bson.D{{Key: "$eq", Value: bson.A{"$failed", true}}})},
+			{Key: "corrective_actions", Value: countIf(bson.D{{Key: "$eq", Value: bson.A{"$corrective", true}}})},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := journalCollection(tenant).Aggregate(timeout, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	buckets := []AnalyticsBucket{}
+	for cur.Next(timeout) {
+		var bucket AnalyticsBucket
+		if err := cur.Decode(&bucket); err != nil {
+			return nil, err
+		}
+
+		if bucket.Checks != 0 {
+			bucket.FailureRate = float64(bucket.Failures) / float64(bucket.Checks)
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return &Analytics{
+		Scheme:  query.Scheme,
+		Field:   query.Field,
+		Items:   query.Items,
+		From:    query.From,
+		To:      query.To,
+		Bucket:  query.Bucket,
+		Checks:  checks,
+		Buckets: buckets,
+	}, nil
+}
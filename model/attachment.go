@@ -0,0 +1,332 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	// Декодеры форматов изображений для миниатюр
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AttachmentFieldType тип поля схемы журнала, в котором хранятся вложения. Поле Photo принимает только изображения
+const AttachmentFieldType = "Attachment"
+
+// AttachmentThumbnailSize наибольшая сторона миниатюры изображения в пикселях
+const AttachmentThumbnailSize = 200
+
+// AttachmentThumbnailMaxPixels наибольшее число пикселей изображения, для которого строится миниатюра.
+// Небольшой файл может объявить огромные размеры, а декодер выделяет память под все пиксели сразу
+const AttachmentThumbnailMaxPixels = 40000000
+
+// attachmentTypes типы содержимого, которые можно прикрепить к журналу. Тип определяется по содержимому, а не по имени файла
+var attachmentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"application/pdf",
+	"text/plain; charset=utf-8",
+}
+
+// Errors godoc
+var (
+	ErrAttachmentEmpty        = errors.New("attachment is empty")
+	ErrAttachmentTooLarge     = errors.New("attachment is too large")
+	ErrAttachmentTypeInvalid  = errors.New("attachment type is not allowed")
+	ErrAttachmentFieldInvalid = errors.New("journal scheme has no attachment field with this name")
+)
+
+// Attachment вложение (фото, документ) записи журнала
+type Attachment struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	db.Model `bson:",inline"`
+	Journal  primitive.ObjectID `bson:"journal" json:"journal" example:"5ca10d9d015c736a72b7b3b7"`
+	// Field поле схемы журнала с типом Attachment или Photo
+	Field       string `bson:"field" json:"field" example:"delivery_note"`
+	Filename    string `bson:"filename" json:"filename" example:"накладная.pdf"`
+	ContentType string `bson:"content_type" json:"content_type" example:"application/pdf"`
+	Size        int64  `bson:"size" json:"size" example:"102400"`
+	// Checksum sha256 содержимого в hex
+	Checksum   string `bson:"checksum" json:"checksum" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	Thumbnail  bool   `bson:"thumbnail" json:"thumbnail" example:"false"`
+	UploadedBy string `bson:"uploaded_by" json:"uploaded_by" example:"olegov"`
+
+	Key          string `bson:"key" json:"-"`
+	ThumbnailKey string `bson:"thumbnail_key,omitempty" json:"-"`
+}
+
+func attachmentCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("Attachment")
+}
+
+// AttachmentMaxSize наибольший размер вложения в байтах из настройки ATTACHMENT_MAX_SIZE (в мегабайтах). По умолчанию 10 МБ
+func AttachmentMaxSize() int64 {
+	if size := viper.GetInt64("attachment_max_size"); size > 0 {
+		return size << 20
+	}
+	return 10 << 20
+}
+
+// attachmentField тип поля field схемы журнала. Вложения принимают только поля Attachment и Photo
+func attachmentField(tenant db.Tenant, journal Journal, field string) (string, error) {
+	scheme, err := journalSchemeByName(tenant, journal.Scheme)
+	if err != nil {
+		return "", ErrAttachmentFieldInvalid
+	}
+	for _, schemeField := range scheme.Fields {
+		if schemeField.Name == field && (schemeField.Type == FieldAttachment || schemeField.Type == FieldPhoto) {
+			return schemeField.Type, nil
+		}
+	}
+	return "", ErrAttachmentFieldInvalid
+}
+
+// thumbnail уменьшенная копия изображения в jpeg. false, если data не изображение
+// или в нем больше AttachmentThumbnailMaxPixels пикселей
+func thumbnail(data []byte) ([]byte, bool) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 ||
+		int64(config.Width)*int64(config.Height) > AttachmentThumbnailMaxPixels {
+		return nil, false
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, false
+	}
+
+	scale := float64(AttachmentThumbnailSize) / float64(width)
+	if height > width {
+		scale = float64(AttachmentThumbnailSize) / float64(height)
+	}
+	if scale > 1 {
+		scale = 1
+	}
+	thumbWidth, thumbHeight := int(float64(width)*scale), int(float64(height)*scale)
+	if thumbWidth == 0 {
+		thumbWidth = 1
+	}
+	if thumbHeight == 0 {
+		thumbHeight = 1
+	}
+
+	// Ближайший сосед: для миниатюры в списке этого достаточно
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		for x := 0; x < thumbWidth; x++ {
+			thumb.Set(x, y, src.At(bounds.Min.X+x*width/thumbWidth, bounds.Min.Y+y*height/thumbHeight))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// AddAttachment прикрепляет файл к полю field журнала. Размер ограничен AttachmentMaxSize,
+// тип определяется по содержимому. Для изображений сохраняется миниатюра. К журналу на удержании прикреплять нельзя
+func AddAttachment(tenant db.Tenant, scope *OrgScope, journalID string, field string, filename string, by string, content io.Reader) (*Attachment, error) {
+	journal, err := scopedJournal(tenant, scope, journalID)
+	if err != nil {
+		return nil, err
+	}
+	if journal.Status == JournalClosed {
+		return nil, ErrJournalClosed
+	}
+	if err := journal.checkHold(); err != nil {
+		return nil, err
+	}
+	fieldType, err := attachmentField(tenant, *journal, field)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := AttachmentMaxSize()
+	data, err := ioutil.ReadAll(io.LimitReader(content, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrAttachmentEmpty
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if !CheckIn(contentType, attachmentTypes) || (fieldType == FieldPhoto && !strings.HasPrefix(contentType, "image/")) {
+		return nil, ErrAttachmentTypeInvalid
+	}
+
+	filename = strings.TrimSpace(filepath.Base(filepath.Clean("/" + filename)))
+	if filename == "/" || filename == "." || len(filename) == 0 {
+		filename = "attachment"
+	}
+
+	checksum := sha256.Sum256(data)
+	attachment := Attachment{
+		Journal:     journal.ID,
+		Field:       field,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Checksum:    hex.EncodeToString(checksum[:]),
+		UploadedBy:  by,
+	}
+
+	attachment.Key, err = Attachments().Put(tenant, filename, data)
+	if err != nil {
+		return nil, err
+	}
+	if thumb, ok := thumbnail(data); ok {
+		if key, err := Attachments().Put(tenant, "thumbnail_"+filename, thumb); err == nil {
+			attachment.Thumbnail = true
+			attachment.ThumbnailKey = key
+		}
+	}
+
+	attachment.CreatedAt = time.Now()
+	attachment.UpdatedAt = time.Now()
+	attachment.Version = 1
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	insertedResault, err := attachmentCollection(tenant).InsertOne(timeout, attachment)
+	if err != nil {
+		// Метаданные не сохранились: содержимое без них никому не доступно
+		Attachments().Delete(tenant, attachment.Key)
+		if attachment.Thumbnail {
+			Attachments().Delete(tenant, attachment.ThumbnailKey)
+		}
+		return nil, err
+	}
+	attachment.ID = insertedResault.InsertedID.(primitive.ObjectID)
+
+	return &attachment, nil
+}
+
+func journalAttachments(tenant db.Tenant, journalID primitive.ObjectID) ([]Attachment, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "journal", Value: journalID}, {Key: "deleted_at", Value: nil}}
+	cur, err := attachmentCollection(tenant).Find(timeout, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	attachments := []Attachment{}
+	for cur.Next(timeout) {
+		var attachment Attachment
+		if err := cur.Decode(&attachment); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, cur.Err()
+}
+
+// AttachmentsOf вложения журнала
+func AttachmentsOf(tenant db.Tenant, scope *OrgScope, journalID string) ([]Attachment, error) {
+	journal, err := scopedJournal(tenant, scope, journalID)
+	if err != nil {
+		return nil, err
+	}
+
+	return journalAttachments(tenant, journal.ID)
+}
+
+// AttachmentOne вложение id журнала journalID
+func AttachmentOne(tenant db.Tenant, scope *OrgScope, journalID string, id string) (*Attachment, error) {
+	journal, err := scopedJournal(tenant, scope, journalID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachmentID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: attachmentID}, {Key: "journal", Value: journal.ID}, {Key: "deleted_at", Value: nil}}
+	var attachment Attachment
+	if err := attachmentCollection(tenant).FindOne(timeout, filter).Decode(&attachment); err != nil {
+		return nil, err
+	}
+
+	return &attachment, nil
+}
+
+// OpenAttachment открывает содержимое вложения или, если thumbnail, его миниатюру
+func OpenAttachment(tenant db.Tenant, attachment Attachment, thumbnail bool) (io.ReadCloser, error) {
+	if thumbnail {
+		if !attachment.Thumbnail {
+			return nil, mongo.ErrNoDocuments
+		}
+		return Attachments().Open(tenant, attachment.ThumbnailKey)
+	}
+	return Attachments().Open(tenant, attachment.Key)
+}
+
+// AttachmentDelete помечает вложение удаленным пользователем by. Содержимое остается в хранилище
+// до окончательного удаления по сроку хранения корзины. Вложения журнала на удержании удалить нельзя
+func AttachmentDelete(tenant db.Tenant, journalID string, id string, version int64, by string) (*Attachment, error) {
+	journal, err := JournalOne(tenant, journalID)
+	if err != nil {
+		return nil, err
+	}
+	if err := journal.checkHold(); err != nil {
+		return nil, err
+	}
+
+	attachment, err := AttachmentOne(tenant, nil, journalID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	set := append(softDelete(by, now),
+		bson.E{Key: "updated_at", Value: now},
+		bson.E{Key: "version", Value: version + 1},
+	)
+	if err := updateVersioned(attachmentCollection(tenant), attachment.ID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: set}}); err != nil {
+		return nil, err
+	}
+
+	attachment.DeletedAt = &now
+	attachment.DeletedBy = by
+	attachment.UpdatedAt = now
+	attachment.Version = version + 1
+	return attachment, nil
+}
@@ -0,0 +1,163 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Хранилища содержимого вложений, настройка ATTACHMENT_STORAGE
+const (
+	// AttachmentGridFS файлы в GridFS базы площадки
+	AttachmentGridFS = "gridfs"
+	// AttachmentFilesystem файлы в каталоге ATTACHMENT_DIR, по подкаталогу на площадку
+	AttachmentFilesystem = "filesystem"
+)
+
+// attachmentBucket имя GridFS bucket для вложений
+const attachmentBucket = "attachments"
+
+// AttachmentStore хранилище содержимого вложений. Метаданные вложений хранятся отдельно, в коллекции Attachment
+type AttachmentStore interface {
+	// Put сохраняет содержимое и возвращает ключ, по которому его можно получить
+	Put(tenant db.Tenant, name string, data []byte) (string, error)
+	// Open открывает содержимое по ключу
+	Open(tenant db.Tenant, key string) (io.ReadCloser, error)
+	// Delete удаляет содержимое по ключу
+	Delete(tenant db.Tenant, key string) error
+}
+
+var (
+	attachmentStoreOnce sync.Once
+	attachmentStore     AttachmentStore
+)
+
+// Attachments хранилище вложений, выбранное настройкой ATTACHMENT_STORAGE. По умолчанию GridFS
+func Attachments() AttachmentStore {
+	attachmentStoreOnce.Do(func() {
+		if viper.GetString("attachment_storage") == AttachmentFilesystem {
+			dir := viper.GetString("attachment_dir")
+			if len(dir) == 0 {
+				dir = "attachments"
+			}
+			attachmentStore = fileStore{dir: dir}
+			return
+		}
+		attachmentStore = gridFSStore{name: attachmentBucket}
+	})
+	return attachmentStore
+}
+
+// gridFSStore хранит файлы в GridFS bucket name. Ключ - ObjectID файла в hex
+type gridFSStore struct {
+	name string
+}
+
+func (s gridFSStore) bucket(tenant db.Tenant) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(tenant.Database(), options.GridFSBucket().SetName(s.name))
+}
+
+func (s gridFSStore) Put(tenant db.Tenant, name string, data []byte) (string, error) {
+	bucket, err := s.bucket(tenant)
+	if err != nil {
+		return "", err
+	}
+	if err := bucket.SetWriteDeadline(time.Now().Add(60 * time.Second)); err != nil {
+		return "", err
+	}
+
+	id, err := bucket.UploadFromStream(name, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	return id.Hex(), nil
+}
+
+func (s gridFSStore) Open(tenant db.Tenant, key string) (io.ReadCloser, error) {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, err := s.bucket(tenant)
+	if err != nil {
+		return nil, err
+	}
+	if err := bucket.SetReadDeadline(time.Now().Add(60 * time.Second)); err != nil {
+		return nil, err
+	}
+
+	return bucket.OpenDownloadStream(id)
+}
+
+func (s gridFSStore) Delete(tenant db.Tenant, key string) error {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := s.bucket(tenant)
+	if err != nil {
+		return err
+	}
+	if err := bucket.SetWriteDeadline(time.Now().Add(60 * time.Second)); err != nil {
+		return err
+	}
+
+	return bucket.Delete(id)
+}
+
+// fileStore хранит файлы в каталоге dir/<площадка>/<ключ>. Ключ - новый ObjectID в hex,
+// имя файла от клиента в путь не попадает
+type fileStore struct {
+	dir string
+}
+
+func (s fileStore) path(tenant db.Tenant, key string) (string, error) {
+	if _, err := primitive.ObjectIDFromHex(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, string(tenant), key), nil
+}
+
+func (s fileStore) Put(tenant db.Tenant, name string, data []byte) (string, error) {
+	key := primitive.NewObjectID().Hex()
+	path, err := s.path(tenant, key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, data, 0640); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (s fileStore) Open(tenant db.Tenant, key string) (io.ReadCloser, error) {
+	path, err := s.path(tenant, key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s fileStore) Delete(tenant db.Tenant, key string) error {
+	path, err := s.path(tenant, key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
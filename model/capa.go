@@ -0,0 +1,489 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Состояния корректирующего действия
+const (
+	CAPAOpen   = "open"
+	CAPAClosed = "closed"
+)
+
+// Результаты проверки эффективности корректирующего действия
+const (
+	CAPAEffective   = "effective"
+	CAPAIneffective = "ineffective"
+)
+
+// Значения Accepted журнала, у которого есть корректирующие действия
+const (
+	capaPending  = -1
+	capaResolved = 1
+)
+
+// Errors godoc
+var (
+	ErrCAPAClosed       = errors.New("CAPA is closed")
+	ErrCAPAAction       = errors.New("action description is empty")
+	ErrCAPAVerification = errors.New("verification result must be effective or ineffective")
+	ErrCAPAIncomplete   = errors.New("CAPA can be closed only with root cause, actions taken and effective verification")
+)
+
+// CAPAAction выполненное корректирующее или предупреждающее действие
+type CAPAAction struct {
+	Description string    `bson:"description" json:"description" example:"Весы перекалиброваны, партия перевзвешена"`
+	By          string    `bson:"by" json:"by" example:"olegov"`
+	At          time.Time `bson:"at" json:"at"`
+}
+
+// CAPAVerification проверка эффективности выполненных действий
+type CAPAVerification struct {
+	Result  string    `bson:"result" json:"result" example:"effective"`
+	Comment string    `bson:"comment,omitempty" json:"comment,omitempty" example:"Три контрольных взвешивания в допуске"`
+	By      string    `bson:"by" json:"by" example:"quality_lead"`
+	At      time.Time `bson:"at" json:"at"`
+}
+
+// CAPA запись о корректирующем и предупреждающем действии. Открывается автоматически,
+// когда вычисляемая проверка журнала не пройдена, и закрывается только после проверки эффективности
+type CAPA struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3bd"`
+	db.Model `bson:",inline"`
+
+	Journal primitive.ObjectID `bson:"journal" json:"journal" example:"5ca10d9d015c736a72b7b3ba"`
+	Scheme  string             `bson:"scheme" json:"scheme" example:"scales_calibration"`
+	Item    string             `bson:"item" json:"item" example:"scale"`
+	// Date дата журнала
+	Date time.Time `bson:"date" json:"date"`
+	// Check вычисляемое поле не пройденной проверки
+	Check string `bson:"check" json:"check" example:"result"`
+	// Occurrence смена (id) или день (YYYY-MM-DD), в которые проверка не пройдена. По проверке журнала
+	// открывается одно действие на смену или день
+	Occurrence string              `bson:"occurrence" json:"occurrence" example:"2019-04-01"`
+	Shift      *primitive.ObjectID `bson:"shift,omitempty" json:"shift,omitempty" example:"5ca10d9d015c736a72b7b3b7"`
+	// Field проверяемое поле и его значение на момент открытия
+	Field string      `bson:"field" json:"field" example:"weight"`
+	Value interface{} `bson:"value,omitempty" json:"value,omitempty" swaggertype:"string" example:"10.4"`
+
+	Status       string            `bson:"status" json:"status" example:"open"`
+	Owner        string            `bson:"owner,omitempty" json:"owner,omitempty" example:"quality_lead"`
+	DueDate      time.Time         `bson:"due_date" json:"due_date"`
+	RootCause    string            `bson:"root_cause,omitempty" json:"root_cause,omitempty" example:"Весы не откалиброваны после ремонта"`
+	Actions      []CAPAAction      `bson:"actions,omitempty" json:"actions,omitempty"`
+	Verification *CAPAVerification `bson:"verification,omitempty" json:"verification,omitempty"`
+
+	ClosedBy string     `bson:"closed_by,omitempty" json:"closed_by,omitempty" example:"quality_lead"`
+	ClosedAt *time.Time `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	Closure  string     `bson:"closure,omitempty" json:"closure,omitempty" example:"Добавлена ежедневная поверка весов"`
+	// Confirmation подтверждение закрытия повторным вводом учетных данных
+	Confirmation *Confirmation `bson:"confirmation,omitempty" json:"confirmation,omitempty"`
+
+	// Overdue открыто после срока. Не хранится
+	Overdue bool `bson:"-" json:"overdue" example:"true"`
+}
+
+// CAPAUpdate назначение ответственного, срока и первопричины
+type CAPAUpdate struct {
+	Owner     string     `json:"owner,omitempty" example:"quality_lead"`
+	DueDate   *time.Time `json:"due_date,omitempty"`
+	RootCause string     `json:"root_cause,omitempty" example:"Весы не откалиброваны после ремонта"`
+}
+
+// NewCAPAAction выполненное действие
+type NewCAPAAction struct {
+	Description string `json:"description" binding:"required" example:"Весы перекалиброваны, партия перевзвешена"`
+}
+
+// CAPAVerify результат проверки эффективности
+type CAPAVerify struct {
+	Result  string `json:"result" binding:"required" example:"effective"`
+	Comment string `json:"comment,omitempty" example:"Три контрольных взвешивания в допуске"`
+}
+
+// CAPAClose закрытие корректирующего действия
+type CAPAClose struct {
+	Closure string `json:"closure" binding:"required" example:"Добавлена ежедневная поверка весов"`
+}
+
+// capaFields поля, доступные в фильтрах и сортировке списка корректирующих действий
+var capaFields = db.Fields{
+	"journal":    {Key: "journal", Type: db.ObjectIDField},
+	"scheme":     {Key: "scheme", Type: db.StringField, Sort: true},
+	"item":       {Key: "item", Type: db.StringField, Sort: true},
+	"date":       {Key: "date", Type: db.TimeField, Sort: true},
+	"check":      {Key: "check", Type: db.StringField, Sort: true},
+	"occurrence": {Key: "occurrence", Type: db.StringField, Sort: true},
+	"shift":      {Key: "shift", Type: db.ObjectIDField},
+	"field":      {Key: "field", Type: db.StringField, Sort: true},
+	"status":     {Key: "status", Type: db.StringField, Sort: true},
+	"owner":      {Key: "owner", Type: db.StringField, Sort: true},
+	"due_date":   {Key: "due_date", Type: db.TimeField, Sort: true},
+	"closed_at":  {Key: "closed_at", Type: db.TimeField, Sort: true},
+	"created_at": {Key: "created_at", Type: db.TimeField, Sort: true},
+	"updated_at": {Key: "updated_at", Type: db.TimeField, Sort: true},
+}
+
+func capaCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("capa")
+}
+
+// CAPADueDays срок корректирующего действия в днях из настройки CAPA_DUE_DAYS. По умолчанию 7
+func CAPADueDays() int {
+	if days := viper.GetInt("capa_due_days"); days > 0 {
+		return days
+	}
+	return 7
+}
+
+// overdue отмечает открытые корректирующие действия, срок которых прошел
+func (c *CAPA) overdue(now time.Time) {
+	c.Overdue = c.Status == CAPAOpen && c.DueDate.Before(now)
+}
+
+// failedChecks не пройденные проверки журнала: вычисляемые поля схемы со значением false
+func failedChecks(scheme JournalScheme, journal Journal) []JournalField {
+	failed := []JournalField{}
+	for _, field := range scheme.Fields {
+		if field.Computed == nil {
+			continue
+		}
+		if passed, ok := journal.Values[field.Name].(bool); ok && !passed {
+			failed = append(failed, field)
+		}
+	}
+	return failed
+}
+
+// capaOccurrence смена или день, в которые журнал записан с не пройденной проверкой
+func capaOccurrence(journal Journal, now time.Time) string {
+	if journal.Shift != nil {
+		return journal.Shift.Hex()
+	}
+	return now.Format("2006-01-02")
+}
+
+// newCAPA открытое корректирующее действие по не пройденной проверке check журнала
+func newCAPA(journal Journal, check JournalField, occurrence string, now time.Time) CAPA {
+	field := check.Computed.Field
+	if len(field) == 0 {
+		field = check.Name
+	}
+
+	capa := CAPA{
+		Journal:    journal.ID,
+		Scheme:     journal.Scheme,
+		Item:       journal.Item,
+		Date:       journal.Date,
+		Check:      check.Name,
+		Occurrence: occurrence,
+		Shift:      journal.Shift,
+		Field:      field,
+		Value:      journal.Values[field],
+		Status:     CAPAOpen,
+		DueDate:    now.AddDate(0, 0, CAPADueDays()),
+	}
+	capa.CreatedAt = now
+	capa.UpdatedAt = now
+	capa.Version = 1
+	return capa
+}
+
+// key отбор корректирующего действия по журналу, проверке и смене или дню
+func (c CAPA) key() bson.D {
+	return bson.D{{Key: "journal", Value: c.Journal}, {Key: "check", Value: c.Check}, {Key: "occurrence", Value: c.Occurrence}}
+}
+
+// openCAPAs открывает корректирующие действия по не пройденным проверкам журнала: одно на проверку
+// в смену или день, в которые журнал записан. Повторная запись в ту же смену или день нового действия не открывает
+func openCAPAs(tenant db.Tenant, journal Journal) error {
+	scheme, err := journalSchemeByName(tenant, journal.Scheme)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	occurrence := capaOccurrence(journal, now)
+	for _, check := range failedChecks(scheme, journal) {
+		capa := newCAPA(journal, check, occurrence, now)
+
+		// Уникальный индекс по journal, check и occurrence не дает двум записям открыть одно действие
+		timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err = capaCollection(tenant).UpdateOne(timeout, capa.key(), bson.D{{Key: "$setOnInsert", Value: capa}}, options.Update().SetUpsert(true))
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// capaAccepted значение Accepted журнала по его корректирующим действиям: -1, пока есть открытые,
+// 1, когда все закрыты. nil, если корректирующих действий у журнала нет
+func capaAccepted(tenant db.Tenant, journal primitive.ObjectID) (*int, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := capaCollection(tenant).CountDocuments(timeout, bson.D{{Key: "journal", Value: journal}})
+	if err != nil || total == 0 {
+		return nil, err
+	}
+
+	open, err := capaCollection(tenant).CountDocuments(timeout, bson.D{{Key: "journal", Value: journal}, {Key: "status", Value: CAPAOpen}})
+	if err != nil {
+		return nil, err
+	}
+	return acceptedOf(total, open), nil
+}
+
+// acceptedOf значение Accepted журнала с total корректирующими действиями, из которых open открыты
+func acceptedOf(total, open int64) *int {
+	if total == 0 {
+		return nil
+	}
+	accepted := capaResolved
+	if open != 0 {
+		accepted = capaPending
+	}
+	return &accepted
+}
+
+// syncCAPAAccepted записывает в Accepted журнала состояние его корректирующих действий.
+// Accepted производный и меняется и у журналов на удержании: удержание защищает значения журнала
+func syncCAPAAccepted(tenant db.Tenant, journal primitive.ObjectID) error {
+	accepted, err := capaAccepted(tenant, journal)
+	if err != nil || accepted == nil {
+		return err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: journal}, {Key: "accepted", Value: bson.D{{Key: "$ne", Value: *accepted}}}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "accepted", Value: *accepted}, {Key: "updated_at", Value: time.Now()}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+	_, err = journalCollection(tenant).UpdateOne(timeout, filter, update)
+	return err
+}
+
+// trackCAPAs открывает корректирующие действия по записанному журналу и обновляет его Accepted.
+// Журнал уже сохранен, поэтому ошибка только записывается в лог: недостающие действия откроются при следующей записи
+func trackCAPAs(tenant db.Tenant, journal *Journal) *Journal {
+	if err := openCAPAs(tenant, *journal); err != nil {
+		log.Println("CAPA of journal", journal.ID.Hex(), "was not opened:", err)
+		return journal
+	}
+	if err := syncCAPAAccepted(tenant, journal.ID); err != nil {
+		log.Println("accepted of journal", journal.ID.Hex(), "was not updated:", err)
+		return journal
+	}
+
+	tracked, err := journalFindOne(tenant, journal.ID)
+	if err != nil {
+		return journal
+	}
+	return tracked
+}
+
+func listCAPAs(tenant db.Tenant, base bson.D, scope *OrgScope, query db.Query) (db.Page, error) {
+	if scope != nil {
+		base = append(base, scope.filter())
+	}
+
+	var list []CAPA
+	page, err := db.FindPage(capaCollection(tenant), base, capaFields, query, nil, &list)
+	if err != nil {
+		return db.Page{}, err
+	}
+	now := time.Now()
+	for i := range list {
+		list[i].overdue(now)
+	}
+	page.Items = list
+	return page, nil
+}
+
+// CAPAs корректирующие действия. scope ограничивает позиции и схемы контроллера, nil - все
+func CAPAs(tenant db.Tenant, scope *OrgScope, query db.Query) (db.Page, error) {
+	return listCAPAs(tenant, bson.D{}, scope, query)
+}
+
+// OverdueCAPAs открытые корректирующие действия, срок которых прошел
+func OverdueCAPAs(tenant db.Tenant, scope *OrgScope, query db.Query) (db.Page, error) {
+	base := bson.D{
+		{Key: "status", Value: CAPAOpen},
+		{Key: "due_date", Value: bson.D{{Key: "$lt", Value: time.Now()}}},
+	}
+	return listCAPAs(tenant, base, scope, query)
+}
+
+// CAPAOne корректирующее действие id, если оно попадает в scope
+func CAPAOne(tenant db.Tenant, scope *OrgScope, id string) (*CAPA, error) {
+	capaID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var capa CAPA
+	if err := capaCollection(tenant).FindOne(timeout, bson.D{{Key: "_id", Value: capaID}}).Decode(&capa); err != nil {
+		return nil, err
+	}
+	if scope != nil && !scope.contains(Journal{Scheme: capa.Scheme, Item: capa.Item}) {
+		return nil, ErrJournalOutOfScope
+	}
+	capa.overdue(time.Now())
+	return &capa, nil
+}
+
+// updateCAPA применяет update к открытому корректирующему действию id с версией version
+func updateCAPA(tenant db.Tenant, scope *OrgScope, id string, version int64, set bson.D, extra bson.D) (*CAPA, error) {
+	capa, err := CAPAOne(tenant, scope, id)
+	if err != nil {
+		return nil, err
+	}
+	if capa.Status == CAPAClosed {
+		return nil, ErrCAPAClosed
+	}
+
+	set = append(set, bson.E{Key: "updated_at", Value: time.Now()}, bson.E{Key: "version", Value: version + 1})
+	update := append(bson.D{{Key: "$set", Value: set}}, extra...)
+	filter := bson.D{{Key: "status", Value: CAPAOpen}}
+	if err := updateVersioned(capaCollection(tenant), capa.ID, version, filter, update); err != nil {
+		return nil, err
+	}
+
+	return CAPAOne(tenant, scope, id)
+}
+
+// UpdateCAPA назначает ответственного, срок и первопричину открытого корректирующего действия.
+// Пустые поля запроса не меняются
+func UpdateCAPA(tenant db.Tenant, id string, version int64, change CAPAUpdate) (*CAPA, error) {
+	set := bson.D{}
+	if len(change.Owner) != 0 {
+		set = append(set, bson.E{Key: "owner", Value: change.Owner})
+	}
+	if change.DueDate != nil {
+		set = append(set, bson.E{Key: "due_date", Value: *change.DueDate})
+	}
+	if len(change.RootCause) != 0 {
+		set = append(set, bson.E{Key: "root_cause", Value: strings.TrimSpace(change.RootCause)})
+	}
+	return updateCAPA(tenant, nil, id, version, set, nil)
+}
+
+// AddCAPAAction записывает действие, выполненное пользователем by. Новое действие сбрасывает
+// проверку эффективности: ее нужно провести заново
+func AddCAPAAction(tenant db.Tenant, scope *OrgScope, id string, version int64, by string, action NewCAPAAction) (*CAPA, error) {
+	description := strings.TrimSpace(action.Description)
+	if len(description) == 0 {
+		return nil, ErrCAPAAction
+	}
+
+	extra := bson.D{
+		{Key: "$push", Value: bson.D{{Key: "actions", Value: CAPAAction{Description: description, By: by, At: time.Now()}}}},
+		{Key: "$unset", Value: bson.D{{Key: "verification", Value: ""}}},
+	}
+	return updateCAPA(tenant, scope, id, version, bson.D{}, extra)
+}
+
+// VerifyCAPA записывает результат проверки эффективности действий от имени пользователя by
+func VerifyCAPA(tenant db.Tenant, id string, version int64, by string, verify CAPAVerify) (*CAPA, error) {
+	if verify.Result != CAPAEffective && verify.Result != CAPAIneffective {
+		return nil, ErrCAPAVerification
+	}
+
+	verification := CAPAVerification{Result: verify.Result, Comment: verify.Comment, By: by, At: time.Now()}
+	return updateCAPA(tenant, nil, id, version, bson.D{{Key: "verification", Value: verification}}, nil)
+}
+
+// checkClose проверяет, что действие открыто и у него есть первопричина, выполненные действия и эффективная проверка
+func (c CAPA) checkClose() error {
+	if c.Status == CAPAClosed {
+		return ErrCAPAClosed
+	}
+	if len(c.RootCause) == 0 || len(c.Actions) == 0 || c.Verification == nil || c.Verification.Result != CAPAEffective {
+		return ErrCAPAIncomplete
+	}
+	return nil
+}
+
+// CloseCAPA закрывает корректирующее действие от имени пользователя by. Закрыть можно только действие
+// с первопричиной, выполненными действиями и эффективной проверкой. Когда закрыты все действия журнала,
+// его Accepted становится 1
+func CloseCAPA(tenant db.Tenant, id string, version int64, by string, close CAPAClose, confirmation *Confirmation) (*CAPA, error) {
+	capa, err := CAPAOne(tenant, nil, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := capa.checkClose(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	set := bson.D{
+		{Key: "status", Value: CAPAClosed},
+		{Key: "closed_by", Value: by},
+		{Key: "closed_at", Value: now},
+		{Key: "closure", Value: close.Closure},
+	}
+	if confirmation != nil {
+		set = append(set, bson.E{Key: "confirmation", Value: confirmation})
+	}
+	closed, err := updateCAPA(tenant, nil, id, version, set, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncCAPAAccepted(tenant, closed.Journal); err != nil {
+		log.Println("accepted of journal", closed.Journal.Hex(), "was not updated:", err)
+	}
+	return closed, nil
+}
+
+// journalCAPAs корректирующие действия журнала в порядке открытия
+func journalCAPAs(tenant db.Tenant, journal primitive.ObjectID) ([]CAPA, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := capaCollection(tenant).Find(timeout, bson.D{{Key: "journal", Value: journal}}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	now := time.Now()
+	list := []CAPA{}
+	for cur.Next(timeout) {
+		var capa CAPA
+		if err := cur.Decode(&capa); err != nil {
+			return nil, err
+		}
+		capa.overdue(now)
+		list = append(list, capa)
+	}
+	return list, cur.Err()
+}
+
+// purgeJournalCAPAs удаляет корректирующие действия журналов ids, удаленных окончательно
+func purgeJournalCAPAs(tenant db.Tenant, ids []primitive.ObjectID) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	_, err := capaCollection(tenant).DeleteMany(timeout, bson.D{{Key: "journal", Value: bson.D{{Key: "$in", Value: ids}}}})
+	return err
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFailedChecks(t *testing.T) {
+	scheme := JournalScheme{Fields: []JournalField{
+		{Name: "weight"},
+		{Name: "in_range", Computed: &JournalComputed{Field: "weight"}},
+		{Name: "in_norm", Computed: &JournalComputed{Field: "weight"}},
+		{Name: "deviation", Computed: &JournalComputed{Field: "weight"}},
+	}}
+	journal := Journal{Values: map[string]interface{}{
+		"weight":    10.4,
+		"in_range":  false,
+		"in_norm":   true,
+		"deviation": 0.4,
+	}}
+
+	failed := failedChecks(scheme, journal)
+	if len(failed) != 1 || failed[0].Name != "in_range" {
+		t.Errorf("failedChecks = %+v, want in_range", failed)
+	}
+}
+
+func TestCAPAOccurrence(t *testing.T) {
+	now := time.Date(2019, 4, 1, 23, 30, 0, 0, time.Local)
+	if got := capaOccurrence(Journal{}, now); got != "2019-04-01" {
+		t.Errorf("without shift: capaOccurrence = %s, want 2019-04-01", got)
+	}
+
+	shift := primitive.NewObjectID()
+	if got := capaOccurrence(Journal{Shift: &shift}, now); got != shift.Hex() {
+		t.Errorf("with shift: capaOccurrence = %s, want %s", got, shift.Hex())
+	}
+}
+
+func TestNewCAPA(t *testing.T) {
+	now := time.Date(2019, 4, 1, 10, 0, 0, 0, time.Local)
+	journal := Journal{
+		ID:     primitive.NewObjectID(),
+		Scheme: "scales_calibration",
+		Item:   "scale",
+		Values: map[string]interface{}{"weight": 10.4, "in_range": false},
+	}
+
+	capa := newCAPA(journal, JournalField{Name: "in_range", Computed: &JournalComputed{Field: "weight"}}, "2019-04-01", now)
+	if capa.Field != "weight" || capa.Value != 10.4 {
+		t.Errorf("checked field = %s %v, want weight 10.4", capa.Field, capa.Value)
+	}
+	if capa.Status != CAPAOpen || capa.Version != 1 {
+		t.Errorf("status %s version %d, want open 1", capa.Status, capa.Version)
+	}
+	if want := now.AddDate(0, 0, CAPADueDays()); !capa.DueDate.Equal(want) {
+		t.Errorf("due date = %v, want %v", capa.DueDate, want)
+	}
+
+	key := bson.D{{Key: "journal", Value: journal.ID}, {Key: "check", Value: "in_range"}, {Key: "occurrence", Value: "2019-04-01"}}
+	if got := capa.key(); !reflect.DeepEqual(got, key) {
+		t.Errorf("key = %v, want %v", got, key)
+	}
+
+	own := newCAPA(journal, JournalField{Name: "in_range", Computed: &JournalComputed{}}, "2019-04-01", now)
+	if own.Field != "in_range" || own.Value != false {
+		t.Errorf("check without field: %s %v, want in_range false", own.Field, own.Value)
+	}
+}
+
+func TestAcceptedOf(t *testing.T) {
+	if got := acceptedOf(0, 0); got != nil {
+		t.Errorf("no CAPA: acceptedOf = %d, want nil", *got)
+	}
+	if got := acceptedOf(2, 1); got == nil || *got != capaPending {
+		t.Errorf("open CAPA: acceptedOf = %v, want %d", got, capaPending)
+	}
+	if got := acceptedOf(2, 0); got == nil || *got != capaResolved {
+		t.Errorf("closed CAPA: acceptedOf = %v, want %d", got, capaResolved)
+	}
+}
+
+func TestCAPACheckClose(t *testing.T) {
+	complete := CAPA{
+		Status:       CAPAOpen,
+		RootCause:    "Весы не откалиброваны",
+		Actions:      []CAPAAction{{Description: "Весы перекалиброваны"}},
+		Verification: &CAPAVerification{Result: CAPAEffective},
+	}
+
+	ineffective := complete
+	ineffective.Verification = &CAPAVerification{Result: CAPAIneffective}
+	noCause := complete
+	noCause.RootCause = ""
+	noActions := complete
+	noActions.Actions = nil
+	unverified := complete
+	unverified.Verification = nil
+	closed := complete
+	closed.Status = CAPAClosed
+
+	tests := []struct {
+		name string
+		capa CAPA
+		want error
+	}{
+		{"complete", complete, nil},
+		{"ineffective", ineffective, ErrCAPAIncomplete},
+		{"no root cause", noCause, ErrCAPAIncomplete},
+		{"no actions", noActions, ErrCAPAIncomplete},
+		{"not verified", unverified, ErrCAPAIncomplete},
+		{"closed", closed, ErrCAPAClosed},
+	}
+	for _, test := range tests {
+		if got := test.capa.checkClose(); got != test.want {
+			t.Errorf("%s: checkClose = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCAPAOverdue(t *testing.T) {
+	now := time.Date(2019, 4, 10, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		capa CAPA
+		want bool
+	}{
+		{"open past due", CAPA{Status: CAPAOpen, DueDate: now.AddDate(0, 0, -1)}, true},
+		{"open in time", CAPA{Status: CAPAOpen, DueDate: now.AddDate(0, 0, 1)}, false},
+		{"closed past due", CAPA{Status: CAPAClosed, DueDate: now.AddDate(0, 0, -1)}, false},
+	}
+	for _, test := range tests {
+		test.capa.overdue(now)
+		if test.capa.Overdue != test.want {
+			t.Errorf("%s: overdue = %v, want %v", test.name, test.capa.Overdue, test.want)
+		}
+	}
+}
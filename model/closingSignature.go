@@ -0,0 +1,145 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/Oxynger/JournalApp/db"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Результаты проверки подписи росписи
+const (
+	ClosingSignatureValid   = "valid"
+	ClosingSignatureInvalid = "invalid"
+	// ClosingUnsigned роспись сделана до введения ключей подписи
+	ClosingUnsigned = "unsigned"
+	// ClosingKeyUnknown ключа с отпечатком росписи нет на площадке
+	ClosingKeyUnknown = "unknown_key"
+	// ClosingKeyRevoked роспись подписана ключом после его отзыва
+	ClosingKeyRevoked = "revoked"
+	// ClosingValuesChanged подпись верна, но значения закрытого журнала изменились после росписи
+	ClosingValuesChanged = "values_changed"
+)
+
+// payload канонический вид росписи журнала за день или смену, который подписывает контроллер.
+// Смысл и время подтверждения входят в него, только если роспись подтверждена
+func (c JournalClosing) payload(journal Journal) ([]byte, error) {
+	var shift, meaning string
+	var confirmedAt interface{}
+	if c.Shift != nil {
+		shift = c.Shift.Hex()
+	}
+	if c.Confirmation != nil {
+		meaning = c.Confirmation.Meaning
+		confirmedAt = canonical(c.Confirmation.At)
+	}
+	return json.Marshal(struct {
+		Journal         string      `json:"journal"`
+		Scheme          string      `json:"scheme"`
+		Item            string      `json:"item"`
+		Date            interface{} `json:"date"`
+		Shift           string      `json:"shift"`
+		ClosedBy        string      `json:"closed_by"`
+		ClosedAt        interface{} `json:"closed_at"`
+		Accepted        *int        `json:"accepted"`
+		SignatureDigest string      `json:"signature_digest"`
+		ValuesDigest    string      `json:"values_digest"`
+		KeyFingerprint  string      `json:"key_fingerprint"`
+		Meaning         string      `json:"meaning,omitempty"`
+		ConfirmedAt     interface{} `json:"confirmed_at,omitempty"`
+	}{
+		Journal:         journal.ID.Hex(),
+		Scheme:          journal.Scheme,
+		Item:            journal.Item,
+		Date:            canonical(c.Date),
+		Shift:           shift,
+		ClosedBy:        c.ClosedBy,
+		ClosedAt:        canonical(c.ClosedAt),
+		Accepted:        c.Accepted,
+		SignatureDigest: sha256Hex(c.Signature),
+		ValuesDigest:    c.ValuesDigest,
+		KeyFingerprint:  c.KeyFingerprint,
+		Meaning:         meaning,
+		ConfirmedAt:     confirmedAt,
+	})
+}
+
+// sign подписывает роспись журнала закрытым ключом с отпечатком fingerprint
+func (c *JournalClosing) sign(journal Journal, fingerprint string, private ed25519.PrivateKey) error {
+	digest, err := valuesDigest(journal.Values)
+	if err != nil {
+		return err
+	}
+	c.ValuesDigest = digest
+	c.KeyFingerprint = fingerprint
+
+	payload, err := c.payload(journal)
+	if err != nil {
+		return err
+	}
+	c.DigitalSignature = ed25519.Sign(private, payload)
+	return nil
+}
+
+// verify проверяет подпись росписи ключом key
+func (c JournalClosing) verify(journal Journal, key OperatorKey) string {
+	payload, err := c.payload(journal)
+	if err != nil || len(key.PublicKey) != ed25519.PublicKeySize ||
+		!ed25519.Verify(ed25519.PublicKey(key.PublicKey), payload, c.DigitalSignature) {
+		return ClosingSignatureInvalid
+	}
+	if key.RevokedAt != nil && c.ClosedAt.After(*key.RevokedAt) {
+		return ClosingKeyRevoked
+	}
+	return ClosingSignatureValid
+}
+
+// signingKeys ключи, которыми подписаны росписи журнала, по отпечатку
+func signingKeys(tenant db.Tenant, journal Journal) (map[string]OperatorKey, error) {
+	var fingerprints []string
+	for _, closing := range journal.Closings {
+		if len(closing.KeyFingerprint) != 0 {
+			fingerprints = append(fingerprints, closing.KeyFingerprint)
+		}
+	}
+	return operatorKeysByFingerprint(tenant, fingerprints)
+}
+
+// finalClosing индекс росписи, которой закрыт не ежедневный журнал, или -1. Значения такого журнала
+// после росписи не меняются, поэтому их хеш можно пересчитать
+func (j Journal) finalClosing() int {
+	if j.Daily || j.Status != JournalClosed || len(j.Closings) == 0 {
+		return -1
+	}
+	return len(j.Closings) - 1
+}
+
+// verifyClosings проверяет подписи росписей журнала и записывает результат в Verification.
+// У росписи, закрывшей не ежедневный журнал, хеш значений пересчитывается по текущим значениям
+func verifyClosings(tenant db.Tenant, journal *Journal) error {
+	keys, err := signingKeys(tenant, *journal)
+	if err != nil {
+		return err
+	}
+
+	final := journal.finalClosing()
+	for i, closing := range journal.Closings {
+		key, ok := keys[closing.KeyFingerprint]
+		switch {
+		case len(closing.DigitalSignature) == 0:
+			journal.Closings[i].Verification = ClosingUnsigned
+		case !ok:
+			journal.Closings[i].Verification = ClosingKeyUnknown
+		default:
+			journal.Closings[i].Verification = closing.verify(*journal, key)
+		}
+
+		if i == final && len(closing.ValuesDigest) != 0 && journal.Closings[i].Verification == ClosingSignatureValid {
+			digest, err := valuesDigest(journal.Values)
+			if err != nil || digest != closing.ValuesDigest {
+				journal.Closings[i].Verification = ClosingValuesChanged
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Смысл подтверждения критического действия
+const (
+	MeaningAuthored    = "authored"
+	MeaningReviewed    = "reviewed"
+	MeaningApproved    = "approved"
+	MeaningResponsible = "responsible"
+)
+
+// Критические действия, которые требуют подтверждения
+const (
+	ActionJournalClose        = "journal.close"
+	ActionItemSchemeCreate    = "item_scheme.create"
+	ActionItemSchemeUpdate    = "item_scheme.update"
+	ActionItemSchemeDelete    = "item_scheme.delete"
+	ActionJournalSchemeCreate = "journal_scheme.create"
+	ActionJournalSchemeUpdate = "journal_scheme.update"
+	ActionJournalSchemeDelete = "journal_scheme.delete"
+	ActionReportSchemeCreate  = "report_scheme.create"
+	ActionReportSchemeUpdate  = "report_scheme.update"
+	ActionReportSchemeDelete  = "report_scheme.delete"
+	ActionSchemeImport        = "scheme.import"
+	ActionJournalReview       = "journal.review"
+	ActionCAPAClose           = "capa.close"
+)
+
+// Meanings допустимые значения смысла подтверждения
+var Meanings = []string{MeaningAuthored, MeaningReviewed, MeaningApproved, MeaningResponsible}
+
+// Errors godoc
+var (
+	ErrMeaningInvalid = errors.New("meaning must be authored, reviewed, approved or responsible")
+)
+
+// Confirmation подтверждение критического действия: кто повторно ввел учетные данные, когда и с каким смыслом
+type Confirmation struct {
+	Meaning string    `bson:"meaning" json:"meaning" example:"approved"`
+	By      string    `bson:"by" json:"by" example:"olegov"`
+	At      time.Time `bson:"at" json:"at"`
+}
+
+// ConfirmedAction запись журнала подтвержденных действий. Записи только добавляются
+type ConfirmedAction struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Confirmation `bson:",inline"`
+	// Action критическое действие
+	Action string `bson:"action" json:"action" example:"journal.close"`
+	// Resource путь запроса, которым выполнено действие
+	Resource    string    `bson:"resource" json:"resource" example:"/api/v1/journal/5ca10d9d015c736a72b7b3b9/signature"`
+	PerformedAt time.Time `bson:"performed_at" json:"performed_at"`
+}
+
+// confirmedActionFields поля, доступные в фильтрах и сортировке журнала подтвержденных действий
+var confirmedActionFields = db.Fields{
+	"action":       {Key: "action", Type: db.StringField, Sort: true},
+	"meaning":      {Key: "meaning", Type: db.StringField, Sort: true},
+	"by":           {Key: "by", Type: db.StringField, Sort: true},
+	"at":           {Key: "at", Type: db.TimeField, Sort: true},
+	"performed_at": {Key: "performed_at", Type: db.TimeField, Sort: true},
+}
+
+func confirmedActionCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("confirmedAction")
+}
+
+// CheckMeaning проверяет смысл подтверждения
+func CheckMeaning(meaning string) error {
+	if !CheckIn(meaning, Meanings) {
+		return ErrMeaningInvalid
+	}
+	return nil
+}
+
+// RecordConfirmedAction записывает выполненное критическое действие action над resource с подтверждением confirmation
+func RecordConfirmedAction(tenant db.Tenant, action, resource string, confirmation Confirmation) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := confirmedActionCollection(tenant).InsertOne(timeout, ConfirmedAction{
+		Confirmation: confirmation,
+		Action:       action,
+		Resource:     resource,
+		PerformedAt:  time.Now(),
+	})
+	return err
+}
+
+// ConfirmedActions журнал подтвержденных действий площадки
+func ConfirmedActions(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var list []ConfirmedAction
+	return db.FindPage(confirmedActionCollection(tenant), bson.D{}, confirmedActionFields, query, nil, &list)
+}
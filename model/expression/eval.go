@@ -0,0 +1,335 @@
+package expression
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type node interface {
+	eval(env Env) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+type refNode struct {
+	item bool
+	name string
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+// List приводит значение из JSON или BSON к списку
+func List(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case primitive.A:
+		return []interface{}(v), true
+	case []string:
+		items := make([]interface{}, len(v))
+		for i := range v {
+			items[i] = v[i]
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// normalize приводит значения из JSON и BSON к типам языка: float64, string, bool, nil и списку []interface{}
+func normalize(value interface{}) interface{} {
+	if items, ok := List(value); ok {
+		return items
+	}
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
+
+func (n literalNode) eval(env Env) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n refNode) eval(env Env) (interface{}, error) {
+	if n.item {
+		value := normalize(env.Item[n.name])
+		// Информация о позиции хранится строками: "2" в выражении - число
+		if s, ok := value.(string); ok {
+			if number, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				return number, nil
+			}
+		}
+		return value, nil
+	}
+	return normalize(env.Fields[n.name]), nil
+}
+
+func numberOf(value interface{}, op string) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case nil:
+		return 0, ErrMissingValue
+	}
+	return 0, &TypeError{Msg: op + " expects numbers"}
+}
+
+func (n unaryNode) eval(env Env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "!":
+		return !Truthy(x), nil
+	case "-":
+		number, err := numberOf(x, "-")
+		if err != nil {
+			return nil, err
+		}
+		return -number, nil
+	}
+	return numberOf(x, "+")
+}
+
+func (n binaryNode) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Логические операторы вычисляются сокращенно
+	switch n.op {
+	case "&&":
+		if !Truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return Truthy(right), nil
+	case "||":
+		if Truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return Truthy(right), nil
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		if !equatable(left) || !equatable(right) {
+			return nil, &TypeError{Msg: n.op + " expects numbers, strings, booleans or null"}
+		}
+		return (left == right) == (n.op == "=="), nil
+	case "<", "<=", ">", ">=":
+		return compare(n.op, left, right)
+	case "+":
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		}
+	}
+
+	l, err := numberOf(left, n.op)
+	if err != nil {
+		return nil, err
+	}
+	r, err := numberOf(right, n.op)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return math.Mod(l, r), nil
+	}
+	return nil, &TypeError{Msg: "unknown operator " + n.op}
+}
+
+// equatable значения, которые можно сравнивать на равенство. Списки сравнивать нельзя
+func equatable(value interface{}) bool {
+	switch value.(type) {
+	case nil, bool, float64, string:
+		return true
+	}
+	return false
+}
+
+func compare(op string, left, right interface{}) (interface{}, error) {
+	if l, ok := left.(string); ok {
+		r, ok := right.(string)
+		if !ok {
+			return nil, &TypeError{Msg: op + " expects two numbers or two strings"}
+		}
+		switch op {
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		}
+		return l >= r, nil
+	}
+
+	l, err := numberOf(left, op)
+	if err != nil {
+		return nil, err
+	}
+	r, err := numberOf(right, op)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	}
+	return l >= r, nil
+}
+
+func (n callNode) eval(env Env) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return n.fn.call(n.name, args)
+}
+
+// function встроенная функция. maxArgs -1 - любое количество аргументов
+type function struct {
+	minArgs int
+	maxArgs int
+	call    func(name string, args []interface{}) (interface{}, error)
+}
+
+func numbers(name string, args []interface{}) ([]float64, error) {
+	result := make([]float64, len(args))
+	for i, arg := range args {
+		number, err := numberOf(arg, name)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = number
+	}
+	return result, nil
+}
+
+func unaryMath(f func(float64) float64) function {
+	return function{minArgs: 1, maxArgs: 1, call: func(name string, args []interface{}) (interface{}, error) {
+		x, err := numbers(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return f(x[0]), nil
+	}}
+}
+
+func extremum(less func(a, b float64) bool) function {
+	return function{minArgs: 1, maxArgs: -1, call: func(name string, args []interface{}) (interface{}, error) {
+		x, err := numbers(name, args)
+		if err != nil {
+			return nil, err
+		}
+		result := x[0]
+		for _, value := range x[1:] {
+			if less(value, result) {
+				result = value
+			}
+		}
+		return result, nil
+	}}
+}
+
+// functions встроенные функции языка
+var functions = map[string]function{
+	"abs":   unaryMath(math.Abs),
+	"floor": unaryMath(math.Floor),
+	"ceil":  unaryMath(math.Ceil),
+	"min":   extremum(func(a, b float64) bool { return a < b }),
+	"max":   extremum(func(a, b float64) bool { return a > b }),
+	// round(x) или round(x, digits)
+	"round": {minArgs: 1, maxArgs: 2, call: func(name string, args []interface{}) (interface{}, error) {
+		x, err := numbers(name, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) == 1 {
+			return math.Round(x[0]), nil
+		}
+		digits := math.Pow10(int(math.Max(-15, math.Min(15, x[1]))))
+		return math.Round(x[0]*digits) / digits, nil
+	}},
+	"len": {minArgs: 1, maxArgs: 1, call: func(name string, args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(utf8.RuneCountInString(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return nil, ErrMissingValue
+		}
+		return nil, &TypeError{Msg: "len expects a string or an array"}
+	}},
+}
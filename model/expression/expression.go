@@ -0,0 +1,321 @@
+// Package expression небольшой язык выражений для вычисляемых полей и условий отображения полей журнала.
+//
+// Выражение может содержать числа, строки в кавычках, true, false, null, ссылки на поля журнала (weight)
+// и на информацию о позиции (item.max_w), арифметику (+ - * / %), сравнения (== != < <= > >=),
+// логику (&& || ! или and or not) и функции abs, min, max, round, floor, ceil, len.
+// Вычисление не имеет побочных эффектов: выражение видит только переданные ему значения
+package expression
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Ограничения на размер выражения
+const (
+	MaxLength = 1024
+	MaxDepth  = 32
+)
+
+// ItemPrefix префикс ссылок на информацию о позиции
+const ItemPrefix = "item."
+
+// Errors godoc
+var (
+	ErrTooLong        = errors.New("expression is too long")
+	ErrTooDeep        = errors.New("expression is too deeply nested")
+	ErrDivisionByZero = errors.New("division by zero")
+	// ErrMissingValue в выражении используется незаполненное поле
+	ErrMissingValue = errors.New("value is missing")
+)
+
+// SyntaxError ошибка разбора выражения
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d: %s", e.Pos, e.Msg)
+}
+
+// TypeError операция применена к значениям неподходящего типа
+type TypeError struct {
+	Msg string
+}
+
+func (e *TypeError) Error() string {
+	return "type error: " + e.Msg
+}
+
+// Env значения, доступные выражению
+type Env struct {
+	// Fields значения полей журнала
+	Fields map[string]interface{}
+	// Item информация о позиции
+	Item map[string]interface{}
+}
+
+// Expression разобранное выражение
+type Expression struct {
+	source string
+	root   node
+	fields []string
+	item   []string
+}
+
+// Parse разбирает и проверяет выражение. Неизвестные функции и неверное число аргументов - ошибка разбора
+func Parse(source string) (*Expression, error) {
+	if len(source) > MaxLength {
+		return nil, ErrTooLong
+	}
+
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.expression(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, &SyntaxError{Pos: p.peek().pos, Msg: "unexpected " + p.peek().text}
+	}
+
+	e := &Expression{source: source, root: root}
+	fields, item := map[string]bool{}, map[string]bool{}
+	collectRefs(root, fields, item)
+	e.fields = sortedKeys(fields)
+	e.item = sortedKeys(item)
+
+	return e, nil
+}
+
+// String исходный текст выражения
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Fields поля журнала, на которые ссылается выражение
+func (e *Expression) Fields() []string {
+	return e.fields
+}
+
+// ItemInfo поля информации о позиции, на которые ссылается выражение
+func (e *Expression) ItemInfo() []string {
+	return e.item
+}
+
+// Eval вычисляет выражение. Результат - float64, string, bool или nil
+func (e *Expression) Eval(env Env) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+// Truthy значение считается истинным: true, ненулевое число, непустая строка
+func Truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return len(v) != 0
+	}
+	return true
+}
+
+func collectRefs(n node, fields, item map[string]bool) {
+	switch n := n.(type) {
+	case refNode:
+		if n.item {
+			item[n.name] = true
+		} else {
+			fields[n.name] = true
+		}
+	case unaryNode:
+		collectRefs(n.x, fields, item)
+	case binaryNode:
+		collectRefs(n.left, fields, item)
+		collectRefs(n.right, fields, item)
+	case callNode:
+		for _, arg := range n.args {
+			collectRefs(arg, fields, item)
+		}
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Приоритеты бинарных операторов
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+const unaryPrecedence = 7
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// expression разбирает выражение с операторами приоритета выше minPrecedence
+func (p *parser) expression(minPrecedence int, depth int) (node, error) {
+	if depth > MaxDepth {
+		return nil, ErrTooDeep
+	}
+
+	left, err := p.unary(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokenOperator || !ok || prec <= minPrecedence {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.expression(prec, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) unary(depth int) (node, error) {
+	if depth > MaxDepth {
+		return nil, ErrTooDeep
+	}
+
+	t := p.peek()
+	if t.kind == tokenOperator && (t.text == "-" || t.text == "!" || t.text == "+") {
+		p.next()
+		x, err := p.expression(unaryPrecedence-1, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: t.text, x: x}, nil
+	}
+
+	return p.primary(depth)
+}
+
+func (p *parser) primary(depth int) (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		return literalNode{value: t.number}, nil
+
+	case tokenString:
+		return literalNode{value: t.text}, nil
+
+	case tokenLParen:
+		x, err := p.expression(0, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "missing )"}
+		}
+		return x, nil
+
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null":
+			return literalNode{value: nil}, nil
+		}
+
+		if p.peek().kind == tokenLParen {
+			return p.call(t, depth)
+		}
+
+		if strings.HasPrefix(t.text, ItemPrefix) {
+			name := strings.TrimPrefix(t.text, ItemPrefix)
+			if len(name) == 0 || strings.Contains(name, ".") {
+				return nil, &SyntaxError{Pos: t.pos, Msg: "invalid item reference " + t.text}
+			}
+			return refNode{item: true, name: name}, nil
+		}
+		if strings.Contains(t.text, ".") {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "invalid field reference " + t.text}
+		}
+		return refNode{name: t.text}, nil
+	}
+
+	if t.kind == tokenEOF {
+		return nil, &SyntaxError{Pos: t.pos, Msg: "unexpected end of expression"}
+	}
+	return nil, &SyntaxError{Pos: t.pos, Msg: "unexpected " + t.text}
+}
+
+func (p *parser) call(name token, depth int) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, &SyntaxError{Pos: name.pos, Msg: "unknown function " + name.text}
+	}
+	p.next()
+
+	args := []node{}
+	if p.peek().kind == tokenRParen {
+		p.next()
+	} else {
+		for {
+			arg, err := p.expression(0, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			t := p.next()
+			if t.kind == tokenRParen {
+				break
+			}
+			if t.kind != tokenComma {
+				return nil, &SyntaxError{Pos: t.pos, Msg: "expected , or )"}
+			}
+		}
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, &SyntaxError{Pos: name.pos, Msg: "wrong number of arguments for " + name.text}
+	}
+
+	return callNode{name: name.text, fn: fn, args: args}, nil
+}
@@ -0,0 +1,202 @@
+package expression
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEval(t *testing.T) {
+	env := Env{
+		Fields: map[string]interface{}{
+			"weight": 2.5,
+			"count":  int32(4),
+			"name":   "весы",
+			"done":   true,
+			"tags":   primitive.A{"a", "b", "c"},
+			"marks":  []interface{}{1.0, 2.0},
+			"codes":  []string{"x"},
+		},
+		Item: map[string]interface{}{
+			"max_w": "3",
+			"model": "ВЛ-210",
+		},
+	}
+
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"2 * 3 % 4", 2.0},
+		{"-2 * 3", -6.0},
+		{"- -2", 2.0},
+		{"+weight", 2.5},
+		{"1.5e1", 15.0},
+		{".5 + .5", 1.0},
+		{"weight * count", 10.0},
+		{"weight <= item.max_w", true},
+		{"item.model + ' ' + name", "ВЛ-210 весы"},
+		{"'a\\'b'", "a'b"},
+		{"\"abc\" < \"abd\"", true},
+		{"1 < 2 == true", true},
+		{"1 == 1 && 2 > 3 || done", true},
+		{"not done or 1 > 0 and 0", false},
+		{"!done", false},
+		{"!''", true},
+		{"missing == null", true},
+		{"done != false", true},
+		{"missing && missing + 1", false},
+		{"done || missing + 1", true},
+		{"abs(-3)", 3.0},
+		{"floor(2.7) + ceil(2.2)", 5.0},
+		{"min(3, 1, 2)", 1.0},
+		{"max(3, 1, 2)", 3.0},
+		{"round(2.5)", 3.0},
+		{"round(2.345, 2)", 2.35},
+		{"len(name)", 4.0},
+		{"len(tags)", 3.0},
+		{"len(marks)", 2.0},
+		{"len(codes)", 1.0},
+	}
+
+	for _, test := range tests {
+		e, err := Parse(test.source)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.source, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Eval(%q) = %v, want %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := Env{Fields: map[string]interface{}{"name": "весы", "tags": primitive.A{"a"}}}
+
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{"1 / 0", ErrDivisionByZero},
+		{"1 % 0", ErrDivisionByZero},
+		{"missing + 1", ErrMissingValue},
+		{"-missing", ErrMissingValue},
+		{"len(missing)", ErrMissingValue},
+		{"abs(missing)", ErrMissingValue},
+	}
+
+	for _, test := range tests {
+		e, err := Parse(test.source)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.source, err)
+			continue
+		}
+		if _, err := e.Eval(env); err != test.err {
+			t.Errorf("Eval(%q) error = %v, want %v", test.source, err, test.err)
+		}
+	}
+
+	typeErrors := []string{
+		"name * 2",
+		"name < 1",
+		"-name",
+		"tags == tags",
+		"len(1)",
+		"abs(name)",
+	}
+
+	for _, source := range typeErrors {
+		e, err := Parse(source)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", source, err)
+			continue
+		}
+		if _, err := e.Eval(env); err == nil {
+			t.Errorf("Eval(%q) succeeded, want a type error", source)
+		} else if _, ok := err.(*TypeError); !ok {
+			t.Errorf("Eval(%q) error = %v, want a type error", source, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		pos    int
+	}{
+		{"", 0},
+		{"1 +", 3},
+		{"(1 + 2", 0},
+		{"1 2", 2},
+		{"foo(1)", 0},
+		{"abs()", 0},
+		{"abs(1, 2)", 0},
+		{"round(1, 2, 3)", 0},
+		{"min(1 2)", 6},
+		{"item.", 0},
+		{"item.a.b", 0},
+		{"a.b", 0},
+		{"'abc", 0},
+		{"1 # 2", 2},
+		{"1..2", 0},
+		{")", 0},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.source)
+		syntax, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%q) error = %v, want a syntax error", test.source, err)
+			continue
+		}
+		if syntax.Pos != test.pos {
+			t.Errorf("Parse(%q) error at %d, want %d", test.source, syntax.Pos, test.pos)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	long := make([]byte, MaxLength+1)
+	for i := range long {
+		long[i] = '1'
+	}
+	if _, err := Parse(string(long)); err != ErrTooLong {
+		t.Errorf("Parse(long) error = %v, want %v", err, ErrTooLong)
+	}
+
+	deep := ""
+	for i := 0; i <= MaxDepth; i++ {
+		deep += "("
+	}
+	deep += "1"
+	for i := 0; i <= MaxDepth; i++ {
+		deep += ")"
+	}
+	if _, err := Parse(deep); err != ErrTooDeep {
+		t.Errorf("Parse(deep) error = %v, want %v", err, ErrTooDeep)
+	}
+}
+
+func TestRefs(t *testing.T) {
+	e, err := Parse("weight / item.max_w + len(tags) + weight * item.min_w")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, item := e.Fields(), e.ItemInfo()
+	if len(fields) != 2 || fields[0] != "tags" || fields[1] != "weight" {
+		t.Errorf("Fields() = %v, want [tags weight]", fields)
+	}
+	if len(item) != 2 || item[0] != "max_w" || item[1] != "min_w" {
+		t.Errorf("ItemInfo() = %v, want [max_w min_w]", item)
+	}
+}
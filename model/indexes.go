@@ -0,0 +1,155 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// journalIndexModels индексы для фильтрации и постраничного вывода списка журналов
+func journalIndexModels() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "scheme", Value: 1}, {Key: "date", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "item", Value: 1}, {Key: "date", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "shift", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "legal_holds", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "archive", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "scheme", Value: 1}, {Key: "review.state", Value: 1}}},
+	}
+}
+
+// CreateIndexes создает индексы коллекций моделей площадки
+func CreateIndexes(tenant db.Tenant) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := journalCollection(tenant).CreateIndexes(timeout, journalIndexModels()...)
+	if err != nil {
+		return err
+	}
+
+	err = controlLimitsCollection(tenant).CreateIndexes(timeout, mongo.IndexModel{
+		Keys:    bson.D{{Key: "scheme", Value: 1}, {Key: "field", Value: 1}, {Key: "item", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Индекс частичный, а не sparse: в общем режиме поле tenant есть у всех документов
+	err = operatorCollection(tenant).CreateIndexes(timeout, mongo.IndexModel{
+		Keys: bson.D{{Key: "login", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "login", Value: bson.D{{Key: "$type", Value: "string"}}}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = orgUnitCollection(tenant).CreateIndexes(timeout,
+		mongo.IndexModel{Keys: bson.D{{Key: "parent", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "path", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "operators", Value: 1}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = shiftCollection(tenant).CreateIndexes(timeout,
+		mongo.IndexModel{Keys: bson.D{{Key: "start", Value: 1}, {Key: "end", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "template", Value: 1}, {Key: "start", Value: 1}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = shiftHandoverCollection(tenant).CreateIndexes(timeout, mongo.IndexModel{
+		Keys:    bson.D{{Key: "shift", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = attachmentCollection(tenant).CreateIndexes(timeout, mongo.IndexModel{
+		Keys: bson.D{{Key: "journal", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = journalChainCollection(tenant).CreateIndexes(timeout, mongo.IndexModel{
+		Keys:    bson.D{{Key: "journal", Value: 1}, {Key: "seq", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = operatorKeyCollection(tenant).CreateIndexes(timeout,
+		mongo.IndexModel{Keys: bson.D{{Key: "fingerprint", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "login", Value: 1}, {Key: "status", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "operator", Value: 1}, {Key: "created_at", Value: -1}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = confirmedActionCollection(tenant).CreateIndexes(timeout,
+		mongo.IndexModel{Keys: bson.D{{Key: "action", Value: 1}, {Key: "performed_at", Value: -1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "by", Value: 1}, {Key: "performed_at", Value: -1}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = commentThreadCollection(tenant).CreateIndexes(timeout,
+		mongo.IndexModel{Keys: bson.D{{Key: "journal", Value: 1}, {Key: "status", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "comments.mentions", Value: 1}, {Key: "updated_at", Value: -1}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = capaCollection(tenant).CreateIndexes(timeout,
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "journal", Value: 1}, {Key: "check", Value: 1}, {Key: "occurrence", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "occurrence", Value: bson.D{{Key: "$type", Value: "string"}}}}),
+		},
+		mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "due_date", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "owner", Value: 1}, {Key: "status", Value: 1}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = journalArchiveCollection(tenant).CreateIndexes(timeout, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = legalHoldEventCollection(tenant).CreateIndexes(timeout, mongo.IndexModel{
+		Keys: bson.D{{Key: "hold", Value: 1}, {Key: "at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, index := range searchIndexModels() {
+		if err := index.coll(tenant).CreateIndexes(timeout, index.model); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
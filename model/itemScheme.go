@@ -4,13 +4,11 @@ import (
 	"context"
 	"errors"
 	"log"
-	"strconv"
+	"time"
 
 	"github.com/Oxynger/JournalApp/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ItemInfo godoc
@@ -48,32 +46,39 @@ type ItemField struct {
 
 // ItemScheme godoc
 type ItemScheme struct {
-	ID      primitive.ObjectID `bson:"_id" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
-	Name    string             `bson:"name" json:"name" example:"scale"`
-	Title   string             `bson:"title" json:"title" example:"Весы"`
-	Fields  []ItemField        `bson:"fields" json:"fields"`
-	Deleted bool               `bson:"deleted" json:"-"`
+	ID        primitive.ObjectID `bson:"_id" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Name      string             `bson:"name" json:"name" example:"scale"`
+	Title     string             `bson:"title" json:"title" example:"Весы"`
+	Fields    []ItemField        `bson:"fields" json:"fields"`
+	DeletedAt *time.Time         `bson:"deleted_at" json:"-"`
+	DeletedBy string             `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64              `bson:"version" json:"version" example:"1"`
 }
 
 // NewItemScheme godoc
 type NewItemScheme struct {
-	Name    string      `bson:"name" json:"name" example:"scale"`
-	Title   string      `bson:"title" json:"title" example:"Весы"`
-	Fields  []ItemField `bson:"fields" json:"fields"`
-	Deleted bool        `bson:"deleted" json:"-"`
+	Name      string      `bson:"name" json:"name" example:"scale"`
+	Title     string      `bson:"title" json:"title" example:"Весы"`
+	Fields    []ItemField `bson:"fields" json:"fields"`
+	DeletedAt *time.Time  `bson:"deleted_at" json:"-"`
+	DeletedBy string      `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64       `bson:"version" json:"-"`
 }
 
 // UpdateItemScheme godoc
 type UpdateItemScheme struct {
-	Name    string      `bson:"name" json:"name" example:"scale"`
-	Title   string      `bson:"title" json:"title" example:"Весы"`
-	Fields  []ItemField `bson:"fields" json:"fields"`
-	Deleted bool        `bson:"deleted" json:"-"`
+	Name      string      `bson:"name" json:"name" example:"scale"`
+	Title     string      `bson:"title" json:"title" example:"Весы"`
+	Fields    []ItemField `bson:"fields" json:"fields"`
+	DeletedAt *time.Time  `bson:"deleted_at" json:"-"`
+	DeletedBy string      `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64       `bson:"version" json:"-"`
 }
 
 // Insert godoc
-func (s NewItemScheme) Insert() error {
-	insertResault, err := ItemSchemeCollection().InsertOne(context.Background(), s)
+func (s NewItemScheme) Insert(tenant db.Tenant) error {
+	s.Version = 1
+	insertResault, err := ItemSchemeCollection(tenant).InsertOne(context.Background(), s)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -83,18 +88,19 @@ func (s NewItemScheme) Insert() error {
 }
 
 // Update godoc
-func (s UpdateItemScheme) Update(id string) error {
+func (s UpdateItemScheme) Update(tenant db.Tenant, id string, version int64) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	updateResault, err := ItemSchemeCollection().UpdateOne(context.Background(), bson.D{{"_id", objectID}}, bson.D{{"$set", s}})
+	s.Version = version + 1
+	err = updateVersioned(ItemSchemeCollection(tenant), objectID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: s}})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	log.Println("updated documents: ", updateResault.UpsertedID)
+	log.Println("updated documents: ", objectID)
 	return err
 }
 
@@ -106,7 +112,7 @@ func (s NewItemScheme) Validation() error {
 		return ErrNameInvalid
 	case len(s.Title) == 0:
 		return ErrTitleInvalid
-	case s.Deleted == true:
+	case s.DeletedAt != nil:
 		return ErrDeletedInvalid
 	case s.Fields == nil:
 		return ErrFieldsInvalid
@@ -137,7 +143,7 @@ func (s UpdateItemScheme) Validation() error {
 		return ErrNameInvalid
 	case len(s.Title) == 0:
 		return ErrTitleInvalid
-	case s.Deleted == true:
+	case s.DeletedAt != nil:
 		return ErrDeletedInvalid
 	case s.Fields == nil:
 		return ErrFieldsInvalid
@@ -161,15 +167,12 @@ func (s UpdateItemScheme) Validation() error {
 }
 
 // ItemSchemeCollection godoc
-func ItemSchemeCollection() *mongo.Collection {
-	client := db.Client()
-	coll := client.Database("test").Collection("itemScheme")
-
-	return coll
+func ItemSchemeCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("itemScheme")
 }
 
 // SomeAdd godoc
-func SomeAdd() ItemScheme {
+func SomeAdd(tenant db.Tenant) ItemScheme {
 	scaleScheme := ItemScheme{
 		Name:  "scale",
 		Title: "Весы",
@@ -192,7 +195,7 @@ func SomeAdd() ItemScheme {
 		},
 	}
 
-	insertResault, err := ItemSchemeCollection().InsertOne(context.Background(), scaleScheme)
+	insertResault, err := ItemSchemeCollection(tenant).InsertOne(context.Background(), scaleScheme)
 
 	if err != nil {
 		log.Println(err)
@@ -204,60 +207,33 @@ func SomeAdd() ItemScheme {
 	return scaleScheme
 }
 
-//ItemSchemeAll get list item schemes godoc
-func ItemSchemeAll(offset string, limit string) ([]ItemScheme, error) {
-	offsetInt, err := strconv.ParseInt(offset, 10, 64)
-	if err != nil{
-		return nil, err
-	}
-	if offsetInt < 0{
-		return nil, ErrNegativeParam
-	} 
-	limitInt, err := strconv.ParseInt(limit, 10, 64)
-	if err != nil{
-		return nil, err
-	}
-	if limitInt < 0{
-		return nil, ErrNegativeParam
-	} 
-
-	options := options.Find()
-	options.SetLimit(limitInt)
-	options.SetSkip(offsetInt)
+// itemSchemeFields поля, доступные в фильтрах и сортировке списка схем
+var itemSchemeFields = db.Fields{
+	"name":  {Key: "name", Type: db.StringField, Sort: true},
+	"title": {Key: "title", Type: db.StringField, Sort: true},
+}
 
-	cur, err := ItemSchemeCollection().Find(context.Background(), bson.D{{"deleted", false}}, options)
+//ItemSchemeAll get list item schemes godoc
+func ItemSchemeAll(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var listSchemes []ItemScheme
+	page, err := db.FindPage(ItemSchemeCollection(tenant), bson.D{{Key: "deleted_at", Value: nil}}, itemSchemeFields, query, nil, &listSchemes)
 	if err != nil {
 		log.Println(err)
-		return nil, err
-	}
-	defer cur.Close(context.Background())
-	listSchemes := []ItemScheme{}
-
-	for cur.Next(context.Background()) {
-		row := new(ItemScheme)
-		err := cur.Decode(&row)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		listSchemes = append(listSchemes, *row)
-	}
-	if err := cur.Err(); err != nil {
-		return nil, err
+		return db.Page{}, err
 	}
 
-	return listSchemes, err
+	return page, err
 }
 
 //ItemSchemeOne get list item schemes with id godoc
-func ItemSchemeOne(id string) (ItemScheme, error) {
+func ItemSchemeOne(tenant db.Tenant, id string) (ItemScheme, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Println(err)
 		return ItemScheme{}, err
 	}
 	row := new(ItemScheme)
-	err = ItemSchemeCollection().FindOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted", false}}}}}).Decode(&row)
+	err = ItemSchemeCollection(tenant).FindOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted_at", nil}}}}}).Decode(&row)
 	if err != nil {
 		log.Println(err)
 		return ItemScheme{}, err
@@ -267,17 +243,13 @@ func ItemSchemeOne(id string) (ItemScheme, error) {
 }
 
 // DeleteSchemeOne godoc
-func DeleteSchemeOne(id string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	updateResault, err := ItemSchemeCollection().UpdateOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted", false}}}}}, bson.D{{"$set", bson.D{{"deleted", true}}}})
+// Схему, на которую ссылаются другие схемы, можно удалить только вместе с ними (cascade). by - кто удаляет
+func DeleteSchemeOne(tenant db.Tenant, id string, version int64, by string, cascade bool) error {
+	err := deleteWithDependents(tenant, ItemSchemeCollection(tenant), id, version, by, itemSchemeDependents, cascade)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	log.Println("deleted documents: ", updateResault.UpsertedID)
+	log.Println("deleted documents: ", id)
 	return err
 }
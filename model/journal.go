@@ -9,80 +9,102 @@ import (
 
 	"github.com/Oxynger/JournalApp/db"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+)
 
-	"go.mongodb.org/mongo-driver/mongo/options"
+// Статусы журнала
+const (
+	JournalOpen   = "open"
+	JournalClosed = "closed"
 )
 
 // Journal godoc
 type Journal struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
 	db.Model `bson:",inline"`
-	Daily    bool `bson:"daily" json:"daily" binding:"required"`
-	Fixed    bool `bson:"fixed" json:"fixed" binding:"required"`
+	Scheme   string    `bson:"scheme" json:"scheme" example:"scales_calibration"`
+	Item     string    `bson:"item" json:"item" example:"scale"`
+	Date     time.Time `bson:"date" json:"date"`
+	Status   string    `bson:"status" json:"status" example:"open"`
+	Daily    bool      `bson:"daily" json:"daily" binding:"required"`
+	Fixed    bool      `bson:"fixed" json:"fixed" binding:"required"`
+
+	// Accepted -1 если журнал закрыт с корректирующими действиями (может отсутствовать).
+	// Если у журнала есть CAPA, -1 пока хоть одно открыто и 1 когда закрыты все.
+	// Проставляется сервером при закрытии журнала и по корректирующим действиям
+	Accepted *int `bson:"accepted,omitempty" json:"accepted,omitempty" example:"-1"`
+
+	// Warnings нарушения правил контрольных карт, найденные при записи журнала
+	Warnings []SPCWarning `bson:"warnings,omitempty" json:"warnings,omitempty"`
+
+	// Shift смена, в которую журнал был записан последний раз. Проставляется сервером
+	Shift *primitive.ObjectID `bson:"shift,omitempty" json:"shift,omitempty" example:"5ca10d9d015c736a72b7b3b7"`
+	// Closings росписи о закрытии журнала за день или смену. Добавляются только через CloseJournal
+	Closings []JournalClosing `bson:"closings,omitempty" json:"closings,omitempty"`
 	Values   map[string]interface{}
-}
 
-// journalCollection godoc
-func journalCollection() *mongo.Collection {
-	client := db.Client()
-	coll := client.Database("test").Collection("Journal")
+	// ItemInfo информация о позиции на момент записи журнала. Доступна выражениям схемы как item.<имя>
+	ItemInfo map[string]interface{} `bson:"item_info,omitempty" json:"item_info,omitempty"`
+
+	// LegalHolds удержания, под которыми находится журнал. Меняются только через PlaceLegalHold и ReleaseLegalHold
+	LegalHolds []primitive.ObjectID `bson:"legal_holds,omitempty" json:"legal_holds,omitempty"`
+
+	// Archive архив, в который перенесен журнал. В Mongo остается заглушка без значений,
+	// JournalOne возвращает полный журнал из архива. В списке журналов значения архивных журналов не возвращаются
+	Archive *primitive.ObjectID `bson:"archive,omitempty" json:"archive,omitempty"`
 
-	return coll
+	// Review состояние проверки журнала, если у схемы есть порядок проверки. Меняется только через ReviewJournal
+	Review *JournalReview `bson:"review,omitempty" json:"review,omitempty"`
+
+	// SearchText строковые значения полей и информации о позиции для полнотекстового поиска. Проставляется сервером
+	SearchText string `bson:"search_text,omitempty" json:"-"`
 }
 
-// JournalsAll godoc
-func JournalsAll() (list []Journal, err error) {
-	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
+// journalFields поля, доступные в фильтрах и сортировке списка журналов
+var journalFields = db.Fields{
+	"scheme":     {Key: "scheme", Type: db.StringField, Sort: true},
+	"item":       {Key: "item", Type: db.StringField, Sort: true},
+	"date":       {Key: "date", Type: db.TimeField, Sort: true},
+	"status":     {Key: "status", Type: db.StringField, Sort: true},
+	"shift":      {Key: "shift", Type: db.ObjectIDField},
+	"review":     {Key: "review.state", Type: db.StringField, Sort: true},
+	"created_at": {Key: "created_at", Type: db.TimeField, Sort: true},
+	"updated_at": {Key: "updated_at", Type: db.TimeField, Sort: true},
+}
+
+// journalCollection godoc
+func journalCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("Journal")
+}
 
+// JournalsAll godoc. scope ограничивает журналы позициями и схемами узлов контроллера, nil - все журналы
+func JournalsAll(tenant db.Tenant, scope *OrgScope, query db.Query) (db.Page, error) {
 	filter := bson.D{
 		{
-			Key:   "deleted",
-			Value: false,
+			Key:   "deleted_at",
+			Value: nil,
 		},
 	}
-
-	withoutFields := bson.D{
-		{Key: "deleted", Value: 0},
+	if scope != nil {
+		filter = append(filter, scope.filter())
 	}
 
-	findOptions := options.Find()
-	findOptions.SetProjection(withoutFields)
-
-	cur, err := journalCollection().Find(timeout, filter, findOptions)
-
-	defer cur.Close(timeout)
+	var list []Journal
+	page, err := db.FindPage(journalCollection(tenant), filter, journalFields, query, nil, &list)
 
 	if err != nil {
 		log.Println(err)
-		return nil, err
-	}
-
-	for cur.Next(timeout) {
-		var resault Journal
-		err := cur.Decode(&resault)
-
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-
-		list = append(list, resault)
-	}
-
-	if err := cur.Err(); err != nil {
-		log.Println(err)
-		return nil, err
+		return db.Page{}, err
 	}
 
-	return list, nil
+	return page, nil
 }
 
-func journalFindOne(id primitive.ObjectID) (journal *Journal, err error) {
+func journalFindOne(tenant db.Tenant, id primitive.ObjectID) (journal *Journal, err error) {
 	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
 	filter := bson.D{
 		{
-			Key:   "deleted",
-			Value: false,
+			Key:   "deleted_at",
+			Value: nil,
 		},
 		{
 			Key:   "_id",
@@ -90,16 +112,20 @@ func journalFindOne(id primitive.ObjectID) (journal *Journal, err error) {
 		},
 	}
 
-	withoutFields := bson.D{
-		{Key: "deleted", Value: 0},
-	}
+	err = journalCollection(tenant).FindOne(timeout, filter).Decode(&journal)
 
-	findOneOptions := options.FindOne()
-	findOneOptions.SetProjection(withoutFields)
+	if err != nil {
+		return nil, err
+	}
 
-	err = journalCollection().FindOne(timeout, filter, findOneOptions).Decode(&journal)
+	if journal.Archive != nil {
+		journal, err = archivedJournal(tenant, journal)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	if err != nil {
+	if err := verifyClosings(tenant, journal); err != nil {
 		return nil, err
 	}
 
@@ -107,14 +133,14 @@ func journalFindOne(id primitive.ObjectID) (journal *Journal, err error) {
 }
 
 // JournalOne godoc
-func JournalOne(id string) (journal *Journal, err error) {
+func JournalOne(tenant db.Tenant, id string) (journal *Journal, err error) {
 	journalID, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	journal, err = journalFindOne(journalID)
+	journal, err = journalFindOne(tenant, journalID)
 
 	if err != nil {
 		return nil, err
@@ -123,87 +149,146 @@ func JournalOne(id string) (journal *Journal, err error) {
 	return journal, nil
 }
 
-// JournalDelete godoc
-func JournalDelete(id string) (journal *Journal, err error) {
+// JournalDelete godoc. by - кто удаляет. Журнал на удержании удалить нельзя
+func JournalDelete(tenant db.Tenant, id string, version int64, by string) (journal *Journal, err error) {
 	journalID, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
 	filter := bson.D{
 		{
-			Key:   "deleted",
-			Value: false,
-		},
-		{
-			Key:   "_id",
-			Value: journalID,
+			Key:   "deleted_at",
+			Value: nil,
 		},
 	}
 
+	now := time.Now()
 	deleteSet := bson.D{
 		{
 			Key: "$set",
-			Value: bson.D{
-				{
-					Key:   "deleted",
-					Value: true,
-				},
-			},
+			Value: append(softDelete(by, now), bson.E{
+				Key:   "version",
+				Value: version + 1,
+			}),
 		},
 	}
 
-	journal, err = JournalOne(id)
+	journal, err = JournalOne(tenant, id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = journalCollection().UpdateOne(timeout, filter, deleteSet)
+	if err := journal.checkHold(); err != nil {
+		return nil, err
+	}
+
+	err = updateVersioned(journalCollection(tenant), journalID, version, append(filter, notHeld()), deleteSet)
 
 	if err != nil {
 		return nil, err
 	}
 
+	journal.DeletedAt = &now
+	journal.DeletedBy = by
+	journal.Version = version + 1
+
 	return journal, nil
 }
 
-// AddJournal godoc
-func AddJournal(journal Journal) (*Journal, error) {
+// AddJournal godoc. Новый журнал открыт: статус меняется только через CloseJournal
+func AddJournal(tenant db.Tenant, journal Journal) (*Journal, error) {
 	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
 
-	insertedResault, err := journalCollection().InsertOne(timeout, journal)
+	journal.CreatedAt = time.Now()
+	journal.UpdatedAt = time.Now()
+	journal.Version = 1
+	journal.DeletedAt = nil
+	journal.DeletedBy = ""
+	journal.LegalHolds = nil
+	journal.Archive = nil
+	journal.Review = newJournalReview(tenant, journal.Scheme)
+	journal.Status = JournalOpen
+	journal.Accepted = nil
+
+	if err := validateValues(tenant, &journal); err != nil {
+		return nil, err
+	}
+
+	journal.Warnings = spcWarnings(tenant, journal)
+	journal.SearchText = journalSearchText(journal)
+	journal.Shift = activeShift(tenant, journal, journal.CreatedAt)
+	journal.Closings = nil
+
+	insertedResault, err := journalCollection(tenant).InsertOne(timeout, journal)
 
 	if err != nil {
 		return nil, err
 	}
 
-	resaultJournal, err := journalFindOne(insertedResault.InsertedID.(primitive.ObjectID))
+	resaultJournal, err := journalFindOne(tenant, insertedResault.InsertedID.(primitive.ObjectID))
 
 	if err != nil {
 		return nil, err
 	}
 
-	return resaultJournal, nil
+	return trackCAPAs(tenant, resaultJournal), nil
 }
 
-// JournalUpdate godoc
-func JournalUpdate(id string, journal Journal) (*Journal, error) {
-	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
-
+// JournalUpdate godoc. Журнал на удержании, на проверке или утвержденный изменить нельзя.
+// Статус журнала не меняется: он закрывается только через CloseJournal
+func JournalUpdate(tenant db.Tenant, id string, version int64, journal Journal) (*Journal, error) {
 	journalID, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
 		return nil, err
 	}
 
+	timeJournal, err := journalFindOne(tenant, journalID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := timeJournal.checkHold(); err != nil {
+		return nil, err
+	}
+
+	if err := timeJournal.checkReview(); err != nil {
+		return nil, err
+	}
+
+	if err := rehydrate(tenant, timeJournal); err != nil {
+		return nil, err
+	}
+
+	if err := validateValues(tenant, &journal); err != nil {
+		return nil, err
+	}
+
+	journal.CreatedAt = timeJournal.CreatedAt
+	journal.UpdatedAt = time.Now()
+	journal.Version = version + 1
+	journal.DeletedAt = nil
+	journal.DeletedBy = ""
+	journal.LegalHolds = nil
+	journal.Archive = nil
+	journal.Review = timeJournal.Review
+	journal.Status = timeJournal.Status
+	journal.Accepted = timeJournal.Accepted
+	journal.Warnings = spcWarnings(tenant, journal)
+	journal.SearchText = journalSearchText(journal)
+	journal.Shift = activeShift(tenant, journal, journal.UpdatedAt)
+	journal.Closings = nil
+
 	filter := bson.D{
 		{
-			Key:   "_id",
-			Value: journalID,
+			Key:   "deleted_at",
+			Value: nil,
 		},
+		notHeld(),
 	}
 
 	update := bson.D{
@@ -213,13 +298,17 @@ func JournalUpdate(id string, journal Journal) (*Journal, error) {
 		},
 	}
 
-	journalCollection().UpdateOne(timeout, filter, update)
+	err = updateVersioned(journalCollection(tenant), journalID, version, filter, update)
+
+	if err != nil {
+		return nil, err
+	}
 
-	resaultJournal, err := journalFindOne(journalID)
+	resaultJournal, err := journalFindOne(tenant, journalID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return resaultJournal, nil
+	return trackCAPAs(tenant, resaultJournal), nil
 }
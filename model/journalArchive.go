@@ -0,0 +1,664 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// archiveBucket имя GridFS bucket для архивов журналов
+const archiveBucket = "archives"
+
+// Состояния архива. Архивирование проходит их по порядку; прерванное продолжается с состояния, на котором остановилось
+const (
+	// ArchiveWriting журналы выбраны, файл пишется
+	ArchiveWriting = "writing"
+	// ArchiveWritten файл сохранен в хранилище, но еще не проверен
+	ArchiveWritten = "written"
+	// ArchiveVerified файл проверен, журналы в Mongo еще не заменены заглушками
+	ArchiveVerified = "verified"
+	// ArchiveDone журналы в Mongo заменены заглушками
+	ArchiveDone = "archived"
+)
+
+// Errors godoc
+var (
+	ErrArchiveChecksum   = errors.New("archive checksum does not match")
+	ErrArchiveContent    = errors.New("archive content does not match its index")
+	ErrArchiveNotWritten = errors.New("archive file is not written yet")
+)
+
+// archivedFields поля журнала, которые хранятся только в архиве. Остальные поля остаются в заглушке
+var archivedFields = []string{"values", "closings", "item_info", "warnings", "accepted", "shift"}
+
+// ArchivedJournal журнал в архиве и его версия на момент записи
+type ArchivedJournal struct {
+	ID      primitive.ObjectID `bson:"_id" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Version int64              `bson:"version" json:"version" example:"4"`
+}
+
+// JournalArchive индекс файла архива: журналы одной схемы за один месяц по дате журнала.
+// Файл - gzip JSONL, по журналу в Extended JSON на строку
+type JournalArchive struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Scheme string             `bson:"scheme" json:"scheme" example:"scales_calibration"`
+	Month  time.Time          `bson:"month" json:"month"`
+	Status string             `bson:"status" json:"status" example:"archived"`
+
+	// Journals журналы файла. В списке архивов не возвращается
+	Journals []ArchivedJournal `bson:"journals" json:"journals,omitempty"`
+	Count    int               `bson:"count" json:"count" example:"31"`
+
+	// Key ключ файла в хранилище архивов
+	Key  string `bson:"key,omitempty" json:"-"`
+	Size int64  `bson:"size" json:"size" example:"20480"`
+	// Checksum sha256 сжатого файла в hex
+	Checksum string `bson:"checksum,omitempty" json:"checksum,omitempty"`
+
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	ArchivedAt *time.Time `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
+	VerifiedAt *time.Time `bson:"verified_at,omitempty" json:"verified_at,omitempty"`
+	// Error ошибка последней проверки
+	Error string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// ArchiveRun итог архивирования
+type ArchiveRun struct {
+	// Archives сколько архивов доведено до конца, включая прерванные ранее
+	Archives int `json:"archives" example:"3"`
+	// Journals сколько журналов заменено заглушками
+	Journals int64 `json:"journals" example:"93"`
+}
+
+// journalArchiveFields поля, доступные в фильтрах и сортировке списка архивов
+var journalArchiveFields = db.Fields{
+	"scheme":      {Key: "scheme", Type: db.StringField, Sort: true},
+	"month":       {Key: "month", Type: db.TimeField, Sort: true},
+	"status":      {Key: "status", Type: db.StringField, Sort: true},
+	"created_at":  {Key: "created_at", Type: db.TimeField, Sort: true},
+	"verified_at": {Key: "verified_at", Type: db.TimeField, Sort: true},
+}
+
+var (
+	archiveStoreOnce sync.Once
+	archiveStore     AttachmentStore
+)
+
+// Archives хранилище файлов архива, выбранное настройкой ARCHIVE_STORAGE. Те же хранилища, что и у вложений, по умолчанию GridFS
+func Archives() AttachmentStore {
+	archiveStoreOnce.Do(func() {
+		if viper.GetString("archive_storage") == AttachmentFilesystem {
+			dir := viper.GetString("archive_dir")
+			if len(dir) == 0 {
+				dir = "archives"
+			}
+			archiveStore = fileStore{dir: dir}
+			return
+		}
+		archiveStore = gridFSStore{name: archiveBucket}
+	})
+	return archiveStore
+}
+
+// ArchiveAfterDays через сколько дней архивируются журналы схем без своей политики хранения. 0 - не архивируются
+func ArchiveAfterDays() int {
+	return viper.GetInt("archive_after_days")
+}
+
+func journalArchiveCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("journalArchive")
+}
+
+// JournalArchivesAll архивы площадки без списков журналов
+func JournalArchivesAll(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var list []JournalArchive
+	return db.FindPage(journalArchiveCollection(tenant), bson.D{}, journalArchiveFields, query, bson.D{{Key: "journals", Value: 0}}, &list)
+}
+
+func journalArchiveFindOne(tenant db.Tenant, id primitive.ObjectID) (*JournalArchive, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var archive JournalArchive
+	if err := journalArchiveCollection(tenant).FindOne(timeout, bson.D{{Key: "_id", Value: id}}).Decode(&archive); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// JournalArchiveOne архив id со списком журналов
+func JournalArchiveOne(tenant db.Tenant, id string) (*JournalArchive, error) {
+	archiveID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return journalArchiveFindOne(tenant, archiveID)
+}
+
+func setArchive(tenant db.Tenant, id primitive.ObjectID, set bson.D) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := journalArchiveCollection(tenant).UpdateOne(timeout, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: set}})
+	return err
+}
+
+// archivable отбор журналов, которые можно архивировать: не в архиве, не удалены и не на удержании
+func archivable() bson.D {
+	return bson.D{
+		{Key: "archive", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "deleted_at", Value: nil},
+		notHeld(),
+	}
+}
+
+// archiveThresholds срок архивирования в днях для схем, у которых есть журналы для архивирования.
+// Схемы без своего ArchiveAfterDays получают defaultDays, схемы с нулевым сроком пропускаются
+func archiveThresholds(tenant db.Tenant, defaultDays int) (map[string]int, error) {
+	policies, err := retentionPolicies(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := journalCollection(tenant).Aggregate(timeout, bson.A{
+		bson.D{{Key: "$match", Value: archivable()}},
+		bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$scheme"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	thresholds := map[string]int{}
+	for cur.Next(timeout) {
+		var group struct {
+			Scheme string `bson:"_id"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			return nil, err
+		}
+		days := defaultDays
+		if policy, ok := policies[group.Scheme]; ok && policy.ArchiveAfterDays > 0 {
+			days = policy.ArchiveAfterDays
+		}
+		if days > 0 {
+			thresholds[group.Scheme] = days
+		}
+	}
+	return thresholds, cur.Err()
+}
+
+// newArchives заводит архивы в состоянии ArchiveWriting для журналов схемы scheme старше before, по архиву на месяц
+func newArchives(tenant db.Tenant, scheme string, before time.Time) ([]JournalArchive, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	match := append(archivable(), bson.E{Key: "scheme", Value: scheme}, bson.E{Key: "date", Value: bson.D{{Key: "$lt", Value: before}}})
+	cur, err := journalCollection(tenant).Aggregate(timeout, bson.A{
+		bson.D{{Key: "$match", Value: match}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "year", Value: bson.D{{Key: "$year", Value: "$date"}}}, {Key: "month", Value: bson.D{{Key: "$month", Value: "$date"}}}}},
+			{Key: "journals", Value: bson.D{{Key: "$push", Value: bson.D{{Key: "_id", Value: "$_id"}, {Key: "version", Value: "$version"}}}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "_id.year", Value: 1}, {Key: "_id.month", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	archives := []JournalArchive{}
+	for cur.Next(timeout) {
+		var group struct {
+			ID struct {
+				Year  int `bson:"year"`
+				Month int `bson:"month"`
+			} `bson:"_id"`
+			Journals []ArchivedJournal `bson:"journals"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			return nil, err
+		}
+
+		archive := JournalArchive{
+			Scheme:    scheme,
+			Month:     time.Date(group.ID.Year, time.Month(group.ID.Month), 1, 0, 0, 0, 0, time.UTC),
+			Status:    ArchiveWriting,
+			Journals:  group.Journals,
+			Count:     len(group.Journals),
+			CreatedAt: time.Now(),
+		}
+		insertedResault, err := journalArchiveCollection(tenant).InsertOne(timeout, archive)
+		if err != nil {
+			return nil, err
+		}
+		archive.ID = insertedResault.InsertedID.(primitive.ObjectID)
+		archives = append(archives, archive)
+	}
+	return archives, cur.Err()
+}
+
+// writeArchive пишет журналы архива в файл и сохраняет его в хранилище. Журналы, которые изменились
+// или исчезли после выбора, в архив не попадают
+func writeArchive(tenant db.Tenant, archive *JournalArchive) error {
+	// Файл прерванной записи мог сохраниться, но не попасть в индекс
+	if len(archive.Key) != 0 {
+		if err := Archives().Delete(tenant, archive.Key); err != nil {
+			log.Println(err)
+		}
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	versions := map[primitive.ObjectID]int64{}
+	ids := bson.A{}
+	for _, journal := range archive.Journals {
+		versions[journal.ID] = journal.Version
+		ids = append(ids, journal.ID)
+	}
+
+	filter := append(archivable(), bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}})
+	cur, err := journalCollection(tenant).Find(timeout, filter, options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(timeout)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	written := []ArchivedJournal{}
+	for cur.Next(timeout) {
+		var head ArchivedJournal
+		if err := cur.Decode(&head); err != nil {
+			return err
+		}
+		if version, ok := versions[head.ID]; !ok || version != head.Version {
+			continue
+		}
+
+		line, err := bson.MarshalExtJSON(cur.Current, true, false)
+		if err != nil {
+			return err
+		}
+		if _, err := zw.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		written = append(written, head)
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(buf.Bytes())
+	name := archive.Scheme + "_" + archive.Month.Format("2006-01") + ".jsonl.gz"
+	key, err := Archives().Put(tenant, name, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	archive.Key = key
+	archive.Journals = written
+	archive.Count = len(written)
+	archive.Size = int64(buf.Len())
+	archive.Checksum = hex.EncodeToString(checksum[:])
+	archive.Status = ArchiveWritten
+	return setArchive(tenant, archive.ID, bson.D{
+		{Key: "key", Value: archive.Key},
+		{Key: "journals", Value: archive.Journals},
+		{Key: "count", Value: archive.Count},
+		{Key: "size", Value: archive.Size},
+		{Key: "checksum", Value: archive.Checksum},
+		{Key: "status", Value: archive.Status},
+	})
+}
+
+// openArchive читает файл архива и сверяет его контрольную сумму
+func openArchive(tenant db.Tenant, archive JournalArchive) (*gzip.Reader, error) {
+	if len(archive.Key) == 0 {
+		return nil, ErrArchiveNotWritten
+	}
+
+	file, err := Archives().Open(tenant, archive.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	checksum := sha256.Sum256(data)
+	if hex.EncodeToString(checksum[:]) != archive.Checksum {
+		return nil, ErrArchiveChecksum
+	}
+
+	return gzip.NewReader(bytes.NewReader(data))
+}
+
+// eachArchived вызывает fn для каждого журнала файла архива, пока fn не вернет false
+func eachArchived(tenant db.Tenant, archive JournalArchive, fn func(journal *Journal) bool) error {
+	zr, err := openArchive(tenant, archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	reader := bufio.NewReader(zr)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) != 0 {
+			var journal Journal
+			if err := bson.UnmarshalExtJSON(line, true, &journal); err != nil {
+				return err
+			}
+			if !fn(&journal) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkArchive проверяет контрольную сумму файла и что в нем ровно журналы индекса с их версиями
+func checkArchive(tenant db.Tenant, archive JournalArchive) error {
+	expected := map[primitive.ObjectID]int64{}
+	for _, journal := range archive.Journals {
+		expected[journal.ID] = journal.Version
+	}
+
+	content := true
+	err := eachArchived(tenant, archive, func(journal *Journal) bool {
+		version, ok := expected[journal.ID]
+		if !ok || version != journal.Version {
+			content = false
+			return false
+		}
+		delete(expected, journal.ID)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !content || len(expected) != 0 {
+		return ErrArchiveContent
+	}
+	return nil
+}
+
+// stubArchive заменяет журналы архива заглушками. Журнал, который изменился после записи файла, остается полным
+func stubArchive(tenant db.Tenant, archive *JournalArchive) (int64, error) {
+	unset := bson.D{}
+	for _, field := range archivedFields {
+		unset = append(unset, bson.E{Key: field, Value: ""})
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "archive", Value: archive.ID}}},
+		{Key: "$unset", Value: unset},
+	}
+
+	var stubbed int64
+	for _, journal := range archive.Journals {
+		timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		filter := append(archivable(), bson.E{Key: "_id", Value: journal.ID}, bson.E{Key: "version", Value: journal.Version})
+		result, err := journalCollection(tenant).UpdateOne(timeout, filter, update)
+		cancel()
+		if err != nil {
+			return stubbed, err
+		}
+		stubbed += result.ModifiedCount
+	}
+
+	now := time.Now()
+	archive.Status = ArchiveDone
+	archive.ArchivedAt = &now
+	return stubbed, setArchive(tenant, archive.ID, bson.D{{Key: "status", Value: archive.Status}, {Key: "archived_at", Value: now}})
+}
+
+// advanceArchive доводит архив до состояния ArchiveDone с того состояния, в котором он находится.
+// Возвращает количество журналов, замененных заглушками
+func advanceArchive(tenant db.Tenant, archive *JournalArchive) (int64, error) {
+	rewritten := false
+	for {
+		switch archive.Status {
+		case ArchiveWriting:
+			if err := writeArchive(tenant, archive); err != nil {
+				return 0, err
+			}
+		case ArchiveWritten:
+			// Файл, который не прошел проверку, пишется заново один раз: журналы еще полностью в Mongo
+			next := ArchiveVerified
+			if err := checkArchive(tenant, *archive); err != nil {
+				if rewritten {
+					return 0, err
+				}
+				log.Println("archive", archive.ID.Hex(), "failed verification, rewriting:", err)
+				next = ArchiveWriting
+				rewritten = true
+			}
+			now := time.Now()
+			archive.Status = next
+			archive.VerifiedAt = &now
+			if err := setArchive(tenant, archive.ID, bson.D{{Key: "status", Value: next}, {Key: "verified_at", Value: now}}); err != nil {
+				return 0, err
+			}
+		case ArchiveVerified:
+			return stubArchive(tenant, archive)
+		default:
+			return 0, nil
+		}
+	}
+}
+
+// ArchiveJournals переносит в архив журналы старше срока архивирования их схемы (RetentionPolicy.ArchiveAfterDays,
+// для схем без него - defaultDays). Сначала доводятся архивы, прерванные при прошлом запуске
+func ArchiveJournals(tenant db.Tenant, now time.Time, defaultDays int) (ArchiveRun, error) {
+	run := ArchiveRun{}
+
+	// Прерванные архивы доводятся до выбора новых журналов: их журналы еще не заменены заглушками
+	// и иначе попали бы во второй архив
+	pending, err := pendingArchives(tenant)
+	if err != nil {
+		return run, err
+	}
+	if err := run.advance(tenant, pending); err != nil {
+		return run, err
+	}
+
+	thresholds, err := archiveThresholds(tenant, defaultDays)
+	if err != nil {
+		return run, err
+	}
+	for scheme, days := range thresholds {
+		archives, err := newArchives(tenant, scheme, now.AddDate(0, 0, -days))
+		if err != nil {
+			return run, err
+		}
+		if err := run.advance(tenant, archives); err != nil {
+			return run, err
+		}
+	}
+	return run, nil
+}
+
+func (run *ArchiveRun) advance(tenant db.Tenant, archives []JournalArchive) error {
+	for i := range archives {
+		stubbed, err := advanceArchive(tenant, &archives[i])
+		run.Journals += stubbed
+		if err != nil {
+			return err
+		}
+		run.Archives++
+	}
+	return nil
+}
+
+func pendingArchives(tenant db.Tenant) ([]JournalArchive, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := journalArchiveCollection(tenant).Find(timeout, bson.D{{Key: "status", Value: bson.D{{Key: "$ne", Value: ArchiveDone}}}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	archives := []JournalArchive{}
+	for cur.Next(timeout) {
+		var archive JournalArchive
+		if err := cur.Decode(&archive); err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	return archives, cur.Err()
+}
+
+// VerifyArchive проверяет файл архива: контрольную сумму и что в нем ровно журналы индекса.
+// Результат сохраняется в verified_at и error
+func VerifyArchive(tenant db.Tenant, id string) (*JournalArchive, error) {
+	archive, err := JournalArchiveOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	archive.VerifiedAt = &now
+	archive.Error = ""
+	if err := checkArchive(tenant, *archive); err != nil {
+		archive.Error = err.Error()
+	}
+
+	if err := setArchive(tenant, archive.ID, bson.D{{Key: "verified_at", Value: now}, {Key: "error", Value: archive.Error}}); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// archivedJournal полный журнал из архива. Поля, которые остаются в заглушке, берутся из нее:
+// они могли измениться после архивирования (удаление, удержания)
+func archivedJournal(tenant db.Tenant, stub *Journal) (*Journal, error) {
+	archive, err := journalArchiveFindOne(tenant, *stub.Archive)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Journal
+	err = eachArchived(tenant, *archive, func(journal *Journal) bool {
+		if journal.ID != stub.ID {
+			return true
+		}
+		found = journal
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	stub.Values = found.Values
+	stub.Closings = found.Closings
+	stub.ItemInfo = found.ItemInfo
+	stub.Warnings = found.Warnings
+	stub.Accepted = found.Accepted
+	stub.Shift = found.Shift
+	return stub, nil
+}
+
+// rehydrate возвращает в Mongo поля архивного журнала перед его изменением. Файл архива не меняется:
+// журнал без поля archive читается из Mongo, а при следующем архивировании попадает в новый файл
+func rehydrate(tenant db.Tenant, journal *Journal) error {
+	if journal.Archive == nil {
+		return nil
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "values", Value: journal.Values},
+			{Key: "closings", Value: journal.Closings},
+			{Key: "item_info", Value: journal.ItemInfo},
+			{Key: "warnings", Value: journal.Warnings},
+			{Key: "accepted", Value: journal.Accepted},
+			{Key: "shift", Value: journal.Shift},
+		}},
+		{Key: "$unset", Value: bson.D{{Key: "archive", Value: ""}}},
+	}
+	_, err := journalCollection(tenant).UpdateOne(timeout, bson.D{{Key: "_id", Value: journal.ID}, {Key: "archive", Value: *journal.Archive}}, update)
+	if err != nil {
+		return err
+	}
+	journal.Archive = nil
+	return nil
+}
+
+// PurgeOrphanArchives удаляет файлы и индексы архивов, на которые не ссылается ни одна заглушка:
+// все их журналы удалены окончательно или изменены и возвращены в Mongo
+func PurgeOrphanArchives(tenant db.Tenant) (int64, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cur, err := journalArchiveCollection(tenant).Find(timeout, bson.D{{Key: "status", Value: ArchiveDone}}, options.Find().SetProjection(bson.D{{Key: "journals", Value: 0}}))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(timeout)
+
+	var purged int64
+	for cur.Next(timeout) {
+		var archive JournalArchive
+		if err := cur.Decode(&archive); err != nil {
+			return purged, err
+		}
+
+		stubs, err := journalCollection(tenant).CountDocuments(timeout, bson.D{{Key: "archive", Value: archive.ID}})
+		if err != nil {
+			return purged, err
+		}
+		if stubs != 0 {
+			continue
+		}
+
+		if err := Archives().Delete(tenant, archive.Key); err != nil {
+			log.Println(err)
+		}
+		if _, err := journalArchiveCollection(tenant).DeleteMany(timeout, bson.D{{Key: "_id", Value: archive.ID}}); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, cur.Err()
+}
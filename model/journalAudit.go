@@ -0,0 +1,80 @@
+package model
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+)
+
+// События истории журнала
+const (
+	AuditJournalClosed      = "closed"
+	AuditClosingVoided      = "closing_voided"
+	AuditReviewTransition   = "review"
+	AuditDiscussionResolved = "discussion_resolved"
+	AuditCAPAClosed         = "capa_closed"
+)
+
+// AuditEntry событие истории журнала. Заполнено только поле события соответствующего типа
+type AuditEntry struct {
+	Event string    `json:"event" example:"discussion_resolved"`
+	By    string    `json:"by" example:"quality_lead"`
+	At    time.Time `json:"at"`
+
+	Closing    *JournalClosing   `json:"closing,omitempty"`
+	Review     *ReviewTransition `json:"review,omitempty"`
+	Discussion *CommentThread    `json:"discussion,omitempty"`
+	CAPA       *CAPA             `json:"capa,omitempty"`
+}
+
+// JournalAudit история журнала id, доступного в scope, по времени: росписи и их отмены, переходы проверки,
+// решенные обсуждения вместе со всеми сообщениями и закрытые корректирующие действия.
+// Открытые обсуждения и действия в историю не входят
+func JournalAudit(tenant db.Tenant, scope *OrgScope, id string) ([]AuditEntry, error) {
+	journal, err := scopedJournal(tenant, scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := resolvedThreads(tenant, journal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	capas, err := journalCAPAs(tenant, journal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []AuditEntry{}
+	for i := range journal.Closings {
+		closing := &journal.Closings[i]
+		entries = append(entries, AuditEntry{Event: AuditJournalClosed, By: closing.ClosedBy, At: closing.ClosedAt, Closing: closing})
+		if closing.Voided != nil {
+			entries = append(entries, AuditEntry{Event: AuditClosingVoided, By: closing.Voided.By, At: closing.Voided.At, Closing: closing})
+		}
+	}
+	if journal.Review != nil {
+		for i := range journal.Review.History {
+			transition := &journal.Review.History[i]
+			entries = append(entries, AuditEntry{Event: AuditReviewTransition, By: transition.By, At: transition.At, Review: transition})
+		}
+	}
+	for i := range threads {
+		thread := &threads[i]
+		entries = append(entries, AuditEntry{Event: AuditDiscussionResolved, By: thread.ResolvedBy, At: *thread.ResolvedAt, Discussion: thread})
+	}
+
+	for i := range capas {
+		capa := &capas[i]
+		if capa.Status == CAPAClosed {
+			entries = append(entries, AuditEntry{Event: AuditCAPAClosed, By: capa.ClosedBy, At: *capa.ClosedAt, CAPA: capa})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].At.Before(entries[j].At)
+	})
+	return entries, nil
+}
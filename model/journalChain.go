@@ -0,0 +1,434 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Причины разрыва цепочки
+const (
+	// ChainDigest содержимое звена не совпадает с его хешем
+	ChainDigest = "digest"
+	// ChainPrevious звено ссылается не на хеш предыдущего звена
+	ChainPrevious = "previous"
+	// ChainMissing в цепочке пропущено звено
+	ChainMissing = "missing"
+	// ChainClosing роспись журнала не совпадает со звеном
+	ChainClosing = "closing"
+	// ChainClosingRemoved у звена больше нет росписи в журнале
+	ChainClosingRemoved = "closing_removed"
+	// ChainUnchained роспись журнала не попала в цепочку
+	ChainUnchained = "unchained"
+	// ChainValues значения закрытого журнала изменились после последней росписи
+	ChainValues = "values"
+	// ChainSnapshot сохраненные в звене значения не совпадают с его хешем значений
+	ChainSnapshot = "snapshot"
+	// ChainJournalMissing журнала цепочки больше нет
+	ChainJournalMissing = "journal_missing"
+)
+
+// ChainLink звено цепочки хешей: роспись Seq журнала Journal. Digest - sha256 от росписи, хеша изображения росписи,
+// хеша значений журнала на момент закрытия и Previous, хеша предыдущего звена. У первого звена Previous пустой.
+// Values - канонический вид значений, по которым посчитан ValuesDigest: по нему проверяется закрытый день
+// ежедневного журнала, значения которого в самом журнале дальше меняются
+type ChainLink struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Journal primitive.ObjectID `bson:"journal" json:"journal" example:"5ca10d9d015c736a72b7b3b9"`
+	// Seq номер росписи в Closings журнала
+	Seq      int                 `bson:"seq" json:"seq" example:"0"`
+	Date     time.Time           `bson:"date" json:"date"`
+	Shift    *primitive.ObjectID `bson:"shift,omitempty" json:"shift,omitempty" example:"5ca10d9d015c736a72b7b3b7"`
+	ClosedBy string              `bson:"closed_by" json:"closed_by" example:"olegov"`
+	ClosedAt time.Time           `bson:"closed_at" json:"closed_at"`
+	Accepted *int                `bson:"accepted,omitempty" json:"accepted,omitempty" example:"-1"`
+
+	SignatureDigest string    `bson:"signature_digest" json:"signature_digest"`
+	ValuesDigest    string    `bson:"values_digest" json:"values_digest"`
+	Values          string    `bson:"values,omitempty" json:"values,omitempty"`
+	Previous        string    `bson:"previous" json:"previous"`
+	Digest          string    `bson:"digest" json:"digest"`
+	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
+}
+
+// ChainBreak разрыв цепочки в звене или росписи Seq
+type ChainBreak struct {
+	Seq    int    `json:"seq" example:"3"`
+	Reason string `json:"reason" example:"digest"`
+}
+
+// ChainReport результат проверки цепочки журнала
+type ChainReport struct {
+	Journal  primitive.ObjectID `json:"journal" example:"5ca10d9d015c736a72b7b3ba"`
+	Valid    bool               `json:"valid" example:"true"`
+	Closings int                `json:"closings" example:"30"`
+	Breaks   []ChainBreak       `json:"breaks"`
+	Links    []ChainLink        `json:"links"`
+}
+
+func journalChainCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("journalChain")
+}
+
+// canonical приводит значение к виду, который не зависит от порядка ключей и типа целых чисел в Mongo.
+// Время - RFC3339 в UTC с точностью до миллисекунд, как оно хранится в Mongo
+func canonical(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[key] = canonical(item)
+		}
+		return result
+	case primitive.M:
+		return canonical(map[string]interface{}(v))
+	case primitive.D:
+		result := map[string]interface{}{}
+		for _, e := range v {
+			result[e.Key] = canonical(e.Value)
+		}
+		return result
+	case primitive.A:
+		return canonical([]interface{}(v))
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = canonical(item)
+		}
+		return result
+	case primitive.DateTime:
+		return canonical(time.Unix(0, int64(v)*int64(time.Millisecond)))
+	case time.Time:
+		return v.UTC().Truncate(time.Millisecond).Format(time.RFC3339Nano)
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.Decimal128:
+		return v.String()
+	case int32:
+		return int64(v)
+	case int:
+		return int64(v)
+	}
+	return value
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalValues канонический вид значений журнала
+func canonicalValues(values map[string]interface{}) (string, error) {
+	data, err := json.Marshal(canonical(values))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// valuesDigest хеш канонического вида значений журнала
+func valuesDigest(values map[string]interface{}) (string, error) {
+	data, err := canonicalValues(values)
+	if err != nil {
+		return "", err
+	}
+	return sha256Hex([]byte(data)), nil
+}
+
+// digest хеш звена от всех его полей, кроме ID, CreatedAt и самого Digest
+func (l ChainLink) digest() (string, error) {
+	var shift string
+	if l.Shift != nil {
+		shift = l.Shift.Hex()
+	}
+	data, err := json.Marshal(struct {
+		Journal         string `json:"journal"`
+		Seq             int    `json:"seq"`
+		Date            string `json:"date"`
+		Shift           string `json:"shift"`
+		ClosedBy        string `json:"closed_by"`
+		ClosedAt        string `json:"closed_at"`
+		Accepted        *int   `json:"accepted"`
+		SignatureDigest string `json:"signature_digest"`
+		ValuesDigest    string `json:"values_digest"`
+		Previous        string `json:"previous"`
+	}{
+		Journal:         l.Journal.Hex(),
+		Seq:             l.Seq,
+		Date:            canonical(l.Date).(string),
+		Shift:           shift,
+		ClosedBy:        l.ClosedBy,
+		ClosedAt:        canonical(l.ClosedAt).(string),
+		Accepted:        l.Accepted,
+		SignatureDigest: l.SignatureDigest,
+		ValuesDigest:    l.ValuesDigest,
+		Previous:        l.Previous,
+	})
+	if err != nil {
+		return "", err
+	}
+	return sha256Hex(data), nil
+}
+
+// matches совпадает ли роспись журнала со звеном
+func (l ChainLink) matches(closing JournalClosing) bool {
+	sameShift := (l.Shift == nil && closing.Shift == nil) || (l.Shift != nil && closing.Shift != nil && *l.Shift == *closing.Shift)
+	sameAccepted := (l.Accepted == nil && closing.Accepted == nil) || (l.Accepted != nil && closing.Accepted != nil && *l.Accepted == *closing.Accepted)
+	return sameShift && sameAccepted &&
+		canonical(l.Date) == canonical(closing.Date) &&
+		canonical(l.ClosedAt) == canonical(closing.ClosedAt) &&
+		l.ClosedBy == closing.ClosedBy &&
+		l.SignatureDigest == sha256Hex(closing.Signature) &&
+		(len(closing.ValuesDigest) == 0 || l.ValuesDigest == closing.ValuesDigest)
+}
+
+func chainLinks(tenant db.Tenant, journalID primitive.ObjectID) ([]ChainLink, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := journalChainCollection(tenant).Find(timeout, bson.D{{Key: "journal", Value: journalID}}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	links := []ChainLink{}
+	for cur.Next(timeout) {
+		var link ChainLink
+		if err := cur.Decode(&link); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, cur.Err()
+}
+
+// appendChain добавляет в цепочку журнала звенья для росписей, которых в ней еще нет.
+// journal должен быть прочитан из Mongo после закрытия: время в нем уже с точностью Mongo.
+// Хеш значений звена берется из подписанной росписи. Текущие значения сохраняются только в звене,
+// хеш которого с ними совпадает: для пропущенных ранее росписей значений на момент закрытия уже нет
+func appendChain(tenant db.Tenant, journal Journal) error {
+	links, err := chainLinks(tenant, journal.ID)
+	if err != nil {
+		return err
+	}
+
+	var previous string
+	if len(links) != 0 {
+		previous = links[len(links)-1].Digest
+	}
+
+	snapshot, err := canonicalValues(journal.Values)
+	if err != nil {
+		return err
+	}
+	values := sha256Hex([]byte(snapshot))
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for seq := len(links); seq < len(journal.Closings); seq++ {
+		closing := journal.Closings[seq]
+		digest := closing.ValuesDigest
+		// У росписей до введения подписи хеша значений нет, текущие значения относятся только к последней
+		if len(digest) == 0 && seq == len(journal.Closings)-1 {
+			digest = values
+		}
+		link := ChainLink{
+			Journal:         journal.ID,
+			Seq:             seq,
+			Date:            closing.Date,
+			Shift:           closing.Shift,
+			ClosedBy:        closing.ClosedBy,
+			ClosedAt:        closing.ClosedAt,
+			Accepted:        closing.Accepted,
+			SignatureDigest: sha256Hex(closing.Signature),
+			ValuesDigest:    digest,
+			Previous:        previous,
+			CreatedAt:       time.Now(),
+		}
+		if digest == values {
+			link.Values = snapshot
+		}
+		if link.Digest, err = link.digest(); err != nil {
+			return err
+		}
+		// Уникальный индекс по journal и seq не дает двум закрытиям добавить одно звено
+		if _, err := journalChainCollection(tenant).InsertOne(timeout, link); err != nil {
+			return err
+		}
+		previous = link.Digest
+	}
+	return nil
+}
+
+// chainJournal журнал цепочки, в том числе удаленный в корзину или перенесенный в архив
+func chainJournal(tenant db.Tenant, id primitive.ObjectID) (*Journal, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var journal Journal
+	if err := journalCollection(tenant).FindOne(timeout, bson.D{{Key: "_id", Value: id}}).Decode(&journal); err != nil {
+		return nil, err
+	}
+	if journal.Archive != nil {
+		return archivedJournal(tenant, &journal)
+	}
+	return &journal, nil
+}
+
+// verifyChain проходит цепочку журнала от первого звена и сверяет ее с росписями журнала.
+// Значения каждого закрытого дня сверяются по сохраненному в звене виду и хешу значений подписанной росписи,
+// текущие значения - только у закрытого журнала
+func verifyChain(tenant db.Tenant, journalID primitive.ObjectID) (*ChainReport, error) {
+	links, err := chainLinks(tenant, journalID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ChainReport{Journal: journalID, Breaks: []ChainBreak{}, Links: links}
+	broken := func(seq int, reason string) {
+		report.Breaks = append(report.Breaks, ChainBreak{Seq: seq, Reason: reason})
+	}
+
+	var previous string
+	for i, link := range links {
+		if link.Seq != i {
+			broken(i, ChainMissing)
+		}
+		if link.Previous != previous {
+			broken(link.Seq, ChainPrevious)
+		}
+		if digest, err := link.digest(); err != nil || digest != link.Digest {
+			broken(link.Seq, ChainDigest)
+		}
+		if len(link.Values) != 0 && sha256Hex([]byte(link.Values)) != link.ValuesDigest {
+			broken(link.Seq, ChainSnapshot)
+		}
+		previous = link.Digest
+	}
+
+	journal, err := chainJournal(tenant, journalID)
+	if err == mongo.ErrNoDocuments && len(links) != 0 {
+		broken(0, ChainJournalMissing)
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report.Closings = len(journal.Closings)
+	for i, link := range links {
+		if i >= len(journal.Closings) {
+			broken(link.Seq, ChainClosingRemoved)
+			continue
+		}
+		if !link.matches(journal.Closings[i]) {
+			broken(link.Seq, ChainClosing)
+		}
+	}
+	for seq := len(links); seq < len(journal.Closings); seq++ {
+		broken(seq, ChainUnchained)
+	}
+
+	// Ежедневный журнал после закрытия за день остается открытым, его значения меняются законно
+	if journal.Status == JournalClosed && len(links) != 0 {
+		values, err := valuesDigest(journal.Values)
+		if err != nil {
+			return nil, err
+		}
+		if values != links[len(links)-1].ValuesDigest {
+			broken(links[len(links)-1].Seq, ChainValues)
+		}
+	}
+
+	report.Valid = len(report.Breaks) == 0
+	return report, nil
+}
+
+// VerifyJournalChain проверяет цепочку хешей журнала id
+func VerifyJournalChain(tenant db.Tenant, id string) (*ChainReport, error) {
+	journalID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return verifyChain(tenant, journalID)
+}
+
+// VerifyChains проверяет цепочки всех журналов площадки, у которых есть звенья или росписи,
+// и передает отчет по каждому в report
+func VerifyChains(tenant db.Tenant, report func(ChainReport)) error {
+	ids := map[primitive.ObjectID]bool{}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cur, err := journalChainCollection(tenant).Aggregate(timeout, bson.A{
+		bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$journal"}}}},
+	})
+	if err != nil {
+		return err
+	}
+	for cur.Next(timeout) {
+		var group struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			cur.Close(timeout)
+			return err
+		}
+		ids[group.ID] = true
+	}
+	cur.Close(timeout)
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	// У заглушек архивных журналов росписей в Mongo нет, они проверяются по архиву
+	closed := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "closings.0", Value: bson.D{{Key: "$exists", Value: true}}}},
+		bson.D{{Key: "archive", Value: bson.D{{Key: "$exists", Value: true}}}},
+	}}}
+	journals, err := journalIDs(tenant, closed, 0)
+	if err != nil {
+		return err
+	}
+	for _, id := range journals {
+		ids[id] = true
+	}
+
+	for id := range ids {
+		result, err := verifyChain(tenant, id)
+		if err != nil {
+			return err
+		}
+		report(*result)
+	}
+	return nil
+}
+
+// purgeJournalChains удаляет цепочки журналов ids, удаленных окончательно
+func purgeJournalChains(tenant db.Tenant, ids []primitive.ObjectID) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	_, err := journalChainCollection(tenant).DeleteMany(timeout, bson.D{{Key: "journal", Value: bson.D{{Key: "$in", Value: ids}}}})
+	return err
+}
+
+// chainClosing добавляет звенья для новых росписей журнала. Роспись уже сохранена, поэтому ошибка
+// только записывается в лог: недостающие звенья добавятся при следующем закрытии, проверка покажет их как unchained
+func chainClosing(tenant db.Tenant, journal Journal) {
+	if err := appendChain(tenant, journal); err != nil {
+		log.Println("hash chain of journal", journal.ID.Hex(), "was not extended:", err)
+	}
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCanonicalValues(t *testing.T) {
+	at := time.Date(2019, 4, 1, 12, 30, 0, 123456789, time.FixedZone("MSK", 3*60*60))
+	id := primitive.NewObjectID()
+
+	tests := []struct {
+		name string
+		a, b map[string]interface{}
+	}{
+		{
+			"integer types",
+			map[string]interface{}{"count": 3},
+			map[string]interface{}{"count": int32(3)},
+		},
+		{
+			"nested document order",
+			map[string]interface{}{"tare": map[string]interface{}{"a": 1, "b": "x"}},
+			map[string]interface{}{"tare": primitive.D{{Key: "b", Value: "x"}, {Key: "a", Value: int64(1)}}},
+		},
+		{
+			"nested document types",
+			map[string]interface{}{"tare": primitive.M{"a": 1.5}},
+			map[string]interface{}{"tare": primitive.D{{Key: "a", Value: 1.5}}},
+		},
+		{
+			"arrays",
+			map[string]interface{}{"tags": []interface{}{"a", int32(2)}},
+			map[string]interface{}{"tags": primitive.A{"a", 2}},
+		},
+		{
+			"time zone and precision",
+			map[string]interface{}{"checked_at": at},
+			map[string]interface{}{"checked_at": primitive.DateTime(at.UnixNano() / int64(time.Millisecond))},
+		},
+		{
+			"object id",
+			map[string]interface{}{"ref": id},
+			map[string]interface{}{"ref": id.Hex()},
+		},
+	}
+
+	for _, test := range tests {
+		a, err := canonicalValues(test.a)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		b, err := canonicalValues(test.b)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if a != b {
+			t.Errorf("%s: canonical forms differ: %s and %s", test.name, a, b)
+		}
+	}
+
+	got, err := canonicalValues(map[string]interface{}{"b": 1, "a": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":"x","b":1}`; got != want {
+		t.Errorf("canonicalValues = %s, want %s", got, want)
+	}
+}
+
+func TestValuesDigest(t *testing.T) {
+	values := map[string]interface{}{"weight": 2.5, "ok": true}
+
+	stored, err := bson.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded map[string]interface{}
+	if err := bson.Unmarshal(stored, &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := valuesDigest(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := valuesDigest(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != reloaded {
+		t.Errorf("digest changed after a round trip through BSON: %s and %s", digest, reloaded)
+	}
+
+	snapshot, err := canonicalValues(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256Hex([]byte(snapshot)) != digest {
+		t.Errorf("digest is not the hash of the canonical snapshot")
+	}
+
+	changed, err := valuesDigest(map[string]interface{}{"weight": 2.6, "ok": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == digest {
+		t.Errorf("digest did not change with the values")
+	}
+}
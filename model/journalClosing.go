@@ -0,0 +1,205 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Размер изображения росписи
+const (
+	SignatureWidth  = 250
+	SignatureHeight = 125
+)
+
+// Errors godoc
+var (
+	ErrSignatureInvalid     = errors.New("signature must be base64 encoded png 250x125")
+	ErrJournalClosed        = errors.New("journal is closed")
+	ErrJournalAlreadyClosed = errors.New("journal is already closed for this period")
+	ErrNoActiveShift        = errors.New("there is no active shift")
+	ErrJournalOutOfScope    = errors.New("journal is not assigned to operator")
+)
+
+// scopedJournal журнал id, если он попадает в scope. scope nil - любой журнал
+func scopedJournal(tenant db.Tenant, scope *OrgScope, id string) (*Journal, error) {
+	journal, err := JournalOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil && !scope.contains(*journal) {
+		return nil, ErrJournalOutOfScope
+	}
+	return journal, nil
+}
+
+// JournalClosing роспись контроллера о закрытии журнала за день или смену
+type JournalClosing struct {
+	// Date начало суток, за которые закрыт журнал
+	Date time.Time `bson:"date" json:"date"`
+	// Shift смена, за которую закрыт журнал. Пустая, если журнал закрыт за день
+	Shift    *primitive.ObjectID `bson:"shift,omitempty" json:"shift,omitempty" example:"5ca10d9d015c736a72b7b3b7"`
+	ClosedBy string              `bson:"closed_by" json:"closed_by" example:"olegov"`
+	ClosedAt time.Time           `bson:"closed_at" json:"closed_at"`
+	Accepted *int                `bson:"accepted,omitempty" json:"accepted,omitempty" example:"-1"`
+	// Signature изображение росписи в формате png
+	Signature []byte `bson:"signature" json:"-"`
+
+	// ValuesDigest хеш значений журнала на момент закрытия
+	ValuesDigest string `bson:"values_digest,omitempty" json:"values_digest,omitempty"`
+	// KeyFingerprint отпечаток ключа контроллера, которым подписана роспись
+	KeyFingerprint string `bson:"key_fingerprint,omitempty" json:"key_fingerprint,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	// DigitalSignature отсоединенная подпись ed25519 канонического вида росписи в base64
+	DigitalSignature []byte `bson:"digital_signature,omitempty" json:"digital_signature,omitempty" swaggertype:"string" example:"q83vEjRWeJA..."`
+	// Confirmation подтверждение закрытия повторным вводом учетных данных
+	Confirmation *Confirmation `bson:"confirmation,omitempty" json:"confirmation,omitempty"`
+	// Voided отмена росписи при отклонении журнала проверяющим. Отмененная роспись остается в журнале и цепочке,
+	// но не мешает закрыть журнал за тот же день или смену повторно
+	Voided *ClosingVoid `bson:"voided,omitempty" json:"voided,omitempty"`
+	// Verification результат проверки подписи при чтении журнала. Не хранится
+	Verification string `bson:"-" json:"verification,omitempty" example:"valid"`
+}
+
+// ClosingVoid отмена росписи
+type ClosingVoid struct {
+	By     string    `bson:"by" json:"by" example:"quality_lead"`
+	At     time.Time `bson:"at" json:"at"`
+	Reason string    `bson:"reason" json:"reason" example:"Не заполнена поверка за вторник"`
+}
+
+// JournalClose запрос на закрытие журнала
+type JournalClose struct {
+	// Signature роспись: png 250x125 в base64
+	Signature string `json:"signature" binding:"required" example:"iVBORw0KGgo..."`
+	// Accepted -1 если журнал закрывается с корректирующими действиями
+	Accepted *int `json:"accepted,omitempty" example:"-1"`
+	// PerShift закрыть ежедневный журнал за текущую смену, а не за день
+	PerShift bool `json:"per_shift" example:"true"`
+	// Secret пароль или PIN-код контроллера, которым защищен его ключ подписи
+	Secret string `json:"secret" binding:"required" example:"qwerty12"`
+}
+
+func decodeSignature(signature string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrSignatureInvalid
+	}
+
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width != SignatureWidth || config.Height != SignatureHeight {
+		return nil, ErrSignatureInvalid
+	}
+
+	return data, nil
+}
+
+// CloseJournal добавляет роспись о закрытии журнала. Ежедневный журнал закрывается за текущий день
+// или, если close.PerShift, за текущую смену и остается открытым для следующих периодов.
+// Остальные журналы закрываются окончательно. Журнал на удержании закрыть нельзя.
+// scope ограничивает журналы контроллера его узлами, nil - любой журнал.
+// Роспись подписывается ключом контроллера by, который открывается паролем или PIN-кодом close.Secret,
+// вместе с подтверждением confirmation.
+// Каждая роспись добавляет звено в цепочку хешей журнала
+func CloseJournal(tenant db.Tenant, scope *OrgScope, id string, version int64, by string, close JournalClose, confirmation *Confirmation) (*Journal, error) {
+	signature, err := decodeSignature(close.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := scopedJournal(tenant, scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	key, private, err := unlockOperatorKey(tenant, by, close.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if journal.Status == JournalClosed {
+		return nil, ErrJournalClosed
+	}
+	if err := journal.checkHold(); err != nil {
+		return nil, err
+	}
+	if err := rehydrate(tenant, journal); err != nil {
+		return nil, err
+	}
+
+	// У журнала с корректирующими действиями Accepted определяется ими, а не контроллером
+	accepted, err := capaAccepted(tenant, journal.ID)
+	if err != nil {
+		return nil, err
+	}
+	if accepted != nil {
+		close.Accepted = accepted
+	}
+
+	now := time.Now()
+	closing := JournalClosing{
+		Date:         time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local),
+		ClosedBy:     by,
+		ClosedAt:     now,
+		Accepted:     close.Accepted,
+		Signature:    signature,
+		Confirmation: confirmation,
+	}
+
+	if close.PerShift {
+		closing.Shift = activeShift(tenant, *journal, now)
+		if closing.Shift == nil {
+			return nil, ErrNoActiveShift
+		}
+	}
+
+	for _, closed := range journal.Closings {
+		if closed.Voided != nil {
+			continue
+		}
+		samePeriod := closed.Shift == nil && closing.Shift == nil && closed.Date.Equal(closing.Date)
+		if closed.Shift != nil && closing.Shift != nil && *closed.Shift == *closing.Shift {
+			samePeriod = true
+		}
+		if samePeriod {
+			return nil, ErrJournalAlreadyClosed
+		}
+	}
+
+	if err := closing.sign(*journal, key.Fingerprint, private); err != nil {
+		return nil, err
+	}
+
+	set := bson.D{
+		{Key: "updated_at", Value: now},
+		{Key: "version", Value: version + 1},
+	}
+	if close.Accepted != nil {
+		set = append(set, bson.E{Key: "accepted", Value: *close.Accepted})
+	}
+	if !journal.Daily {
+		set = append(set, bson.E{Key: "status", Value: JournalClosed})
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$push", Value: bson.D{{Key: "closings", Value: closing}}},
+	}
+
+	filter := bson.D{{Key: "deleted_at", Value: nil}, {Key: "status", Value: bson.D{{Key: "$ne", Value: JournalClosed}}}, notHeld()}
+	if err := updateVersioned(journalCollection(tenant), journal.ID, version, filter, update); err != nil {
+		return nil, err
+	}
+
+	closed, err := JournalOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	chainClosing(tenant, *closed)
+
+	return closed, nil
+}
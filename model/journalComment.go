@@ -0,0 +1,495 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Состояния обсуждения
+const (
+	ThreadOpen     = "open"
+	ThreadResolved = "resolved"
+)
+
+// Errors godoc
+var (
+	ErrCommentEmpty      = errors.New("comment text is empty")
+	ErrCommentField      = errors.New("comment field is not a field of the journal scheme")
+	ErrCommentParent     = errors.New("comment replies to a comment of another thread")
+	ErrCommentMention    = errors.New("mentioned login is neither a user nor an operator of the tenant")
+	ErrThreadResolved    = errors.New("discussion is resolved")
+	ErrThreadNotResolved = errors.New("discussion is not resolved")
+)
+
+// mentionPattern упоминание пользователя или контроллера в тексте комментария: @login
+var mentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_.\-]+)`)
+
+// Comment сообщение обсуждения. Сообщения только добавляются и не меняются
+type Comment struct {
+	ID primitive.ObjectID `bson:"_id" json:"_id" example:"5ca10d9d015c736a72b7b3bc"`
+	// Parent сообщение, на которое это ответ. Пустой у сообщений, отвечающих на обсуждение в целом
+	Parent   *primitive.ObjectID `bson:"parent,omitempty" json:"parent,omitempty" example:"5ca10d9d015c736a72b7b3bb"`
+	Text     string              `bson:"text" json:"text" example:"@olegov почему вес вне допуска?"`
+	Author   string              `bson:"author" json:"author" example:"quality_lead"`
+	Mentions []string            `bson:"mentions,omitempty" json:"mentions,omitempty" example:"olegov"`
+	At       time.Time           `bson:"at" json:"at"`
+}
+
+// CommentRead отметка о прочтении обсуждения пользователем By: прочитаны все сообщения до At
+type CommentRead struct {
+	By string    `bson:"by" json:"by" example:"olegov"`
+	At time.Time `bson:"at" json:"at"`
+}
+
+// CommentThread обсуждение журнала, дня журнала или значения поля. Обсуждение хранится отдельно от журнала
+// и никогда не меняет его значения. Решенное обсуждение закрыто для ответов и входит в историю журнала
+type CommentThread struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3bb"`
+	Journal primitive.ObjectID `bson:"journal" json:"journal" example:"5ca10d9d015c736a72b7b3ba"`
+	// Date день журнала, к которому относится обсуждение. Пустой - обсуждение всего журнала
+	Date *time.Time `bson:"date,omitempty" json:"date,omitempty"`
+	// Field поле журнала, к значению которого относится обсуждение
+	Field string `bson:"field,omitempty" json:"field,omitempty" example:"weight"`
+	// Value значение поля на момент начала обсуждения
+	Value interface{} `bson:"value,omitempty" json:"value,omitempty" swaggertype:"string" example:"10.4"`
+
+	Status    string        `bson:"status" json:"status" example:"open"`
+	Comments  []Comment     `bson:"comments" json:"comments"`
+	Reads     []CommentRead `bson:"reads,omitempty" json:"reads,omitempty"`
+	CreatedBy string        `bson:"created_by" json:"created_by" example:"quality_lead"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	ResolvedBy string     `bson:"resolved_by,omitempty" json:"resolved_by,omitempty" example:"quality_lead"`
+	ResolvedAt *time.Time `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+	Resolution string     `bson:"resolution,omitempty" json:"resolution,omitempty" example:"Весы перекалиброваны"`
+
+	// Unread сколько сообщений текущий пользователь еще не прочитал. Не хранится
+	Unread int `bson:"-" json:"unread" example:"2"`
+}
+
+// NewCommentThread начало обсуждения
+type NewCommentThread struct {
+	Date  *time.Time `json:"date,omitempty"`
+	Field string     `json:"field,omitempty" example:"weight"`
+	Text  string     `json:"text" binding:"required" example:"@olegov почему вес вне допуска?"`
+	// Mentions упомянутые пользователи и контроллеры в дополнение к @login в тексте
+	Mentions []string `json:"mentions,omitempty" example:"olegov"`
+}
+
+// NewComment ответ в обсуждении
+type NewComment struct {
+	Parent   string   `json:"parent,omitempty" example:"5ca10d9d015c736a72b7b3bc"`
+	Text     string   `json:"text" binding:"required" example:"Весы перекалиброваны, повторное взвешивание в норме"`
+	Mentions []string `json:"mentions,omitempty" example:"quality_lead"`
+}
+
+// ThreadResolution решение обсуждения
+type ThreadResolution struct {
+	Resolution string `json:"resolution" binding:"required" example:"Весы перекалиброваны"`
+}
+
+// commentThreadFields поля, доступные в фильтрах и сортировке списка обсуждений
+var commentThreadFields = db.Fields{
+	"date":       {Key: "date", Type: db.TimeField, Sort: true},
+	"field":      {Key: "field", Type: db.StringField, Sort: true},
+	"status":     {Key: "status", Type: db.StringField, Sort: true},
+	"created_by": {Key: "created_by", Type: db.StringField, Sort: true},
+	"created_at": {Key: "created_at", Type: db.TimeField, Sort: true},
+	"updated_at": {Key: "updated_at", Type: db.TimeField, Sort: true},
+}
+
+func commentThreadCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("commentThread")
+}
+
+// mentions упоминания из текста и явного списка без повторов
+func mentions(text string, listed []string) []string {
+	result := []string{}
+	add := func(login string) {
+		login = strings.TrimRight(login, ".-")
+		if len(login) != 0 && !CheckIn(login, result) {
+			result = append(result, login)
+		}
+	}
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		add(match[1])
+	}
+	for _, login := range listed {
+		add(strings.TrimPrefix(login, "@"))
+	}
+	return result
+}
+
+// knownLogins логины из logins, которые принадлежат пользователям или действующим контроллерам площадки
+func knownLogins(tenant db.Tenant, logins []string) ([]string, error) {
+	known := []string{}
+	if len(logins) == 0 {
+		return known, nil
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	found := map[string]bool{}
+	// Пользователи хранятся в общей коллекции, площадка отбирается явно. Коллекция контроллеров отбирает ее сама
+	userFilter := bson.D{
+		{Key: db.TenantKey, Value: tenant},
+		{Key: "username", Value: bson.D{{Key: "$in", Value: logins}}},
+		{Key: "deleted_at", Value: nil},
+	}
+	users, err := db.Global("Users").Distinct(timeout, "username", userFilter)
+	if err != nil {
+		return nil, err
+	}
+	operatorFilter := bson.D{
+		{Key: "login", Value: bson.D{{Key: "$in", Value: logins}}},
+		{Key: "deleted_at", Value: nil},
+	}
+	operators, err := operatorCollection(tenant).Distinct(timeout, "login", operatorFilter)
+	if err != nil {
+		return nil, err
+	}
+	for _, login := range append(users, operators...) {
+		if s, ok := login.(string); ok {
+			found[s] = true
+		}
+	}
+
+	for _, login := range logins {
+		if found[login] {
+			known = append(known, login)
+		}
+	}
+	return known, nil
+}
+
+// newComment сообщение автора by. Упоминания в тексте, которые не принадлежат пользователям или контроллерам площадки,
+// пропускаются, а неизвестный логин в явном списке - ошибка
+func newComment(tenant db.Tenant, text string, listed []string, by string, now time.Time) (Comment, error) {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return Comment{}, ErrCommentEmpty
+	}
+
+	known, err := knownLogins(tenant, mentions(text, listed))
+	if err != nil {
+		return Comment{}, err
+	}
+	for _, login := range mentions("", listed) {
+		if !CheckIn(login, known) {
+			return Comment{}, ErrCommentMention
+		}
+	}
+
+	return Comment{
+		ID:       primitive.NewObjectID(),
+		Text:     text,
+		Author:   by,
+		Mentions: known,
+		At:       now,
+	}, nil
+}
+
+// unread сколько сообщений пользователь username не прочитал. Свои сообщения считаются прочитанными
+func (t *CommentThread) unread(username string) {
+	var readAt time.Time
+	for _, read := range t.Reads {
+		if read.By == username {
+			readAt = read.At
+		}
+	}
+	t.Unread = 0
+	for _, comment := range t.Comments {
+		if comment.Author != username && comment.At.After(readAt) {
+			t.Unread++
+		}
+	}
+}
+
+// threadOf обсуждение id журнала journal
+func threadOf(tenant db.Tenant, journal primitive.ObjectID, id string) (*CommentThread, error) {
+	threadID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var thread CommentThread
+	filter := bson.D{{Key: "_id", Value: threadID}, {Key: "journal", Value: journal}}
+	if err := commentThreadCollection(tenant).FindOne(timeout, filter).Decode(&thread); err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+
+// CommentThreads обсуждения журнала id, доступного в scope, с числом непрочитанных сообщений пользователя username
+func CommentThreads(tenant db.Tenant, scope *OrgScope, id string, username string, query db.Query) (db.Page, error) {
+	journal, err := scopedJournal(tenant, scope, id)
+	if err != nil {
+		return db.Page{}, err
+	}
+
+	var list []CommentThread
+	page, err := db.FindPage(commentThreadCollection(tenant), bson.D{{Key: "journal", Value: journal.ID}}, commentThreadFields, query, nil, &list)
+	if err != nil {
+		return db.Page{}, err
+	}
+	for i := range list {
+		list[i].unread(username)
+	}
+	page.Items = list
+	return page, nil
+}
+
+// CommentThreadOne обсуждение threadID журнала id, доступного в scope
+func CommentThreadOne(tenant db.Tenant, scope *OrgScope, id, threadID, username string) (*CommentThread, error) {
+	journal, err := scopedJournal(tenant, scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	thread, err := threadOf(tenant, journal.ID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	thread.unread(username)
+	return thread, nil
+}
+
+// StartCommentThread начинает обсуждение журнала id, дня или значения поля от имени пользователя by.
+// Журнал не меняется
+func StartCommentThread(tenant db.Tenant, scope *OrgScope, id string, by string, newThread NewCommentThread) (*CommentThread, error) {
+	journal, err := scopedJournal(tenant, scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	comment, err := newComment(tenant, newThread.Text, newThread.Mentions, by, now)
+	if err != nil {
+		return nil, err
+	}
+
+	thread := CommentThread{
+		Journal:   journal.ID,
+		Field:     newThread.Field,
+		Status:    ThreadOpen,
+		Comments:  []Comment{comment},
+		Reads:     []CommentRead{{By: by, At: now}},
+		CreatedBy: by,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if newThread.Date != nil {
+		day := time.Date(newThread.Date.Year(), newThread.Date.Month(), newThread.Date.Day(), 0, 0, 0, 0, newThread.Date.Location())
+		thread.Date = &day
+	}
+	if len(newThread.Field) != 0 {
+		scheme, err := journalSchemeByName(tenant, journal.Scheme)
+		if err != nil {
+			return nil, ErrCommentField
+		}
+		known := false
+		for _, field := range scheme.Fields {
+			known = known || field.Name == newThread.Field
+		}
+		if !known {
+			return nil, ErrCommentField
+		}
+		thread.Value = journal.Values[newThread.Field]
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	insertedResault, err := commentThreadCollection(tenant).InsertOne(timeout, thread)
+	if err != nil {
+		return nil, err
+	}
+	thread.ID = insertedResault.InsertedID.(primitive.ObjectID)
+
+	return &thread, nil
+}
+
+// ReplyComment добавляет ответ пользователя by в открытое обсуждение threadID журнала id
+func ReplyComment(tenant db.Tenant, scope *OrgScope, id, threadID string, by string, reply NewComment) (*CommentThread, error) {
+	thread, err := CommentThreadOne(tenant, scope, id, threadID, by)
+	if err != nil {
+		return nil, err
+	}
+	if thread.Status == ThreadResolved {
+		return nil, ErrThreadResolved
+	}
+
+	now := time.Now()
+	comment, err := newComment(tenant, reply.Text, reply.Mentions, by, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply.Parent) != 0 {
+		parent, err := primitive.ObjectIDFromHex(reply.Parent)
+		if err != nil {
+			return nil, ErrCommentParent
+		}
+		found := false
+		for _, c := range thread.Comments {
+			found = found || c.ID == parent
+		}
+		if !found {
+			return nil, ErrCommentParent
+		}
+		comment.Parent = &parent
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: thread.ID}, {Key: "status", Value: ThreadOpen}}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{{Key: "comments", Value: comment}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}}},
+	}
+	result, err := commentThreadCollection(tenant).UpdateOne(timeout, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrThreadResolved
+	}
+
+	if err := markThreadRead(tenant, thread.ID, by, now); err != nil {
+		return nil, err
+	}
+	return CommentThreadOne(tenant, scope, id, threadID, by)
+}
+
+func markThreadRead(tenant db.Tenant, thread primitive.ObjectID, by string, at time.Time) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := commentThreadCollection(tenant).UpdateOne(timeout,
+		bson.D{{Key: "_id", Value: thread}, {Key: "reads.by", Value: by}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "reads.$.at", Value: at}}}})
+	if err != nil || result.MatchedCount != 0 {
+		return err
+	}
+
+	_, err = commentThreadCollection(tenant).UpdateOne(timeout,
+		bson.D{{Key: "_id", Value: thread}, {Key: "reads.by", Value: bson.D{{Key: "$ne", Value: by}}}},
+		bson.D{{Key: "$push", Value: bson.D{{Key: "reads", Value: CommentRead{By: by, At: at}}}}})
+	return err
+}
+
+// ReadCommentThread отмечает, что пользователь by прочитал все сообщения обсуждения threadID
+func ReadCommentThread(tenant db.Tenant, scope *OrgScope, id, threadID string, by string) (*CommentThread, error) {
+	thread, err := CommentThreadOne(tenant, scope, id, threadID, by)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := markThreadRead(tenant, thread.ID, by, time.Now()); err != nil {
+		return nil, err
+	}
+	return CommentThreadOne(tenant, scope, id, threadID, by)
+}
+
+// ResolveCommentThread закрывает обсуждение threadID с решением от имени пользователя by.
+// Решенное обсуждение не принимает ответов и попадает в историю журнала
+func ResolveCommentThread(tenant db.Tenant, scope *OrgScope, id, threadID string, by string, resolution ThreadResolution) (*CommentThread, error) {
+	thread, err := CommentThreadOne(tenant, scope, id, threadID, by)
+	if err != nil {
+		return nil, err
+	}
+	if thread.Status == ThreadResolved {
+		return nil, ErrThreadResolved
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.D{{Key: "_id", Value: thread.ID}, {Key: "status", Value: ThreadOpen}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: ThreadResolved},
+		{Key: "resolved_by", Value: by},
+		{Key: "resolved_at", Value: now},
+		{Key: "resolution", Value: resolution.Resolution},
+		{Key: "updated_at", Value: now},
+	}}}
+	result, err := commentThreadCollection(tenant).UpdateOne(timeout, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrThreadResolved
+	}
+
+	return CommentThreadOne(tenant, scope, id, threadID, by)
+}
+
+// MentionedThreads открытые обсуждения, в которых упомянут пользователь username, начиная с последних.
+// scope ограничивает журналы контроллера, nil - все журналы
+func MentionedThreads(tenant db.Tenant, scope *OrgScope, username string, query db.Query) (db.Page, error) {
+	filter := bson.D{{Key: "comments.mentions", Value: username}, {Key: "status", Value: ThreadOpen}}
+	if scope != nil {
+		ids, err := journalIDs(tenant, bson.D{{Key: "deleted_at", Value: nil}, scope.filter()}, 0)
+		if err != nil {
+			return db.Page{}, err
+		}
+		filter = append(filter, bson.E{Key: "journal", Value: bson.D{{Key: "$in", Value: ids}}})
+	}
+
+	var list []CommentThread
+	page, err := db.FindPage(commentThreadCollection(tenant), filter, commentThreadFields, query, nil, &list)
+	if err != nil {
+		return db.Page{}, err
+	}
+	for i := range list {
+		list[i].unread(username)
+	}
+	page.Items = list
+	return page, nil
+}
+
+// resolvedThreads решенные обсуждения журнала в порядке решения
+func resolvedThreads(tenant db.Tenant, journal primitive.ObjectID) ([]CommentThread, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "journal", Value: journal}, {Key: "status", Value: ThreadResolved}}
+	cur, err := commentThreadCollection(tenant).Find(timeout, filter, options.Find().SetSort(bson.D{{Key: "resolved_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	threads := []CommentThread{}
+	for cur.Next(timeout) {
+		var thread CommentThread
+		if err := cur.Decode(&thread); err != nil {
+			return nil, err
+		}
+		threads = append(threads, thread)
+	}
+	return threads, cur.Err()
+}
+
+// purgeJournalComments удаляет обсуждения журналов ids, удаленных окончательно
+func purgeJournalComments(tenant db.Tenant, ids []primitive.ObjectID) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	_, err := commentThreadCollection(tenant).DeleteMany(timeout, bson.D{{Key: "journal", Value: bson.D{{Key: "$in", Value: ids}}}})
+	return err
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+
+	"github.com/Oxynger/JournalApp/db"
+)
+
+// ExportJournal пишет в w zip-архив журнала: journal.json с результатом проверки подписей росписей,
+// keys.json с открытыми ключами, которыми подписаны росписи, discussions.json с решенными обсуждениями,
+// capa.json с корректирующими действиями, attachments.json со списком вложений и содержимое вложений в каталоге attachments/
+func ExportJournal(tenant db.Tenant, scope *OrgScope, id string, w io.Writer) error {
+	journal, err := scopedJournal(tenant, scope, id)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := journalAttachments(tenant, journal.ID)
+	if err != nil {
+		return err
+	}
+
+	keys, err := signingKeys(tenant, *journal)
+	if err != nil {
+		return err
+	}
+	discussions, err := resolvedThreads(tenant, journal.ID)
+	if err != nil {
+		return err
+	}
+
+	capas, err := journalCAPAs(tenant, journal.ID)
+	if err != nil {
+		return err
+	}
+
+	publicKeys := []OperatorKey{}
+	for _, key := range keys {
+		publicKeys = append(publicKeys, key)
+	}
+
+	archive := zip.NewWriter(w)
+
+	if err := writeJSON(archive, "journal.json", journal); err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "keys.json", publicKeys); err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "discussions.json", discussions); err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "capa.json", capas); err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "attachments.json", attachments); err != nil {
+		return err
+	}
+
+	for _, attachment := range attachments {
+		if err := writeAttachment(tenant, archive, attachment); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func writeJSON(archive *zip.Writer, name string, value interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func writeAttachment(tenant db.Tenant, archive *zip.Writer, attachment Attachment) error {
+	content, err := OpenAttachment(tenant, attachment, false)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	file, err := archive.Create("attachments/" + attachment.ID.Hex() + "_" + attachment.Filename)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, content)
+	return err
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/Oxynger/JournalApp/model/expression"
+)
+
+// ComputedExpression тип вычисляемого поля, значение которого задано выражением
+const ComputedExpression = "expression"
+
+// Errors godoc
+var (
+	ErrExpressionInvalid = errors.New("expression is empty")
+	ErrExpressionCycle   = errors.New("computed expressions reference each other in a cycle")
+)
+
+// computedExpression выражение вычисляемого поля или nil
+func (f JournalField) computedExpression() *string {
+	if f.Computed == nil || f.Computed.Type != ComputedExpression {
+		return nil
+	}
+	return f.Computed.Expression
+}
+
+// condition выражение условия отображения полей If или nil
+func (f JournalField) condition() *string {
+	if f.If == nil {
+		return nil
+	}
+	return f.If.Condition
+}
+
+func expressionError(field string, err error) error {
+	return &FieldError{Field: field, Reason: "expression: " + err.Error()}
+}
+
+// parseExpressions разбирает выражения вычисляемых полей схемы в порядке, в котором их можно вычислить:
+// поле идет после полей, на которые ссылается его выражение
+func parseExpressions(fields []JournalField) ([]JournalField, map[string]*expression.Expression, error) {
+	byName := map[string]JournalField{}
+	parsed := map[string]*expression.Expression{}
+	for _, field := range fields {
+		byName[field.Name] = field
+		if source := field.computedExpression(); source != nil {
+			e, err := expression.Parse(*source)
+			if err != nil {
+				return nil, nil, expressionError(field.Name, err)
+			}
+			parsed[field.Name] = e
+		}
+	}
+
+	// Поиск в глубину: 1 - поле в обработке, 2 - обработано
+	state := map[string]int{}
+	order := []JournalField{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return ErrExpressionCycle
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, ref := range parsed[name].Fields() {
+			if _, ok := parsed[ref]; ok {
+				if err := visit(ref); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = 2
+		order = append(order, byName[name])
+		return nil
+	}
+	for _, field := range fields {
+		if _, ok := parsed[field.Name]; ok {
+			if err := visit(field.Name); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return order, parsed, nil
+}
+
+// validateExpressions проверяет выражения схемы при сохранении: синтаксис, ссылки на существующие поля
+// и на поля информации о позиции из itemInfo, отсутствие циклов между вычисляемыми полями
+func validateExpressions(fields []JournalField, itemInfo *[]string) error {
+	names := []string{}
+	for _, field := range fields {
+		names = append(names, field.Name)
+	}
+	info := []string{}
+	if itemInfo != nil {
+		info = *itemInfo
+	}
+
+	// Условие If может ссылаться на свое поле, вычисляемое выражение - нет
+	check := func(field string, source string, computed bool) error {
+		e, err := expression.Parse(source)
+		if err != nil {
+			return expressionError(field, err)
+		}
+		for _, ref := range e.Fields() {
+			if !CheckIn(ref, names) {
+				return &FieldError{Field: field, Reason: "expression references unknown field " + ref}
+			}
+			if computed && ref == field {
+				return &FieldError{Field: field, Reason: "expression references the field itself"}
+			}
+		}
+		for _, ref := range e.ItemInfo() {
+			if !CheckIn(ref, info) {
+				return &FieldError{Field: field, Reason: "expression references unknown item info " + ref}
+			}
+		}
+		return nil
+	}
+
+	for _, field := range fields {
+		if source := field.computedExpression(); source != nil {
+			if err := check(field.Name, *source, true); err != nil {
+				return err
+			}
+		}
+		if source := field.condition(); source != nil {
+			if err := check(field.Name, *source, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, _, err := parseExpressions(fields)
+	return err
+}
+
+// journalEnv значения журнала, доступные выражениям
+func journalEnv(journal *Journal) expression.Env {
+	return expression.Env{Fields: journal.Values, Item: journal.ItemInfo}
+}
+
+// computeExpressions вычисляет поля с типом expression и записывает результат в значения журнала.
+// Если в выражении используется незаполненное поле, вычисляемое поле остается пустым.
+// Возвращает ошибки всех полей, которые не удалось вычислить
+func computeExpressions(journal *Journal, fields []JournalField) []error {
+	order, parsed, err := parseExpressions(fields)
+	if err != nil {
+		return []error{err}
+	}
+
+	errs := []error{}
+	for _, field := range order {
+		value, err := parsed[field.Name].Eval(journalEnv(journal))
+		if err == expression.ErrMissingValue {
+			delete(journal.Values, field.Name)
+			continue
+		}
+		if err != nil {
+			delete(journal.Values, field.Name)
+			errs = append(errs, expressionError(field.Name, err))
+			continue
+		}
+		journal.Values[field.Name] = value
+	}
+
+	return errs
+}
+
+// shown показываются ли поля, перечисленные в If поля controller. Без условия - когда поле controller заполнено.
+// Условие, которое не удалось вычислить, считается ложным
+func shown(journal *Journal, controller JournalField) bool {
+	source := controller.condition()
+	if source == nil {
+		return conditionMet(journal.Values[controller.Name])
+	}
+
+	e, err := expression.Parse(*source)
+	if err != nil {
+		return false
+	}
+	value, err := e.Eval(journalEnv(journal))
+	return err == nil && expression.Truthy(value)
+}
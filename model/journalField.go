@@ -0,0 +1,456 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/model/expression"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Типы полей схемы журнала
+const (
+	FieldInteger     = "Integer"
+	FieldDouble      = "Double"
+	FieldDecimal     = "Decimal"
+	FieldTemperature = "Temperature"
+	FieldString      = "String"
+	FieldBarcode     = "Barcode"
+	FieldBoolean     = "Boolean"
+	FieldArray       = "Array"
+	FieldSignature   = "Signature"
+	FieldDate        = "Date"
+	FieldTime        = "Time"
+	FieldDateTime    = "DateTime"
+	FieldObjectID    = "ObjectId"
+	FieldSelect      = "Select"
+	FieldMultiSelect = "MultiSelect"
+	FieldPhoto       = "Photo"
+	FieldAttachment  = AttachmentFieldType
+
+	// fieldDoubleLegacy написание Double в схемах, сохраненных до исправления опечатки
+	fieldDoubleLegacy = "Dooble"
+)
+
+// MaxFieldPrecision наибольшее количество знаков после запятой у поля Decimal
+const MaxFieldPrecision = 10
+
+// Форматы значений полей Date, Time и DateTime
+const (
+	FieldDateFormat     = "2006-01-02"
+	FieldTimeFormat     = "15:04"
+	FieldDateTimeFormat = time.RFC3339
+)
+
+// fieldTypes все допустимые типы полей
+var fieldTypes = []string{
+	FieldInteger, FieldDouble, fieldDoubleLegacy, FieldDecimal, FieldTemperature,
+	FieldString, FieldBarcode, FieldBoolean, FieldArray, FieldSignature,
+	FieldDate, FieldTime, FieldDateTime, FieldObjectID,
+	FieldSelect, FieldMultiSelect, FieldPhoto, FieldAttachment,
+}
+
+// temperatureUnits единицы температуры и абсолютный ноль в них
+var temperatureUnits = map[string]float64{
+	"C": -273.15,
+	"F": -459.67,
+	"K": 0,
+}
+
+var barcodePattern = regexp.MustCompile(`^[\x21-\x7e]+$`)
+
+// Errors godoc
+var (
+	ErrConstraintInvalid = errors.New("field constraints are not valid for field type")
+	ErrPrecisionInvalid  = errors.New("precision must be from 0 to 10")
+	ErrUnitInvalid       = errors.New("temperature unit must be C, F or K")
+	ErrOptionsInvalid    = errors.New("options must be a non-empty list of unique values")
+	ErrLengthInvalid     = errors.New("min_length and max_length must be non-negative and min_length <= max_length")
+	ErrRangeInvalid      = errors.New("min must be less or equal to max")
+	ErrPatternInvalid    = errors.New("pattern is not a valid regular expression")
+	ErrDefaultInvalid    = errors.New("default value does not match field type and constraints")
+)
+
+// JournalConstraints ограничения на значение поля журнала
+type JournalConstraints struct {
+	// Required значение обязательно. Для полей, которые показываются по условию If, - только когда условие выполнено
+	Required bool `bson:"required,omitempty" json:"required,omitempty" example:"true"`
+
+	// MinLength, MaxLength длина строки в символах. Для String и Barcode
+	MinLength *int `bson:"min_length,omitempty" json:"min_length,omitempty" example:"1"`
+	MaxLength *int `bson:"max_length,omitempty" json:"max_length,omitempty" example:"64"`
+	// Pattern регулярное выражение, которому должна соответствовать строка. Для String и Barcode
+	Pattern *string `bson:"pattern,omitempty" json:"pattern,omitempty" example:"^[0-9]{8}$"`
+
+	// Min, Max допустимый диапазон числа. Для Integer, Double, Decimal и Temperature
+	Min *float64 `bson:"min,omitempty" json:"min,omitempty" example:"0"`
+	Max *float64 `bson:"max,omitempty" json:"max,omitempty" example:"100"`
+	// Unit единица измерения. Для Temperature: C, F или K, по умолчанию C
+	Unit *string `bson:"unit,omitempty" json:"unit,omitempty" example:"кг"`
+	// Precision количество знаков после запятой. Для Decimal обязательно
+	Precision *int `bson:"precision,omitempty" json:"precision,omitempty" example:"2"`
+
+	// Options допустимые значения. Для Select и MultiSelect обязательно
+	Options []string `bson:"options,omitempty" json:"options,omitempty" example:"годен,не годен"`
+
+	// Default значение, которое подставляется, если поле не заполнено
+	Default interface{} `bson:"default,omitempty" json:"default,omitempty"`
+}
+
+// FieldError значение поля журнала не подходит под тип или ограничения поля схемы
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s: %s", e.Field, e.Reason)
+}
+
+// numericType тип поля - число
+func numericType(fieldType string) bool {
+	return CheckIn(fieldType, []string{FieldInteger, FieldDouble, fieldDoubleLegacy, FieldDecimal, FieldTemperature})
+}
+
+// textType тип поля - строка, для которой имеют смысл длина и регулярное выражение
+func textType(fieldType string) bool {
+	return fieldType == FieldString || fieldType == FieldBarcode
+}
+
+// validate проверяет тип и ограничения поля при сохранении схемы
+func (f JournalField) validate() error {
+	if !CheckIn(f.Type, fieldTypes) {
+		return ErrTypeInvalid
+	}
+
+	c := f.Constraints
+	if c == nil {
+		switch f.Type {
+		case FieldDecimal:
+			return ErrPrecisionInvalid
+		case FieldSelect, FieldMultiSelect:
+			return ErrOptionsInvalid
+		}
+		return nil
+	}
+
+	if (c.MinLength != nil || c.MaxLength != nil || c.Pattern != nil) && !textType(f.Type) {
+		return ErrConstraintInvalid
+	}
+	if (c.Min != nil || c.Max != nil || c.Unit != nil) && !numericType(f.Type) {
+		return ErrConstraintInvalid
+	}
+	if c.Precision != nil && f.Type != FieldDecimal {
+		return ErrConstraintInvalid
+	}
+	if len(c.Options) != 0 && f.Type != FieldSelect && f.Type != FieldMultiSelect {
+		return ErrConstraintInvalid
+	}
+
+	if (c.MinLength != nil && *c.MinLength < 0) || (c.MaxLength != nil && *c.MaxLength < 0) ||
+		(c.MinLength != nil && c.MaxLength != nil && *c.MinLength > *c.MaxLength) {
+		return ErrLengthInvalid
+	}
+	if c.Pattern != nil {
+		if _, err := regexp.Compile(*c.Pattern); err != nil {
+			return ErrPatternInvalid
+		}
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return ErrRangeInvalid
+	}
+
+	switch f.Type {
+	case FieldDecimal:
+		if c.Precision == nil || *c.Precision < 0 || *c.Precision > MaxFieldPrecision {
+			return ErrPrecisionInvalid
+		}
+	case FieldTemperature:
+		if c.Unit != nil {
+			if _, ok := temperatureUnits[*c.Unit]; !ok {
+				return ErrUnitInvalid
+			}
+		}
+	case FieldSelect, FieldMultiSelect:
+		if len(c.Options) == 0 {
+			return ErrOptionsInvalid
+		}
+		seen := map[string]bool{}
+		for _, option := range c.Options {
+			if len(option) == 0 || seen[option] {
+				return ErrOptionsInvalid
+			}
+			seen[option] = true
+		}
+	}
+
+	if c.Default != nil {
+		if err := f.checkValue(c.Default); err != nil {
+			return ErrDefaultInvalid
+		}
+	}
+
+	return nil
+}
+
+// number приводит значение из JSON или BSON к float64
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// list приводит значение из JSON или BSON к списку
+func list(value interface{}) ([]interface{}, bool) {
+	return expression.List(value)
+}
+
+// decimalPlaces число знаков после запятой в кратчайшей десятичной записи n
+func decimalPlaces(n float64) int {
+	s := strconv.FormatFloat(n, 'f', -1, 64)
+	if dot := strings.IndexByte(s, '.'); dot != -1 {
+		return len(s) - dot - 1
+	}
+	return 0
+}
+
+// checkValue проверяет значение поля журнала. value не nil
+func (f JournalField) checkValue(value interface{}) error {
+	c := f.Constraints
+	if c == nil {
+		c = &JournalConstraints{}
+	}
+	fail := func(reason string) error {
+		return &FieldError{Field: f.Name, Reason: reason}
+	}
+
+	switch {
+	case numericType(f.Type):
+		n, ok := number(value)
+		if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+			return fail("must be a number")
+		}
+		if f.Type == FieldInteger && n != math.Trunc(n) {
+			return fail("must be an integer")
+		}
+		if f.Type == FieldDecimal && c.Precision != nil && decimalPlaces(n) > *c.Precision {
+			return fail(fmt.Sprintf("must have at most %d digits after the decimal point", *c.Precision))
+		}
+		if f.Type == FieldTemperature {
+			unit := "C"
+			if c.Unit != nil {
+				unit = *c.Unit
+			}
+			if n < temperatureUnits[unit] {
+				return fail("is below absolute zero")
+			}
+		}
+		if c.Min != nil && n < *c.Min {
+			return fail(fmt.Sprintf("must be at least %v", *c.Min))
+		}
+		if c.Max != nil && n > *c.Max {
+			return fail(fmt.Sprintf("must be at most %v", *c.Max))
+		}
+
+	case textType(f.Type):
+		s, ok := value.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if f.Type == FieldBarcode && !barcodePattern.MatchString(s) {
+			return fail("must be printable ASCII without spaces")
+		}
+		length := utf8.RuneCountInString(s)
+		if c.MinLength != nil && length < *c.MinLength {
+			return fail(fmt.Sprintf("must be at least %d characters long", *c.MinLength))
+		}
+		if c.MaxLength != nil && length > *c.MaxLength {
+			return fail(fmt.Sprintf("must be at most %d characters long", *c.MaxLength))
+		}
+		if c.Pattern != nil {
+			if pattern, err := regexp.Compile(*c.Pattern); err != nil || !pattern.MatchString(s) {
+				return fail("does not match pattern " + *c.Pattern)
+			}
+		}
+
+	case f.Type == FieldBoolean:
+		if _, ok := value.(bool); !ok {
+			return fail("must be a boolean")
+		}
+
+	case f.Type == FieldArray:
+		if _, ok := list(value); !ok {
+			return fail("must be an array")
+		}
+
+	case f.Type == FieldSignature:
+		if _, ok := value.(string); !ok {
+			return fail("must be a string")
+		}
+
+	case f.Type == FieldDate, f.Type == FieldTime, f.Type == FieldDateTime:
+		layout := map[string]string{FieldDate: FieldDateFormat, FieldTime: FieldTimeFormat, FieldDateTime: FieldDateTimeFormat}[f.Type]
+		if _, ok := value.(primitive.DateTime); ok && f.Type != FieldTime {
+			return nil
+		}
+		s, ok := value.(string)
+		if !ok {
+			return fail("must be a string in " + layout + " format")
+		}
+		if _, err := time.Parse(layout, s); err != nil {
+			return fail("must be in " + layout + " format")
+		}
+
+	case f.Type == FieldObjectID:
+		if !objectIDValue(value) {
+			return fail("must be an ObjectId")
+		}
+
+	case f.Type == FieldSelect:
+		s, ok := value.(string)
+		if !ok || !CheckIn(s, c.Options) {
+			return fail("must be one of the options")
+		}
+
+	case f.Type == FieldMultiSelect:
+		items, ok := list(value)
+		if !ok {
+			return fail("must be an array of options")
+		}
+		seen := map[string]bool{}
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok || !CheckIn(s, c.Options) || seen[s] {
+				return fail("must be an array of unique options")
+			}
+			seen[s] = true
+		}
+
+	case f.Type == FieldPhoto, f.Type == FieldAttachment:
+		// Вложения загружаются отдельно, в журнале хранятся их идентификаторы
+		if objectIDValue(value) {
+			return nil
+		}
+		items, ok := list(value)
+		if !ok {
+			return fail("must be an attachment id or an array of attachment ids")
+		}
+		for _, item := range items {
+			if !objectIDValue(item) {
+				return fail("must be an attachment id or an array of attachment ids")
+			}
+		}
+	}
+
+	return nil
+}
+
+func objectIDValue(value interface{}) bool {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return true
+	case string:
+		_, err := primitive.ObjectIDFromHex(v)
+		return err == nil
+	}
+	return false
+}
+
+// present значение поля заполнено
+func present(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		return len(v) != 0
+	}
+	if items, ok := list(value); ok {
+		return len(items) != 0
+	}
+	return true
+}
+
+// conditionMet выполнено ли условие, при котором показывается поле: значение управляющего поля заполнено и не false
+func conditionMet(value interface{}) bool {
+	if b, ok := value.(bool); ok {
+		return b
+	}
+	return present(value)
+}
+
+// validateValues вычисляет поля-выражения, проверяет значения журнала по схеме и подставляет значения по умолчанию.
+// ErrJournalSchemeMissing, если схемы журнала нет в базе
+func validateValues(tenant db.Tenant, journal *Journal) error {
+	scheme, err := journalSchemeByName(tenant, journal.Scheme)
+	if err == mongo.ErrNoDocuments {
+		return ErrJournalSchemeMissing
+	}
+	if err != nil {
+		return err
+	}
+
+	if errs, _ := applyFields(journal, scheme.Fields); len(errs) != 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// applyFields подставляет значения по умолчанию, вычисляет поля-выражения и проверяет значения journal по полям схемы.
+// Возвращает ошибки всех полей и имена полей, скрытых условиями If
+func applyFields(journal *Journal, fields []JournalField) ([]error, map[string]bool) {
+	if journal.Values == nil {
+		journal.Values = map[string]interface{}{}
+	}
+
+	for _, field := range fields {
+		if field.Constraints != nil && field.Constraints.Default != nil && !present(journal.Values[field.Name]) {
+			journal.Values[field.Name] = field.Constraints.Default
+		}
+	}
+
+	errs := computeExpressions(journal, fields)
+
+	// Поле, перечисленное в If другого поля, показывается, только когда выполнено условие управляющего поля
+	hidden := map[string]bool{}
+	for _, field := range fields {
+		if field.If != nil && !shown(journal, field) {
+			for _, name := range field.If.Fields {
+				hidden[name] = true
+			}
+		}
+	}
+
+	for _, field := range fields {
+		value, ok := journal.Values[field.Name]
+		if !ok || !present(value) {
+			if field.Constraints != nil && field.Constraints.Required && !hidden[field.Name] {
+				errs = append(errs, &FieldError{Field: field.Name, Reason: "is required"})
+			}
+			continue
+		}
+
+		if err := field.checkValue(value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs, hidden
+}
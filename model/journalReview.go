@@ -0,0 +1,299 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/model/user"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Состояния проверки журнала
+const (
+	ReviewDraft     = "draft"
+	ReviewSubmitted = "submitted"
+	ReviewReviewed  = "reviewed"
+	ReviewApproved  = "approved"
+	ReviewRejected  = "rejected"
+)
+
+// ReviewStates допустимые состояния проверки журнала
+var ReviewStates = []string{ReviewDraft, ReviewSubmitted, ReviewReviewed, ReviewApproved, ReviewRejected}
+
+// reviewLocked состояния, в которых журнал нельзя изменить: он у проверяющего или уже утвержден
+var reviewLocked = []string{ReviewSubmitted, ReviewReviewed, ReviewApproved}
+
+// reviewDecisions состояния, переход в которые - решение проверяющего
+var reviewDecisions = []string{ReviewReviewed, ReviewApproved, ReviewRejected}
+
+// Errors godoc
+var (
+	ErrWorkflowInvalid    = errors.New("workflow transitions must connect different states draft, submitted, reviewed, approved, rejected and name roles or users")
+	ErrReviewNoWorkflow   = errors.New("journal scheme has no review workflow")
+	ErrReviewTransition   = errors.New("transition is not allowed from the current review state for this user")
+	ErrReviewComment      = errors.New("rejection requires a comment")
+	ErrReviewConfirmation = errors.New("transition requires confirmation with X-Confirmation-Token")
+	ErrJournalInReview    = errors.New("journal is in review or approved and cannot be changed")
+)
+
+// WorkflowTransition разрешенный переход между состояниями проверки
+type WorkflowTransition struct {
+	From string `bson:"from" json:"from" example:"submitted"`
+	To   string `bson:"to" json:"to" example:"approved"`
+	// Roles роли, которым доступен переход. Администратору доступны переходы всех ролей, кроме SuperAdmin
+	Roles []user.Role `bson:"roles" json:"roles" example:"1"`
+	// Users если заданы, переход доступен только этим пользователям, например руководителю службы качества
+	Users []string `bson:"users,omitempty" json:"users,omitempty" example:"quality_lead"`
+	// Confirm переход требует повторного ввода учетных данных, токен передается в X-Confirmation-Token
+	Confirm bool `bson:"confirm,omitempty" json:"confirm,omitempty" example:"true"`
+}
+
+// ReviewWorkflow порядок проверки журналов схемы. Новые журналы схемы начинают в draft
+type ReviewWorkflow struct {
+	Transitions []WorkflowTransition `bson:"transitions" json:"transitions"`
+}
+
+// ReviewTransition выполненный переход журнала
+type ReviewTransition struct {
+	From    string    `bson:"from" json:"from" example:"submitted"`
+	To      string    `bson:"to" json:"to" example:"rejected"`
+	By      string    `bson:"by" json:"by" example:"quality_lead"`
+	Role    user.Role `bson:"role" json:"role" example:"1"`
+	At      time.Time `bson:"at" json:"at"`
+	Comment string    `bson:"comment,omitempty" json:"comment,omitempty" example:"Не заполнена поверка за вторник"`
+	// Confirmation подтверждение перехода, если его требует порядок проверки
+	Confirmation *Confirmation `bson:"confirmation,omitempty" json:"confirmation,omitempty"`
+}
+
+// JournalReview состояние проверки журнала
+type JournalReview struct {
+	State string `bson:"state" json:"state" example:"rejected"`
+	// RejectReason комментарий последнего отклонения. Виден контроллеру, пока журнал не утвержден
+	RejectReason string             `bson:"reject_reason,omitempty" json:"reject_reason,omitempty" example:"Не заполнена поверка за вторник"`
+	History      []ReviewTransition `bson:"history,omitempty" json:"history,omitempty"`
+}
+
+// ReviewRequest переход журнала в состояние To
+type ReviewRequest struct {
+	To string `json:"to" binding:"required" example:"approved"`
+	// Comment комментарий к переходу, при отклонении обязателен
+	Comment string `json:"comment,omitempty" example:"Проверено"`
+}
+
+func (w *ReviewWorkflow) validate() error {
+	if w == nil {
+		return nil
+	}
+	if len(w.Transitions) == 0 {
+		return ErrWorkflowInvalid
+	}
+	for _, t := range w.Transitions {
+		if !CheckIn(t.From, ReviewStates) || !CheckIn(t.To, ReviewStates) || t.From == t.To {
+			return ErrWorkflowInvalid
+		}
+		if len(t.Roles) == 0 && len(t.Users) == 0 {
+			return ErrWorkflowInvalid
+		}
+		for _, role := range t.Roles {
+			if role < user.Operator || role > user.SuperAdmin {
+				return ErrWorkflowInvalid
+			}
+		}
+	}
+	return nil
+}
+
+// allows проверяет, что пользователь username с ролью role может выполнить переход
+func (t WorkflowTransition) allows(username string, role user.Role) bool {
+	if len(t.Users) != 0 && !CheckIn(username, t.Users) {
+		return false
+	}
+	if len(t.Roles) == 0 {
+		return true
+	}
+	for _, required := range t.Roles {
+		if role.Grants(required) {
+			return true
+		}
+	}
+	return false
+}
+
+// transition переход from -> to, доступный пользователю username с ролью role
+func (w ReviewWorkflow) transition(from, to, username string, role user.Role) (WorkflowTransition, bool) {
+	for _, t := range w.Transitions {
+		if t.From == from && t.To == to && t.allows(username, role) {
+			return t, true
+		}
+	}
+	return WorkflowTransition{}, false
+}
+
+// reviewState состояние проверки журнала. Журналы, созданные до включения проверки, считаются черновиками
+func (j Journal) reviewState() string {
+	if j.Review == nil {
+		return ReviewDraft
+	}
+	return j.Review.State
+}
+
+// checkReview возвращает ErrJournalInReview, если журнал у проверяющего или утвержден
+func (j Journal) checkReview() error {
+	if j.Review != nil && CheckIn(j.Review.State, reviewLocked) {
+		return ErrJournalInReview
+	}
+	return nil
+}
+
+// newJournalReview начальное состояние проверки нового журнала схемы scheme. nil, если у схемы нет порядка проверки
+func newJournalReview(tenant db.Tenant, scheme string) *JournalReview {
+	journalScheme, err := journalSchemeByName(tenant, scheme)
+	if err != nil || journalScheme.Workflow == nil {
+		return nil
+	}
+	return &JournalReview{State: ReviewDraft}
+}
+
+// ReviewJournal переводит журнал id с версией version в состояние request.To от имени пользователя by с ролью role.
+// scope ограничивает журналы контроллера, nil - любой журнал.
+// Отклонение открывает журнал для исправления, журнал на удержании отклонить нельзя.
+// У закрытого журнала отклонение отменяет последнюю роспись, после исправления журнал закрывается заново
+func ReviewJournal(tenant db.Tenant, scope *OrgScope, id string, version int64, by string, role user.Role, request ReviewRequest, confirmation *Confirmation) (*Journal, error) {
+	journal, err := scopedJournal(tenant, scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, err := journalSchemeByName(tenant, journal.Scheme)
+	if err != nil || scheme.Workflow == nil {
+		return nil, ErrReviewNoWorkflow
+	}
+
+	from := journal.reviewState()
+	t, ok := scheme.Workflow.transition(from, request.To, by, role)
+	if !ok {
+		return nil, ErrReviewTransition
+	}
+	if request.To == ReviewRejected && len(request.Comment) == 0 {
+		return nil, ErrReviewComment
+	}
+	if t.Confirm && confirmation == nil {
+		return nil, ErrReviewConfirmation
+	}
+
+	now := time.Now()
+	set := bson.D{
+		{Key: "review.state", Value: request.To},
+		{Key: "updated_at", Value: now},
+		{Key: "version", Value: version + 1},
+	}
+	filter := bson.D{{Key: "deleted_at", Value: nil}}
+
+	switch request.To {
+	case ReviewRejected:
+		if err := journal.checkHold(); err != nil {
+			return nil, err
+		}
+		filter = append(filter, notHeld())
+		set = append(set,
+			bson.E{Key: "review.reject_reason", Value: request.Comment},
+			bson.E{Key: "status", Value: JournalOpen})
+		if final := journal.finalClosing(); final != -1 {
+			set = append(set, bson.E{Key: "closings." + strconv.Itoa(final) + ".voided", Value: ClosingVoid{
+				By:     by,
+				At:     now,
+				Reason: request.Comment,
+			}})
+		}
+	case ReviewApproved:
+		set = append(set, bson.E{Key: "review.reject_reason", Value: ""})
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$push", Value: bson.D{{Key: "review.history", Value: ReviewTransition{
+			From:         from,
+			To:           request.To,
+			By:           by,
+			Role:         role,
+			At:           now,
+			Comment:      request.Comment,
+			Confirmation: confirmation,
+		}}}},
+	}
+	if err := updateVersioned(journalCollection(tenant), journal.ID, version, filter, update); err != nil {
+		return nil, err
+	}
+
+	return JournalOne(tenant, id)
+}
+
+// awaitingFilter условие на журналы, в которых пользователь username с ролью role может принять решение:
+// проверить, утвердить или отклонить. Пустой bson.A, если таких схем нет
+func awaitingFilter(tenant db.Tenant, username string, role user.Role) (bson.A, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "deleted_at", Value: nil}, {Key: "workflow", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	cur, err := JournalSchemeCollection(tenant).Find(timeout, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	awaiting := bson.A{}
+	for cur.Next(timeout) {
+		var scheme struct {
+			Name     string         `bson:"name"`
+			Workflow ReviewWorkflow `bson:"workflow"`
+		}
+		if err := cur.Decode(&scheme); err != nil {
+			return nil, err
+		}
+
+		states := []string{}
+		for _, t := range scheme.Workflow.Transitions {
+			if CheckIn(t.To, reviewDecisions) && t.allows(username, role) && !CheckIn(t.From, states) {
+				states = append(states, t.From)
+			}
+		}
+		if len(states) == 0 {
+			continue
+		}
+
+		state := bson.E{Key: "review.state", Value: bson.D{{Key: "$in", Value: states}}}
+		if CheckIn(ReviewDraft, states) {
+			// Журналы, созданные до включения проверки, считаются черновиками
+			state = bson.E{Key: "$or", Value: bson.A{bson.D{state}, bson.D{{Key: "review", Value: nil}}}}
+		}
+		awaiting = append(awaiting, bson.D{{Key: "scheme", Value: scheme.Name}, state})
+	}
+	return awaiting, cur.Err()
+}
+
+// JournalsAwaitingReview журналы, которые ждут решения пользователя username с ролью role.
+// scope ограничивает журналы позициями и схемами узлов контроллера, nil - все журналы
+func JournalsAwaitingReview(tenant db.Tenant, scope *OrgScope, username string, role user.Role, query db.Query) (db.Page, error) {
+	awaiting, err := awaitingFilter(tenant, username, role)
+	if err != nil {
+		return db.Page{}, err
+	}
+	if len(awaiting) == 0 {
+		// Ни одного состояния не ждет решения пользователя: условие, которому не подходит ни один журнал
+		awaiting = bson.A{bson.D{{Key: "_id", Value: nil}}}
+	}
+
+	filter := bson.D{
+		{Key: "deleted_at", Value: nil},
+		{Key: "$and", Value: bson.A{bson.D{{Key: "$or", Value: awaiting}}}},
+	}
+	if scope != nil {
+		filter = append(filter, scope.filter())
+	}
+
+	var list []Journal
+	return db.FindPage(journalCollection(tenant), filter, journalFields, query, nil, &list)
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/Oxynger/JournalApp/model/user"
+)
+
+func TestReviewWorkflowValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		workflow *ReviewWorkflow
+		want     error
+	}{
+		{"no workflow", nil, nil},
+		{"no transitions", &ReviewWorkflow{}, ErrWorkflowInvalid},
+		{
+			"valid",
+			&ReviewWorkflow{Transitions: []WorkflowTransition{
+				{From: ReviewDraft, To: ReviewSubmitted, Roles: []user.Role{user.Operator}},
+				{From: ReviewSubmitted, To: ReviewApproved, Users: []string{"quality_lead"}},
+			}},
+			nil,
+		},
+		{
+			"unknown state",
+			&ReviewWorkflow{Transitions: []WorkflowTransition{{From: ReviewDraft, To: "done", Roles: []user.Role{user.Operator}}}},
+			ErrWorkflowInvalid,
+		},
+		{
+			"same state",
+			&ReviewWorkflow{Transitions: []WorkflowTransition{{From: ReviewDraft, To: ReviewDraft, Roles: []user.Role{user.Operator}}}},
+			ErrWorkflowInvalid,
+		},
+		{
+			"nobody allowed",
+			&ReviewWorkflow{Transitions: []WorkflowTransition{{From: ReviewDraft, To: ReviewSubmitted}}},
+			ErrWorkflowInvalid,
+		},
+		{
+			"unknown role",
+			&ReviewWorkflow{Transitions: []WorkflowTransition{{From: ReviewDraft, To: ReviewSubmitted, Roles: []user.Role{user.SuperAdmin + 1}}}},
+			ErrWorkflowInvalid,
+		},
+	}
+	for _, test := range tests {
+		if got := test.workflow.validate(); got != test.want {
+			t.Errorf("%s: validate = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReviewWorkflowTransition(t *testing.T) {
+	workflow := ReviewWorkflow{Transitions: []WorkflowTransition{
+		{From: ReviewDraft, To: ReviewSubmitted, Roles: []user.Role{user.Operator}},
+		{From: ReviewSubmitted, To: ReviewReviewed, Roles: []user.Role{user.Helpdesk}},
+		{From: ReviewReviewed, To: ReviewApproved, Users: []string{"quality_lead"}, Confirm: true},
+		{From: ReviewReviewed, To: ReviewRejected, Roles: []user.Role{user.Helpdesk}, Users: []string{"quality_lead"}},
+	}}
+
+	tests := []struct {
+		name     string
+		from, to string
+		username string
+		role     user.Role
+		want     bool
+	}{
+		{"operator submits", ReviewDraft, ReviewSubmitted, "ivanov", user.Operator, true},
+		{"operator cannot review", ReviewSubmitted, ReviewReviewed, "ivanov", user.Operator, false},
+		{"reviewer reviews", ReviewSubmitted, ReviewReviewed, "petrov", user.Helpdesk, true},
+		{"administrator takes any role", ReviewSubmitted, ReviewReviewed, "admin", user.Administrator, true},
+		{"no transition between states", ReviewDraft, ReviewApproved, "admin", user.SuperAdmin, false},
+		{"named user approves", ReviewReviewed, ReviewApproved, "quality_lead", user.Operator, true},
+		{"other user cannot approve", ReviewReviewed, ReviewApproved, "admin", user.Administrator, false},
+		{"user and role both required", ReviewReviewed, ReviewRejected, "quality_lead", user.Operator, false},
+		{"user with role rejects", ReviewReviewed, ReviewRejected, "quality_lead", user.Helpdesk, true},
+	}
+	for _, test := range tests {
+		if _, got := workflow.transition(test.from, test.to, test.username, test.role); got != test.want {
+			t.Errorf("%s: transition(%s, %s) = %v, want %v", test.name, test.from, test.to, got, test.want)
+		}
+	}
+
+	if transition, _ := workflow.transition(ReviewReviewed, ReviewApproved, "quality_lead", user.Operator); !transition.Confirm {
+		t.Error("approval transition lost its confirmation flag")
+	}
+}
+
+func TestJournalReviewState(t *testing.T) {
+	tests := []struct {
+		name    string
+		journal Journal
+		state   string
+		locked  bool
+	}{
+		{"created before review", Journal{}, ReviewDraft, false},
+		{"draft", Journal{Review: &JournalReview{State: ReviewDraft}}, ReviewDraft, false},
+		{"submitted", Journal{Review: &JournalReview{State: ReviewSubmitted}}, ReviewSubmitted, true},
+		{"reviewed", Journal{Review: &JournalReview{State: ReviewReviewed}}, ReviewReviewed, true},
+		{"approved", Journal{Review: &JournalReview{State: ReviewApproved}}, ReviewApproved, true},
+		{"rejected", Journal{Review: &JournalReview{State: ReviewRejected}}, ReviewRejected, false},
+	}
+	for _, test := range tests {
+		if got := test.journal.reviewState(); got != test.state {
+			t.Errorf("%s: reviewState = %s, want %s", test.name, got, test.state)
+		}
+		if err := test.journal.checkReview(); (err == ErrJournalInReview) != test.locked {
+			t.Errorf("%s: checkReview = %v, locked %v", test.name, err, test.locked)
+		}
+	}
+}
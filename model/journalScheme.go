@@ -1,16 +1,14 @@
 package model
 
 import (
-	"strconv"
 	"context"
 	"errors"
 	"log"
+	"time"
 
 	"github.com/Oxynger/JournalApp/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 //Errors godoc
@@ -29,12 +27,14 @@ var (
 	ErrIfInvalid = errors.New("error in if field")
 	ErrComputedTypeInvalid = errors.New("Computed Type isn't exits")
 	ErrTypeInvalid = errors.New("Type isn't exits")
-	ErrNegativeParam = errors.New("param is negative")
 )
 
 // JournalIf godoc
 type JournalIf struct {
 	Fields []string `bson:"fields" json:"fields" example:["result", "value"]` //Проверяемые поля
+
+	// Condition выражение: поля Fields показываются, только когда оно истинно. Пустое - когда поле заполнено
+	Condition *string `bson:"condition,omitempty" json:"condition,omitempty" example:"result == false"`
 }
 
 // JournalComputed godoc
@@ -68,6 +68,10 @@ type JournalComputed struct {
 	// значение не принадлежит данному массиву, т || len(s.Fields[i].Computed.Field) == 0
 	// check = false
 	Enum *[]string `bson:"enum,omitempty" json:"enum,omitempty" example:""`
+
+	// Expression Если type expression. Значение поля вычисляется сервером при записи журнала,
+	// см. пакет model/expression
+	Expression *string `bson:"expression,omitempty" json:"expression,omitempty" example:"abs(weight - item.giri_w) <= item.norm_deviation"`
 }
 
 // JournalField godoc
@@ -81,6 +85,9 @@ type JournalField struct {
 
 	// If условие при котором будут отображаться дополнительные поля
 	If *JournalIf `bson:"if,omitempty" json:"if,omitempty"`
+
+	// Constraints ограничения на значение поля. Проверяются при записи журнала
+	Constraints *JournalConstraints `bson:"constraints,omitempty" json:"constraints,omitempty"`
 }
 
 // JournalScheme godoc
@@ -93,7 +100,13 @@ type JournalScheme struct {
 	Item     string             `bson:"item" json:"item" example:"scale"`
 	ItemInfo *[]string          `bson:"item_info" json:"item_info" example:"["name", "min_w", "max_w", "giri_w", "norm_deviation"]"`
 	Fields   []JournalField     `bson:"fields" json:"fields"`
-	Deleted  bool               `bson:"deleted" json:"-"`
+	// Retention сроки хранения журналов схемы. nil - хранятся бессрочно
+	Retention *RetentionPolicy `bson:"retention,omitempty" json:"retention,omitempty"`
+	// Workflow порядок проверки журналов схемы. nil - журналы не проверяются
+	Workflow  *ReviewWorkflow `bson:"workflow,omitempty" json:"workflow,omitempty"`
+	DeletedAt *time.Time      `bson:"deleted_at" json:"-"`
+	DeletedBy string          `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64           `bson:"version" json:"version" example:"1"`
 }
 
 // NewJournalScheme godoc
@@ -105,7 +118,13 @@ type NewJournalScheme struct {
 	Item     string         `bson:"item" json:"item" example:"scale"`
 	ItemInfo *[]string      `bson:"item_info" json:"item_info" example:"["name", "min_w", "max_w", "giri_w", "norm_deviation"]"`
 	Fields   []JournalField `bson:"fields" json:"fields"`
-	Deleted  bool           `bson:"deleted" json:"-"`
+	// Retention сроки хранения журналов схемы. nil - хранятся бессрочно
+	Retention *RetentionPolicy `bson:"retention,omitempty" json:"retention,omitempty"`
+	// Workflow порядок проверки журналов схемы. nil - журналы не проверяются
+	Workflow  *ReviewWorkflow `bson:"workflow,omitempty" json:"workflow,omitempty"`
+	DeletedAt *time.Time      `bson:"deleted_at" json:"-"`
+	DeletedBy string          `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64           `bson:"version" json:"-"`
 }
 
 // UpdateJournalScheme godoc
@@ -117,71 +136,48 @@ type UpdateJournalScheme struct {
 	Item     string         `bson:"item" json:"item" example:"scale"`
 	ItemInfo *[]string      `bson:"item_info" json:"item_info" example:"["name", "min_w", "max_w", "giri_w", "norm_deviation"]"`
 	Fields   []JournalField `bson:"fields" json:"fields"`
-	Deleted  bool           `bson:"deleted" json:"-"`
+	// Retention сроки хранения журналов схемы. nil - хранятся бессрочно, прежняя политика снимается
+	Retention *RetentionPolicy `bson:"retention" json:"retention,omitempty"`
+	// Workflow порядок проверки журналов схемы. nil - журналы не проверяются, прежний порядок снимается
+	Workflow  *ReviewWorkflow `bson:"workflow" json:"workflow,omitempty"`
+	DeletedAt *time.Time      `bson:"deleted_at" json:"-"`
+	DeletedBy string          `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64           `bson:"version" json:"-"`
 }
 
 // JournalSchemeCollection godoc
-func JournalSchemeCollection() *mongo.Collection {
-	client := db.Client()
-	coll := client.Database("test").Collection("journalScheme")
-
-	return coll
+func JournalSchemeCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("journalScheme")
 }
 
-//JournalSchemeAll get list Journak schemes godoc
-func JournalSchemeAll(offset string, limit string) ([]JournalScheme, error) {
-	offsetInt, err := strconv.ParseInt(offset, 10, 64)
-	if err != nil{
-		return nil, err
-	}
-	if offsetInt < 0{
-		return nil, ErrNegativeParam
-	} 
-	limitInt, err := strconv.ParseInt(limit, 10, 64)
-	if err != nil{
-		return nil, err
-	}
-	if limitInt < 0{
-		return nil, ErrNegativeParam
-	} 
-
-	options := options.Find()
-	options.SetLimit(limitInt)
-	options.SetSkip(offsetInt)
+// journalSchemeFields поля, доступные в фильтрах и сортировке списка схем
+var journalSchemeFields = db.Fields{
+	"name":  {Key: "name", Type: db.StringField, Sort: true},
+	"title": {Key: "title", Type: db.StringField, Sort: true},
+	"item":  {Key: "item", Type: db.StringField, Sort: true},
+}
 
-	cur, err := JournalSchemeCollection().Find(context.Background(), bson.D{{"deleted", false}}, options)
+//JournalSchemeAll get list journal schemes godoc
+func JournalSchemeAll(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var listSchemes []JournalScheme
+	page, err := db.FindPage(JournalSchemeCollection(tenant), bson.D{{Key: "deleted_at", Value: nil}}, journalSchemeFields, query, nil, &listSchemes)
 	if err != nil {
 		log.Println(err)
-		return nil, err
-	}
-	defer cur.Close(context.Background())
-	listSchemes := []JournalScheme{}
-
-	for cur.Next(context.Background()) {
-		row := new(JournalScheme)
-		err := cur.Decode(&row)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		listSchemes = append(listSchemes, *row)
-	}
-	if err := cur.Err(); err != nil {
-		return nil, err
+		return db.Page{}, err
 	}
 
-	return listSchemes, err
+	return page, err
 }
 
 //JournalSchemeOne get list journal schemes with id godoc
-func JournalSchemeOne(id string) (JournalScheme, error) {
+func JournalSchemeOne(tenant db.Tenant, id string) (JournalScheme, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Println(err)
 		return JournalScheme{}, err
 	}
 	row := new(JournalScheme)
-	err = JournalSchemeCollection().FindOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted", false}}}}}).Decode(&row)
+	err = JournalSchemeCollection(tenant).FindOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted_at", nil}}}}}).Decode(&row)
 	if err != nil {
 		log.Println(err)
 		return JournalScheme{}, err
@@ -191,8 +187,9 @@ func JournalSchemeOne(id string) (JournalScheme, error) {
 }
 
 // Insert godoc
-func (s NewJournalScheme) Insert() error {
-	insertResault, err := JournalSchemeCollection().InsertOne(context.Background(), s)
+func (s NewJournalScheme) Insert(tenant db.Tenant) error {
+	s.Version = 1
+	insertResault, err := JournalSchemeCollection(tenant).InsertOne(context.Background(), s)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -203,8 +200,7 @@ func (s NewJournalScheme) Insert() error {
 
 // Validation godoc
 func (s NewJournalScheme) Validation() error {
-	ComputedTypes := []string{"deviation","range","equals","less","more","more_than"}
-	Types := []string{"Integer", "Dooble", "String", "Boolean", "Array", "Signature", "Date", "ObjectId"}
+	ComputedTypes := []string{"deviation","range","equals","less","more","more_than",ComputedExpression}
 	switch {
 	case len(s.Name) == 0:
 		return ErrNameInvalid
@@ -218,8 +214,12 @@ func (s NewJournalScheme) Validation() error {
 		return ErrItemInvalid
 	case s.ItemInfo == nil:
 		return ErrItemInfoInvalid
-	case s.Deleted == true:
+	case s.DeletedAt != nil:
 		return ErrDeletedInvalid
+	case s.Retention.validate() != nil:
+		return ErrRetentionInvalid
+	case s.Workflow.validate() != nil:
+		return ErrWorkflowInvalid
 	case s.Fields == nil:
 		return ErrFieldsInvalid
 	case s.Fields != nil:
@@ -231,14 +231,12 @@ func (s NewJournalScheme) Validation() error {
 					return ErrFieldsTitleInvalid
 				case len(s.Fields[i].Type) == 0:
 					return ErrFieldsTypeInvalid
-				case !(CheckIn(s.Fields[i].Type, Types)):
-					return ErrTypeInvalid
 				case s.Fields[i].Computed != nil || s.Fields[i].If != nil:
 					if s.Fields[i].Computed != nil {
 						switch {
 						case len(s.Fields[i].Computed.Type) == 0:
 							return ErrComputedInvalid
-						case len(s.Fields[i].Computed.Field) == 0:
+						case len(s.Fields[i].Computed.Field) == 0 && s.Fields[i].Computed.Type != ComputedExpression:
 							return ErrComputedInvalid
 						case s.Fields[i].Computed.Type == "deviation" && (s.Fields[i].Computed.Deviation == nil || s.Fields[i].Computed.Norm == nil):
 							return ErrDeviatonTypeInvalid
@@ -252,6 +250,8 @@ func (s NewJournalScheme) Validation() error {
 							return ErrMoreTypeInvalid
 						case s.Fields[i].Computed.Type == "more_than" && (s.Fields[i].Computed.ID == nil || s.Fields[i].Computed.On == nil):
 							return ErrMore_ThanTypeInvalid
+						case s.Fields[i].Computed.Type == ComputedExpression && s.Fields[i].Computed.Expression == nil:
+							return ErrExpressionInvalid
 						case !(CheckIn(s.Fields[i].Computed.Type, ComputedTypes)):
 							return ErrComputedTypeInvalid
 						} 
@@ -269,33 +269,36 @@ func (s NewJournalScheme) Validation() error {
 						
 					}
 			}
+			if err := s.Fields[i].validate(); err != nil {
+				return err
+			}
 		}
-		return nil
+		return validateExpressions(s.Fields, s.ItemInfo)
 	default:
 		return nil
 	}
 }
 
 // Update godoc
-func (s UpdateJournalScheme) Update(id string) error {
+func (s UpdateJournalScheme) Update(tenant db.Tenant, id string, version int64) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	updateResault, err := JournalSchemeCollection().UpdateOne(context.Background(), bson.D{{"_id", objectID}}, bson.D{{"$set", s}})
+	s.Version = version + 1
+	err = updateVersioned(JournalSchemeCollection(tenant), objectID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: s}})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	log.Println("updated documents: ", updateResault.UpsertedID)
+	log.Println("updated documents: ", objectID)
 	return err
 }
 
 // Validation godoc
 func (s UpdateJournalScheme) Validation() error {
-	ComputedTypes := []string{"deviation","range","equals","less","more","more_than"}
-	Types := []string{"Integer", "Dooble", "String", "Boolean", "Array", "Signature", "Date", "ObjectId"}
+	ComputedTypes := []string{"deviation","range","equals","less","more","more_than",ComputedExpression}
 	switch {
 	case len(s.Name) == 0:
 		return ErrNameInvalid
@@ -309,8 +312,12 @@ func (s UpdateJournalScheme) Validation() error {
 		return ErrItemInvalid
 	case s.ItemInfo == nil:
 		return ErrItemInfoInvalid
-	case s.Deleted == true:
+	case s.DeletedAt != nil:
 		return ErrDeletedInvalid
+	case s.Retention.validate() != nil:
+		return ErrRetentionInvalid
+	case s.Workflow.validate() != nil:
+		return ErrWorkflowInvalid
 	case s.Fields == nil:
 		return ErrFieldsInvalid
 	case s.Fields != nil:
@@ -322,14 +329,12 @@ func (s UpdateJournalScheme) Validation() error {
 					return ErrFieldsTitleInvalid
 				case len(s.Fields[i].Type) == 0:
 					return ErrFieldsTypeInvalid
-				case !(CheckIn(s.Fields[i].Type, Types)):
-					return ErrTypeInvalid
 				case s.Fields[i].Computed != nil || s.Fields[i].If != nil:
 					if s.Fields[i].Computed != nil {
 						switch {
 						case len(s.Fields[i].Computed.Type) == 0:
 							return ErrComputedInvalid
-						case len(s.Fields[i].Computed.Field) == 0:
+						case len(s.Fields[i].Computed.Field) == 0 && s.Fields[i].Computed.Type != ComputedExpression:
 							return ErrComputedInvalid
 						case s.Fields[i].Computed.Type == "deviation" && (s.Fields[i].Computed.Deviation == nil || s.Fields[i].Computed.Norm == nil):
 							return ErrDeviatonTypeInvalid
@@ -343,6 +348,8 @@ func (s UpdateJournalScheme) Validation() error {
 							return ErrMoreTypeInvalid
 						case s.Fields[i].Computed.Type == "more_than" && (s.Fields[i].Computed.ID == nil || s.Fields[i].Computed.On == nil):
 							return ErrMore_ThanTypeInvalid
+						case s.Fields[i].Computed.Type == ComputedExpression && s.Fields[i].Computed.Expression == nil:
+							return ErrExpressionInvalid
 						case !(CheckIn(s.Fields[i].Computed.Type, ComputedTypes)):
 							return ErrComputedTypeInvalid
 						} 
@@ -360,25 +367,24 @@ func (s UpdateJournalScheme) Validation() error {
 						
 					}
 			}
+			if err := s.Fields[i].validate(); err != nil {
+				return err
+			}
 		}
-		return nil
+		return validateExpressions(s.Fields, s.ItemInfo)
 	default:
 		return nil
 	}
 }
 
 // DeleteJournalSchemeOne godoc
-func DeleteJournalSchemeOne(id string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	updateResault, err := JournalSchemeCollection().UpdateOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted", false}}}}}, bson.D{{"$set", bson.D{{"deleted", true}}}})
+// Схему, на которую ссылаются другие схемы, можно удалить только вместе с ними (cascade). by - кто удаляет
+func DeleteJournalSchemeOne(tenant db.Tenant, id string, version int64, by string, cascade bool) error {
+	err := deleteWithDependents(tenant, JournalSchemeCollection(tenant), id, version, by, journalSchemeDependents, cascade)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	log.Println("deleted documents: ", updateResault.UpsertedID)
+	log.Println("deleted documents: ", id)
 	return err
 }
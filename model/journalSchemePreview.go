@@ -0,0 +1,77 @@
+package model
+
+// SchemePreview несохраненная схема журнала с примером информации о позиции и значений
+type SchemePreview struct {
+	Scheme   NewJournalScheme       `json:"scheme" binding:"required"`
+	ItemInfo map[string]interface{} `json:"item_info"`
+	Values   map[string]interface{} `json:"values"`
+}
+
+// PreviewField поле схемы в том виде, в котором его увидит контроллер
+type PreviewField struct {
+	Name  string `json:"name" example:"result"`
+	Title string `json:"title" example:"Результат"`
+	Type  string `json:"type" example:"Boolean"`
+	// Shown false, если поле скрыто условием If другого поля
+	Shown bool `json:"shown" example:"true"`
+	// Computed значение поля вычисляется сервером
+	Computed bool        `json:"computed" example:"true"`
+	Value    interface{} `json:"value,omitempty"`
+	Errors   []string    `json:"errors,omitempty"`
+}
+
+// SchemePreviewResult результат предпросмотра схемы
+type SchemePreviewResult struct {
+	// Valid схему можно сохранить, а пример значений прошел бы проверку при записи журнала
+	Valid bool `json:"valid" example:"false"`
+	// SchemeError ошибка, из-за которой схему нельзя сохранить
+	SchemeError string         `json:"scheme_error,omitempty" example:"field result: expression references unknown field weight"`
+	Fields      []PreviewField `json:"fields"`
+	// Values значения после подстановки значений по умолчанию и вычисления выражений
+	Values map[string]interface{} `json:"values"`
+	// Errors ошибки, которые не относятся к конкретному полю
+	Errors []string `json:"errors,omitempty"`
+}
+
+// PreviewJournalScheme проверяет схему и применяет ее к примеру значений так же, как при записи журнала.
+// Ничего не сохраняет и не читает из базы
+func PreviewJournalScheme(preview SchemePreview) SchemePreviewResult {
+	result := SchemePreviewResult{Fields: []PreviewField{}}
+
+	if err := preview.Scheme.Validation(); err != nil {
+		result.SchemeError = err.Error()
+	}
+
+	values := map[string]interface{}{}
+	for name, value := range preview.Values {
+		values[name] = value
+	}
+	journal := Journal{Scheme: preview.Scheme.Name, Values: values, ItemInfo: preview.ItemInfo}
+
+	errs, hidden := applyFields(&journal, preview.Scheme.Fields)
+
+	fieldErrors := map[string][]string{}
+	for _, err := range errs {
+		if fieldErr, ok := err.(*FieldError); ok {
+			fieldErrors[fieldErr.Field] = append(fieldErrors[fieldErr.Field], fieldErr.Reason)
+			continue
+		}
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	for _, field := range preview.Scheme.Fields {
+		result.Fields = append(result.Fields, PreviewField{
+			Name:     field.Name,
+			Title:    field.Title,
+			Type:     field.Type,
+			Shown:    !hidden[field.Name],
+			Computed: field.computedExpression() != nil,
+			Value:    journal.Values[field.Name],
+			Errors:   fieldErrors[field.Name],
+		})
+	}
+
+	result.Values = journal.Values
+	result.Valid = len(result.SchemeError) == 0 && len(errs) == 0
+	return result
+}
@@ -0,0 +1,309 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Действия журнала аудита удержаний
+const (
+	LegalHoldPlaced   = "placed"
+	LegalHoldReleased = "released"
+)
+
+// Errors godoc
+var (
+	ErrLegalHold          = errors.New("journal is under legal hold")
+	ErrLegalHoldCriteria  = errors.New("legal hold must match by scheme, item, date range or journals")
+	ErrLegalHoldRange     = errors.New("legal hold from must not be after to")
+	ErrLegalHoldReleased  = errors.New("legal hold is already released")
+	ErrLegalHoldNoJournal = errors.New("legal hold journal id is invalid")
+)
+
+// NewLegalHold условия удержания. Условия объединяются через И
+type NewLegalHold struct {
+	// Reason основание удержания: номер расследования, предписание
+	Reason   string     `bson:"reason" json:"reason" binding:"required" example:"Расследование 2019-14"`
+	Scheme   string     `bson:"scheme,omitempty" json:"scheme,omitempty" example:"scales_calibration"`
+	Item     string     `bson:"item,omitempty" json:"item,omitempty" example:"scale"`
+	From     *time.Time `bson:"from,omitempty" json:"from,omitempty"`
+	To       *time.Time `bson:"to,omitempty" json:"to,omitempty"`
+	Journals []string   `bson:"-" json:"journals,omitempty"`
+}
+
+// LegalHold удержание журналов. Пока оно не снято, журналы, попавшие под него при установке,
+// нельзя изменить, удалить или удалить окончательно, в том числе по срокам хранения
+type LegalHold struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	db.Model `bson:",inline"`
+	Reason   string               `bson:"reason" json:"reason" example:"Расследование 2019-14"`
+	Scheme   string               `bson:"scheme,omitempty" json:"scheme,omitempty" example:"scales_calibration"`
+	Item     string               `bson:"item,omitempty" json:"item,omitempty" example:"scale"`
+	From     *time.Time           `bson:"from,omitempty" json:"from,omitempty"`
+	To       *time.Time           `bson:"to,omitempty" json:"to,omitempty"`
+	Journals []primitive.ObjectID `bson:"journals,omitempty" json:"journals,omitempty"`
+
+	// Matched сколько журналов попало под удержание
+	Matched  int64     `bson:"matched" json:"matched" example:"42"`
+	PlacedBy string    `bson:"placed_by" json:"placed_by" example:"admin"`
+	PlacedAt time.Time `bson:"placed_at" json:"placed_at"`
+
+	ReleasedBy    string     `bson:"released_by,omitempty" json:"released_by,omitempty" example:"admin"`
+	ReleasedAt    *time.Time `bson:"released_at" json:"released_at"`
+	ReleaseReason string     `bson:"release_reason,omitempty" json:"release_reason,omitempty" example:"Расследование закрыто"`
+}
+
+// LegalHoldRelease снятие удержания
+type LegalHoldRelease struct {
+	Reason string `json:"reason" binding:"required" example:"Расследование закрыто"`
+}
+
+// LegalHoldEvent запись журнала аудита удержаний. Записи только добавляются
+type LegalHoldEvent struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Hold   primitive.ObjectID `bson:"hold" json:"hold" example:"5ca10d9d015c736a72b7b3b9"`
+	Action string             `bson:"action" json:"action" example:"placed"`
+	By     string             `bson:"by" json:"by" example:"admin"`
+	At     time.Time          `bson:"at" json:"at"`
+	Reason string             `bson:"reason" json:"reason" example:"Расследование 2019-14"`
+	// Journals сколько журналов было поставлено на удержание или снято с него
+	Journals int64 `bson:"journals" json:"journals" example:"42"`
+}
+
+// legalHoldFields поля, доступные в фильтрах и сортировке списка удержаний
+var legalHoldFields = db.Fields{
+	"scheme":      {Key: "scheme", Type: db.StringField, Sort: true},
+	"item":        {Key: "item", Type: db.StringField, Sort: true},
+	"placed_by":   {Key: "placed_by", Type: db.StringField, Sort: true},
+	"placed_at":   {Key: "placed_at", Type: db.TimeField, Sort: true},
+	"released_at": {Key: "released_at", Type: db.TimeField, Sort: true},
+}
+
+// legalHoldEventFields поля, доступные в фильтрах и сортировке журнала аудита удержаний
+var legalHoldEventFields = db.Fields{
+	"hold":   {Key: "hold", Type: db.ObjectIDField},
+	"action": {Key: "action", Type: db.StringField, Sort: true},
+	"by":     {Key: "by", Type: db.StringField, Sort: true},
+	"at":     {Key: "at", Type: db.TimeField, Sort: true},
+}
+
+func legalHoldCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("legalHold")
+}
+
+func legalHoldEventCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("legalHoldEvent")
+}
+
+// held отбор документов, которые находятся хотя бы под одним удержанием
+func held() bson.E {
+	return bson.E{Key: "legal_holds.0", Value: bson.D{{Key: "$exists", Value: true}}}
+}
+
+// notHeld отбор документов, которые не находятся на удержании и могут быть изменены или удалены окончательно
+func notHeld() bson.E {
+	return bson.E{Key: "legal_holds.0", Value: bson.D{{Key: "$exists", Value: false}}}
+}
+
+// checkHold возвращает ErrLegalHold, если журнал находится на удержании
+func (j Journal) checkHold() error {
+	if len(j.LegalHolds) != 0 {
+		return ErrLegalHold
+	}
+	return nil
+}
+
+// filter отбор журналов удержания, в том числе удаленных в корзину
+func (h LegalHold) filter() bson.D {
+	filter := bson.D{}
+	if len(h.Scheme) != 0 {
+		filter = append(filter, bson.E{Key: "scheme", Value: h.Scheme})
+	}
+	if len(h.Item) != 0 {
+		filter = append(filter, bson.E{Key: "item", Value: h.Item})
+	}
+	if h.From != nil || h.To != nil {
+		date := bson.D{}
+		if h.From != nil {
+			date = append(date, bson.E{Key: "$gte", Value: *h.From})
+		}
+		if h.To != nil {
+			date = append(date, bson.E{Key: "$lte", Value: *h.To})
+		}
+		filter = append(filter, bson.E{Key: "date", Value: date})
+	}
+	if len(h.Journals) != 0 {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: h.Journals}}})
+	}
+	return filter
+}
+
+// validate проверяет, что удержание ограничено хотя бы одним условием и период задан верно
+func (h LegalHold) validate() error {
+	if len(h.filter()) == 0 {
+		return ErrLegalHoldCriteria
+	}
+	if h.From != nil && h.To != nil && h.From.After(*h.To) {
+		return ErrLegalHoldRange
+	}
+	return nil
+}
+
+func addLegalHoldEvent(tenant db.Tenant, event LegalHoldEvent) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := legalHoldEventCollection(tenant).InsertOne(timeout, event)
+	return err
+}
+
+// LegalHoldsAll удержания площадки, действующие и снятые
+func LegalHoldsAll(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var list []LegalHold
+	return db.FindPage(legalHoldCollection(tenant), bson.D{}, legalHoldFields, query, nil, &list)
+}
+
+// LegalHoldOne удержание id
+func LegalHoldOne(tenant db.Tenant, id string) (*LegalHold, error) {
+	holdID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var hold LegalHold
+	if err := legalHoldCollection(tenant).FindOne(timeout, bson.D{{Key: "_id", Value: holdID}}).Decode(&hold); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// LegalHoldEvents журнал аудита удержаний
+func LegalHoldEvents(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var list []LegalHoldEvent
+	return db.FindPage(legalHoldEventCollection(tenant), bson.D{}, legalHoldEventFields, query, nil, &list)
+}
+
+// PlaceLegalHold ставит на удержание журналы, которые подходят под условия, от имени пользователя by.
+// Журналы, созданные после установки, под удержание не попадают
+func PlaceLegalHold(tenant db.Tenant, newHold NewLegalHold, by string) (*LegalHold, error) {
+	now := time.Now()
+	hold := LegalHold{
+		Reason:   newHold.Reason,
+		Scheme:   newHold.Scheme,
+		Item:     newHold.Item,
+		From:     newHold.From,
+		To:       newHold.To,
+		PlacedBy: by,
+		PlacedAt: now,
+	}
+	for _, id := range newHold.Journals {
+		journalID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, ErrLegalHoldNoJournal
+		}
+		hold.Journals = append(hold.Journals, journalID)
+	}
+
+	if err := hold.validate(); err != nil {
+		return nil, err
+	}
+
+	hold.CreatedAt = now
+	hold.UpdatedAt = now
+	hold.Version = 1
+
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	insertedResault, err := legalHoldCollection(tenant).InsertOne(timeout, hold)
+	if err != nil {
+		return nil, err
+	}
+	hold.ID = insertedResault.InsertedID.(primitive.ObjectID)
+
+	result, err := journalCollection(tenant).UpdateMany(timeout, hold.filter(),
+		bson.D{{Key: "$addToSet", Value: bson.D{{Key: "legal_holds", Value: hold.ID}}}})
+	if err != nil {
+		return nil, err
+	}
+	hold.Matched = result.MatchedCount
+
+	if _, err := legalHoldCollection(tenant).UpdateOne(timeout, bson.D{{Key: "_id", Value: hold.ID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "matched", Value: hold.Matched}}}}); err != nil {
+		return nil, err
+	}
+
+	err = addLegalHoldEvent(tenant, LegalHoldEvent{
+		Hold:     hold.ID,
+		Action:   LegalHoldPlaced,
+		By:       by,
+		At:       now,
+		Reason:   hold.Reason,
+		Journals: hold.Matched,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &hold, nil
+}
+
+// ReleaseLegalHold снимает удержание id с версией version от имени пользователя by.
+// Журналы, которые остаются под другими удержаниями, по-прежнему защищены
+func ReleaseLegalHold(tenant db.Tenant, id string, version int64, by string, release LegalHoldRelease) (*LegalHold, error) {
+	hold, err := LegalHoldOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if hold.ReleasedAt != nil {
+		return nil, ErrLegalHoldReleased
+	}
+
+	now := time.Now()
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "released_by", Value: by},
+		{Key: "released_at", Value: now},
+		{Key: "release_reason", Value: release.Reason},
+		{Key: "updated_at", Value: now},
+		{Key: "version", Value: version + 1},
+	}}}
+	if err := updateVersioned(legalHoldCollection(tenant), hold.ID, version, bson.D{{Key: "released_at", Value: nil}}, update); err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	result, err := journalCollection(tenant).UpdateMany(timeout, bson.D{{Key: "legal_holds", Value: hold.ID}},
+		bson.D{{Key: "$pull", Value: bson.D{{Key: "legal_holds", Value: hold.ID}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	err = addLegalHoldEvent(tenant, LegalHoldEvent{
+		Hold:     hold.ID,
+		Action:   LegalHoldReleased,
+		By:       by,
+		At:       now,
+		Reason:   release.Reason,
+		Journals: result.ModifiedCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hold.ReleasedBy = by
+	hold.ReleasedAt = &now
+	hold.ReleaseReason = release.Reason
+	hold.UpdatedAt = now
+	hold.Version = version + 1
+	return hold, nil
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestJournalCheckHold(t *testing.T) {
+	if err := (Journal{}).checkHold(); err != nil {
+		t.Errorf("journal without holds: checkHold = %v, want nil", err)
+	}
+
+	journal := Journal{LegalHolds: []primitive.ObjectID{primitive.NewObjectID()}}
+	if err := journal.checkHold(); err != ErrLegalHold {
+		t.Errorf("journal under hold: checkHold = %v, want %v", err, ErrLegalHold)
+	}
+}
+
+func TestHeldFilters(t *testing.T) {
+	if got, want := held(), (bson.E{Key: "legal_holds.0", Value: bson.D{{Key: "$exists", Value: true}}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("held = %v, want %v", got, want)
+	}
+	if got, want := notHeld(), (bson.E{Key: "legal_holds.0", Value: bson.D{{Key: "$exists", Value: false}}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("notHeld = %v, want %v", got, want)
+	}
+}
+
+func TestLegalHoldFilter(t *testing.T) {
+	from := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 3, 31, 0, 0, 0, 0, time.UTC)
+	journal := primitive.NewObjectID()
+
+	tests := []struct {
+		name string
+		hold LegalHold
+		want bson.D
+	}{
+		{"no criteria", LegalHold{}, bson.D{}},
+		{"scheme", LegalHold{Scheme: "scales"}, bson.D{{Key: "scheme", Value: "scales"}}},
+		{
+			"item and period",
+			LegalHold{Item: "scale", From: &from, To: &to},
+			bson.D{
+				{Key: "item", Value: "scale"},
+				{Key: "date", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lte", Value: to}}},
+			},
+		},
+		{"open period", LegalHold{To: &to}, bson.D{{Key: "date", Value: bson.D{{Key: "$lte", Value: to}}}}},
+		{
+			"journals",
+			LegalHold{Journals: []primitive.ObjectID{journal}},
+			bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: []primitive.ObjectID{journal}}}}},
+		},
+	}
+	for _, test := range tests {
+		if got := test.hold.filter(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: filter = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLegalHoldValidate(t *testing.T) {
+	from := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 3, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		hold LegalHold
+		want error
+	}{
+		{"no criteria", LegalHold{Reason: "Расследование"}, ErrLegalHoldCriteria},
+		{"scheme", LegalHold{Scheme: "scales"}, nil},
+		{"period", LegalHold{From: &from, To: &to}, nil},
+		{"same day", LegalHold{From: &from, To: &from}, nil},
+		{"reversed period", LegalHold{From: &to, To: &from}, ErrLegalHoldRange},
+	}
+	for _, test := range tests {
+		if got := test.hold.validate(); got != test.want {
+			t.Errorf("%s: validate = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package model
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
+	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -11,17 +13,42 @@ import (
 
 	"github.com/Oxynger/JournalApp/db"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Состояния учетной записи контроллера
+const (
+	OperatorActive    = "active"
+	OperatorSuspended = "suspended"
+	// OperatorArchived уволенный контроллер. Остается в базе, чтобы на него ссылались старые журналы
+	OperatorArchived = "archived"
+)
+
+// MinPasswordLength минимальная длина пароля контроллера
+const MinPasswordLength = 8
+
+// Errors godoc
+var (
+	ErrPasswordPolicy = errors.New("password must be at least 8 characters long and contain letters and digits")
+)
+
 // Operator соотвествует сущности controller.
 type Operator struct {
 	db.Model   `bson:",inline"`
 	FirstName  string      `bson:"first_name" json:"first_name" example:"Олег"`
 	MiddleName string      `bson:"middle_name" json:"middle_name" example:"Олегович"`
 	LastName   string      `bson:"last_name" json:"last_name" example:"Олегов"`
-	Password   interface{} `bson:"password" json:"password" swaggertype:"string" example:"qwert"`
+	Password   interface{} `bson:"password,omitempty" json:"password" swaggertype:"string" example:"qwerty12"`
+
+	Login string `bson:"login,omitempty" json:"login" example:"olegov"`
+	Email string `bson:"email" json:"email" example:"olegov@example.com"`
+	Phone string `bson:"phone" json:"phone" example:"+79990000000"`
+
+	// Поля жизненного цикла меняются только отдельными методами, поэтому omitempty:
+	// $set при изменении контроллера их не затирает
+	Status             string     `bson:"status,omitempty" json:"-"`
+	MustChangePassword bool       `bson:"must_change_password,omitempty" json:"-"`
+	LastLoginAt        *time.Time `bson:"last_login_at,omitempty" json:"-"`
 }
 
 // ResponseOperator структура оператора который придет в ответе от сервера
@@ -29,15 +56,47 @@ type ResponseOperator struct {
 	ID        primitive.ObjectID `bson:"_id" json:"ID" example:"5ca10d9d015c736a72b7b3ba"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	Version   int64              `bson:"version" json:"version"`
 
 	FirstName  string `bson:"first_name" json:"first_name" example:"Олег"`
 	MiddleName string `bson:"middle_name" json:"middle_name" example:"Олегович"`
 	LastName   string `bson:"last_name" json:"last_name" example:"Олегов"`
+
+	Login              string     `bson:"login" json:"login" example:"olegov"`
+	Email              string     `bson:"email" json:"email" example:"olegov@example.com"`
+	Phone              string     `bson:"phone" json:"phone" example:"+79990000000"`
+	Status             string     `bson:"status" json:"status" example:"active"`
+	MustChangePassword bool       `bson:"must_change_password" json:"must_change_password" example:"false"`
+	LastLoginAt        *time.Time `bson:"last_login_at" json:"last_login_at"`
+}
+
+// CheckPasswordPolicy проверяет, что пароль достаточно длинный и содержит буквы и цифры
+func CheckPasswordPolicy(password string) error {
+	hasLetter, hasDigit := false, false
+	for _, r := range password {
+		hasLetter = hasLetter || unicode.IsLetter(r)
+		hasDigit = hasDigit || unicode.IsDigit(r)
+	}
+
+	if len([]rune(password)) < MinPasswordLength || !hasLetter || !hasDigit {
+		return ErrPasswordPolicy
+	}
+
+	return nil
 }
 
 // HashPassword encrypts operator password
 func (o *Operator) HashPassword() error {
-	convertPwd := []byte(o.Password.(string))
+	plain, ok := o.Password.(string)
+	if !ok {
+		return ErrPasswordPolicy
+	}
+
+	if err := CheckPasswordPolicy(plain); err != nil {
+		return err
+	}
+
+	convertPwd := []byte(plain)
 
 	password, err := bcrypt.GenerateFromPassword(convertPwd, bcrypt.DefaultCost)
 	o.Password = password
@@ -49,55 +108,43 @@ func (o *Operator) HashPassword() error {
 	return nil
 }
 
-func operatorCollection() *mongo.Collection {
-	client := db.Client()
-	coll := client.Database("test").Collection("Operator")
+func operatorCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("Operator")
+}
 
-	return coll
+// operatorFields поля, доступные в фильтрах и сортировке списка контроллеров
+var operatorFields = db.Fields{
+	"first_name":  {Key: "first_name", Type: db.StringField, Sort: true},
+	"middle_name": {Key: "middle_name", Type: db.StringField, Sort: true},
+	"last_name":   {Key: "last_name", Type: db.StringField, Sort: true},
+	"login":       {Key: "login", Type: db.StringField, Sort: true},
+	"status":      {Key: "status", Type: db.StringField, Sort: true},
+	"created_at":  {Key: "created_at", Type: db.TimeField, Sort: true},
 }
 
 // OperatorsAll godoc
-func OperatorsAll() (list []ResponseOperator, err error) {
-	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
-
+func OperatorsAll(tenant db.Tenant, query db.Query) (db.Page, error) {
 	filter := bson.D{
 		{Key: "deleted_at", Value: nil},
 	}
 
 	withoutFields := bson.D{
 		{Key: "deleted_at", Value: 0},
-		{Key: "Password", Value: 0},
+		{Key: "password", Value: 0},
+		{Key: "reset_code", Value: 0},
 	}
 
-	findOptions := options.Find()
-	findOptions.SetProjection(withoutFields)
-
-	cur, err := operatorCollection().Find(timeout, filter, findOptions)
-	defer cur.Close(timeout)
+	var list []ResponseOperator
+	page, err := db.FindPage(operatorCollection(tenant), filter, operatorFields, query, withoutFields, &list)
 
 	if err != nil {
-		return nil, err
-	}
-
-	for cur.Next(timeout) {
-		var resault ResponseOperator
-		err := cur.Decode(&resault)
-
-		if err != nil {
-			return nil, err
-		}
-
-		list = append(list, resault)
+		return db.Page{}, err
 	}
 
-	if err = cur.Err(); err != nil {
-		return nil, err
-	}
-
-	return list, nil
+	return page, nil
 }
 
-func operatorFindOne(id primitive.ObjectID) (operator *ResponseOperator, err error) {
+func operatorFindOne(tenant db.Tenant, id primitive.ObjectID) (operator *ResponseOperator, err error) {
 	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
 
 	filter := bson.D{
@@ -106,13 +153,14 @@ func operatorFindOne(id primitive.ObjectID) (operator *ResponseOperator, err err
 	}
 	withoutFields := bson.D{
 		{Key: "deleted_at", Value: 0},
-		{Key: "Password", Value: 0},
+		{Key: "password", Value: 0},
+		{Key: "reset_code", Value: 0},
 	}
 
 	findOneOptions := options.FindOne()
 	findOneOptions.SetProjection(withoutFields)
 
-	err = operatorCollection().FindOne(timeout, filter, findOneOptions).Decode(&operator)
+	err = operatorCollection(tenant).FindOne(timeout, filter, findOneOptions).Decode(&operator)
 
 	if err != nil {
 		return nil, err
@@ -122,14 +170,14 @@ func operatorFindOne(id primitive.ObjectID) (operator *ResponseOperator, err err
 }
 
 // OperatorOne godoc
-func OperatorOne(id string) (operator *ResponseOperator, err error) {
+func OperatorOne(tenant db.Tenant, id string) (operator *ResponseOperator, err error) {
 	operatorID, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	operator, err = operatorFindOne(operatorID)
+	operator, err = operatorFindOne(tenant, operatorID)
 
 	if err != nil {
 		return nil, err
@@ -138,45 +186,46 @@ func OperatorOne(id string) (operator *ResponseOperator, err error) {
 	return operator, nil
 }
 
-// OperatorDelete godoc
-func OperatorDelete(id string) (operator *ResponseOperator, err error) {
+// operatorDeletedReason основание отзыва ключей подписи при удалении контроллера
+const operatorDeletedReason = "operator deleted"
+
+// OperatorDelete godoc. by - кто удаляет. Ключи подписи контроллера отзываются
+func OperatorDelete(tenant db.Tenant, id string, version int64, by string) (operator *ResponseOperator, err error) {
 	operatorID, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
 	filter := bson.D{
 		{
 			Key:   "deleted_at",
 			Value: nil,
 		},
-		{
-			Key:   "_id",
-			Value: operatorID,
-		},
 	}
 
 	deleteSet := bson.D{
 		{
-			Key: "$set",
-			Value: bson.D{
-				{
-					Key:   "deleted_at",
-					Value: time.Now(),
-				},
-			},
+			Key:   "$set",
+			Value: append(softDelete(by, time.Now()), bson.E{Key: "version", Value: version + 1}),
 		},
 	}
 
-	operator, err = OperatorOne(id)
+	operator, err = OperatorOne(tenant, id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = operatorCollection().UpdateOne(timeout, filter, deleteSet)
+	err = updateVersioned(operatorCollection(tenant), operatorID, version, filter, deleteSet)
+
+	if err != nil {
+		return nil, err
+	}
+
+	operator.Version = version + 1
+
+	_, err = revokeOperatorKeys(tenant, operatorID, by, operatorDeletedReason)
 
 	if err != nil {
 		return nil, err
@@ -186,19 +235,23 @@ func OperatorDelete(id string) (operator *ResponseOperator, err error) {
 }
 
 // AddOperator godoc
-func AddOperator(operator Operator) (*ResponseOperator, error) {
+func AddOperator(tenant db.Tenant, operator Operator) (*ResponseOperator, error) {
 	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
 
 	operator.DeletedAt = nil
 	operator.CreatedAt = time.Now()
 	operator.UpdatedAt = time.Now()
+	operator.Version = 1
+	operator.Status = OperatorActive
+	operator.MustChangePassword = true
+	operator.LastLoginAt = nil
 
-	insertedResault, err := operatorCollection().InsertOne(timeout, operator)
+	insertedResault, err := operatorCollection(tenant).InsertOne(timeout, operator)
 	if err != nil {
 		return nil, err
 	}
 
-	resaultOperator, err := operatorFindOne(insertedResault.InsertedID.(primitive.ObjectID))
+	resaultOperator, err := operatorFindOne(tenant, insertedResault.InsertedID.(primitive.ObjectID))
 	log.Println(resaultOperator.CreatedAt)
 
 	if err != nil {
@@ -208,25 +261,36 @@ func AddOperator(operator Operator) (*ResponseOperator, error) {
 	return resaultOperator, nil
 }
 
-// OperatorUpdate godoc
-func OperatorUpdate(id string, operator Operator) (*ResponseOperator, error) {
-	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
-
+// OperatorUpdate godoc. Контроллер меняется, только если его версия совпадает с version.
+// Пароль здесь не меняется: его меняет сам контроллер или сбрасывает администратор кодом сброса,
+// чтобы пароль прошел политику и ключ подписи контроллера остался доступен
+func OperatorUpdate(tenant db.Tenant, id string, version int64, operator Operator) (*ResponseOperator, error) {
 	operatorID, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	timeOperator, err := operatorFindOne(operatorID)
+	timeOperator, err := operatorFindOne(tenant, operatorID)
+
+	if err != nil {
+		return nil, err
+	}
 
 	operator.CreatedAt = timeOperator.CreatedAt
 	operator.UpdatedAt = time.Now()
+	operator.Version = version + 1
+	operator.DeletedAt = nil
+	operator.DeletedBy = ""
+	operator.Password = nil
+	operator.Status = ""
+	operator.MustChangePassword = false
+	operator.LastLoginAt = nil
 
 	filter := bson.D{
 		{
-			Key:   "_id",
-			Value: operatorID,
+			Key:   "deleted_at",
+			Value: nil,
 		},
 	}
 
@@ -237,13 +301,11 @@ func OperatorUpdate(id string, operator Operator) (*ResponseOperator, error) {
 		},
 	}
 
-	operatorCollection().UpdateOne(timeout, filter, update)
+	err = updateVersioned(operatorCollection(tenant), operatorID, version, filter, update)
 
 	if err != nil {
 		return nil, err
 	}
 
-	resaultOperator, err := operatorFindOne(operatorID)
-
-	return resaultOperator, nil
+	return operatorFindOne(tenant, operatorID)
 }
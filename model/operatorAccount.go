@@ -0,0 +1,242 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ResetCodeTTL время жизни одноразового кода сброса пароля
+const ResetCodeTTL = 24 * time.Hour
+
+const resetCodeLength = 8
+
+// Errors godoc
+var (
+	ErrOperatorStatusInvalid = errors.New("status must be active, suspended or archived")
+	ErrOperatorCredentials   = errors.New("wrong login or password")
+	ErrOperatorSuspended     = errors.New("operator is suspended")
+	ErrOperatorArchived      = errors.New("operator is archived")
+	// ErrOperatorMustChangePassword вход по коду сброса или до смены выданного пароля: пароль нужно сменить через /login/operator/password
+	ErrOperatorMustChangePassword = errors.New("password must be changed before logging in")
+)
+
+// operatorAccount поля учетной записи, нужные для аутентификации
+type operatorAccount struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Status            string             `bson:"status"`
+	Password          []byte             `bson:"password"`
+	ResetCode         []byte             `bson:"reset_code"`
+	ResetCodeExpireAt *time.Time         `bson:"reset_code_expire_at"`
+
+	MustChangePassword bool `bson:"must_change_password"`
+}
+
+// usesResetCode проверяет одноразовый код сброса пароля
+func (a operatorAccount) usesResetCode(code string) bool {
+	if a.ResetCode == nil || a.ResetCodeExpireAt == nil || a.ResetCodeExpireAt.Before(time.Now()) {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(a.ResetCode, []byte(code)) == nil
+}
+
+func operatorSet(tenant db.Tenant, id primitive.ObjectID, set bson.D, unset ...string) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.D{
+		{Key: "$set", Value: append(set, bson.E{Key: "updated_at", Value: time.Now()})},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+	if len(unset) != 0 {
+		fields := bson.D{}
+		for _, field := range unset {
+			fields = append(fields, bson.E{Key: field, Value: ""})
+		}
+		update = append(update, bson.E{Key: "$unset", Value: fields})
+	}
+
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "deleted_at", Value: nil}}
+	updateResault, err := operatorCollection(tenant).UpdateOne(timeout, filter, update)
+	if err != nil {
+		return err
+	}
+	if updateResault.MatchedCount == 0 {
+		return ErrOperatorCredentials
+	}
+
+	return nil
+}
+
+// operatorArchivedReason основание отзыва ключей подписи при архивировании контроллера
+const operatorArchivedReason = "operator archived"
+
+// SetOperatorStatus меняет состояние учетной записи контроллера. Ключи подписи архивного контроллера отзываются
+func SetOperatorStatus(tenant db.Tenant, id string, status string) (*ResponseOperator, error) {
+	if !CheckIn(status, []string{OperatorActive, OperatorSuspended, OperatorArchived}) {
+		return nil, ErrOperatorStatusInvalid
+	}
+
+	operatorID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := operatorSet(tenant, operatorID, bson.D{{Key: "status", Value: status}}); err != nil {
+		return nil, err
+	}
+
+	if status == OperatorArchived {
+		if _, err := revokeOperatorKeys(tenant, operatorID, "", operatorArchivedReason); err != nil {
+			return nil, err
+		}
+	}
+
+	return operatorFindOne(tenant, operatorID)
+}
+
+// ResetOperatorPassword создает одноразовый код, по которому контроллер входит и задает новый пароль.
+// Код возвращается только один раз, в базе хранится его хеш
+func ResetOperatorPassword(tenant db.Tenant, id string) (string, error) {
+	operatorID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return "", err
+	}
+
+	code := make([]byte, resetCodeLength)
+	for i := range code {
+		digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + digit.Int64())
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(code, bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	set := bson.D{
+		{Key: "reset_code", Value: hash},
+		{Key: "reset_code_expire_at", Value: time.Now().Add(ResetCodeTTL)},
+		{Key: "must_change_password", Value: true},
+	}
+	if err := operatorSet(tenant, operatorID, set); err != nil {
+		return "", err
+	}
+
+	return string(code), nil
+}
+
+func findOperatorAccount(tenant db.Tenant, login string) (*operatorAccount, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var account operatorAccount
+	filter := bson.D{{Key: "login", Value: login}, {Key: "deleted_at", Value: nil}}
+	if err := operatorCollection(tenant).FindOne(timeout, filter).Decode(&account); err != nil {
+		return nil, ErrOperatorCredentials
+	}
+
+	return &account, nil
+}
+
+// checkOperatorAccount проверяет состояние учетной записи и пароль или код сброса
+func checkOperatorAccount(tenant db.Tenant, login, password string) (*operatorAccount, bool, error) {
+	account, err := findOperatorAccount(tenant, login)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch account.Status {
+	case OperatorSuspended:
+		return nil, false, ErrOperatorSuspended
+	case OperatorArchived:
+		return nil, false, ErrOperatorArchived
+	}
+
+	if bcrypt.CompareHashAndPassword(account.Password, []byte(password)) == nil {
+		return account, false, nil
+	}
+
+	if account.usesResetCode(password) {
+		return account, true, nil
+	}
+
+	return nil, false, ErrOperatorCredentials
+}
+
+// AuthenticateOperator проверяет логин и пароль контроллера и запоминает время входа.
+// Приостановленные и архивные контроллеры не проходят аутентификацию. Код сброса и выданный администратором пароль
+// для входа не годятся: с ними можно только сменить пароль
+func AuthenticateOperator(tenant db.Tenant, login, password string) (*ResponseOperator, error) {
+	account, usedResetCode, err := checkOperatorAccount(tenant, login, password)
+	if err != nil {
+		return nil, err
+	}
+	if usedResetCode || account.MustChangePassword {
+		return nil, ErrOperatorMustChangePassword
+	}
+
+	if err := operatorSet(tenant, account.ID, bson.D{{Key: "last_login_at", Value: time.Now()}}); err != nil {
+		return nil, err
+	}
+
+	return operatorFindOne(tenant, account.ID)
+}
+
+// checkOperatorPassword проверяет пароль контроллера. Код сброса не подходит
+func checkOperatorPassword(tenant db.Tenant, login, password string) (*operatorAccount, error) {
+	account, usedResetCode, err := checkOperatorAccount(tenant, login, password)
+	if err != nil {
+		return nil, err
+	}
+	if usedResetCode {
+		return nil, ErrOperatorCredentials
+	}
+	return account, nil
+}
+
+// CheckOperatorPassword проверяет пароль контроллера при подтверждении критического действия. Код сброса не подходит
+func CheckOperatorPassword(tenant db.Tenant, login, password string) error {
+	_, err := checkOperatorPassword(tenant, login, password)
+	return err
+}
+
+// ChangeOperatorPassword меняет пароль контроллера. Текущим паролем может быть код сброса.
+// Ключ подписи, защищенный паролем, перешифровывается новым паролем или, если его не открыть, создается заново
+func ChangeOperatorPassword(tenant db.Tenant, login, current, password string) error {
+	if err := CheckPasswordPolicy(password); err != nil {
+		return err
+	}
+
+	account, _, err := checkOperatorAccount(tenant, login, current)
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	set := bson.D{
+		{Key: "password", Value: hash},
+		{Key: "must_change_password", Value: false},
+	}
+
+	if err := operatorSet(tenant, account.ID, set, "reset_code", "reset_code_expire_at"); err != nil {
+		return err
+	}
+
+	return rewrapOperatorKey(tenant, account.ID, login, current, password)
+}
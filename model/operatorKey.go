@@ -0,0 +1,351 @@
+package model
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"time"
+	"unicode"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Состояния ключа подписи контроллера
+const (
+	OperatorKeyActive = "active"
+	// OperatorKeyRotated ключ заменен новым. Подписи, сделанные им, остаются действительными
+	OperatorKeyRotated = "rotated"
+	// OperatorKeyRevoked ключ отозван. Подписи, сделанные им до отзыва, остаются действительными
+	OperatorKeyRevoked = "revoked"
+)
+
+// Чем защищен закрытый ключ контроллера
+const (
+	KeyProtectedByPassword = "password"
+	KeyProtectedByPIN      = "pin"
+)
+
+// Длина PIN-кода ключа подписи
+const (
+	MinPINLength = 4
+	MaxPINLength = 8
+)
+
+// Параметры scrypt для ключа шифрования закрытого ключа
+const (
+	keyScryptN   = 1 << 15
+	keyScryptR   = 8
+	keyScryptP   = 1
+	keySaltBytes = 16
+)
+
+// Errors godoc
+var (
+	ErrPINPolicy          = errors.New("pin must be 4 to 8 digits")
+	ErrOperatorKeyMissing = errors.New("operator has no active signing key")
+	ErrOperatorKeySecret  = errors.New("wrong password or pin for signing key")
+)
+
+// OperatorKey ключ подписи ed25519 контроллера. Закрытый ключ хранится зашифрованным паролем или PIN-кодом контроллера,
+// сервер не может подписать роспись без них. Ключи не удаляются: по ним проверяются старые росписи
+type OperatorKey struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Operator primitive.ObjectID `bson:"operator" json:"operator" example:"5ca10d9d015c736a72b7b3b9"`
+	Login    string             `bson:"login" json:"login" example:"olegov"`
+	// Fingerprint sha256 открытого ключа в hex
+	Fingerprint string `bson:"fingerprint" json:"fingerprint" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	// PublicKey открытый ключ ed25519 в base64
+	PublicKey  []byte `bson:"public_key" json:"public_key" swaggertype:"string" example:"MCowBQYDK2VwAyEA..."`
+	Protection string `bson:"protection" json:"protection" example:"password"`
+	Status     string `bson:"status" json:"status" example:"active"`
+
+	CreatedAt    time.Time  `bson:"created_at" json:"created_at"`
+	RotatedAt    *time.Time `bson:"rotated_at,omitempty" json:"rotated_at,omitempty"`
+	RevokedAt    *time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	RevokedBy    string     `bson:"revoked_by,omitempty" json:"revoked_by,omitempty" example:"admin"`
+	RevokeReason string     `bson:"revoke_reason,omitempty" json:"revoke_reason,omitempty" example:"Контроллер уволен"`
+
+	// Seed зашифрованный AES-GCM seed закрытого ключа. Ключ шифрования - scrypt от пароля или PIN-кода и Salt
+	Seed  []byte `bson:"seed" json:"-"`
+	Salt  []byte `bson:"salt" json:"-"`
+	Nonce []byte `bson:"nonce" json:"-"`
+}
+
+// OperatorKeyRevoke отзыв ключей контроллера
+type OperatorKeyRevoke struct {
+	Reason string `json:"reason" binding:"required" example:"Контроллер уволен"`
+}
+
+func operatorKeyCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("operatorKey")
+}
+
+// CheckPINPolicy проверяет, что PIN-код состоит из 4-8 цифр
+func CheckPINPolicy(pin string) error {
+	if len(pin) < MinPINLength || len(pin) > MaxPINLength {
+		return ErrPINPolicy
+	}
+	for _, r := range pin {
+		if !unicode.IsDigit(r) {
+			return ErrPINPolicy
+		}
+	}
+	return nil
+}
+
+func keyCipher(secret string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(secret), salt, keyScryptN, keyScryptR, keyScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal шифрует seed закрытого ключа секретом secret с новой солью
+func (k *OperatorKey) seal(seed []byte, secret string) error {
+	k.Salt = make([]byte, keySaltBytes)
+	if _, err := rand.Read(k.Salt); err != nil {
+		return err
+	}
+
+	aead, err := keyCipher(secret, k.Salt)
+	if err != nil {
+		return err
+	}
+
+	k.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(k.Nonce); err != nil {
+		return err
+	}
+	k.Seed = aead.Seal(nil, k.Nonce, seed, []byte(k.Fingerprint))
+	return nil
+}
+
+// open расшифровывает закрытый ключ. ErrOperatorKeySecret, если секрет не подходит
+func (k OperatorKey) open(secret string) (ed25519.PrivateKey, error) {
+	aead, err := keyCipher(secret, k.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := aead.Open(nil, k.Nonce, k.Seed, []byte(k.Fingerprint))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrOperatorKeySecret
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// activeOperatorKey действующий ключ контроллера login
+func activeOperatorKey(tenant db.Tenant, login string) (*OperatorKey, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var key OperatorKey
+	filter := bson.D{{Key: "login", Value: login}, {Key: "status", Value: OperatorKeyActive}}
+	if err := operatorKeyCollection(tenant).FindOne(timeout, filter).Decode(&key); err != nil {
+		return nil, ErrOperatorKeyMissing
+	}
+	return &key, nil
+}
+
+// operatorKeysByFingerprint ключи площадки с отпечатками fingerprints, в том числе замененные и отозванные
+func operatorKeysByFingerprint(tenant db.Tenant, fingerprints []string) (map[string]OperatorKey, error) {
+	keys := map[string]OperatorKey{}
+	if len(fingerprints) == 0 {
+		return keys, nil
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "fingerprint", Value: bson.D{{Key: "$in", Value: fingerprints}}}}
+	cur, err := operatorKeyCollection(tenant).Find(timeout, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	for cur.Next(timeout) {
+		var key OperatorKey
+		if err := cur.Decode(&key); err != nil {
+			return nil, err
+		}
+		keys[key.Fingerprint] = key
+	}
+	return keys, cur.Err()
+}
+
+// newOperatorKey создает ключ контроллера, защищенный секретом secret, и переводит прежний действующий ключ в rotated
+func newOperatorKey(tenant db.Tenant, operator primitive.ObjectID, login, secret, protection string) (*OperatorKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	key := OperatorKey{
+		Operator:    operator,
+		Login:       login,
+		Fingerprint: sha256Hex(public),
+		PublicKey:   public,
+		Protection:  protection,
+		Status:      OperatorKeyActive,
+		CreatedAt:   now,
+	}
+	if err := key.seal(private.Seed(), secret); err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = operatorKeyCollection(tenant).UpdateMany(timeout,
+		bson.D{{Key: "operator", Value: operator}, {Key: "status", Value: OperatorKeyActive}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: OperatorKeyRotated}, {Key: "rotated_at", Value: now}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	insertedResault, err := operatorKeyCollection(tenant).InsertOne(timeout, key)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = insertedResault.InsertedID.(primitive.ObjectID)
+
+	return &key, nil
+}
+
+// rewrapOperatorKey перешифровывает действующий ключ, защищенный паролем, новым паролем контроллера.
+// Если ключа нет или старый пароль к нему не подходит (вход по коду сброса), создается новый ключ
+func rewrapOperatorKey(tenant db.Tenant, operator primitive.ObjectID, login, current, password string) error {
+	key, err := activeOperatorKey(tenant, login)
+	if err == ErrOperatorKeyMissing {
+		_, err = newOperatorKey(tenant, operator, login, password, KeyProtectedByPassword)
+		return err
+	}
+	if err != nil || key.Protection != KeyProtectedByPassword {
+		return err
+	}
+
+	private, err := key.open(current)
+	if err == ErrOperatorKeySecret {
+		_, err = newOperatorKey(tenant, operator, login, password, KeyProtectedByPassword)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := key.seal(private.Seed(), password); err != nil {
+		return err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = operatorKeyCollection(tenant).UpdateOne(timeout, bson.D{{Key: "_id", Value: key.ID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "seed", Value: key.Seed}, {Key: "salt", Value: key.Salt}, {Key: "nonce", Value: key.Nonce}}}})
+	return err
+}
+
+// unlockOperatorKey расшифровывает действующий ключ контроллера login паролем или PIN-кодом secret
+func unlockOperatorKey(tenant db.Tenant, login, secret string) (*OperatorKey, ed25519.PrivateKey, error) {
+	key, err := activeOperatorKey(tenant, login)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	private, err := key.open(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, private, nil
+}
+
+// RotateOperatorKey создает новый ключ подписи контроллера по логину и паролю. Если задан pin,
+// закрытый ключ защищается PIN-кодом, иначе паролем. Прежний ключ переводится в rotated
+func RotateOperatorKey(tenant db.Tenant, login, password, pin string) (*OperatorKey, error) {
+	account, err := checkOperatorPassword(tenant, login, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pin) == 0 {
+		return newOperatorKey(tenant, account.ID, login, password, KeyProtectedByPassword)
+	}
+	if err := CheckPINPolicy(pin); err != nil {
+		return nil, err
+	}
+	return newOperatorKey(tenant, account.ID, login, pin, KeyProtectedByPIN)
+}
+
+// OperatorKeys ключи подписи контроллера id, начиная с последнего
+func OperatorKeys(tenant db.Tenant, id string) ([]OperatorKey, error) {
+	operatorID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cur, err := operatorKeyCollection(tenant).Find(timeout, bson.D{{Key: "operator", Value: operatorID}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	keys := []OperatorKey{}
+	for cur.Next(timeout) {
+		var key OperatorKey
+		if err := cur.Decode(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, cur.Err()
+}
+
+func revokeOperatorKeys(tenant db.Tenant, operator primitive.ObjectID, by, reason string) (int64, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "operator", Value: operator}, {Key: "status", Value: bson.D{{Key: "$ne", Value: OperatorKeyRevoked}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: OperatorKeyRevoked},
+		{Key: "revoked_at", Value: time.Now()},
+		{Key: "revoked_by", Value: by},
+		{Key: "revoke_reason", Value: reason},
+	}}}
+	result, err := operatorKeyCollection(tenant).UpdateMany(timeout, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// RevokeOperatorKeys отзывает все ключи контроллера id от имени пользователя by. Росписи, сделанные до отзыва,
+// остаются действительными, новые росписи отозванными ключами не принимаются. Возвращает ключи контроллера
+func RevokeOperatorKeys(tenant db.Tenant, id string, by string, revoke OperatorKeyRevoke) ([]OperatorKey, error) {
+	operator, err := OperatorOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := revokeOperatorKeys(tenant, operator.ID, by, revoke.Reason); err != nil {
+		return nil, err
+	}
+	return OperatorKeys(tenant, id)
+}
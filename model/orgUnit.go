@@ -0,0 +1,424 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Уровни организационной структуры: площадка -> цех -> группа позиций
+const (
+	OrgSite      = "site"
+	OrgWorkshop  = "workshop"
+	OrgItemGroup = "item_group"
+)
+
+// orgParentKind уровень, которому подчиняется узел
+var orgParentKind = map[string]string{
+	OrgSite:      "",
+	OrgWorkshop:  OrgSite,
+	OrgItemGroup: OrgWorkshop,
+}
+
+// Errors godoc
+var (
+	ErrOrgKindInvalid     = errors.New("kind must be site, workshop or item_group")
+	ErrOrgParentInvalid   = errors.New("workshop must belong to a site and item group to a workshop")
+	ErrOrgHasChildren     = errors.New("org unit has children")
+	ErrOrgItemsInvalid    = errors.New("items can be set only for item group")
+	ErrOrgOperatorUnknown = errors.New("operator not found")
+)
+
+// OrgUnit узел организационной структуры. Группа позиций соответствует ItemGroup:
+// в Items перечислены позиции (поле item журнала), которые в нее входят
+type OrgUnit struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	db.Model `bson:",inline"`
+	Kind     string              `bson:"kind" json:"kind" example:"workshop"`
+	Parent   *primitive.ObjectID `bson:"parent" json:"parent" example:"5ca10d9d015c736a72b7b3b9"`
+	// Path вышестоящие узлы от площадки до родителя
+	Path  []primitive.ObjectID `bson:"path" json:"path"`
+	Name  string               `bson:"name" json:"name" example:"salad"`
+	Title string               `bson:"title" json:"title" example:"Салатный цех"`
+	Items []string             `bson:"items" json:"items"`
+
+	// Operators контроллеры, закрепленные за узлом. Контроллер видит журналы узла и всех нижестоящих
+	Operators []primitive.ObjectID `bson:"operators" json:"operators"`
+	// Schemes схемы журналов, которые ведутся в узле
+	Schemes []string `bson:"schemes" json:"schemes"`
+}
+
+// OrgNode узел дерева организационной структуры
+type OrgNode struct {
+	OrgUnit  `bson:",inline"`
+	Children []*OrgNode `json:"children"`
+}
+
+// OrgScope позиции и схемы журналов, доступные контроллеру. Журнал доступен, если он попадает в один из узлов
+type OrgScope struct {
+	Units []OrgScopeUnit
+}
+
+// OrgScopeUnit позиции узла вместе с нижестоящими и схемы журналов, которые ведутся в узле.
+// Если схемы не заданы, доступны журналы любых схем по позициям узла
+type OrgScopeUnit struct {
+	Items   []string
+	Schemes []string
+}
+
+// orgUnitFields поля, доступные в фильтрах и сортировке списка узлов
+var orgUnitFields = db.Fields{
+	"kind":   {Key: "kind", Type: db.StringField, Sort: true},
+	"parent": {Key: "parent", Type: db.ObjectIDField},
+	"name":   {Key: "name", Type: db.StringField, Sort: true},
+	"title":  {Key: "title", Type: db.StringField, Sort: true},
+}
+
+func orgUnitCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("OrgUnit")
+}
+
+// OrgUnitsAll godoc
+func OrgUnitsAll(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var list []OrgUnit
+	return db.FindPage(orgUnitCollection(tenant), bson.D{{Key: "deleted_at", Value: nil}}, orgUnitFields, query, nil, &list)
+}
+
+func orgUnitFindOne(tenant db.Tenant, id primitive.ObjectID) (*OrgUnit, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var unit OrgUnit
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "deleted_at", Value: nil}}
+	if err := orgUnitCollection(tenant).FindOne(timeout, filter).Decode(&unit); err != nil {
+		return nil, err
+	}
+
+	return &unit, nil
+}
+
+// OrgUnitOne godoc
+func OrgUnitOne(tenant db.Tenant, id string) (*OrgUnit, error) {
+	unitID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgUnitFindOne(tenant, unitID)
+}
+
+// OrgTree вся организационная структура площадки деревом
+func OrgTree(tenant db.Tenant) ([]*OrgNode, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "title", Value: 1}})
+	cur, err := orgUnitCollection(tenant).Find(timeout, bson.D{{Key: "deleted_at", Value: nil}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	nodes := []*OrgNode{}
+	byID := map[primitive.ObjectID]*OrgNode{}
+	for cur.Next(timeout) {
+		node := &OrgNode{Children: []*OrgNode{}}
+		if err := cur.Decode(&node.OrgUnit); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		byID[node.ID] = node
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	roots := []*OrgNode{}
+	for _, node := range nodes {
+		if node.Parent == nil {
+			roots = append(roots, node)
+			continue
+		}
+		if parent, ok := byID[*node.Parent]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	return roots, nil
+}
+
+// AddOrgUnit добавляет узел. Родитель должен быть уровнем выше: цех - площадка, группа позиций - цех
+func AddOrgUnit(tenant db.Tenant, unit OrgUnit) (*OrgUnit, error) {
+	parentKind, ok := orgParentKind[unit.Kind]
+	if !ok {
+		return nil, ErrOrgKindInvalid
+	}
+	if len(unit.Name) == 0 {
+		return nil, ErrNameInvalid
+	}
+	if len(unit.Items) != 0 && unit.Kind != OrgItemGroup {
+		return nil, ErrOrgItemsInvalid
+	}
+
+	unit.Path = []primitive.ObjectID{}
+	switch {
+	case len(parentKind) == 0 && unit.Parent != nil, len(parentKind) != 0 && unit.Parent == nil:
+		return nil, ErrOrgParentInvalid
+	case unit.Parent != nil:
+		parent, err := orgUnitFindOne(tenant, *unit.Parent)
+		if err != nil || parent.Kind != parentKind {
+			return nil, ErrOrgParentInvalid
+		}
+		unit.Path = append(append(unit.Path, parent.Path...), parent.ID)
+	}
+
+	if unit.Items == nil {
+		unit.Items = []string{}
+	}
+	if unit.Operators == nil {
+		unit.Operators = []primitive.ObjectID{}
+	}
+	if unit.Schemes == nil {
+		unit.Schemes = []string{}
+	}
+
+	unit.ID = primitive.NilObjectID
+	unit.CreatedAt = time.Now()
+	unit.UpdatedAt = time.Now()
+	unit.DeletedAt = nil
+	unit.Version = 1
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	insertedResault, err := orgUnitCollection(tenant).InsertOne(timeout, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgUnitFindOne(tenant, insertedResault.InsertedID.(primitive.ObjectID))
+}
+
+// orgUnitSet меняет поля узла, если его версия совпадает с version
+func orgUnitSet(tenant db.Tenant, id string, version int64, set bson.D) (*OrgUnit, error) {
+	unitID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: append(set, bson.E{Key: "updated_at", Value: time.Now()}, bson.E{Key: "version", Value: version + 1})},
+	}
+	err = updateVersioned(orgUnitCollection(tenant), unitID, version, bson.D{{Key: "deleted_at", Value: nil}}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgUnitFindOne(tenant, unitID)
+}
+
+// OrgUnitUpdate меняет название и позиции узла. Уровень и родитель узла не меняются
+func OrgUnitUpdate(tenant db.Tenant, id string, version int64, unit OrgUnit) (*OrgUnit, error) {
+	if len(unit.Name) == 0 {
+		return nil, ErrNameInvalid
+	}
+
+	current, err := OrgUnitOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(unit.Items) != 0 && current.Kind != OrgItemGroup {
+		return nil, ErrOrgItemsInvalid
+	}
+	if unit.Items == nil {
+		unit.Items = []string{}
+	}
+
+	return orgUnitSet(tenant, id, version, bson.D{
+		{Key: "name", Value: unit.Name},
+		{Key: "title", Value: unit.Title},
+		{Key: "items", Value: unit.Items},
+	})
+}
+
+// AssignOperators закрепляет контроллеров за узлом. Список заменяет прежний
+func AssignOperators(tenant db.Tenant, id string, version int64, operators []primitive.ObjectID) (*OrgUnit, error) {
+	if operators == nil {
+		operators = []primitive.ObjectID{}
+	}
+
+	for _, operatorID := range operators {
+		if _, err := operatorFindOne(tenant, operatorID); err != nil {
+			return nil, ErrOrgOperatorUnknown
+		}
+	}
+
+	return orgUnitSet(tenant, id, version, bson.D{{Key: "operators", Value: operators}})
+}
+
+// AssignSchemes закрепляет схемы журналов за узлом. Список заменяет прежний
+func AssignSchemes(tenant db.Tenant, id string, version int64, schemes []string) (*OrgUnit, error) {
+	if schemes == nil {
+		schemes = []string{}
+	}
+
+	for _, name := range schemes {
+		if _, err := journalSchemeByName(tenant, name); err != nil {
+			return nil, errors.New("journal scheme " + name + " not found")
+		}
+	}
+
+	return orgUnitSet(tenant, id, version, bson.D{{Key: "schemes", Value: schemes}})
+}
+
+// OrgUnitDelete помечает узел удаленным. Узел с подчиненными узлами не удаляется
+func OrgUnitDelete(tenant db.Tenant, id string, version int64) (*OrgUnit, error) {
+	unit, err := OrgUnitOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	children, err := orgUnitCollection(tenant).CountDocuments(timeout, bson.D{{Key: "parent", Value: unit.ID}, {Key: "deleted_at", Value: nil}})
+	if err != nil {
+		return nil, err
+	}
+	if children != 0 {
+		return nil, ErrOrgHasChildren
+	}
+
+	if _, err := orgUnitSet(tenant, id, version, bson.D{{Key: "deleted_at", Value: time.Now()}}); err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	return unit, nil
+}
+
+// OperatorOrgScope позиции и схемы журналов узлов, за которыми закреплен контроллер, и всех нижестоящих узлов.
+// Контроллер, закрепленный за площадкой или цехом (старший смены, мастер), видит все ниже своего узла
+func OperatorOrgScope(tenant db.Tenant, login string) (*OrgScope, error) {
+	account, err := findOperatorAccount(tenant, login)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	assigned := []primitive.ObjectID{}
+	cur, err := orgUnitCollection(tenant).Find(timeout, bson.D{{Key: "operators", Value: account.ID}, {Key: "deleted_at", Value: nil}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	for cur.Next(timeout) {
+		var unit OrgUnit
+		if err := cur.Decode(&unit); err != nil {
+			return nil, err
+		}
+		assigned = append(assigned, unit.ID)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgScopeOf(tenant, assigned)
+}
+
+// orgScopeOf позиции и схемы журналов узлов units и всех нижестоящих узлов
+func orgScopeOf(tenant db.Tenant, units []primitive.ObjectID) (*OrgScope, error) {
+	scope := &OrgScope{Units: []OrgScopeUnit{}}
+	if len(units) == 0 {
+		return scope, nil
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: units}}}},
+			bson.D{{Key: "path", Value: bson.D{{Key: "$in", Value: units}}}},
+		}},
+		{Key: "deleted_at", Value: nil},
+	}
+	cur, err := orgUnitCollection(tenant).Find(timeout, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	found := []OrgUnit{}
+	for cur.Next(timeout) {
+		var unit OrgUnit
+		if err := cur.Decode(&unit); err != nil {
+			return nil, err
+		}
+		found = append(found, unit)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	scope.Units = scopeUnits(found)
+	return scope, nil
+}
+
+// scopeUnits позиции и схемы журналов каждого из узлов found.
+// Позиции есть только у групп позиций, узел выше получает позиции своих групп
+func scopeUnits(found []OrgUnit) []OrgScopeUnit {
+	units := []OrgScopeUnit{}
+	for _, unit := range found {
+		items := append([]string{}, unit.Items...)
+		for _, below := range found {
+			if containsObjectID(below.Path, unit.ID) {
+				items = append(items, below.Items...)
+			}
+		}
+		if len(items) != 0 {
+			units = append(units, OrgScopeUnit{Items: items, Schemes: unit.Schemes})
+		}
+	}
+	return units
+}
+
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, current := range ids {
+		if current == id {
+			return true
+		}
+	}
+	return false
+}
+
+// contains проверяет, что журнал попадает в scope
+func (scope OrgScope) contains(journal Journal) bool {
+	for _, unit := range scope.Units {
+		if CheckIn(journal.Item, unit.Items) && (len(unit.Schemes) == 0 || CheckIn(journal.Scheme, unit.Schemes)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter условие на журналы, доступные в пределах scope. Пустой scope не допускает ни одного журнала
+func (scope OrgScope) filter() bson.E {
+	units := bson.A{bson.D{{Key: "item", Value: bson.D{{Key: "$in", Value: bson.A{}}}}}}
+	for _, unit := range scope.Units {
+		match := bson.D{{Key: "item", Value: bson.D{{Key: "$in", Value: unit.Items}}}}
+		if len(unit.Schemes) != 0 {
+			match = append(match, bson.E{Key: "scheme", Value: bson.D{{Key: "$in", Value: unit.Schemes}}})
+		}
+		units = append(units, match)
+	}
+	return bson.E{Key: "$or", Value: units}
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOrgScopeContains(t *testing.T) {
+	scope := OrgScope{Units: []OrgScopeUnit{
+		{Items: []string{"salad"}, Schemes: []string{"temperature"}},
+		{Items: []string{"soup"}},
+	}}
+
+	tests := []struct {
+		name    string
+		journal Journal
+		want    bool
+	}{
+		{"item and scheme of one unit", Journal{Item: "salad", Scheme: "temperature"}, true},
+		{"scheme not kept in the unit", Journal{Item: "salad", Scheme: "washing"}, false},
+		{"unit without schemes", Journal{Item: "soup", Scheme: "washing"}, true},
+		{"item of no unit", Journal{Item: "bread", Scheme: "temperature"}, false},
+	}
+	for _, test := range tests {
+		if got := scope.contains(test.journal); got != test.want {
+			t.Errorf("%s: contains = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if (OrgScope{}).contains(Journal{Item: "salad", Scheme: "temperature"}) {
+		t.Error("empty scope contains a journal")
+	}
+}
+
+func TestOrgScopeFilter(t *testing.T) {
+	scope := OrgScope{Units: []OrgScopeUnit{
+		{Items: []string{"salad"}, Schemes: []string{"temperature"}},
+		{Items: []string{"soup"}},
+	}}
+
+	want := bson.E{Key: "$or", Value: bson.A{
+		bson.D{{Key: "item", Value: bson.D{{Key: "$in", Value: bson.A{}}}}},
+		bson.D{
+			{Key: "item", Value: bson.D{{Key: "$in", Value: []string{"salad"}}}},
+			{Key: "scheme", Value: bson.D{{Key: "$in", Value: []string{"temperature"}}}},
+		},
+		bson.D{{Key: "item", Value: bson.D{{Key: "$in", Value: []string{"soup"}}}}},
+	}}
+	if got := scope.filter(); !reflect.DeepEqual(got, want) {
+		t.Errorf("filter = %v, want %v", got, want)
+	}
+
+	empty := bson.E{Key: "$or", Value: bson.A{bson.D{{Key: "item", Value: bson.D{{Key: "$in", Value: bson.A{}}}}}}}
+	if got := (OrgScope{}).filter(); !reflect.DeepEqual(got, empty) {
+		t.Errorf("empty filter = %v, want %v", got, empty)
+	}
+}
+
+func TestScopeUnits(t *testing.T) {
+	site := primitive.NewObjectID()
+	workshop := primitive.NewObjectID()
+	group := primitive.NewObjectID()
+
+	found := []OrgUnit{
+		{ID: site, Kind: OrgSite},
+		{ID: workshop, Kind: OrgWorkshop, Path: []primitive.ObjectID{site}, Schemes: []string{"temperature"}},
+		{ID: group, Kind: OrgItemGroup, Path: []primitive.ObjectID{site, workshop}, Items: []string{"salad", "soup"}},
+	}
+
+	want := []OrgScopeUnit{
+		{Items: []string{"salad", "soup"}},
+		{Items: []string{"salad", "soup"}, Schemes: []string{"temperature"}},
+		{Items: []string{"salad", "soup"}},
+	}
+	if got := scopeUnits(found); !reflect.DeepEqual(got, want) {
+		t.Errorf("scopeUnits = %+v, want %+v", got, want)
+	}
+
+	if got := scopeUnits([]OrgUnit{{ID: site, Kind: OrgSite}}); len(got) != 0 {
+		t.Errorf("units without items: scopeUnits = %+v, want none", got)
+	}
+}
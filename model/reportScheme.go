@@ -4,13 +4,11 @@ import (
 	"context"
 	"errors"
 	"log"
-	"strconv"
+	"time"
 
 	"github.com/Oxynger/JournalApp/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 //Errors godoc
@@ -28,94 +26,71 @@ type ReportField struct {
 
 // ReportScheme godoc
 type ReportScheme struct {
-	ID      primitive.ObjectID `bson:"_id" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
-	Name    string             `bson:"name" json:"name" example:"scales_calibration"`
-	Title   string             `bson:"title" json:"title" example:"Учет и калибровка весов"`
-	Journal string             `bson:"journal" json:"journal" example:"scales_calibration"`
-	Fields  []ReportField      `bson:"fields" json:"fields"`
-	Deleted bool               `bson:"deleted" json:"-"`
+	ID        primitive.ObjectID `bson:"_id" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Name      string             `bson:"name" json:"name" example:"scales_calibration"`
+	Title     string             `bson:"title" json:"title" example:"Учет и калибровка весов"`
+	Journal   string             `bson:"journal" json:"journal" example:"scales_calibration"`
+	Fields    []ReportField      `bson:"fields" json:"fields"`
+	DeletedAt *time.Time         `bson:"deleted_at" json:"-"`
+	DeletedBy string             `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64              `bson:"version" json:"version" example:"1"`
 }
 
 // NewReportScheme godoc
 type NewReportScheme struct {
-	Name    string        `bson:"name" json:"name" example:"scales_calibration"`
-	Title   string        `bson:"title" json:"title" example:"Учет и калибровка весов"`
-	Journal string        `bson:"journal" json:"journal" example:"scales_calibration"`
-	Fields  []ReportField `bson:"fields" json:"fields"`
-	Deleted bool          `bson:"deleted" json:"-"`
+	Name      string        `bson:"name" json:"name" example:"scales_calibration"`
+	Title     string        `bson:"title" json:"title" example:"Учет и калибровка весов"`
+	Journal   string        `bson:"journal" json:"journal" example:"scales_calibration"`
+	Fields    []ReportField `bson:"fields" json:"fields"`
+	DeletedAt *time.Time    `bson:"deleted_at" json:"-"`
+	DeletedBy string        `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64         `bson:"version" json:"-"`
 }
 
 // UpdateReportScheme godoc
 type UpdateReportScheme struct {
-	Name    string        `bson:"name" json:"name" example:"scales_calibration"`
-	Title   string        `bson:"title" json:"title" example:"Учет и калибровка весов"`
-	Journal string        `bson:"journal" json:"journal" example:"scales_calibration"`
-	Fields  []ReportField `bson:"fields" json:"fields"`
-	Deleted bool          `bson:"deleted" json:"-"`
+	Name      string        `bson:"name" json:"name" example:"scales_calibration"`
+	Title     string        `bson:"title" json:"title" example:"Учет и калибровка весов"`
+	Journal   string        `bson:"journal" json:"journal" example:"scales_calibration"`
+	Fields    []ReportField `bson:"fields" json:"fields"`
+	DeletedAt *time.Time    `bson:"deleted_at" json:"-"`
+	DeletedBy string        `bson:"deleted_by,omitempty" json:"-"`
+	Version   int64         `bson:"version" json:"-"`
 }
 
 // ReportSchemeCollection godoc
-func ReportSchemeCollection() *mongo.Collection {
-	client := db.Client()
-	coll := client.Database("test").Collection("reportScheme")
+func ReportSchemeCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("reportScheme")
+}
 
-	return coll
+// reportSchemeFields поля, доступные в фильтрах и сортировке списка схем
+var reportSchemeFields = db.Fields{
+	"name":    {Key: "name", Type: db.StringField, Sort: true},
+	"title":   {Key: "title", Type: db.StringField, Sort: true},
+	"journal": {Key: "journal", Type: db.StringField, Sort: true},
 }
 
 //ReportSchemeAll get list report schemes godoc
-func ReportSchemeAll(offset string, limit string) ([]ReportScheme, error) {
-	offsetInt, err := strconv.ParseInt(offset, 10, 64)
-	if err != nil{
-		return nil, err
-	}
-	if offsetInt < 0{
-		return nil, ErrNegativeParam
-	} 
-	limitInt, err := strconv.ParseInt(limit, 10, 64)
-	if err != nil{
-		return nil, err
-	}
-	if limitInt < 0{
-		return nil, ErrNegativeParam
-	} 
-
-	options := options.Find()
-	options.SetLimit(limitInt)
-	options.SetSkip(offsetInt)
-
-	cur, err := ReportSchemeCollection().Find(context.Background(), bson.D{{"deleted", false}}, options)
+func ReportSchemeAll(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var listSchemes []ReportScheme
+	page, err := db.FindPage(ReportSchemeCollection(tenant), bson.D{{Key: "deleted_at", Value: nil}}, reportSchemeFields, query, nil, &listSchemes)
 	if err != nil {
 		log.Println(err)
-		return nil, err
-	}
-	defer cur.Close(context.Background())
-	listSchemes := []ReportScheme{}
-
-	for cur.Next(context.Background()) {
-		row := new(ReportScheme)
-		err := cur.Decode(&row)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		listSchemes = append(listSchemes, *row)
-	}
-	if err := cur.Err(); err != nil {
-		return nil, err
+		return db.Page{}, err
 	}
 
-	return listSchemes, err
+	return page, err
 }
 
 //ReportSchemeOne get list report schemes with id godoc
-func ReportSchemeOne(id string) (ReportScheme, error) {
+func ReportSchemeOne(tenant db.Tenant, id string) (ReportScheme, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Println(err)
 		return ReportScheme{}, err
 	}
 	row := new(ReportScheme)
-	err = ReportSchemeCollection().FindOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted", false}}}}}).Decode(&row)
+	err = ReportSchemeCollection(tenant).FindOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted_at", nil}}}}}).Decode(&row)
 	if err != nil {
 		log.Println(err)
 		return ReportScheme{}, err
@@ -125,8 +100,9 @@ func ReportSchemeOne(id string) (ReportScheme, error) {
 }
 
 // Insert godoc
-func (s NewReportScheme) Insert() error {
-	insertResault, err := ReportSchemeCollection().InsertOne(context.Background(), s)
+func (s NewReportScheme) Insert(tenant db.Tenant) error {
+	s.Version = 1
+	insertResault, err := ReportSchemeCollection(tenant).InsertOne(context.Background(), s)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -144,7 +120,7 @@ func (s NewReportScheme) Validation() error {
 		return ErrTitleInvalid
 	case len(s.Journal) == 0:
 		return ErrJournalInvalid
-	case s.Deleted == true:
+	case s.DeletedAt != nil:
 		return ErrDeletedInvalid
 	case s.Fields == nil:
 		return ErrFieldsInvalid
@@ -164,18 +140,19 @@ func (s NewReportScheme) Validation() error {
 }
 
 // Update godoc
-func (s UpdateReportScheme) Update(id string) error {
+func (s UpdateReportScheme) Update(tenant db.Tenant, id string, version int64) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	updateResault, err := ReportSchemeCollection().UpdateOne(context.Background(), bson.D{{"_id", objectID}}, bson.D{{"$set", s}})
+	s.Version = version + 1
+	err = updateVersioned(ReportSchemeCollection(tenant), objectID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: s}})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	log.Println("updated documents: ", updateResault.UpsertedID)
+	log.Println("updated documents: ", objectID)
 	return err
 }
 
@@ -189,18 +166,18 @@ func (s UpdateReportScheme) Validation() error {
 	}
 }
 
-// DeleteReportSchemeOne godoc
-func DeleteReportSchemeOne(id string) error {
+// DeleteReportSchemeOne godoc. by - кто удаляет
+func DeleteReportSchemeOne(tenant db.Tenant, id string, version int64, by string) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	updateResault, err := ReportSchemeCollection().UpdateOne(context.Background(), bson.D{{"$and", bson.A{bson.D{{"_id", objectID}}, bson.D{{"deleted", false}}}}}, bson.D{{"$set", bson.D{{"deleted", true}}}})
+	err = updateVersioned(ReportSchemeCollection(tenant), objectID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: append(softDelete(by, time.Now()), bson.E{Key: "version", Value: version + 1})}})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	log.Println("deleted documents: ", updateResault.UpsertedID)
+	log.Println("deleted documents: ", objectID)
 	return err
 }
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Errors godoc
+var (
+	ErrRetentionInvalid = errors.New("retention days must not be negative and destroy_after_days must not be less than min_retention_days and archive_after_days")
+	ErrRetentionPeriod  = errors.New("journal is within the minimum retention period of its scheme")
+)
+
+// RetentionPolicy сроки хранения журналов схемы в днях от даты журнала
+type RetentionPolicy struct {
+	// MinRetentionDays сколько дней журнал нельзя удалить окончательно, в том числе из корзины
+	MinRetentionDays int `bson:"min_retention_days" json:"min_retention_days" example:"1095"`
+	// ArchiveAfterDays через сколько дней журнал переносится в архив. 0 - не переносится
+	ArchiveAfterDays int `bson:"archive_after_days,omitempty" json:"archive_after_days,omitempty" example:"365"`
+	// DestroyAfterDays через сколько дней журнал удаляется окончательно, даже если не был удален. 0 - хранится бессрочно
+	DestroyAfterDays int `bson:"destroy_after_days,omitempty" json:"destroy_after_days,omitempty" example:"1825"`
+}
+
+func (p *RetentionPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.MinRetentionDays < 0 || p.ArchiveAfterDays < 0 || p.DestroyAfterDays < 0 {
+		return ErrRetentionInvalid
+	}
+	if p.DestroyAfterDays != 0 && (p.DestroyAfterDays < p.MinRetentionDays || p.DestroyAfterDays < p.ArchiveAfterDays) {
+		return ErrRetentionInvalid
+	}
+	return nil
+}
+
+// retentionPolicies политики хранения действующих схем журналов площадки по имени схемы
+func retentionPolicies(tenant db.Tenant) (map[string]RetentionPolicy, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "deleted_at", Value: nil}, {Key: "retention", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	cur, err := JournalSchemeCollection(tenant).Find(timeout, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	policies := map[string]RetentionPolicy{}
+	for cur.Next(timeout) {
+		var scheme struct {
+			Name      string          `bson:"name"`
+			Retention RetentionPolicy `bson:"retention"`
+		}
+		if err := cur.Decode(&scheme); err != nil {
+			return nil, err
+		}
+		policies[scheme.Name] = scheme.Retention
+	}
+	return policies, cur.Err()
+}
+
+// notRetained отбор журналов, у которых прошел минимальный срок хранения их схемы
+func notRetained(tenant db.Tenant, now time.Time) (bson.D, error) {
+	policies, err := retentionPolicies(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	retained := bson.A{}
+	for name, policy := range policies {
+		if policy.MinRetentionDays == 0 {
+			continue
+		}
+		retained = append(retained, bson.D{
+			{Key: "scheme", Value: name},
+			{Key: "date", Value: bson.D{{Key: "$gt", Value: now.AddDate(0, 0, -policy.MinRetentionDays)}}},
+		})
+	}
+	if len(retained) == 0 {
+		return bson.D{}, nil
+	}
+	return bson.D{{Key: "$nor", Value: retained}}, nil
+}
+
+// checkRetention возвращает ErrRetentionPeriod, если журнал схемы scheme с датой date еще нельзя удалить окончательно
+func checkRetention(tenant db.Tenant, scheme string, date time.Time, now time.Time) error {
+	policies, err := retentionPolicies(tenant)
+	if err != nil {
+		return err
+	}
+	policy, ok := policies[scheme]
+	if ok && policy.MinRetentionDays > 0 && date.After(now.AddDate(0, 0, -policy.MinRetentionDays)) {
+		return ErrRetentionPeriod
+	}
+	return nil
+}
+
+// DestroyExpired окончательно удаляет журналы схем с DestroyAfterDays, дата которых старше срока, вместе с вложениями.
+// Журналы на удержании остаются. Возвращает количество удаленных журналов
+func DestroyExpired(tenant db.Tenant, now time.Time) (int64, error) {
+	policies, err := retentionPolicies(tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	var destroyed int64
+	for name, policy := range policies {
+		if policy.DestroyAfterDays == 0 {
+			continue
+		}
+		filter := bson.D{
+			{Key: "scheme", Value: name},
+			{Key: "date", Value: bson.D{{Key: "$lt", Value: now.AddDate(0, 0, -policy.DestroyAfterDays)}}},
+			notHeld(),
+		}
+		count, err := purgeExpiredJournals(tenant, filter)
+		destroyed += count
+		if err != nil {
+			return destroyed, err
+		}
+	}
+	return destroyed, nil
+}
@@ -0,0 +1,437 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Формат пакета схем
+const (
+	BundleFormat  = "journalapp.schemes"
+	BundleVersion = 1
+)
+
+// Что делать при импорте, если схема с таким именем уже есть
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictRename    = "rename"
+)
+
+// Результаты импорта схемы
+const (
+	ImportCreated     = "created"
+	ImportOverwritten = "overwritten"
+	ImportRenamed     = "renamed"
+	ImportSkipped     = "skipped"
+	ImportFailed      = "failed"
+)
+
+// Виды схем в пакете
+const (
+	BundleItemScheme    = "item_scheme"
+	BundleJournalScheme = "journal_scheme"
+	BundleReportScheme  = "report_scheme"
+)
+
+// Errors godoc
+var (
+	ErrBundleFormat     = errors.New("not a scheme bundle")
+	ErrBundleVersion    = errors.New("scheme bundle version is not supported")
+	ErrConflictInvalid  = errors.New("on_conflict must be skip, overwrite or rename")
+	ErrBundleDependency = errors.New("referenced scheme was not imported from the bundle and is not in the database")
+)
+
+// SchemeBundle переносимый пакет схем: схемы объектов, схемы журналов, которые ссылаются на них через Item,
+// и схемы отчетов, которые ссылаются на схемы журналов через Journal
+type SchemeBundle struct {
+	Format         string             `json:"format" example:"journalapp.schemes"`
+	Version        int                `json:"version" example:"1"`
+	ExportedAt     time.Time          `json:"exported_at"`
+	Tenant         db.Tenant          `json:"tenant,omitempty" example:"default"`
+	ItemSchemes    []NewItemScheme    `json:"item_schemes"`
+	JournalSchemes []NewJournalScheme `json:"journal_schemes"`
+	ReportSchemes  []NewReportScheme  `json:"report_schemes"`
+}
+
+// BundleSelection какие схемы выгрузить. Пустой выбор - все схемы площадки
+type BundleSelection struct {
+	// Items имена схем объектов: выгружаются вместе со схемами журналов и отчетов, которые от них зависят
+	Items []string
+	// Journals имена схем журналов: выгружаются вместе со схемами объектов, от которых зависят, и схемами отчетов
+	Journals []string
+}
+
+// ImportOptions параметры импорта
+type ImportOptions struct {
+	OnConflict string
+	DryRun     bool
+}
+
+// ImportEntry результат импорта одной схемы
+type ImportEntry struct {
+	Kind string `json:"kind" example:"journal_scheme"`
+	Name string `json:"name" example:"scales_calibration"`
+	// NewName имя, под которым схема сохранена, если она переименована
+	NewName string `json:"new_name,omitempty" example:"scales_calibration_2"`
+	Action  string `json:"action" example:"created"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ImportReport отчет об импорте. При dry_run ничего не сохранено, отчет показывает, что было бы сделано
+type ImportReport struct {
+	DryRun  bool          `json:"dry_run" example:"true"`
+	Entries []ImportEntry `json:"entries"`
+	Failed  int           `json:"failed" example:"0"`
+}
+
+// decodeAll читает неудаленные схемы по фильтру, decode вызывается для каждого документа
+func decodeAll(coll *db.Collection, filter bson.D, decode func(cur *mongo.Cursor) error) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter = append(bson.D{{Key: "deleted_at", Value: nil}}, filter...)
+	cur, err := coll.Find(timeout, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(timeout)
+
+	for cur.Next(timeout) {
+		if err := decode(cur); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func inFilter(key string, names []string) bson.D {
+	return bson.D{{Key: key, Value: bson.D{{Key: "$in", Value: names}}}}
+}
+
+// ExportSchemes собирает пакет схем. Пакет замкнут по зависимостям: в нем есть все схемы объектов,
+// на которые ссылаются его схемы журналов, и все схемы журналов, на которые ссылаются его схемы отчетов
+func ExportSchemes(tenant db.Tenant, selection BundleSelection) (*SchemeBundle, error) {
+	bundle := &SchemeBundle{
+		Format:         BundleFormat,
+		Version:        BundleVersion,
+		ExportedAt:     time.Now(),
+		Tenant:         tenant,
+		ItemSchemes:    []NewItemScheme{},
+		JournalSchemes: []NewJournalScheme{},
+		ReportSchemes:  []NewReportScheme{},
+	}
+
+	all := len(selection.Items) == 0 && len(selection.Journals) == 0
+
+	journalFilter := bson.D{}
+	if !all {
+		journalFilter = bson.D{{Key: "$or", Value: bson.A{
+			inFilter("item", append([]string{}, selection.Items...)),
+			inFilter("name", append([]string{}, selection.Journals...)),
+		}}}
+	}
+	if err := decodeAll(JournalSchemeCollection(tenant), journalFilter, func(cur *mongo.Cursor) error {
+		var scheme NewJournalScheme
+		if err := cur.Decode(&scheme); err != nil {
+			return err
+		}
+		bundle.JournalSchemes = append(bundle.JournalSchemes, scheme)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	items := append([]string{}, selection.Items...)
+	journals := []string{}
+	for _, scheme := range bundle.JournalSchemes {
+		journals = append(journals, scheme.Name)
+		if !CheckIn(scheme.Item, items) {
+			items = append(items, scheme.Item)
+		}
+	}
+
+	itemFilter, reportFilter := bson.D{}, bson.D{}
+	if !all {
+		itemFilter = inFilter("name", items)
+		reportFilter = inFilter("journal", journals)
+	}
+	if err := decodeAll(ItemSchemeCollection(tenant), itemFilter, func(cur *mongo.Cursor) error {
+		var scheme NewItemScheme
+		if err := cur.Decode(&scheme); err != nil {
+			return err
+		}
+		bundle.ItemSchemes = append(bundle.ItemSchemes, scheme)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := decodeAll(ReportSchemeCollection(tenant), reportFilter, func(cur *mongo.Cursor) error {
+		var scheme NewReportScheme
+		if err := cur.Decode(&scheme); err != nil {
+			return err
+		}
+		bundle.ReportSchemes = append(bundle.ReportSchemes, scheme)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
+
+// existingScheme _id и версия неудаленной схемы с именем name
+func existingScheme(coll *db.Collection, name string) (primitive.ObjectID, int64, bool, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var scheme struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		Version int64              `bson:"version"`
+	}
+	err := coll.FindOne(timeout, bson.D{{Key: "name", Value: name}, {Key: "deleted_at", Value: nil}}).Decode(&scheme)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, 0, false, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, 0, false, err
+	}
+	return scheme.ID, scheme.Version, true, nil
+}
+
+// importer состояние одного импорта
+type importer struct {
+	tenant  db.Tenant
+	options ImportOptions
+	report  *ImportReport
+	// names новые имена схем пакета по виду и исходному имени. Нет в names - схема не импортирована
+	names map[string]map[string]string
+	// taken имена, занятые схемами, которые создаются при этом импорте
+	taken map[string]map[string]bool
+	// failed схемы пакета по виду и исходному имени, которые не удалось импортировать.
+	// Зависимые от них схемы тоже не импортируются, а не связываются со схемой базы с тем же именем
+	failed map[string]map[string]bool
+	// itemFields поля схем объектов, записанных из пакета, по новому имени
+	itemFields map[string][]ItemField
+}
+
+func (im *importer) add(entry ImportEntry) {
+	if entry.Action == ImportFailed {
+		im.report.Failed++
+		im.failed[entry.Kind][entry.Name] = true
+	}
+	im.report.Entries = append(im.report.Entries, entry)
+}
+
+// resolve имя, под которым доступна схема name вида kind: из пакета или из базы
+func (im *importer) resolve(kind string, coll *db.Collection, name string) (string, error) {
+	if newName, ok := im.names[kind][name]; ok {
+		return newName, nil
+	}
+	if im.failed[kind][name] {
+		return "", ErrBundleDependency
+	}
+	_, _, exists, err := existingScheme(coll, name)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrBundleDependency
+	}
+	return name, nil
+}
+
+// freeName первое свободное имя вида name_2, name_3...
+func (im *importer) freeName(kind string, coll *db.Collection, name string) (string, error) {
+	for i := 2; ; i++ {
+		candidate := name + "_" + strconv.Itoa(i)
+		if im.taken[kind][candidate] {
+			continue
+		}
+		_, _, exists, err := existingScheme(coll, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+}
+
+// place решает, что делать со схемой name, и сохраняет ее через insert или overwrite.
+// check, если задан, проверяет перед заменой, что существующую схему можно заменить.
+// Возвращает имя, под которым схема сохранена, или пустую строку, если схема из пакета не записана
+func (im *importer) place(kind string, coll *db.Collection, name string, insert func(name string) error, overwrite func(id primitive.ObjectID, version int64) error, check func(id primitive.ObjectID) error) string {
+	entry := ImportEntry{Kind: kind, Name: name}
+	fail := func(err error) {
+		entry.Action = ImportFailed
+		entry.Error = err.Error()
+		im.add(entry)
+	}
+
+	id, version, exists, err := existingScheme(coll, name)
+	if err != nil {
+		fail(err)
+		return ""
+	}
+
+	switch {
+	case !exists:
+		entry.Action = ImportCreated
+	case im.options.OnConflict == ConflictSkip:
+		// Зависимые схемы пакета ссылаются на уже существующую схему
+		entry.Action = ImportSkipped
+		im.names[kind][name] = name
+		im.add(entry)
+		return ""
+	case im.options.OnConflict == ConflictOverwrite:
+		entry.Action = ImportOverwritten
+		if check != nil {
+			if err := check(id); err != nil {
+				fail(err)
+				return ""
+			}
+		}
+	default:
+		entry.Action = ImportRenamed
+		if entry.NewName, err = im.freeName(kind, coll, name); err != nil {
+			fail(err)
+			return ""
+		}
+	}
+
+	target := name
+	if entry.Action == ImportRenamed {
+		target = entry.NewName
+	}
+
+	if !im.options.DryRun {
+		if entry.Action == ImportOverwritten {
+			err = overwrite(id, version)
+		} else {
+			err = insert(target)
+		}
+		if err != nil {
+			fail(err)
+			return ""
+		}
+	}
+
+	im.names[kind][name] = target
+	im.taken[kind][target] = true
+	im.add(entry)
+	return target
+}
+
+// ImportSchemes импортирует пакет схем: сначала схемы объектов, затем журналов, затем отчетов.
+// Ссылки Item и Journal разрешаются на схемы пакета (с учетом переименования) или на схемы в базе.
+// Схема с неразрешенной ссылкой или ошибкой проверки не импортируется, как и схемы пакета, которые на нее ссылаются
+func ImportSchemes(tenant db.Tenant, bundle SchemeBundle, options ImportOptions) (*ImportReport, error) {
+	if bundle.Format != BundleFormat {
+		return nil, ErrBundleFormat
+	}
+	if bundle.Version < 1 || bundle.Version > BundleVersion {
+		return nil, ErrBundleVersion
+	}
+	if len(options.OnConflict) == 0 {
+		options.OnConflict = ConflictSkip
+	}
+	if !CheckIn(options.OnConflict, []string{ConflictSkip, ConflictOverwrite, ConflictRename}) {
+		return nil, ErrConflictInvalid
+	}
+
+	im := &importer{
+		tenant:  tenant,
+		options: options,
+		report:  &ImportReport{DryRun: options.DryRun, Entries: []ImportEntry{}},
+		names:   map[string]map[string]string{BundleItemScheme: {}, BundleJournalScheme: {}, BundleReportScheme: {}},
+		taken:   map[string]map[string]bool{BundleItemScheme: {}, BundleJournalScheme: {}, BundleReportScheme: {}},
+		failed:  map[string]map[string]bool{BundleItemScheme: {}, BundleJournalScheme: {}, BundleReportScheme: {}},
+		// Схемы объектов пакета может еще не быть в базе (dry_run), поэтому item_info проверяется по пакету
+		itemFields: map[string][]ItemField{},
+	}
+
+	for _, scheme := range bundle.ItemSchemes {
+		scheme := scheme
+		if err := scheme.Validation(); err != nil {
+			im.add(ImportEntry{Kind: BundleItemScheme, Name: scheme.Name, Action: ImportFailed, Error: err.Error()})
+			continue
+		}
+		target := im.place(BundleItemScheme, ItemSchemeCollection(tenant), scheme.Name,
+			func(name string) error {
+				scheme.Name = name
+				return scheme.Insert(tenant)
+			},
+			func(id primitive.ObjectID, version int64) error {
+				return UpdateItemScheme(scheme).Update(tenant, id.Hex(), version)
+			},
+			func(id primitive.ObjectID) error {
+				return UpdateItemScheme(scheme).CheckReferences(tenant, id.Hex())
+			})
+		if len(target) != 0 {
+			im.itemFields[target] = scheme.Fields
+		}
+	}
+
+	for _, scheme := range bundle.JournalSchemes {
+		scheme := scheme
+		if err := scheme.Validation(); err != nil {
+			im.add(ImportEntry{Kind: BundleJournalScheme, Name: scheme.Name, Action: ImportFailed, Error: err.Error()})
+			continue
+		}
+		item, err := im.resolve(BundleItemScheme, ItemSchemeCollection(tenant), scheme.Item)
+		if err != nil {
+			im.add(ImportEntry{Kind: BundleJournalScheme, Name: scheme.Name, Action: ImportFailed, Error: err.Error() + ": " + scheme.Item})
+			continue
+		}
+		scheme.Item = item
+		if fields, ok := im.itemFields[item]; ok {
+			err = checkItemInfo(scheme.ItemInfo, fields)
+		} else {
+			err = checkItemReference(tenant, item, scheme.ItemInfo)
+		}
+		if err != nil {
+			im.add(ImportEntry{Kind: BundleJournalScheme, Name: scheme.Name, Action: ImportFailed, Error: err.Error()})
+			continue
+		}
+		im.place(BundleJournalScheme, JournalSchemeCollection(tenant), scheme.Name,
+			func(name string) error {
+				scheme.Name = name
+				return scheme.Insert(tenant)
+			},
+			func(id primitive.ObjectID, version int64) error {
+				return UpdateJournalScheme(scheme).Update(tenant, id.Hex(), version)
+			}, nil)
+	}
+
+	for _, scheme := range bundle.ReportSchemes {
+		scheme := scheme
+		if err := scheme.Validation(); err != nil {
+			im.add(ImportEntry{Kind: BundleReportScheme, Name: scheme.Name, Action: ImportFailed, Error: err.Error()})
+			continue
+		}
+		journal, err := im.resolve(BundleJournalScheme, JournalSchemeCollection(tenant), scheme.Journal)
+		if err != nil {
+			im.add(ImportEntry{Kind: BundleReportScheme, Name: scheme.Name, Action: ImportFailed, Error: err.Error() + ": " + scheme.Journal})
+			continue
+		}
+		scheme.Journal = journal
+		im.place(BundleReportScheme, ReportSchemeCollection(tenant), scheme.Name,
+			func(name string) error {
+				scheme.Name = name
+				return scheme.Insert(tenant)
+			},
+			func(id primitive.ObjectID, version int64) error {
+				return UpdateReportScheme(scheme).Update(tenant, id.Hex(), version)
+			}, nil)
+	}
+
+	return im.report, nil
+}
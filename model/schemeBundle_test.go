@@ -0,0 +1,35 @@
+package model
+
+import "testing"
+
+func TestImporterResolve(t *testing.T) {
+	im := &importer{
+		report: &ImportReport{Entries: []ImportEntry{}},
+		names:  map[string]map[string]string{BundleItemScheme: {"scale": "scale_2"}, BundleJournalScheme: {}},
+		failed: map[string]map[string]bool{BundleItemScheme: {}, BundleJournalScheme: {}},
+	}
+	im.add(ImportEntry{Kind: BundleItemScheme, Name: "thermometer", Action: ImportFailed, Error: "invalid"})
+	im.add(ImportEntry{Kind: BundleJournalScheme, Name: "scales_calibration", Action: ImportCreated})
+
+	if im.report.Failed != 1 || len(im.report.Entries) != 2 {
+		t.Errorf("report = %+v, want one failed of two entries", im.report)
+	}
+
+	tests := []struct {
+		kind string
+		name string
+		want string
+		err  error
+	}{
+		{BundleItemScheme, "scale", "scale_2", nil},
+		{BundleItemScheme, "thermometer", "", ErrBundleDependency},
+	}
+
+	for _, test := range tests {
+		// Ни один из случаев не доходит до базы: коллекция не нужна
+		got, err := im.resolve(test.kind, nil, test.name)
+		if got != test.want || err != test.err {
+			t.Errorf("resolve(%s, %s) = %q, %v, want %q, %v", test.kind, test.name, got, err, test.want, test.err)
+		}
+	}
+}
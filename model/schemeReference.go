@@ -0,0 +1,311 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ItemNameInfo поле информации о позиции, которое есть у любого объекта независимо от схемы
+const ItemNameInfo = "name"
+
+// Errors godoc
+var (
+	ErrItemSchemeMissing    = errors.New("item scheme referenced by item does not exist")
+	ErrJournalSchemeMissing = errors.New("journal scheme referenced by journal does not exist")
+	ErrSchemeInUse          = errors.New("scheme is used by other schemes")
+	ErrSchemeRenameInUse    = errors.New("scheme used by other schemes cannot be renamed")
+	ErrItemFieldInUse       = errors.New("item fields used in item_info of journal schemes cannot be removed")
+)
+
+// SchemeRef краткое описание схемы в графе зависимостей
+type SchemeRef struct {
+	ID    primitive.ObjectID `bson:"_id" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Name  string             `bson:"name" json:"name" example:"scales_calibration"`
+	Title string             `bson:"title" json:"title" example:"Калибровка весов"`
+	// ItemInfo поля информации о позиции, которые использует схема журнала
+	ItemInfo []string `bson:"item_info,omitempty" json:"item_info,omitempty"`
+}
+
+// SchemeDependents схемы, которые ссылаются на схему напрямую или через другие схемы
+type SchemeDependents struct {
+	// JournalSchemes схемы журналов, которые ссылаются на схему объектов через Item
+	JournalSchemes []SchemeRef `json:"journal_schemes"`
+	// ReportSchemes схемы отчетов, которые ссылаются через Journal на схему журналов или на зависящие от схемы схемы журналов
+	ReportSchemes []SchemeRef `json:"report_schemes"`
+}
+
+// Empty на схему никто не ссылается
+func (d SchemeDependents) Empty() bool {
+	return len(d.JournalSchemes) == 0 && len(d.ReportSchemes) == 0
+}
+
+// DependentsError операция запрещена, потому что на схему ссылаются другие схемы
+type DependentsError struct {
+	Err        error
+	Dependents SchemeDependents
+}
+
+func (e *DependentsError) Error() string {
+	return e.Err.Error()
+}
+
+func schemeRefs(coll *db.Collection, filter bson.D) ([]SchemeRef, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter = append(bson.D{{Key: "deleted_at", Value: nil}}, filter...)
+	cur, err := coll.Find(timeout, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	refs := []SchemeRef{}
+	for cur.Next(timeout) {
+		var ref SchemeRef
+		if err := cur.Decode(&ref); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, cur.Err()
+}
+
+func schemeName(coll *db.Collection, id string) (string, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return "", err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var ref SchemeRef
+	err = coll.FindOne(timeout, bson.D{{Key: "_id", Value: objectID}, {Key: "deleted_at", Value: nil}}).Decode(&ref)
+	return ref.Name, err
+}
+
+func itemSchemeDependents(tenant db.Tenant, name string) (SchemeDependents, error) {
+	journals, err := schemeRefs(JournalSchemeCollection(tenant), bson.D{{Key: "item", Value: name}})
+	if err != nil {
+		return SchemeDependents{}, err
+	}
+
+	names := []string{}
+	for _, journal := range journals {
+		names = append(names, journal.Name)
+	}
+	reports, err := schemeRefs(ReportSchemeCollection(tenant), bson.D{{Key: "journal", Value: bson.D{{Key: "$in", Value: names}}}})
+	if err != nil {
+		return SchemeDependents{}, err
+	}
+
+	return SchemeDependents{JournalSchemes: journals, ReportSchemes: reports}, nil
+}
+
+func journalSchemeDependents(tenant db.Tenant, name string) (SchemeDependents, error) {
+	reports, err := schemeRefs(ReportSchemeCollection(tenant), bson.D{{Key: "journal", Value: name}})
+	if err != nil {
+		return SchemeDependents{}, err
+	}
+	return SchemeDependents{JournalSchemes: []SchemeRef{}, ReportSchemes: reports}, nil
+}
+
+// ItemSchemeDependents схемы журналов, которые используют схему объектов, и схемы отчетов по этим журналам
+func ItemSchemeDependents(tenant db.Tenant, id string) (SchemeDependents, error) {
+	name, err := schemeName(ItemSchemeCollection(tenant), id)
+	if err != nil {
+		return SchemeDependents{}, err
+	}
+	return itemSchemeDependents(tenant, name)
+}
+
+// JournalSchemeDependents схемы отчетов, которые используют схему журналов
+func JournalSchemeDependents(tenant db.Tenant, id string) (SchemeDependents, error) {
+	name, err := schemeName(JournalSchemeCollection(tenant), id)
+	if err != nil {
+		return SchemeDependents{}, err
+	}
+	return journalSchemeDependents(tenant, name)
+}
+
+// checkItemInfo проверяет, что поля информации о позиции есть в схеме объектов
+func checkItemInfo(itemInfo *[]string, fields []ItemField) error {
+	if itemInfo == nil {
+		return nil
+	}
+
+	names := []string{ItemNameInfo}
+	for _, field := range fields {
+		names = append(names, field.Name)
+	}
+	for _, info := range *itemInfo {
+		if !CheckIn(info, names) {
+			return &FieldError{Field: "item_info", Reason: "item scheme has no field " + info}
+		}
+	}
+	return nil
+}
+
+// checkItemReference проверяет, что схема объектов item существует и содержит поля itemInfo
+func checkItemReference(tenant db.Tenant, item string, itemInfo *[]string) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var scheme ItemScheme
+	err := ItemSchemeCollection(tenant).FindOne(timeout, bson.D{{Key: "name", Value: item}, {Key: "deleted_at", Value: nil}}).Decode(&scheme)
+	if err == mongo.ErrNoDocuments {
+		return ErrItemSchemeMissing
+	}
+	if err != nil {
+		return err
+	}
+
+	return checkItemInfo(itemInfo, scheme.Fields)
+}
+
+// checkJournalReference проверяет, что схема журналов journal существует
+func checkJournalReference(tenant db.Tenant, journal string) error {
+	_, _, exists, err := existingScheme(JournalSchemeCollection(tenant), journal)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrJournalSchemeMissing
+	}
+	return nil
+}
+
+// CheckReferences проверяет ссылку на схему объектов и поля информации о позиции
+func (s NewJournalScheme) CheckReferences(tenant db.Tenant) error {
+	return checkItemReference(tenant, s.Item, s.ItemInfo)
+}
+
+// CheckReferences проверяет ссылку на схему объектов и поля информации о позиции.
+// Схему журналов, на которую ссылаются схемы отчетов, нельзя переименовать
+func (s UpdateJournalScheme) CheckReferences(tenant db.Tenant, id string) error {
+	if err := checkItemReference(tenant, s.Item, s.ItemInfo); err != nil {
+		return err
+	}
+
+	name, err := schemeName(JournalSchemeCollection(tenant), id)
+	if err != nil || name == s.Name {
+		return err
+	}
+	dependents, err := journalSchemeDependents(tenant, name)
+	if err != nil {
+		return err
+	}
+	if !dependents.Empty() {
+		return &DependentsError{Err: ErrSchemeRenameInUse, Dependents: dependents}
+	}
+	return nil
+}
+
+// CheckReferences проверяет ссылку на схему журналов
+func (s NewReportScheme) CheckReferences(tenant db.Tenant) error {
+	return checkJournalReference(tenant, s.Journal)
+}
+
+// CheckReferences проверяет ссылку на схему журналов, если она задана
+func (s UpdateReportScheme) CheckReferences(tenant db.Tenant) error {
+	if len(s.Journal) == 0 {
+		return nil
+	}
+	return checkJournalReference(tenant, s.Journal)
+}
+
+// CheckReferences запрещает переименовать схему объектов, которую используют схемы журналов,
+// и удалить из нее поля, которые схемы журналов используют в item_info
+func (s UpdateItemScheme) CheckReferences(tenant db.Tenant, id string) error {
+	name, err := schemeName(ItemSchemeCollection(tenant), id)
+	if err != nil {
+		return err
+	}
+	dependents, err := itemSchemeDependents(tenant, name)
+	if err != nil || dependents.Empty() {
+		return err
+	}
+	if name != s.Name {
+		return &DependentsError{Err: ErrSchemeRenameInUse, Dependents: dependents}
+	}
+
+	broken := SchemeDependents{JournalSchemes: []SchemeRef{}, ReportSchemes: []SchemeRef{}}
+	for _, journal := range dependents.JournalSchemes {
+		if checkItemInfo(&journal.ItemInfo, s.Fields) != nil {
+			broken.JournalSchemes = append(broken.JournalSchemes, journal)
+		}
+	}
+	if !broken.Empty() {
+		return &DependentsError{Err: ErrItemFieldInUse, Dependents: broken}
+	}
+	return nil
+}
+
+// deleteSchemes помечает схемы из refs удаленными пользователем by
+func deleteSchemes(coll *db.Collection, refs []SchemeRef, by string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ids := bson.A{}
+	for _, ref := range refs {
+		ids = append(ids, ref.ID)
+	}
+	_, err := coll.UpdateMany(timeout,
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}, {Key: "deleted_at", Value: nil}},
+		bson.D{{Key: "$set", Value: softDelete(by, time.Now())}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}})
+	return err
+}
+
+// deleteWithDependents удаляет схему с версией version от имени пользователя by. Если на нее ссылаются другие схемы,
+// без cascade возвращается DependentsError, с cascade зависимые схемы удаляются вместе с ней
+func deleteWithDependents(tenant db.Tenant, coll *db.Collection, id string, version int64, by string, dependentsOf func(tenant db.Tenant, name string) (SchemeDependents, error), cascade bool) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var scheme struct {
+		Name    string `bson:"name"`
+		Version int64  `bson:"version"`
+	}
+	if err := coll.FindOne(timeout, bson.D{{Key: "_id", Value: objectID}, {Key: "deleted_at", Value: nil}}).Decode(&scheme); err != nil {
+		return err
+	}
+	if scheme.Version != version {
+		return db.ErrVersionMismatch
+	}
+
+	dependents, err := dependentsOf(tenant, scheme.Name)
+	if err != nil {
+		return err
+	}
+	if !dependents.Empty() {
+		if !cascade {
+			return &DependentsError{Err: ErrSchemeInUse, Dependents: dependents}
+		}
+		// Сначала отчеты, затем журналы: прерванное удаление не оставляет отчетов без схемы журнала
+		if err := deleteSchemes(ReportSchemeCollection(tenant), dependents.ReportSchemes, by); err != nil {
+			return err
+		}
+		if err := deleteSchemes(JournalSchemeCollection(tenant), dependents.JournalSchemes, by); err != nil {
+			return err
+		}
+	}
+
+	return updateVersioned(coll, objectID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: append(softDelete(by, time.Now()), bson.E{Key: "version", Value: version + 1})}})
+}
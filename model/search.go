@@ -0,0 +1,401 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"html"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Oxynger/JournalApp/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Типы результатов поиска
+const (
+	SearchJournal       = "journal"
+	SearchJournalScheme = "journal_scheme"
+	SearchOperator      = "operator"
+)
+
+// SearchLanguage язык текстовых индексов. Нужен для стемминга кириллических запросов
+const SearchLanguage = "russian"
+
+// searchLanguageOverride поле документа, из которого mongo берет язык. Указано поле,
+// которого нет в документах, чтобы значения вида "language" в журналах не ломали индекс
+const searchLanguageOverride = "search_language"
+
+const highlightSnippet = 40
+
+// ErrSearchQueryInvalid godoc
+var ErrSearchQueryInvalid = errors.New("search query is empty")
+
+// SearchResult найденный документ
+type SearchResult struct {
+	Type       string             `json:"type" example:"journal_scheme"`
+	ID         primitive.ObjectID `json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Title      string             `json:"title" example:"Учет и калибровка весов"`
+	Score      float64            `json:"score" example:"1.5"`
+	Highlights []string           `json:"highlights"`
+}
+
+type searchTarget struct {
+	coll   func(db.Tenant) *db.Collection
+	filter bson.D
+	// without поля, которые не возвращаются и не попадают в подсветку
+	without bson.D
+	// highlighted поля, из которых берется подсветка. Пустой список - все поля документа
+	highlighted []string
+	title       func(doc bson.M) string
+}
+
+type searchIndex struct {
+	coll  func(db.Tenant) *db.Collection
+	model mongo.IndexModel
+}
+
+var searchTargets = map[string]searchTarget{
+	SearchJournal: {
+		coll:        journalCollection,
+		filter:      bson.D{{Key: "deleted_at", Value: nil}},
+		without:     bson.D{{Key: "search_text", Value: 0}},
+		highlighted: journalSearchFields,
+		title: func(doc bson.M) string {
+			title := stringValue(doc["scheme"])
+			if date, ok := doc["date"].(primitive.DateTime); ok {
+				title += " " + time.Unix(int64(date)/1000, 0).Format("2006-01-02")
+			}
+			return strings.TrimSpace(title)
+		},
+	},
+	SearchJournalScheme: {
+		coll:   JournalSchemeCollection,
+		filter: bson.D{{Key: "deleted_at", Value: nil}},
+		title: func(doc bson.M) string {
+			return stringValue(doc["title"])
+		},
+	},
+	SearchOperator: {
+		coll:    operatorCollection,
+		filter:  bson.D{{Key: "deleted_at", Value: nil}},
+		without: bson.D{{Key: "password", Value: 0}, {Key: "reset_code", Value: 0}},
+		title: func(doc bson.M) string {
+			return strings.Join(strings.Fields(stringValue(doc["last_name"])+" "+stringValue(doc["first_name"])+" "+stringValue(doc["middle_name"])), " ")
+		},
+	},
+}
+
+// journalSearchFields поля журнала, по которым он ищется
+var journalSearchFields = []string{"values", "item_info"}
+
+// journalSearchText строковые значения полей и информации о позиции журнала для текстового индекса
+func journalSearchText(journal Journal) string {
+	doc := bson.M{"values": journal.Values, "item_info": journal.ItemInfo}
+	return strings.Join(stringValues(doc), "\n")
+}
+
+// MigrateSearchText заполняет search_text журналов, записанных до его появления.
+// Текстовый индекс журналов строится по search_text, без него журнал не находится
+func MigrateSearchText(tenant db.Tenant) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "search_text", Value: bson.D{{Key: "$exists", Value: false}}}, {Key: "archive", Value: nil}}
+	projection := options.Find().SetProjection(bson.D{{Key: "values", Value: 1}, {Key: "item_info", Value: 1}})
+	cur, err := journalCollection(tenant).Find(timeout, filter, projection)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(timeout)
+
+	for cur.Next(timeout) {
+		var journal Journal
+		if err := cur.Decode(&journal); err != nil {
+			return err
+		}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "search_text", Value: journalSearchText(journal)}}}}
+		if _, err := journalCollection(tenant).UpdateOne(timeout, bson.D{{Key: "_id", Value: journal.ID}}, update); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// searchIndexModels текстовые индексы для поиска
+func searchIndexModels() []searchIndex {
+	textOptions := func(weights bson.D) *options.IndexOptions {
+		opts := options.Index().
+			SetName("search").
+			SetDefaultLanguage(SearchLanguage).
+			SetLanguageOverride(searchLanguageOverride)
+		if weights != nil {
+			opts.SetWeights(weights)
+		}
+		return opts
+	}
+
+	return []searchIndex{
+		{JournalSchemeCollection, mongo.IndexModel{
+			Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "fields.title", Value: "text"}},
+			Options: textOptions(bson.D{{Key: "title", Value: 10}, {Key: "fields.title", Value: 3}}),
+		}},
+		{operatorCollection, mongo.IndexModel{
+			Keys:    bson.D{{Key: "last_name", Value: "text"}, {Key: "first_name", Value: "text"}, {Key: "middle_name", Value: "text"}},
+			Options: textOptions(nil),
+		}},
+		// В журналах значения полей и информация о позиции хранятся в произвольных ключах,
+		// поэтому индексируется их строковая выжимка search_text, а не служебные поля журнала
+		{journalCollection, mongo.IndexModel{
+			Keys:    bson.D{{Key: "search_text", Value: "text"}},
+			Options: textOptions(nil),
+		}},
+	}
+}
+
+// Search ищет документы по тексту. types ограничивает типы результатов, пустой список - все типы
+func Search(tenant db.Tenant, text string, types []string, limit int64) ([]SearchResult, error) {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return nil, ErrSearchQueryInvalid
+	}
+
+	if len(types) == 0 {
+		types = []string{SearchJournal, SearchJournalScheme, SearchOperator}
+	}
+
+	results := []SearchResult{}
+	for _, kind := range types {
+		target, ok := searchTargets[kind]
+		if !ok {
+			return nil, errors.New("unknown search type " + kind)
+		}
+
+		found, err := searchCollection(tenant, kind, target, text, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, found...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	if int64(len(results)) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func searchCollection(tenant db.Tenant, kind string, target searchTarget, text string, limit int64) ([]SearchResult, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := append(bson.D{{Key: "$text", Value: bson.D{
+		{Key: "$search", Value: text},
+		{Key: "$language", Value: SearchLanguage},
+	}}}, target.filter...)
+
+	score := bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}
+	findOptions := options.Find().
+		SetProjection(append(append(bson.D{}, score...), target.without...)).
+		SetSort(score).
+		SetLimit(limit)
+
+	cur, err := target.coll(tenant).Find(timeout, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	results := []SearchResult{}
+	for cur.Next(timeout) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		highlighted := doc
+		if len(target.highlighted) != 0 {
+			highlighted = bson.M{}
+			for _, field := range target.highlighted {
+				highlighted[field] = doc[field]
+			}
+		}
+
+		result := SearchResult{
+			Type:       kind,
+			Title:      target.title(doc),
+			Highlights: highlights(highlighted, text),
+		}
+		result.ID, _ = doc["_id"].(primitive.ObjectID)
+		result.Score, _ = doc["score"].(float64)
+
+		results = append(results, result)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// highlights возвращает фрагменты строковых значений документа, в которых встречаются слова запроса.
+// Найденное слово выделяется тегом <em>, остальной текст экранируется для HTML. Слова сравниваются по началу основы,
+// чтобы "ремонт" подсвечивал и "ремонта", как это делает стемминг mongo
+func highlights(doc bson.M, text string) []string {
+	stems := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+		runes := []rune(strings.Trim(word, `"-`))
+		if len(runes) == 0 {
+			continue
+		}
+		size := len(runes) - 2
+		if size < 4 {
+			size = len(runes)
+		}
+		stems = append(stems, string(runes[:size]))
+	}
+
+	snippets := []string{}
+	for _, value := range stringValues(doc) {
+		if snippet, ok := highlight(value, stems); ok {
+			snippets = append(snippets, snippet)
+		}
+	}
+
+	return snippets
+}
+
+// highlight фрагмент value вокруг первого найденного слова. Текст экранируется для HTML,
+// теги <em> добавляются только вокруг найденных слов
+func highlight(value string, stems []string) (string, bool) {
+	words := strings.FieldsFunc(value, isSeparator)
+	lower := []rune(strings.ToLower(value))
+	runes := []rune(value)
+
+	// Границы найденных слов в runes
+	var spans [][2]int
+	position := 0
+	for _, word := range words {
+		start := indexRunes(lower, []rune(strings.ToLower(word)), position)
+		if start < 0 {
+			continue
+		}
+		end := start + len([]rune(word))
+		position = end
+
+		for _, stem := range stems {
+			if strings.HasPrefix(strings.ToLower(word), stem) {
+				spans = append(spans, [2]int{start, end})
+				break
+			}
+		}
+	}
+
+	if len(spans) == 0 {
+		return "", false
+	}
+
+	first := spans[0][0]
+	from := first - highlightSnippet
+	prefix := "…"
+	if from <= 0 {
+		from, prefix = 0, ""
+	}
+	to := first + highlightSnippet*2
+	for _, span := range spans {
+		// Слово, которое начинается во фрагменте, не обрезается
+		if span[0] < to && span[1] > to {
+			to = span[1]
+		}
+	}
+	suffix := "…"
+	if to >= len(runes) {
+		to, suffix = len(runes), ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString(prefix)
+	last := from
+	for _, span := range spans {
+		if span[0] >= to {
+			break
+		}
+		builder.WriteString(html.EscapeString(string(runes[last:span[0]])))
+		builder.WriteString("<em>" + html.EscapeString(string(runes[span[0]:span[1]])) + "</em>")
+		last = span[1]
+	}
+	builder.WriteString(html.EscapeString(string(runes[last:to])))
+	builder.WriteString(suffix)
+
+	return builder.String(), true
+}
+
+func indexRunes(text, sub []rune, from int) int {
+	for i := from; i+len(sub) <= len(text); i++ {
+		match := true
+		for j := range sub {
+			if text[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+func stringValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case bson.M:
+		values := []string{}
+		for key, item := range v {
+			if key == "_id" || key == "score" {
+				continue
+			}
+			values = append(values, stringValues(item)...)
+		}
+		return values
+	case map[string]interface{}:
+		return stringValues(bson.M(v))
+	case bson.D:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, stringValues(item.Value)...)
+		}
+		return values
+	case bson.A:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, stringValues(item)...)
+		}
+		return values
+	case []interface{}:
+		return stringValues(bson.A(v))
+	default:
+		return nil
+	}
+}
+
+func stringValue(value interface{}) string {
+	text, _ := value.(string)
+	return text
+}
@@ -0,0 +1,679 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxShiftCalendarDays на сколько дней за раз можно составить график смен
+const MaxShiftCalendarDays = 92
+
+// Errors godoc
+var (
+	ErrShiftStartInvalid     = errors.New("start must be in HH:MM format")
+	ErrShiftDurationInvalid  = errors.New("duration must be from 1 to 1440 minutes")
+	ErrShiftWeekdaysInvalid  = errors.New("weekdays must be from 0 (sunday) to 6 (saturday)")
+	ErrShiftWorkshopInvalid  = errors.New("workshop not found")
+	ErrShiftPeriodInvalid    = errors.New("calendar period must be from 1 to 92 days")
+	ErrNotOnShift            = errors.New("operator is not on this shift")
+	ErrShiftHandedOver       = errors.New("shift is already handed over")
+	ErrHandoverAcknowledged  = errors.New("handover is already acknowledged")
+	ErrHandoverSameOperator  = errors.New("outgoing operator can not acknowledge own handover")
+	ErrShiftOperatorsInvalid = errors.New("operator not found")
+)
+
+// ShiftTemplate шаблон смены: время начала, продолжительность и дни недели
+type ShiftTemplate struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	db.Model `bson:",inline"`
+	Name     string `bson:"name" json:"name" example:"day"`
+	Title    string `bson:"title" json:"title" example:"Дневная смена"`
+	// Start время начала смены по местному времени сервера
+	Start string `bson:"start" json:"start" example:"08:00"`
+	// Duration продолжительность смены в минутах. Смена может переходить через полночь
+	Duration int `bson:"duration" json:"duration" example:"720"`
+	// Weekdays дни недели, в которые есть смена: 0 - воскресенье. Пустой список - каждый день
+	Weekdays []int `bson:"weekdays" json:"weekdays" example:"1,2,3,4,5"`
+	// Workshop цех, для которого составляется смена. Пустой - смена для всей площадки
+	Workshop *primitive.ObjectID `bson:"workshop" json:"workshop" example:"5ca10d9d015c736a72b7b3b9"`
+}
+
+// Shift смена в графике
+type Shift struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	db.Model  `bson:",inline"`
+	Template  primitive.ObjectID   `bson:"template" json:"template" example:"5ca10d9d015c736a72b7b3b8"`
+	Workshop  *primitive.ObjectID  `bson:"workshop" json:"workshop" example:"5ca10d9d015c736a72b7b3b9"`
+	Title     string               `bson:"title" json:"title" example:"Дневная смена"`
+	Start     time.Time            `bson:"start" json:"start"`
+	End       time.Time            `bson:"end" json:"end"`
+	Operators []primitive.ObjectID `bson:"operators" json:"operators"`
+}
+
+// ShiftCalendar параметры составления графика смен по шаблону
+type ShiftCalendar struct {
+	Template  primitive.ObjectID   `json:"template" binding:"required" example:"5ca10d9d015c736a72b7b3b8"`
+	From      time.Time            `json:"from" binding:"required"`
+	To        time.Time            `json:"to" binding:"required"`
+	Operators []primitive.ObjectID `json:"operators"`
+}
+
+// HandoverIssue открытый вопрос, который уходящая смена передает следующей
+type HandoverIssue struct {
+	Journal *primitive.ObjectID `bson:"journal,omitempty" json:"journal,omitempty" example:"5ca10d9d015c736a72b7b3ba"`
+	Text    string              `bson:"text" json:"text" example:"Весы №3 показывают отклонение, вызван наладчик"`
+}
+
+// ShiftHandover передача смены
+type ShiftHandover struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	db.Model  `bson:",inline"`
+	Shift     primitive.ObjectID  `bson:"shift" json:"shift" example:"5ca10d9d015c736a72b7b3b7"`
+	NextShift *primitive.ObjectID `bson:"next_shift" json:"next_shift" example:"5ca10d9d015c736a72b7b3b6"`
+	Issues    []HandoverIssue     `bson:"issues" json:"issues"`
+
+	OutgoingOperator string    `bson:"outgoing_operator" json:"outgoing_operator" example:"olegov"`
+	SignedAt         time.Time `bson:"signed_at" json:"signed_at"`
+
+	IncomingOperator string     `bson:"incoming_operator" json:"incoming_operator" example:"ivanov"`
+	AcknowledgedAt   *time.Time `bson:"acknowledged_at" json:"acknowledged_at"`
+	Comment          string     `bson:"comment" json:"comment" example:"Принято"`
+}
+
+// shiftFields поля, доступные в фильтрах и сортировке графика смен
+var shiftFields = db.Fields{
+	"template": {Key: "template", Type: db.ObjectIDField},
+	"workshop": {Key: "workshop", Type: db.ObjectIDField},
+	"start":    {Key: "start", Type: db.TimeField, Sort: true},
+	"end":      {Key: "end", Type: db.TimeField, Sort: true},
+}
+
+func shiftTemplateCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("shiftTemplate")
+}
+
+func shiftCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("shift")
+}
+
+func shiftHandoverCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("shiftHandover")
+}
+
+// startClock час и минута начала смены
+func (t ShiftTemplate) startClock() (int, int, error) {
+	start, err := time.Parse("15:04", t.Start)
+	if err != nil {
+		return 0, 0, ErrShiftStartInvalid
+	}
+	return start.Hour(), start.Minute(), nil
+}
+
+func (t ShiftTemplate) validate(tenant db.Tenant) error {
+	if len(t.Name) == 0 {
+		return ErrNameInvalid
+	}
+	if _, _, err := t.startClock(); err != nil {
+		return err
+	}
+	if t.Duration <= 0 || t.Duration > 24*60 {
+		return ErrShiftDurationInvalid
+	}
+	for _, day := range t.Weekdays {
+		if day < 0 || day > 6 {
+			return ErrShiftWeekdaysInvalid
+		}
+	}
+	if t.Workshop != nil {
+		workshop, err := orgUnitFindOne(tenant, *t.Workshop)
+		if err != nil || workshop.Kind != OrgWorkshop {
+			return ErrShiftWorkshopInvalid
+		}
+	}
+	return nil
+}
+
+// ShiftTemplatesAll godoc
+func ShiftTemplatesAll(tenant db.Tenant) ([]ShiftTemplate, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "start", Value: 1}})
+	cur, err := shiftTemplateCollection(tenant).Find(timeout, bson.D{{Key: "deleted_at", Value: nil}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	templates := []ShiftTemplate{}
+	for cur.Next(timeout) {
+		var template ShiftTemplate
+		if err := cur.Decode(&template); err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+
+	return templates, cur.Err()
+}
+
+func shiftTemplateFindOne(tenant db.Tenant, id primitive.ObjectID) (*ShiftTemplate, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var template ShiftTemplate
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "deleted_at", Value: nil}}
+	if err := shiftTemplateCollection(tenant).FindOne(timeout, filter).Decode(&template); err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+// AddShiftTemplate godoc
+func AddShiftTemplate(tenant db.Tenant, template ShiftTemplate) (*ShiftTemplate, error) {
+	if err := template.validate(tenant); err != nil {
+		return nil, err
+	}
+	if template.Weekdays == nil {
+		template.Weekdays = []int{}
+	}
+
+	template.ID = primitive.NilObjectID
+	template.CreatedAt = time.Now()
+	template.UpdatedAt = time.Now()
+	template.DeletedAt = nil
+	template.Version = 1
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	insertedResault, err := shiftTemplateCollection(tenant).InsertOne(timeout, template)
+	if err != nil {
+		return nil, err
+	}
+
+	return shiftTemplateFindOne(tenant, insertedResault.InsertedID.(primitive.ObjectID))
+}
+
+// ShiftTemplateUpdate меняет шаблон. Уже составленный график не меняется
+func ShiftTemplateUpdate(tenant db.Tenant, id string, version int64, template ShiftTemplate) (*ShiftTemplate, error) {
+	templateID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := template.validate(tenant); err != nil {
+		return nil, err
+	}
+	if template.Weekdays == nil {
+		template.Weekdays = []int{}
+	}
+
+	set := bson.D{
+		{Key: "name", Value: template.Name},
+		{Key: "title", Value: template.Title},
+		{Key: "start", Value: template.Start},
+		{Key: "duration", Value: template.Duration},
+		{Key: "weekdays", Value: template.Weekdays},
+		{Key: "workshop", Value: template.Workshop},
+		{Key: "updated_at", Value: time.Now()},
+		{Key: "version", Value: version + 1},
+	}
+	err = updateVersioned(shiftTemplateCollection(tenant), templateID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		return nil, err
+	}
+
+	return shiftTemplateFindOne(tenant, templateID)
+}
+
+// ShiftTemplateDelete помечает шаблон удаленным. Уже составленный график не меняется
+func ShiftTemplateDelete(tenant db.Tenant, id string, version int64) (*ShiftTemplate, error) {
+	templateID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := shiftTemplateFindOne(tenant, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	set := bson.D{
+		{Key: "deleted_at", Value: now},
+		{Key: "version", Value: version + 1},
+	}
+	if err := updateVersioned(shiftTemplateCollection(tenant), templateID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: set}}); err != nil {
+		return nil, err
+	}
+
+	template.DeletedAt = &now
+	template.Version = version + 1
+	return template, nil
+}
+
+func checkShiftOperators(tenant db.Tenant, operators []primitive.ObjectID) error {
+	for _, operatorID := range operators {
+		if _, err := operatorFindOne(tenant, operatorID); err != nil {
+			return ErrShiftOperatorsInvalid
+		}
+	}
+	return nil
+}
+
+// GenerateShifts составляет график смен по шаблону на дни [from, to).
+// Смены, которые уже есть в графике на это время, не дублируются
+func GenerateShifts(tenant db.Tenant, calendar ShiftCalendar) ([]Shift, error) {
+	template, err := shiftTemplateFindOne(tenant, calendar.Template)
+	if err != nil {
+		return nil, err
+	}
+	hour, minute, err := template.startClock()
+	if err != nil {
+		return nil, err
+	}
+
+	from := time.Date(calendar.From.Year(), calendar.From.Month(), calendar.From.Day(), 0, 0, 0, 0, time.Local)
+	to := time.Date(calendar.To.Year(), calendar.To.Month(), calendar.To.Day(), 0, 0, 0, 0, time.Local)
+	if !from.Before(to) || to.Sub(from) > MaxShiftCalendarDays*24*time.Hour {
+		return nil, ErrShiftPeriodInvalid
+	}
+
+	if calendar.Operators == nil {
+		calendar.Operators = []primitive.ObjectID{}
+	}
+	if err := checkShiftOperators(tenant, calendar.Operators); err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	shifts := []Shift{}
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if len(template.Weekdays) != 0 && !containsWeekday(template.Weekdays, day.Weekday()) {
+			continue
+		}
+
+		// время начала собирается из даты, а не прибавляется к полуночи: в день перевода часов сутки не равны 24 часам
+		start := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, time.Local)
+		shift := Shift{
+			Template:  template.ID,
+			Workshop:  template.Workshop,
+			Title:     template.Title,
+			Start:     start,
+			End:       start.Add(time.Duration(template.Duration) * time.Minute),
+			Operators: calendar.Operators,
+		}
+		shift.CreatedAt = time.Now()
+		shift.UpdatedAt = time.Now()
+		shift.Version = 1
+
+		exists := bson.D{{Key: "template", Value: template.ID}, {Key: "start", Value: start}, {Key: "deleted_at", Value: nil}}
+		count, err := shiftCollection(tenant).CountDocuments(timeout, exists)
+		if err != nil {
+			return nil, err
+		}
+		if count != 0 {
+			continue
+		}
+
+		insertedResault, err := shiftCollection(tenant).InsertOne(timeout, shift)
+		if err != nil {
+			return nil, err
+		}
+		shift.ID = insertedResault.InsertedID.(primitive.ObjectID)
+
+		shifts = append(shifts, shift)
+	}
+
+	return shifts, nil
+}
+
+func containsWeekday(weekdays []int, weekday time.Weekday) bool {
+	for _, day := range weekdays {
+		if time.Weekday(day) == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// ShiftsAll график смен
+func ShiftsAll(tenant db.Tenant, query db.Query) (db.Page, error) {
+	var list []Shift
+	return db.FindPage(shiftCollection(tenant), bson.D{{Key: "deleted_at", Value: nil}}, shiftFields, query, nil, &list)
+}
+
+func shiftFindOne(tenant db.Tenant, id primitive.ObjectID) (*Shift, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var shift Shift
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "deleted_at", Value: nil}}
+	if err := shiftCollection(tenant).FindOne(timeout, filter).Decode(&shift); err != nil {
+		return nil, err
+	}
+
+	return &shift, nil
+}
+
+// ShiftOne godoc
+func ShiftOne(tenant db.Tenant, id string) (*Shift, error) {
+	shiftID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return shiftFindOne(tenant, shiftID)
+}
+
+// SetShiftOperators задает, кто работает в смене. Список заменяет прежний
+func SetShiftOperators(tenant db.Tenant, id string, version int64, operators []primitive.ObjectID) (*Shift, error) {
+	shiftID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if operators == nil {
+		operators = []primitive.ObjectID{}
+	}
+	if err := checkShiftOperators(tenant, operators); err != nil {
+		return nil, err
+	}
+
+	set := bson.D{
+		{Key: "operators", Value: operators},
+		{Key: "updated_at", Value: time.Now()},
+		{Key: "version", Value: version + 1},
+	}
+	err = updateVersioned(shiftCollection(tenant), shiftID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		return nil, err
+	}
+
+	return shiftFindOne(tenant, shiftID)
+}
+
+// ShiftDelete убирает смену из графика
+func ShiftDelete(tenant db.Tenant, id string, version int64) (*Shift, error) {
+	shift, err := ShiftOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	set := bson.D{
+		{Key: "deleted_at", Value: now},
+		{Key: "version", Value: version + 1},
+	}
+	if err := updateVersioned(shiftCollection(tenant), shift.ID, version, bson.D{{Key: "deleted_at", Value: nil}}, bson.D{{Key: "$set", Value: set}}); err != nil {
+		return nil, err
+	}
+
+	shift.DeletedAt = &now
+	shift.Version = version + 1
+	return shift, nil
+}
+
+// ActiveShifts смены, которые идут в момент at
+func ActiveShifts(tenant db.Tenant, at time.Time) ([]Shift, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "start", Value: bson.D{{Key: "$lte", Value: at}}},
+		{Key: "end", Value: bson.D{{Key: "$gt", Value: at}}},
+		{Key: "deleted_at", Value: nil},
+	}
+	cur, err := shiftCollection(tenant).Find(timeout, filter, options.Find().SetSort(bson.D{{Key: "start", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	shifts := []Shift{}
+	for cur.Next(timeout) {
+		var shift Shift
+		if err := cur.Decode(&shift); err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, shift)
+	}
+
+	return shifts, cur.Err()
+}
+
+// activeShift смена, к которой относится запись журнала в момент at: смена цеха, к которому относится журнал,
+// иначе смена всей площадки. Смена чужого цеха журналу не назначается.
+// Ошибки только логируются: без смены журнал все равно должен сохраниться
+func activeShift(tenant db.Tenant, journal Journal, at time.Time) *primitive.ObjectID {
+	shifts, err := ActiveShifts(tenant, at)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	var common *primitive.ObjectID
+	for i := range shifts {
+		shift := shifts[i]
+		if shift.Workshop == nil {
+			if common == nil {
+				common = &shift.ID
+			}
+			continue
+		}
+
+		scope, err := orgScopeOf(tenant, []primitive.ObjectID{*shift.Workshop})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if scope.contains(journal) {
+			return &shift.ID
+		}
+	}
+
+	return common
+}
+
+// onShift проверяет, что контроллер с логином login работает в смене
+func onShift(tenant db.Tenant, shift *Shift, login string) bool {
+	account, err := findOperatorAccount(tenant, login)
+	if err != nil {
+		return false
+	}
+
+	for _, operatorID := range shift.Operators {
+		if operatorID == account.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// nextShift следующая смена того же цеха
+func nextShift(tenant db.Tenant, shift *Shift) (*primitive.ObjectID, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "workshop", Value: shift.Workshop},
+		{Key: "start", Value: bson.D{{Key: "$gt", Value: shift.Start}}},
+		{Key: "deleted_at", Value: nil},
+	}
+
+	var next Shift
+	err := shiftCollection(tenant).FindOne(timeout, filter, options.FindOne().SetSort(bson.D{{Key: "start", Value: 1}})).Decode(&next)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &next.ID, nil
+}
+
+// HandOverShift уходящий контроллер подписывает передачу смены. К переданным вопросам
+// автоматически добавляются журналы смены без действующей росписи за смену или за ее день.
+// operatorOnly требует, чтобы by работал в смене
+func HandOverShift(tenant db.Tenant, id string, by string, operatorOnly bool, issues []HandoverIssue) (*ShiftHandover, error) {
+	shift, err := ShiftOne(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if operatorOnly && !onShift(tenant, shift, by) {
+		return nil, ErrNotOnShift
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := shiftHandoverCollection(tenant).CountDocuments(timeout, bson.D{{Key: "shift", Value: shift.ID}})
+	if err != nil {
+		return nil, err
+	}
+	if count != 0 {
+		return nil, ErrShiftHandedOver
+	}
+
+	if issues == nil {
+		issues = []HandoverIssue{}
+	}
+
+	// Ежедневный журнал после росписи остается открытым, поэтому закрытие проверяется по росписям
+	startDay := time.Date(shift.Start.Year(), shift.Start.Month(), shift.Start.Day(), 0, 0, 0, 0, time.Local)
+	endDay := time.Date(shift.End.Year(), shift.End.Month(), shift.End.Day(), 0, 0, 0, 0, time.Local)
+	closed := bson.D{
+		{Key: "voided", Value: nil},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "shift", Value: shift.ID}},
+			bson.D{{Key: "shift", Value: nil}, {Key: "date", Value: bson.D{{Key: "$gte", Value: startDay}, {Key: "$lte", Value: endDay}}}},
+		}},
+	}
+	openFilter := bson.D{
+		{Key: "shift", Value: shift.ID},
+		{Key: "closings", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$elemMatch", Value: closed}}}}},
+		{Key: "deleted_at", Value: nil},
+	}
+	cur, err := journalCollection(tenant).Find(timeout, openFilter, options.Find().SetProjection(bson.D{{Key: "scheme", Value: 1}, {Key: "item", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	for cur.Next(timeout) {
+		var journal Journal
+		if err := cur.Decode(&journal); err != nil {
+			cur.Close(timeout)
+			return nil, err
+		}
+		journalID := journal.ID
+		issues = append(issues, HandoverIssue{
+			Journal: &journalID,
+			Text:    "Журнал " + journal.Scheme + " " + journal.Item + " не закрыт за смену",
+		})
+	}
+	cur.Close(timeout)
+
+	next, err := nextShift(tenant, shift)
+	if err != nil {
+		return nil, err
+	}
+
+	handover := ShiftHandover{
+		Shift:            shift.ID,
+		NextShift:        next,
+		Issues:           issues,
+		OutgoingOperator: by,
+		SignedAt:         time.Now(),
+	}
+	handover.CreatedAt = time.Now()
+	handover.UpdatedAt = time.Now()
+	handover.Version = 1
+
+	insertedResault, err := shiftHandoverCollection(tenant).InsertOne(timeout, handover)
+	if err != nil {
+		return nil, err
+	}
+	handover.ID = insertedResault.InsertedID.(primitive.ObjectID)
+
+	return &handover, nil
+}
+
+func shiftHandoverFindOne(tenant db.Tenant, filter bson.D) (*ShiftHandover, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var handover ShiftHandover
+	if err := shiftHandoverCollection(tenant).FindOne(timeout, filter).Decode(&handover); err != nil {
+		return nil, err
+	}
+
+	return &handover, nil
+}
+
+// ShiftHandoverOf передача смены id
+func ShiftHandoverOf(tenant db.Tenant, id string) (*ShiftHandover, error) {
+	shiftID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return shiftHandoverFindOne(tenant, bson.D{{Key: "shift", Value: shiftID}})
+}
+
+// AcknowledgeHandover приходящий контроллер подтверждает, что принял смену и переданные вопросы.
+// operatorOnly требует, чтобы by работал в следующей смене
+func AcknowledgeHandover(tenant db.Tenant, id string, by string, operatorOnly bool, comment string) (*ShiftHandover, error) {
+	handoverID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	handover, err := shiftHandoverFindOne(tenant, bson.D{{Key: "_id", Value: handoverID}})
+	if err != nil {
+		return nil, err
+	}
+	if handover.AcknowledgedAt != nil {
+		return nil, ErrHandoverAcknowledged
+	}
+	if handover.OutgoingOperator == by {
+		return nil, ErrHandoverSameOperator
+	}
+	if operatorOnly {
+		if handover.NextShift == nil {
+			return nil, ErrNotOnShift
+		}
+		next, err := shiftFindOne(tenant, *handover.NextShift)
+		if err != nil || !onShift(tenant, next, by) {
+			return nil, ErrNotOnShift
+		}
+	}
+
+	set := bson.D{
+		{Key: "incoming_operator", Value: by},
+		{Key: "acknowledged_at", Value: time.Now()},
+		{Key: "comment", Value: comment},
+		{Key: "updated_at", Value: time.Now()},
+		{Key: "version", Value: handover.Version + 1},
+	}
+	filter := bson.D{{Key: "acknowledged_at", Value: nil}}
+	err = updateVersioned(shiftHandoverCollection(tenant), handoverID, handover.Version, filter, bson.D{{Key: "$set", Value: set}})
+	if err == db.ErrVersionMismatch || err == mongo.ErrNoDocuments {
+		return nil, ErrHandoverAcknowledged
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return shiftHandoverFindOne(tenant, bson.D{{Key: "_id", Value: handoverID}})
+}
@@ -0,0 +1,487 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Типы контрольных карт
+const (
+	// ChartIndividuals карта индивидуальных значений и скользящих размахов (I-MR)
+	ChartIndividuals = "imr"
+	// ChartXbarR карта средних и размахов. Подгруппа - значения одного дня
+	ChartXbarR = "xbar_r"
+)
+
+// Правила Western Electric
+const (
+	RuleBeyond3Sigma = "beyond_3_sigma"
+	Rule2Of3Beyond2  = "2_of_3_beyond_2_sigma"
+	Rule4Of5Beyond1  = "4_of_5_beyond_1_sigma"
+	Rule8OneSide     = "8_on_one_side"
+)
+
+// Errors godoc
+var (
+	ErrChartInvalid     = errors.New("chart must be imr or xbar_r")
+	ErrBaselineTooShort = errors.New("baseline has not enough values")
+)
+
+// Константы контрольных карт по размеру подгруппы n: A2, D3, D4, d2
+var xbarRConstants = map[int][4]float64{
+	2:  {1.880, 0, 3.267, 1.128},
+	3:  {1.023, 0, 2.574, 1.693},
+	4:  {0.729, 0, 2.282, 2.059},
+	5:  {0.577, 0, 2.114, 2.326},
+	6:  {0.483, 0, 2.004, 2.534},
+	7:  {0.419, 0.076, 1.924, 2.704},
+	8:  {0.373, 0.136, 1.864, 2.847},
+	9:  {0.337, 0.184, 1.816, 2.970},
+	10: {0.308, 0.223, 1.777, 3.078},
+}
+
+// d2 для скользящего размаха из двух точек
+const movingRangeD2 = 1.128
+
+// ControlLimits контрольные границы поля журнала для позиции
+type ControlLimits struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	Scheme string             `bson:"scheme" json:"scheme" example:"scales_calibration"`
+	Field  string             `bson:"field" json:"field" example:"result"`
+	Item   string             `bson:"item" json:"item" example:"scale"`
+	Chart  string             `bson:"chart" json:"chart" example:"imr"`
+
+	// Границы карты значений (индивидуальных или средних)
+	Center float64 `bson:"center" json:"center" example:"2"`
+	UCL    float64 `bson:"ucl" json:"ucl" example:"2.03"`
+	LCL    float64 `bson:"lcl" json:"lcl" example:"1.97"`
+	// Sigma одна сигма точки карты значений
+	Sigma float64 `bson:"sigma" json:"sigma" example:"0.01"`
+
+	// Границы карты размахов
+	RangeCenter float64 `bson:"range_center" json:"range_center" example:"0.011"`
+	RangeUCL    float64 `bson:"range_ucl" json:"range_ucl" example:"0.036"`
+	RangeLCL    float64 `bson:"range_lcl" json:"range_lcl" example:"0"`
+
+	// SubgroupSize размер подгруппы для xbar_r
+	SubgroupSize int `bson:"subgroup_size,omitempty" json:"subgroup_size,omitempty" example:"5"`
+
+	BaselineFrom time.Time `bson:"baseline_from" json:"baseline_from"`
+	BaselineTo   time.Time `bson:"baseline_to" json:"baseline_to"`
+	ComputedAt   time.Time `bson:"computed_at" json:"computed_at"`
+}
+
+// SPCWarning нарушение правила контрольной карты
+type SPCWarning struct {
+	Field string  `bson:"field" json:"field" example:"result"`
+	Rule  string  `bson:"rule" json:"rule" example:"beyond_3_sigma"`
+	Value float64 `bson:"value" json:"value" example:"2.05"`
+}
+
+// SPCPoint точка контрольной карты
+type SPCPoint struct {
+	Date       time.Time `json:"date"`
+	Value      float64   `json:"value" example:"2.001"`
+	Range      *float64  `json:"range,omitempty" example:"0.004"`
+	Violations []string  `json:"violations"`
+}
+
+// SPCChart контрольная карта за период
+type SPCChart struct {
+	Limits ControlLimits `json:"limits"`
+	Points []SPCPoint    `json:"points"`
+}
+
+type spcValue struct {
+	Date  time.Time
+	Value float64
+}
+
+func controlLimitsCollection(tenant db.Tenant) *db.Collection {
+	return tenant.Collection("controlLimits")
+}
+
+// numericValue приводит значение поля журнала к числу. Значения приходят и строками, и числами
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// journalValues числовые значения поля журналов позиции за период в порядке дат
+func journalValues(tenant db.Tenant, scheme, item, field string, from, to time.Time) ([]spcValue, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "scheme", Value: scheme},
+		{Key: "item", Value: item},
+		{Key: "deleted_at", Value: nil},
+		{Key: "date", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}},
+	}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}}).
+		SetProjection(bson.D{{Key: "date", Value: 1}, {Key: "values." + field, Value: 1}})
+
+	cur, err := journalCollection(tenant).Find(timeout, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	values := []spcValue{}
+	for cur.Next(timeout) {
+		var journal Journal
+		if err := cur.Decode(&journal); err != nil {
+			return nil, err
+		}
+		if value, ok := numericValue(journal.Values[field]); ok {
+			values = append(values, spcValue{Date: journal.Date, Value: value})
+		}
+	}
+
+	return values, cur.Err()
+}
+
+// subgroups группирует значения по дням
+func subgroups(values []spcValue) ([]time.Time, [][]float64) {
+	dates := []time.Time{}
+	groups := [][]float64{}
+	for _, value := range values {
+		day := time.Date(value.Date.Year(), value.Date.Month(), value.Date.Day(), 0, 0, 0, 0, value.Date.Location())
+		if len(dates) == 0 || !dates[len(dates)-1].Equal(day) {
+			dates = append(dates, day)
+			groups = append(groups, []float64{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], value.Value)
+	}
+	return dates, groups
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+func valueRange(values []float64) float64 {
+	min, max := values[0], values[0]
+	for _, value := range values {
+		min = math.Min(min, value)
+		max = math.Max(max, value)
+	}
+	return max - min
+}
+
+// individualsLimits границы карты I-MR
+func individualsLimits(values []float64) (ControlLimits, error) {
+	if len(values) < 2 {
+		return ControlLimits{}, ErrBaselineTooShort
+	}
+
+	ranges := []float64{}
+	for i := 1; i < len(values); i++ {
+		ranges = append(ranges, math.Abs(values[i]-values[i-1]))
+	}
+
+	center := mean(values)
+	movingRange := mean(ranges)
+	sigma := movingRange / movingRangeD2
+
+	return ControlLimits{
+		Chart:       ChartIndividuals,
+		Center:      center,
+		UCL:         center + 3*sigma,
+		LCL:         center - 3*sigma,
+		Sigma:       sigma,
+		RangeCenter: movingRange,
+		RangeUCL:    xbarRConstants[2][2] * movingRange,
+		RangeLCL:    0,
+	}, nil
+}
+
+// xbarRLimits границы карты X-bar/R. Используются подгруппы самого частого размера
+func xbarRLimits(groups [][]float64) (ControlLimits, error) {
+	sizes := map[int]int{}
+	size := 0
+	for _, group := range groups {
+		sizes[len(group)]++
+		if _, ok := xbarRConstants[len(group)]; ok && sizes[len(group)] > sizes[size] {
+			size = len(group)
+		}
+	}
+
+	constants, ok := xbarRConstants[size]
+	if !ok || sizes[size] < 2 {
+		return ControlLimits{}, ErrBaselineTooShort
+	}
+
+	means := []float64{}
+	ranges := []float64{}
+	for _, group := range groups {
+		if len(group) == size {
+			means = append(means, mean(group))
+			ranges = append(ranges, valueRange(group))
+		}
+	}
+
+	center := mean(means)
+	averageRange := mean(ranges)
+	a2, d3, d4, d2 := constants[0], constants[1], constants[2], constants[3]
+
+	return ControlLimits{
+		Chart:        ChartXbarR,
+		Center:       center,
+		UCL:          center + a2*averageRange,
+		LCL:          center - a2*averageRange,
+		Sigma:        averageRange / d2 / math.Sqrt(float64(size)),
+		RangeCenter:  averageRange,
+		RangeUCL:     d4 * averageRange,
+		RangeLCL:     d3 * averageRange,
+		SubgroupSize: size,
+	}, nil
+}
+
+// chartPoints точки карты значений: индивидуальные значения или средние подгрупп
+func chartPoints(chart string, values []spcValue) []SPCPoint {
+	points := []SPCPoint{}
+
+	if chart == ChartXbarR {
+		dates, groups := subgroups(values)
+		for i, group := range groups {
+			spread := valueRange(group)
+			points = append(points, SPCPoint{Date: dates[i], Value: mean(group), Range: &spread})
+		}
+		return points
+	}
+
+	for i, value := range values {
+		point := SPCPoint{Date: value.Date, Value: value.Value}
+		if i > 0 {
+			spread := math.Abs(value.Value - values[i-1].Value)
+			point.Range = &spread
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// WesternElectric проверяет правила Western Electric для последней точки ряда
+func WesternElectric(points []float64, limits ControlLimits) []string {
+	violations := []string{}
+	if len(points) == 0 || limits.Sigma == 0 {
+		return violations
+	}
+
+	zone := func(value float64) float64 {
+		return (value - limits.Center) / limits.Sigma
+	}
+	last := zone(points[len(points)-1])
+
+	if math.Abs(last) > 3 {
+		violations = append(violations, RuleBeyond3Sigma)
+	}
+
+	// count сколько из последних n точек лежат дальше sigmas на той же стороне, что и последняя
+	count := func(n int, sigmas float64) (int, bool) {
+		if len(points) < n {
+			return 0, false
+		}
+		matched := 0
+		for _, point := range points[len(points)-n:] {
+			z := zone(point)
+			if (last > 0 && z > sigmas) || (last < 0 && z < -sigmas) {
+				matched++
+			}
+		}
+		return matched, true
+	}
+
+	if matched, ok := count(3, 2); ok && matched >= 2 && math.Abs(last) > 2 {
+		violations = append(violations, Rule2Of3Beyond2)
+	}
+	if matched, ok := count(5, 1); ok && matched >= 4 && math.Abs(last) > 1 {
+		violations = append(violations, Rule4Of5Beyond1)
+	}
+	if matched, ok := count(8, 0); ok && matched == 8 {
+		violations = append(violations, Rule8OneSide)
+	}
+
+	return violations
+}
+
+// ComputeControlLimits считает и сохраняет границы по значениям базового периода.
+// Ранее сохраненные границы для той же схемы, поля и позиции заменяются
+func ComputeControlLimits(tenant db.Tenant, scheme, field, item, chart string, from, to time.Time) (*ControlLimits, error) {
+	values, err := journalValues(tenant, scheme, item, field, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var limits ControlLimits
+	switch chart {
+	case ChartIndividuals:
+		numbers := []float64{}
+		for _, value := range values {
+			numbers = append(numbers, value.Value)
+		}
+		limits, err = individualsLimits(numbers)
+	case ChartXbarR:
+		_, groups := subgroups(values)
+		limits, err = xbarRLimits(groups)
+	default:
+		return nil, ErrChartInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	limits.Scheme = scheme
+	limits.Field = field
+	limits.Item = item
+	limits.BaselineFrom = from
+	limits.BaselineTo = to
+	limits.ComputedAt = time.Now()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "scheme", Value: scheme}, {Key: "field", Value: field}, {Key: "item", Value: item}}
+	var saved ControlLimits
+	err = controlLimitsCollection(tenant).FindOneAndReplace(timeout, filter, limits,
+		options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)).Decode(&saved)
+	if err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+// ControlLimitsAll границы схемы журнала. item и field могут быть пустыми
+func ControlLimitsAll(tenant db.Tenant, scheme, field, item string) ([]ControlLimits, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "scheme", Value: scheme}}
+	if len(field) != 0 {
+		filter = append(filter, bson.E{Key: "field", Value: field})
+	}
+	if len(item) != 0 {
+		filter = append(filter, bson.E{Key: "item", Value: item})
+	}
+
+	cur, err := controlLimitsCollection(tenant).Find(timeout, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	list := []ControlLimits{}
+	for cur.Next(timeout) {
+		var limits ControlLimits
+		if err := cur.Decode(&limits); err != nil {
+			return nil, err
+		}
+		list = append(list, limits)
+	}
+
+	return list, cur.Err()
+}
+
+// ControlChart контрольная карта поля позиции за период с отмеченными нарушениями правил
+func ControlChart(tenant db.Tenant, scheme, field, item string, from, to time.Time) (*SPCChart, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var limits ControlLimits
+	filter := bson.D{{Key: "scheme", Value: scheme}, {Key: "field", Value: field}, {Key: "item", Value: item}}
+	if err := controlLimitsCollection(tenant).FindOne(timeout, filter).Decode(&limits); err != nil {
+		return nil, err
+	}
+
+	values, err := journalValues(tenant, scheme, item, field, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	points := chartPoints(limits.Chart, values)
+	series := []float64{}
+	for i := range points {
+		series = append(series, points[i].Value)
+		points[i].Violations = WesternElectric(series, limits)
+	}
+
+	return &SPCChart{Limits: limits, Points: points}, nil
+}
+
+// spcWarnings проверяет значения нового журнала по сохраненным контрольным границам.
+// Ошибки только логируются: без предупреждений журнал все равно должен сохраниться
+func spcWarnings(tenant db.Tenant, journal Journal) []SPCWarning {
+	warnings := []SPCWarning{}
+	if len(journal.Scheme) == 0 || len(journal.Item) == 0 {
+		return warnings
+	}
+
+	list, err := ControlLimitsAll(tenant, journal.Scheme, "", journal.Item)
+	if err != nil {
+		log.Println(err)
+		return warnings
+	}
+
+	date := journal.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	for _, limits := range list {
+		value, ok := numericValue(journal.Values[limits.Field])
+		if !ok {
+			continue
+		}
+
+		// Для правил нужно не больше восьми предыдущих точек, берем их с запасом
+		history, err := journalValues(tenant, journal.Scheme, journal.Item, limits.Field, date.AddDate(0, 0, -30), date)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		history = append(history, spcValue{Date: date, Value: value})
+
+		points := chartPoints(limits.Chart, history)
+		series := []float64{}
+		for _, point := range points {
+			series = append(series, point.Value)
+		}
+
+		for _, rule := range WesternElectric(series, limits) {
+			warnings = append(warnings, SPCWarning{Field: limits.Field, Rule: rule, Value: series[len(series)-1]})
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWesternElectric(t *testing.T) {
+	limits := ControlLimits{Center: 10, Sigma: 1}
+
+	tests := []struct {
+		name   string
+		points []float64
+		want   []string
+	}{
+		{"empty", nil, []string{}},
+		{"in control", []float64{10, 10.5, 9.5, 10.2}, []string{}},
+		{"beyond 3 sigma above", []float64{10, 13.5}, []string{RuleBeyond3Sigma}},
+		{"beyond 3 sigma below", []float64{10, 6.5}, []string{RuleBeyond3Sigma}},
+		{"exactly 3 sigma", []float64{10, 13}, []string{}},
+		{"2 of 3 beyond 2 sigma", []float64{12.5, 10, 12.5}, []string{Rule2Of3Beyond2}},
+		{"2 of 3 on opposite sides", []float64{7.5, 10, 12.5}, []string{}},
+		{"2 of 3 with last inside", []float64{12.5, 12.5, 10}, []string{}},
+		{"2 of 3 with too few points", []float64{12.5, 12.5}, []string{}},
+		{"4 of 5 beyond 1 sigma", []float64{11.5, 11.5, 10, 11.5, 11.5}, []string{Rule4Of5Beyond1}},
+		{"4 of 5 below", []float64{8.5, 8.5, 8.5, 10, 8.5}, []string{Rule4Of5Beyond1}},
+		{"3 of 5 beyond 1 sigma", []float64{11.5, 10, 10, 11.5, 11.5}, []string{}},
+		{"8 on one side", []float64{10.1, 10.2, 10.1, 10.3, 10.1, 10.2, 10.1, 10.2}, []string{Rule8OneSide}},
+		{"7 on one side", []float64{9.9, 10.2, 10.1, 10.3, 10.1, 10.2, 10.1, 10.2}, []string{}},
+		{"8 on center line", []float64{10, 10, 10, 10, 10, 10, 10, 10}, []string{}},
+		{
+			"several rules",
+			[]float64{10.5, 10.5, 10.5, 11.5, 11.5, 11.5, 12.5, 13.5},
+			[]string{RuleBeyond3Sigma, Rule2Of3Beyond2, Rule4Of5Beyond1, Rule8OneSide},
+		},
+	}
+
+	for _, test := range tests {
+		if got := WesternElectric(test.points, limits); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: WesternElectric(%v) = %v, want %v", test.name, test.points, got, test.want)
+		}
+	}
+
+	if got := WesternElectric([]float64{100}, ControlLimits{Center: 10}); len(got) != 0 {
+		t.Errorf("zero sigma: WesternElectric = %v, want none", got)
+	}
+}
+
+func TestIndividualsLimits(t *testing.T) {
+	limits, err := individualsLimits([]float64{2, 4, 2, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sigma := 2 / movingRangeD2
+	checks := []struct {
+		name      string
+		got, want float64
+	}{
+		{"center", limits.Center, 3},
+		{"ucl", limits.UCL, 3 + 3*sigma},
+		{"lcl", limits.LCL, 3 - 3*sigma},
+		{"sigma", limits.Sigma, sigma},
+		{"range center", limits.RangeCenter, 2},
+		{"range ucl", limits.RangeUCL, 3.267 * 2},
+		{"range lcl", limits.RangeLCL, 0},
+	}
+	for _, check := range checks {
+		if math.Abs(check.got-check.want) > 1e-9 {
+			t.Errorf("individualsLimits %s = %v, want %v", check.name, check.got, check.want)
+		}
+	}
+	if limits.Chart != ChartIndividuals {
+		t.Errorf("individualsLimits chart = %q, want %q", limits.Chart, ChartIndividuals)
+	}
+
+	if _, err := individualsLimits([]float64{1}); err != ErrBaselineTooShort {
+		t.Errorf("individualsLimits of one value error = %v, want %v", err, ErrBaselineTooShort)
+	}
+}
+
+func TestXbarRLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups [][]float64
+		size   int
+		center float64
+		rbar   float64
+		err    error
+	}{
+		{"size 2", [][]float64{{1, 3}, {2, 4}, {3, 3}}, 2, 8.0 / 3, 4.0 / 3, nil},
+		{"most frequent size", [][]float64{{1, 2, 3}, {2, 3, 4}, {5, 5}}, 3, 2.5, 2, nil},
+		{"one subgroup", [][]float64{{1, 2, 3}}, 0, 0, 0, ErrBaselineTooShort},
+		{"single values", [][]float64{{1}, {2}, {3}}, 0, 0, 0, ErrBaselineTooShort},
+		{"subgroups too large", [][]float64{make([]float64, 11), make([]float64, 11)}, 0, 0, 0, ErrBaselineTooShort},
+	}
+
+	for _, test := range tests {
+		limits, err := xbarRLimits(test.groups)
+		if err != test.err {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		a2 := xbarRConstants[test.size][0]
+		if limits.SubgroupSize != test.size ||
+			math.Abs(limits.Center-test.center) > 1e-9 ||
+			math.Abs(limits.RangeCenter-test.rbar) > 1e-9 ||
+			math.Abs(limits.UCL-(test.center+a2*test.rbar)) > 1e-9 {
+			t.Errorf("%s: xbarRLimits = %+v", test.name, limits)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+)
+
+// tenantCollections коллекции, данные которых принадлежат площадке
+var tenantCollections = []func(db.Tenant) *db.Collection{
+	journalCollection,
+	ItemSchemeCollection,
+	JournalSchemeCollection,
+	ReportSchemeCollection,
+	operatorCollection,
+	controlLimitsCollection,
+	orgUnitCollection,
+	shiftTemplateCollection,
+	shiftCollection,
+	shiftHandoverCollection,
+	attachmentCollection,
+	legalHoldCollection,
+	legalHoldEventCollection,
+	journalArchiveCollection,
+	journalChainCollection,
+	operatorKeyCollection,
+	confirmedActionCollection,
+	commentThreadCollection,
+	capaCollection,
+}
+
+// AssignDefaultTenant относит документы, созданные до разделения на площадки, к db.DefaultTenant
+func AssignDefaultTenant() error {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	for _, coll := range tenantCollections {
+		if err := coll(db.DefaultTenant).ClaimUntenanted(timeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package tenant
+
+import (
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+)
+
+// Tenant площадка (завод) со своими пользователями, контроллерами, схемами и журналами
+type Tenant struct {
+	ID        db.Tenant `bson:"_id" json:"id" example:"plant_1" binding:"required"`
+	Title     string    `bson:"title" json:"title" example:"Завод №1"`
+	Disabled  bool      `bson:"disabled" json:"disabled" example:"false"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
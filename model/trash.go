@@ -0,0 +1,538 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ресурсы корзины
+const (
+	TrashJournal       = "journal"
+	TrashOperator      = "controller"
+	TrashItemScheme    = "item_scheme"
+	TrashJournalScheme = "journal_scheme"
+	TrashReportScheme  = "report_scheme"
+)
+
+// Errors godoc
+var (
+	ErrTrashResourceInvalid = errors.New("trash resource must be journal, controller, item_scheme, journal_scheme or report_scheme")
+	ErrTrashNameTaken       = errors.New("name of the deleted object is taken by another object")
+	ErrTrashReferenced      = errors.New("deleted object is still referenced by journals, closings or CAPA and cannot be purged")
+)
+
+// TrashEntry удаленный объект в корзине. Заполнены поля, которые есть у ресурса
+type TrashEntry struct {
+	ID        primitive.ObjectID `bson:"_id" json:"_id" example:"5ca10d9d015c736a72b7b3ba"`
+	DeletedAt *time.Time         `bson:"deleted_at" json:"deleted_at"`
+	DeletedBy string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty" example:"admin"`
+	Version   int64              `bson:"version" json:"version" example:"3"`
+
+	// Схемы
+	Name  string `bson:"name,omitempty" json:"name,omitempty" example:"scales_calibration"`
+	Title string `bson:"title,omitempty" json:"title,omitempty" example:"Учет и калибровка весов"`
+	// Журналы
+	Scheme string     `bson:"scheme,omitempty" json:"scheme,omitempty" example:"scales_calibration"`
+	Item   string     `bson:"item,omitempty" json:"item,omitempty" example:"scale"`
+	Date   *time.Time `bson:"date,omitempty" json:"date,omitempty"`
+	// Контроллеры
+	Login     string `bson:"login,omitempty" json:"login,omitempty" example:"olegov"`
+	FirstName string `bson:"first_name,omitempty" json:"first_name,omitempty" example:"Олег"`
+	LastName  string `bson:"last_name,omitempty" json:"last_name,omitempty" example:"Олегов"`
+}
+
+// trashFields поля, доступные в фильтрах и сортировке корзины
+var trashFields = db.Fields{
+	"deleted_at": {Key: "deleted_at", Type: db.TimeField, Sort: true},
+	"deleted_by": {Key: "deleted_by", Type: db.StringField, Sort: true},
+	"name":       {Key: "name", Type: db.StringField, Sort: true},
+	"scheme":     {Key: "scheme", Type: db.StringField, Sort: true},
+	"login":      {Key: "login", Type: db.StringField, Sort: true},
+}
+
+// trashProjection поля документа, которые попадают в TrashEntry
+var trashProjection = bson.D{
+	{Key: "deleted_at", Value: 1},
+	{Key: "deleted_by", Value: 1},
+	{Key: "version", Value: 1},
+	{Key: "name", Value: 1},
+	{Key: "title", Value: 1},
+	{Key: "scheme", Value: 1},
+	{Key: "item", Value: 1},
+	{Key: "date", Value: 1},
+	{Key: "login", Value: 1},
+	{Key: "first_name", Value: 1},
+	{Key: "last_name", Value: 1},
+}
+
+// trashResource коллекция ресурса корзины и проверки перед восстановлением и окончательным удалением
+type trashResource struct {
+	coll func(db.Tenant) *db.Collection
+	// restorable проверяет, что объект можно восстановить, не нарушив ссылок и уникальности имен
+	restorable func(tenant db.Tenant, entry TrashEntry) error
+	// referenced проверяет, что на объект еще ссылаются журналы, росписи или корректирующие действия.
+	// Такой объект остается в корзине: после удаления его имя или логин мог бы занять другой объект
+	referenced func(tenant db.Tenant, entry TrashEntry) (bool, error)
+}
+
+var trashResources = map[string]trashResource{
+	TrashJournal:  {coll: journalCollection},
+	TrashOperator: {coll: operatorCollection, referenced: operatorReferenced},
+	TrashItemScheme: {coll: ItemSchemeCollection, restorable: func(tenant db.Tenant, entry TrashEntry) error {
+		return schemeNameFree(ItemSchemeCollection(tenant), entry.Name)
+	}, referenced: func(tenant db.Tenant, entry TrashEntry) (bool, error) {
+		// Удаленные схемы журналов тоже ссылаются: их можно восстановить
+		return anyDocument(JournalSchemeCollection(tenant), bson.D{{Key: "item", Value: entry.Name}})
+	}},
+	TrashJournalScheme: {coll: JournalSchemeCollection, restorable: func(tenant db.Tenant, entry TrashEntry) error {
+		if err := schemeNameFree(JournalSchemeCollection(tenant), entry.Name); err != nil {
+			return err
+		}
+		return checkItemReference(tenant, entry.Item, nil)
+	}, referenced: journalSchemeReferenced},
+	TrashReportScheme: {coll: ReportSchemeCollection, restorable: func(tenant db.Tenant, entry TrashEntry) error {
+		if err := schemeNameFree(ReportSchemeCollection(tenant), entry.Name); err != nil {
+			return err
+		}
+		var journal struct {
+			Journal string `bson:"journal"`
+		}
+		if err := ReportSchemeCollection(tenant).FindOne(context.Background(), bson.D{{Key: "_id", Value: entry.ID}}).Decode(&journal); err != nil {
+			return err
+		}
+		return checkJournalReference(tenant, journal.Journal)
+	}},
+}
+
+// anyDocument есть ли в коллекции документ по фильтру
+func anyDocument(coll *db.Collection, filter bson.D) (bool, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := coll.CountDocuments(timeout, filter, options.Count().SetLimit(1))
+	return count != 0, err
+}
+
+// operatorReferenced есть ли у контроллера ключи подписи, росписи в журналах или корректирующие действия
+func operatorReferenced(tenant db.Tenant, entry TrashEntry) (bool, error) {
+	checks := []struct {
+		coll   *db.Collection
+		filter bson.D
+	}{
+		{operatorKeyCollection(tenant), bson.D{{Key: "operator", Value: entry.ID}}},
+		{journalCollection(tenant), bson.D{{Key: "closings.closed_by", Value: entry.Login}}},
+		{capaCollection(tenant), bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "owner", Value: entry.Login}},
+			bson.D{{Key: "closed_by", Value: entry.Login}},
+			bson.D{{Key: "actions.by", Value: entry.Login}},
+			bson.D{{Key: "verification.by", Value: entry.Login}},
+		}}}},
+	}
+	for _, check := range checks {
+		if found, err := anyDocument(check.coll, check.filter); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// journalSchemeReferenced есть ли журналы, корректирующие действия или схемы отчетов схемы журналов
+func journalSchemeReferenced(tenant db.Tenant, entry TrashEntry) (bool, error) {
+	checks := []struct {
+		coll   *db.Collection
+		filter bson.D
+	}{
+		{journalCollection(tenant), bson.D{{Key: "scheme", Value: entry.Name}}},
+		{capaCollection(tenant), bson.D{{Key: "scheme", Value: entry.Name}}},
+		{ReportSchemeCollection(tenant), bson.D{{Key: "journal", Value: entry.Name}}},
+	}
+	for _, check := range checks {
+		if found, err := anyDocument(check.coll, check.filter); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func trashResourceOf(resource string) (trashResource, error) {
+	r, ok := trashResources[resource]
+	if !ok {
+		return trashResource{}, ErrTrashResourceInvalid
+	}
+	return r, nil
+}
+
+// softDelete обновление, которое помечает документ удаленным пользователем by
+func softDelete(by string, now time.Time) bson.D {
+	return bson.D{{Key: "deleted_at", Value: now}, {Key: "deleted_by", Value: by}}
+}
+
+func schemeNameFree(coll *db.Collection, name string) error {
+	_, _, exists, err := existingScheme(coll, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrTrashNameTaken
+	}
+	return nil
+}
+
+func trashEntry(tenant db.Tenant, r trashResource, id string) (*TrashEntry, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var entry TrashEntry
+	filter := bson.D{{Key: "_id", Value: objectID}, {Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	if err := r.coll(tenant).FindOne(timeout, filter).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// TrashAll удаленные объекты ресурса
+func TrashAll(tenant db.Tenant, resource string, query db.Query) (db.Page, error) {
+	r, err := trashResourceOf(resource)
+	if err != nil {
+		return db.Page{}, err
+	}
+
+	var list []TrashEntry
+	filter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	return db.FindPage(r.coll(tenant), filter, trashFields, query, trashProjection, &list)
+}
+
+// TrashRestore восстанавливает удаленный объект с версией version
+func TrashRestore(tenant db.Tenant, resource string, id string, version int64) (*TrashEntry, error) {
+	r, err := trashResourceOf(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := trashEntry(tenant, r, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Version != version {
+		return nil, db.ErrVersionMismatch
+	}
+	if r.restorable != nil {
+		if err := r.restorable(tenant, *entry); err != nil {
+			return nil, err
+		}
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: nil}, {Key: "version", Value: version + 1}}},
+		{Key: "$unset", Value: bson.D{{Key: "deleted_by", Value: ""}}},
+	}
+	filter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	if err := updateVersioned(r.coll(tenant), entry.ID, version, filter, update); err != nil {
+		return nil, err
+	}
+
+	entry.DeletedAt = nil
+	entry.DeletedBy = ""
+	entry.Version = version + 1
+	return entry, nil
+}
+
+// purgeJournalAttachments окончательно удаляет вложения журналов ids вместе с содержимым
+func purgeJournalAttachments(tenant db.Tenant, ids []primitive.ObjectID) error {
+	return purgeAttachments(tenant, bson.D{{Key: "journal", Value: bson.D{{Key: "$in", Value: ids}}}})
+}
+
+// purgeAttachments окончательно удаляет вложения по фильтру вместе с содержимым в хранилище
+func purgeAttachments(tenant db.Tenant, filter bson.D) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cur, err := attachmentCollection(tenant).Find(timeout, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(timeout)
+
+	ids := bson.A{}
+	for cur.Next(timeout) {
+		var attachment Attachment
+		if err := cur.Decode(&attachment); err != nil {
+			return err
+		}
+		// Содержимое, которое не удалось удалить, останется в хранилище без ссылок, метаданные удаляются все равно
+		if err := Attachments().Delete(tenant, attachment.Key); err != nil {
+			log.Println(err)
+		}
+		if attachment.Thumbnail {
+			if err := Attachments().Delete(tenant, attachment.ThumbnailKey); err != nil {
+				log.Println(err)
+			}
+		}
+		ids = append(ids, attachment.ID)
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err = attachmentCollection(tenant).DeleteMany(timeout, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	return err
+}
+
+// TrashPurge окончательно удаляет объект из корзины. Журнал на удержании или в пределах
+// минимального срока хранения его схемы удалить нельзя, как и объект, на который еще ссылаются
+func TrashPurge(tenant db.Tenant, resource string, id string, version int64) error {
+	r, err := trashResourceOf(resource)
+	if err != nil {
+		return err
+	}
+
+	entry, err := trashEntry(tenant, r, id)
+	if err != nil {
+		return err
+	}
+	if entry.Version != version {
+		return db.ErrVersionMismatch
+	}
+	if resource == TrashJournal && entry.Date != nil {
+		if err := checkRetention(tenant, entry.Scheme, *entry.Date, time.Now()); err != nil {
+			return err
+		}
+	}
+	if r.referenced != nil {
+		referenced, err := r.referenced(tenant, *entry)
+		if err != nil {
+			return err
+		}
+		if referenced {
+			return ErrTrashReferenced
+		}
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: entry.ID}, versionFilter(version), {Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}}}, notHeld()}
+	result, err := r.coll(tenant).DeleteMany(timeout, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		held, err := r.coll(tenant).CountDocuments(timeout, bson.D{{Key: "_id", Value: entry.ID}, held()})
+		if err != nil {
+			return err
+		}
+		if held > 0 {
+			return ErrLegalHold
+		}
+		return db.ErrVersionMismatch
+	}
+
+	if resource == TrashJournal {
+		if err := purgeJournalChains(tenant, []primitive.ObjectID{entry.ID}); err != nil {
+			return err
+		}
+		if err := purgeJournalComments(tenant, []primitive.ObjectID{entry.ID}); err != nil {
+			return err
+		}
+		if err := purgeJournalCAPAs(tenant, []primitive.ObjectID{entry.ID}); err != nil {
+			return err
+		}
+		return purgeJournalAttachments(tenant, []primitive.ObjectID{entry.ID})
+	}
+	return nil
+}
+
+// PurgeExpired окончательно удаляет объекты всех ресурсов корзины и вложения, удаленные раньше before.
+// Журналы на удержании и в пределах минимального срока хранения остаются вместе с вложениями,
+// контроллеры и схемы, на которые еще ссылаются, остаются в корзине.
+// Возвращает количество удаленных объектов
+func PurgeExpired(tenant db.Tenant, before time.Time) (int64, error) {
+	expired := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}, {Key: "$lt", Value: before}}}, notHeld()}
+
+	retention, err := notRetained(tenant, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for resource, r := range trashResources {
+		if resource == TrashJournal {
+			count, err := purgeExpiredJournals(tenant, append(append(bson.D{}, expired...), retention...))
+			purged += count
+			if err != nil {
+				return purged, err
+			}
+			continue
+		}
+
+		count, err := purgeExpiredEntries(tenant, r, expired)
+		purged += count
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	// Вложения, удаленные из журналов, которые сами остаются
+	heldJournals, err := journalIDs(tenant, bson.D{held()}, 0)
+	if err != nil {
+		return purged, err
+	}
+	attachments := bson.D{
+		{Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}, {Key: "$lt", Value: before}}},
+		{Key: "journal", Value: bson.D{{Key: "$nin", Value: heldJournals}}},
+	}
+	if err := purgeAttachments(tenant, attachments); err != nil {
+		return purged, err
+	}
+
+	return purged, nil
+}
+
+// purgeExpiredEntries удаляет объекты ресурса по фильтру, кроме тех, на которые еще ссылаются
+func purgeExpiredEntries(tenant db.Tenant, r trashResource, filter bson.D) (int64, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if r.referenced == nil {
+		result, err := r.coll(tenant).DeleteMany(timeout, filter)
+		if err != nil {
+			return 0, err
+		}
+		return result.DeletedCount, nil
+	}
+
+	cur, err := r.coll(tenant).Find(timeout, filter, options.Find().SetProjection(trashProjection))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(timeout)
+
+	ids := bson.A{}
+	for cur.Next(timeout) {
+		var entry TrashEntry
+		if err := cur.Decode(&entry); err != nil {
+			return 0, err
+		}
+		referenced, err := r.referenced(tenant, entry)
+		if err != nil {
+			return 0, err
+		}
+		if !referenced {
+			ids = append(ids, entry.ID)
+		}
+	}
+	if err := cur.Err(); err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	byID := append(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}, filter...)
+	result, err := r.coll(tenant).DeleteMany(timeout, byID)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// purgeExpiredJournals удаляет журналы по фильтру порциями, вместе с их вложениями
+func purgeExpiredJournals(tenant db.Tenant, filter bson.D) (int64, error) {
+	const batch = 500
+
+	var purged int64
+	for {
+		ids, err := journalIDs(tenant, filter, batch)
+		if err != nil || len(ids) == 0 {
+			return purged, err
+		}
+
+		// Сначала вложения, цепочки, обсуждения и корректирующие действия: если удаление прервется, журнал останется и будет удален при следующем запуске
+		if err := purgeJournalAttachments(tenant, ids); err != nil {
+			return purged, err
+		}
+		if err := purgeJournalChains(tenant, ids); err != nil {
+			return purged, err
+		}
+		if err := purgeJournalComments(tenant, ids); err != nil {
+			return purged, err
+		}
+		if err := purgeJournalCAPAs(tenant, ids); err != nil {
+			return purged, err
+		}
+
+		timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		byID := append(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}, filter...)
+		result, err := journalCollection(tenant).DeleteMany(timeout, byID)
+		cancel()
+		if err != nil {
+			return purged, err
+		}
+		purged += result.DeletedCount
+
+		if len(ids) < batch {
+			return purged, nil
+		}
+	}
+}
+
+func journalIDs(tenant db.Tenant, filter bson.D, limit int64) ([]primitive.ObjectID, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := journalCollection(tenant).Find(timeout, filter, options.Find().SetLimit(limit).SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	ids := []primitive.ObjectID{}
+	for cur.Next(timeout) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids = append(ids, doc.ID)
+	}
+	return ids, cur.Err()
+}
+
+// MigrateDeletedFlags переводит журналы и схемы с флага deleted на deleted_at, как у остальных моделей.
+// Время удаления старых документов неизвестно, им ставится время миграции
+func MigrateDeletedFlags(tenant db.Tenant) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	deleted := bson.D{{Key: "deleted", Value: true}}
+	markDeleted := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{{Key: "deleted", Value: ""}}},
+	}
+	flagged := bson.D{{Key: "deleted", Value: bson.D{{Key: "$exists", Value: true}}}}
+	unsetFlag := bson.D{{Key: "$unset", Value: bson.D{{Key: "deleted", Value: ""}}}}
+
+	for _, coll := range []func(db.Tenant) *db.Collection{journalCollection, ItemSchemeCollection, JournalSchemeCollection, ReportSchemeCollection} {
+		if _, err := coll(tenant).UpdateMany(timeout, deleted, markDeleted); err != nil {
+			return err
+		}
+		if _, err := coll(tenant).UpdateMany(timeout, flagged, unsetFlag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,11 @@
 package user
 
+import "github.com/Oxynger/JournalApp/db"
+
 type Credentials struct {
 	Username string `bson:"username" json:"username" binding:"required"`
 	Password string `bson:"password" json:"password" binding:"required"`
+	// Tenant площадка контроллера. Логины контроллеров уникальны только в пределах площадки.
+	// Пользователи входят без площадки: она хранится в учетной записи
+	Tenant db.Tenant `bson:"-" json:"tenant,omitempty" example:"default"`
 }
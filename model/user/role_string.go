@@ -11,11 +11,12 @@ func _() {
 	_ = x[Operator-0]
 	_ = x[Administrator-1]
 	_ = x[Helpdesk-2]
+	_ = x[SuperAdmin-3]
 }
 
-const _Role_name = "OperatorAdministratorHelpdesk"
+const _Role_name = "OperatorAdministratorHelpdeskSuperAdmin"
 
-var _Role_index = [...]uint8{0, 8, 21, 29}
+var _Role_index = [...]uint8{0, 8, 21, 29, 39}
 
 func (i Role) String() string {
 	if i < 0 || i >= Role(len(_Role_index)-1) {
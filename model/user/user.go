@@ -4,7 +4,9 @@ package user
 
 import (
 	"encoding/json"
+	"time"
 
+	"github.com/Oxynger/JournalApp/db"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -14,22 +16,46 @@ const (
 	Operator Role = iota
 	Administrator
 	Helpdesk
+	// SuperAdmin управляет площадками и может работать с данными любой площадки
+	SuperAdmin
 )
 
+// Grants проверяет, что роли r доступны эндпоинты роли required.
+// Администратору доступно все в пределах площадки, кроме управления площадками
+func (r Role) Grants(required Role) bool {
+	switch r {
+	case required, SuperAdmin:
+		return true
+	case Administrator:
+		return required != SuperAdmin
+	default:
+		return false
+	}
+}
+
 type User struct {
-	ID       *primitive.ObjectID `json:"ID" bson:"_id,omitempty"`
-	Username string              `bson:"username" json:"username"`
-	Password string              `bson:"password" json:"password"`
-	Role     Role                `bson:"role" json:"role"`
+	ID        *primitive.ObjectID `json:"ID" bson:"_id,omitempty"`
+	Username  string              `bson:"username" json:"username"`
+	Password  string              `bson:"password" json:"password"`
+	Role      Role                `bson:"role" json:"role"`
+	Tenant    db.Tenant           `bson:"tenant" json:"tenant"`
+	Disabled  bool                `bson:"disabled" json:"disabled"`
+	DeletedAt *time.Time          `bson:"deleted_at" json:"-"`
 }
 
 func (usr User) MarshalJSON() ([]byte, error) {
 	temp := struct {
-		Username string `json:"username"`
-		Role     Role   `json:"role"`
+		ID       *primitive.ObjectID `json:"ID"`
+		Username string              `json:"username"`
+		Role     Role                `json:"role"`
+		Tenant   db.Tenant           `json:"tenant"`
+		Disabled bool                `json:"disabled"`
 	}{
+		usr.ID,
 		usr.Username,
 		usr.Role,
+		usr.Tenant,
+		usr.Disabled,
 	}
 
 	return json.Marshal(&temp)
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// versionFilter условие на версию документа. Документы, созданные до появления версий, поля version
+// не имеют и считаются версией 0
+func versionFilter(version int64) bson.E {
+	if version == 0 {
+		return bson.E{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}}
+	}
+	return bson.E{Key: "version", Value: version}
+}
+
+// updateVersioned обновляет документ, только если его версия совпадает с version.
+// filter дополняет условие по _id и версии (например, отбор неудаленных документов).
+// Если документ найден, но версия отличается, возвращается db.ErrVersionMismatch
+func updateVersioned(coll *db.Collection, id primitive.ObjectID, version int64, filter bson.D, update bson.D) error {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	byID := append(bson.D{{Key: "_id", Value: id}}, filter...)
+	versioned := append(byID, versionFilter(version))
+
+	updateResault, err := coll.UpdateOne(timeout, versioned, update)
+	if err != nil {
+		return err
+	}
+
+	if updateResault.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := coll.CountDocuments(timeout, byID)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return db.ErrVersionMismatch
+}
@@ -2,35 +2,83 @@ package router
 
 import (
 	"github.com/Oxynger/JournalApp/api"
+	"github.com/Oxynger/JournalApp/api/analytics"
+	"github.com/Oxynger/JournalApp/api/archive"
 	"github.com/Oxynger/JournalApp/api/auth"
+	"github.com/Oxynger/JournalApp/api/confirmation"
 	"github.com/Oxynger/JournalApp/api/itemScheme"
 	"github.com/Oxynger/JournalApp/api/journal"
+	"github.com/Oxynger/JournalApp/api/journalScheme"
+	"github.com/Oxynger/JournalApp/api/legalHold"
 	"github.com/Oxynger/JournalApp/api/operator"
+	"github.com/Oxynger/JournalApp/api/org"
+	"github.com/Oxynger/JournalApp/api/reportScheme"
+	"github.com/Oxynger/JournalApp/api/schemeBundle"
+	"github.com/Oxynger/JournalApp/api/search"
+	"github.com/Oxynger/JournalApp/api/shift"
+	"github.com/Oxynger/JournalApp/api/spc"
+	"github.com/Oxynger/JournalApp/api/tenants"
+	"github.com/Oxynger/JournalApp/api/trash"
+	"github.com/Oxynger/JournalApp/api/users"
+	"github.com/Oxynger/JournalApp/model"
 	"github.com/Oxynger/JournalApp/model/user"
 	"github.com/Oxynger/JournalApp/service"
 	"github.com/gin-gonic/gin"
 )
 
 // V1 добавляет роутинг для эндпоинтов на /api/v1
-func V1(router *gin.RouterGroup, userService *service.UserService, sessionService *service.SessionService) {
+func V1(router *gin.RouterGroup, userService *service.UserService, tenantService *service.TenantService, sessionService *service.SessionService) {
 	itemSchemeGroup := router.Group("/scheme")
 	{
 		itemSchemeGroup.Use(auth.RequireAuthorization(sessionService, user.Helpdesk))
 		itemSchemeGroup.GET("/item", itemScheme.GetItemSchemes)
 		itemSchemeGroup.GET("/item/:itemscheme_id", itemScheme.GetItemScheme)
-		itemSchemeGroup.POST("/item", itemScheme.NewItemScheme)
-		itemSchemeGroup.PUT("/item/:itemscheme_id", itemScheme.UpdateItemScheme)
-		itemSchemeGroup.DELETE("/item/:itemscheme_id", itemScheme.DeleteItemScheme)
+		itemSchemeGroup.POST("/item", auth.RequireConfirmation(sessionService, model.ActionItemSchemeCreate), itemScheme.NewItemScheme)
+		itemSchemeGroup.PUT("/item/:itemscheme_id", auth.RequireConfirmation(sessionService, model.ActionItemSchemeUpdate), itemScheme.UpdateItemScheme)
+		itemSchemeGroup.DELETE("/item/:itemscheme_id", auth.RequireConfirmation(sessionService, model.ActionItemSchemeDelete), itemScheme.DeleteItemScheme)
+		itemSchemeGroup.GET("/dependents/item/:itemscheme_id", itemScheme.ItemSchemeDependents)
+		itemSchemeGroup.GET("/journal", journalScheme.GetJournalSchemes)
+		itemSchemeGroup.GET("/journal/:journalscheme_id", journalScheme.GetJournalScheme)
+		itemSchemeGroup.POST("/journal", auth.RequireConfirmation(sessionService, model.ActionJournalSchemeCreate), journalScheme.NewJournalScheme)
+		itemSchemeGroup.PUT("/journal/:journalscheme_id", auth.RequireConfirmation(sessionService, model.ActionJournalSchemeUpdate), journalScheme.UpdateJournalScheme)
+		itemSchemeGroup.DELETE("/journal/:journalscheme_id", auth.RequireConfirmation(sessionService, model.ActionJournalSchemeDelete), journalScheme.DeleteJournalScheme)
+		itemSchemeGroup.POST("/journal/preview", journalScheme.PreviewJournalScheme)
+		itemSchemeGroup.GET("/dependents/journal/:journalscheme_id", journalScheme.JournalSchemeDependents)
+		itemSchemeGroup.GET("/report", reportScheme.GetReportSchemes)
+		itemSchemeGroup.GET("/report/:reportscheme_id", reportScheme.GetReportScheme)
+		itemSchemeGroup.POST("/report", auth.RequireConfirmation(sessionService, model.ActionReportSchemeCreate), reportScheme.NewReportScheme)
+		itemSchemeGroup.PUT("/report/:reportscheme_id", auth.RequireConfirmation(sessionService, model.ActionReportSchemeUpdate), reportScheme.UpdateReportScheme)
+		itemSchemeGroup.DELETE("/report/:reportscheme_id", auth.RequireConfirmation(sessionService, model.ActionReportSchemeDelete), reportScheme.DeleteReportScheme)
+		itemSchemeGroup.GET("/bundle", schemeBundle.ExportSchemes)
+		itemSchemeGroup.POST("/bundle", auth.RequireConfirmation(sessionService, model.ActionSchemeImport), schemeBundle.ImportSchemes)
 	}
+	// Список журналов доступен контроллерам: они видят журналы своих узлов организационной структуры
+	router.GET("/journal", auth.RequireAuthorization(sessionService, user.Operator), journal.ListJournals)
+	router.POST("/journal/:journal_id/signature", auth.RequireAuthorization(sessionService, user.Operator), auth.RequireConfirmation(sessionService, model.ActionJournalClose), journal.CloseJournal)
+	router.POST("/journal/:journal_id/attachment", auth.RequireAuthorization(sessionService, user.Operator), journal.UploadAttachment)
+	router.GET("/journal/:journal_id/attachment", auth.RequireAuthorization(sessionService, user.Operator), journal.ListAttachments)
+	router.GET("/journal/:journal_id/attachment/:attachment_id", auth.RequireAuthorization(sessionService, user.Operator), journal.DownloadAttachment)
+	router.GET("/journal/:journal_id/attachment/:attachment_id/thumbnail", auth.RequireAuthorization(sessionService, user.Operator), journal.DownloadThumbnail)
+	router.GET("/journal/:journal_id/export", auth.RequireAuthorization(sessionService, user.Operator), journal.ExportJournal)
+	router.POST("/journal/:journal_id/review", auth.RequireAuthorization(sessionService, user.Operator), auth.AcceptConfirmation(sessionService, model.ActionJournalReview), journal.ReviewJournal)
+	router.GET("/review/awaiting", auth.RequireAuthorization(sessionService, user.Operator), journal.ListAwaitingReview)
+	router.GET("/journal/:journal_id/comment", auth.RequireAuthorization(sessionService, user.Operator), journal.ListCommentThreads)
+	router.POST("/journal/:journal_id/comment", auth.RequireAuthorization(sessionService, user.Operator), journal.StartCommentThread)
+	router.GET("/journal/:journal_id/comment/:thread_id", auth.RequireAuthorization(sessionService, user.Operator), journal.ShowCommentThread)
+	router.POST("/journal/:journal_id/comment/:thread_id/reply", auth.RequireAuthorization(sessionService, user.Operator), journal.ReplyComment)
+	router.POST("/journal/:journal_id/comment/:thread_id/read", auth.RequireAuthorization(sessionService, user.Operator), journal.ReadCommentThread)
+	router.POST("/journal/:journal_id/comment/:thread_id/resolve", auth.RequireAuthorization(sessionService, user.Operator), journal.ResolveCommentThread)
+	router.GET("/journal/:journal_id/audit", auth.RequireAuthorization(sessionService, user.Operator), journal.ShowJournalAudit)
+	router.GET("/comment/mentions", auth.RequireAuthorization(sessionService, user.Operator), journal.ListMentions)
 	journalGroup := router.Group("/journal")
 	{
 		journalGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
-		journalGroup.GET("", journal.ListJournals)
 		journalGroup.GET(":journal_id", journal.ShowJournal)
 		journalGroup.POST("", journal.AddJournal)
 		journalGroup.PUT(":journal_id", journal.UpdateJournal)
 		journalGroup.DELETE(":journal_id", journal.DeleteJournal)
-		journalGroup.POST(":journal_id/signature", journal.CloseJournal)
+		journalGroup.DELETE(":journal_id/attachment/:attachment_id", journal.DeleteAttachment)
+		journalGroup.GET(":journal_id/chain", journal.VerifyJournalChain)
 	}
 	operatorGroup := router.Group("/controller")
 	{
@@ -39,12 +87,130 @@ func V1(router *gin.RouterGroup, userService *service.UserService, sessionServic
 		operatorGroup.GET(":operator_id", operator.ShowOperator)
 		operatorGroup.POST("", operator.AddOperator)
 		operatorGroup.PUT(":operator_id", operator.UpdateOperator)
-		operatorGroup.DELETE(":operator_id", operator.DeleteOperator)
+		operatorGroup.DELETE(":operator_id", operator.DeleteOperator(sessionService))
+		operatorGroup.PUT(":operator_id/status", operator.SetOperatorStatus(sessionService))
+		operatorGroup.POST(":operator_id/password-reset", operator.ResetOperatorPassword)
+		operatorGroup.GET(":operator_id/keys", operator.ListOperatorKeys)
+		operatorGroup.POST(":operator_id/keys/revoke", operator.RevokeOperatorKeys)
+	}
+	orgGroup := router.Group("/org")
+	{
+		orgGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		orgGroup.GET("", org.ListOrgUnits)
+		orgGroup.GET("/tree", org.ShowOrgTree)
+		orgGroup.GET("/unit/:unit_id", org.ShowOrgUnit)
+		orgGroup.POST("", org.AddOrgUnit)
+		orgGroup.PUT("/unit/:unit_id", org.UpdateOrgUnit)
+		orgGroup.DELETE("/unit/:unit_id", org.DeleteOrgUnit)
+		orgGroup.PUT("/unit/:unit_id/operators", org.AssignOperators)
+		orgGroup.PUT("/unit/:unit_id/schemes", org.AssignSchemes)
+	}
+	shiftGroup := router.Group("/shift")
+	{
+		// Текущие смены, передача и прием смены доступны контроллерам
+		operatorAccess := auth.RequireAuthorization(sessionService, user.Operator)
+		adminAccess := auth.RequireAuthorization(sessionService, user.Administrator)
+		shiftGroup.GET("/current", operatorAccess, shift.CurrentShifts)
+		shiftGroup.POST("/calendar/:shift_id/handover", operatorAccess, shift.HandOverShift)
+		shiftGroup.GET("/calendar/:shift_id/handover", operatorAccess, shift.ShowHandover)
+		shiftGroup.POST("/handover/:handover_id/acknowledge", operatorAccess, shift.AcknowledgeHandover)
+		shiftGroup.GET("/template", adminAccess, shift.ListShiftTemplates)
+		shiftGroup.POST("/template", adminAccess, shift.AddShiftTemplate)
+		shiftGroup.PUT("/template/:template_id", adminAccess, shift.UpdateShiftTemplate)
+		shiftGroup.DELETE("/template/:template_id", adminAccess, shift.DeleteShiftTemplate)
+		shiftGroup.GET("/calendar", adminAccess, shift.ListShifts)
+		shiftGroup.POST("/calendar", adminAccess, shift.GenerateShifts)
+		shiftGroup.GET("/calendar/:shift_id", adminAccess, shift.ShowShift)
+		shiftGroup.DELETE("/calendar/:shift_id", adminAccess, shift.DeleteShift)
+		shiftGroup.PUT("/calendar/:shift_id/operators", adminAccess, shift.SetShiftOperators)
+	}
+	userGroup := router.Group("/users")
+	{
+		userGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		userGroup.GET("", users.ListUsers(userService))
+		userGroup.GET(":user_id", users.ShowUser(userService))
+		userGroup.POST("", users.AddUser(userService))
+		userGroup.PUT(":user_id/role", users.UpdateUserRole(userService, sessionService))
+		userGroup.POST(":user_id/disable", users.DisableUser(userService, sessionService))
+		userGroup.POST(":user_id/enable", users.EnableUser(userService))
+		userGroup.DELETE(":user_id", users.DeleteUser(userService, sessionService))
+	}
+	tenantGroup := router.Group("/tenants")
+	{
+		tenantGroup.Use(auth.RequireAuthorization(sessionService, user.SuperAdmin))
+		tenantGroup.GET("", tenants.ListTenants(tenantService))
+		tenantGroup.GET(":tenant_id", tenants.ShowTenant(tenantService))
+		tenantGroup.POST("", tenants.AddTenant(tenantService))
+		tenantGroup.POST(":tenant_id/disable", tenants.DisableTenant(tenantService, sessionService))
+		tenantGroup.POST(":tenant_id/enable", tenants.EnableTenant(tenantService))
+		tenantGroup.POST(":tenant_id/switch", tenants.SwitchTenant(tenantService, sessionService))
+	}
+	searchGroup := router.Group("/search")
+	{
+		searchGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		searchGroup.GET("", search.Search)
+	}
+	analyticsGroup := router.Group("/analytics")
+	{
+		analyticsGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		analyticsGroup.GET("/journal/:scheme", analytics.JournalAnalytics)
+	}
+	spcGroup := router.Group("/spc")
+	{
+		spcGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		spcGroup.POST("/limits", spc.ComputeLimits)
+		spcGroup.GET("/limits/:scheme", spc.ListLimits)
+		spcGroup.GET("/chart/:scheme", spc.ShowChart)
+	}
+	trashGroup := router.Group("/trash")
+	{
+		trashGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		trashGroup.GET("/:resource", trash.ListTrash)
+		trashGroup.POST("/:resource/:id/restore", trash.RestoreTrash)
+		trashGroup.DELETE("/:resource/:id", trash.PurgeTrash)
+	}
+	legalHoldGroup := router.Group("/legal-hold")
+	{
+		legalHoldGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		legalHoldGroup.GET("", legalHold.ListLegalHolds)
+		legalHoldGroup.POST("", legalHold.PlaceLegalHold)
+		legalHoldGroup.GET("/hold/:hold_id", legalHold.ShowLegalHold)
+		legalHoldGroup.POST("/hold/:hold_id/release", legalHold.ReleaseLegalHold)
+		legalHoldGroup.GET("/audit", legalHold.ListLegalHoldEvents)
+	}
+	archiveGroup := router.Group("/archive")
+	{
+		archiveGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		archiveGroup.GET("", archive.ListArchives)
+		archiveGroup.POST("/run", archive.RunArchive)
+		archiveGroup.GET("/file/:archive_id", archive.ShowArchive)
+		archiveGroup.POST("/file/:archive_id/verify", archive.VerifyArchive)
+	}
+	capaGroup := router.Group("/capa")
+	{
+		operatorAccess := auth.RequireAuthorization(sessionService, user.Operator)
+		adminAccess := auth.RequireAuthorization(sessionService, user.Administrator)
+		capaGroup.GET("", operatorAccess, journal.ListCAPAs)
+		capaGroup.GET("/overdue", operatorAccess, journal.ListOverdueCAPAs)
+		capaGroup.GET("/record/:capa_id", operatorAccess, journal.ShowCAPA)
+		capaGroup.POST("/record/:capa_id/action", operatorAccess, journal.AddCAPAAction)
+		capaGroup.PUT("/record/:capa_id", adminAccess, journal.UpdateCAPA)
+		capaGroup.POST("/record/:capa_id/verification", adminAccess, journal.VerifyCAPA)
+		capaGroup.POST("/record/:capa_id/close", adminAccess, auth.RequireConfirmation(sessionService, model.ActionCAPAClose), journal.CloseCAPA)
+	}
+	confirmationGroup := router.Group("/confirmation")
+	{
+		confirmationGroup.Use(auth.RequireAuthorization(sessionService, user.Administrator))
+		confirmationGroup.GET("", confirmation.ListConfirmedActions)
 	}
 	logs := router.Group("/logs/tabletapp")
 	{
 		logs.POST("", api.AddTablelog)
 	}
-	router.POST("/login", auth.LogIn(userService, sessionService))
+	router.POST("/login", auth.LogIn(userService, tenantService, sessionService))
+	router.POST("/login/operator", auth.OperatorLogIn(tenantService, sessionService))
+	router.POST("/login/operator/password", auth.ChangeOperatorPassword)
+	router.POST("/login/operator/key", auth.RotateOperatorKey)
+	router.POST("/login/confirm", auth.RequireSession(sessionService), auth.Confirm(userService, sessionService))
 	router.POST("/logout", auth.LogOut(sessionService))
 }
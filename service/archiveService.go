@@ -0,0 +1,62 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/spf13/viper"
+)
+
+// defaultArchiveInterval как часто по умолчанию запускается архивирование
+const defaultArchiveInterval = 24 * time.Hour
+
+// ArchiveService переносит старые журналы всех площадок в архив
+type ArchiveService struct {
+	tenants  *TenantService
+	interval time.Duration
+}
+
+// NewArchiveService читает период запуска ARCHIVE_INTERVAL в часах
+func NewArchiveService(tenants *TenantService) *ArchiveService {
+	interval := defaultArchiveInterval
+	if hours := viper.GetInt("archive_interval"); hours > 0 {
+		interval = time.Duration(hours) * time.Hour
+	}
+
+	return &ArchiveService{
+		tenants:  tenants,
+		interval: interval,
+	}
+}
+
+// Run запускает архивирование сразу и затем каждые interval
+func (srv *ArchiveService) Run() {
+	ticker := time.NewTicker(srv.interval)
+	defer ticker.Stop()
+
+	for {
+		srv.ArchiveOnce()
+		<-ticker.C
+	}
+}
+
+// ArchiveOnce архивирует журналы всех площадок. Ошибка одной площадки не останавливает остальные,
+// прерванный архив будет доведен при следующем запуске
+func (srv *ArchiveService) ArchiveOnce() {
+	tenants, err := srv.tenants.List()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, t := range tenants {
+		run, err := model.ArchiveJournals(t.ID, time.Now(), model.ArchiveAfterDays())
+		if err != nil {
+			log.Println("archiving of tenant", t.ID, "failed:", err)
+		}
+		if run.Journals > 0 {
+			log.Println("archiving of tenant", t.ID, "moved", run.Journals, "journals to", run.Archives, "archives")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/spf13/viper"
+)
+
+// defaultPurgeInterval как часто по умолчанию запускается очистка корзины
+const defaultPurgeInterval = 24 * time.Hour
+
+// RetentionService окончательно удаляет объекты, которые пролежали в корзине дольше срока хранения,
+// и журналы, срок хранения которых по политике их схемы истек
+type RetentionService struct {
+	tenants *TenantService
+	// years срок хранения удаленных объектов в годах. 0 - объекты не удаляются
+	years    int
+	interval time.Duration
+}
+
+// NewRetentionService читает срок хранения TRASH_RETENTION_YEARS и период запуска TRASH_PURGE_INTERVAL в часах
+func NewRetentionService(tenants *TenantService) *RetentionService {
+	interval := defaultPurgeInterval
+	if hours := viper.GetInt("trash_purge_interval"); hours > 0 {
+		interval = time.Duration(hours) * time.Hour
+	}
+
+	return &RetentionService{
+		tenants:  tenants,
+		years:    viper.GetInt("trash_retention_years"),
+		interval: interval,
+	}
+}
+
+// Run запускает очистку сразу и затем каждые interval
+func (srv *RetentionService) Run() {
+	if srv.years <= 0 {
+		log.Println("trash retention is disabled, only journal scheme retention policies are applied")
+	}
+
+	ticker := time.NewTicker(srv.interval)
+	defer ticker.Stop()
+
+	for {
+		srv.PurgeOnce()
+		<-ticker.C
+	}
+}
+
+// PurgeOnce окончательно удаляет на всех площадках объекты, удаленные раньше, чем years лет назад,
+// журналы с истекшим DestroyAfterDays и архивы, от журналов которых не осталось заглушек. Ошибка одной площадки не останавливает очистку остальных
+func (srv *RetentionService) PurgeOnce() {
+	tenants, err := srv.tenants.List()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	now := time.Now()
+	for _, t := range tenants {
+		if srv.years > 0 {
+			purged, err := model.PurgeExpired(t.ID, now.AddDate(-srv.years, 0, 0))
+			if err != nil {
+				log.Println("trash purge of tenant", t.ID, "failed:", err)
+			}
+			if purged > 0 {
+				log.Println("trash purge of tenant", t.ID, "deleted", purged, "objects")
+			}
+		}
+
+		destroyed, err := model.DestroyExpired(t.ID, now)
+		if err != nil {
+			log.Println("retention policies of tenant", t.ID, "failed:", err)
+		}
+		if destroyed > 0 {
+			log.Println("retention policies of tenant", t.ID, "destroyed", destroyed, "journals")
+		}
+
+		archives, err := model.PurgeOrphanArchives(t.ID)
+		if err != nil {
+			log.Println("archive purge of tenant", t.ID, "failed:", err)
+		}
+		if archives > 0 {
+			log.Println("archive purge of tenant", t.ID, "deleted", archives, "archives")
+		}
+	}
+}
@@ -6,24 +6,45 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Oxynger/JournalApp/db"
 	"github.com/Oxynger/JournalApp/model/user"
 )
 
+// ConfirmationTTL время жизни токена подтверждения критического действия
+const ConfirmationTTL = 5 * time.Minute
+
 type SessionService struct {
-	sessions map[string]*Session
-	lock     sync.RWMutex
+	sessions      map[string]*Session
+	confirmations map[string]*Confirmation
+	lock          sync.RWMutex
 }
 
 type Session struct {
 	Token    string
 	Role     user.Role
 	Username string
+	// Tenant площадка, с данными которой работает сессия
+	Tenant db.Tenant
+	// Home площадка учетной записи пользователя. Не меняется при переключении площадки
+	Home     db.Tenant
 	ExpireAt int64
 }
 
+// Confirmation подтверждение критического действия: пользователь сессии Session повторно ввел учетные данные
+// и указал смысл действия. Токен подтверждения одноразовый
+type Confirmation struct {
+	Token       string
+	Session     string
+	Username    string
+	Meaning     string
+	ConfirmedAt time.Time
+	ExpireAt    int64
+}
+
 func NewSessionService() *SessionService {
 	return &SessionService{
-		sessions: make(map[string]*Session),
+		sessions:      make(map[string]*Session),
+		confirmations: make(map[string]*Confirmation),
 	}
 }
 
@@ -38,11 +59,112 @@ func (srv *SessionService) VerifyToken(token string) bool {
 	return true
 }
 
+// Session возвращает действующую сессию по токену
+func (srv *SessionService) Session(token string) (*Session, bool) {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+
+	session, ok := srv.sessions[token]
+	if !ok || session.ExpireAt < time.Now().Unix() {
+		return nil, false
+	}
+	return session, true
+}
+
+// InvalidateUser завершает все сессии пользователя username площадки tenant.
+// Одноименные пользователи других площадок остаются в системе
+func (srv *SessionService) InvalidateUser(tenant db.Tenant, username string) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	for token, session := range srv.sessions {
+		if session.Home == tenant && session.Username == username {
+			delete(srv.sessions, token)
+		}
+	}
+}
+
+// InvalidateTenant завершает все сессии площадки
+func (srv *SessionService) InvalidateTenant(tenant db.Tenant) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	for token, session := range srv.sessions {
+		if session.Tenant == tenant {
+			delete(srv.sessions, token)
+		}
+	}
+}
+
+// SwitchTenant переключает сессию на другую площадку. Сессия заменяется копией: обработчики,
+// уже получившие сессию, читают ее без блокировки
+func (srv *SessionService) SwitchTenant(token string, tenant db.Tenant) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if session, ok := srv.sessions[token]; ok {
+		switched := *session
+		switched.Tenant = tenant
+		srv.sessions[token] = &switched
+	}
+}
+
 func (srv *SessionService) InvalidateToken(token string) {
 	srv.lock.Lock()
 	defer srv.lock.Unlock()
 
 	delete(srv.sessions, token)
+	for confirmationToken, confirmation := range srv.confirmations {
+		if confirmation.Session == token {
+			delete(srv.confirmations, confirmationToken)
+		}
+	}
+}
+
+// CreateConfirmation выдает токен подтверждения со смыслом meaning для сессии session
+func (srv *SessionService) CreateConfirmation(session *Session, meaning string) (*Confirmation, error) {
+	token, err := generateTokenString()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c := &Confirmation{
+		Token:       token,
+		Session:     session.Token,
+		Username:    session.Username,
+		Meaning:     meaning,
+		ConfirmedAt: now,
+		ExpireAt:    now.Add(ConfirmationTTL).Unix(),
+	}
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	for confirmationToken, confirmation := range srv.confirmations {
+		if confirmation.ExpireAt < now.Unix() {
+			delete(srv.confirmations, confirmationToken)
+		}
+	}
+	srv.confirmations[token] = c
+	return c, nil
+}
+
+// UseConfirmation погашает токен подтверждения. Токен действует только в сессии, для которой выдан
+func (srv *SessionService) UseConfirmation(token string, session *Session) (*Confirmation, bool) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	c, ok := srv.confirmations[token]
+	if !ok {
+		return nil, false
+	}
+	delete(srv.confirmations, token)
+
+	if c.ExpireAt < time.Now().Unix() || c.Session != session.Token || c.Username != session.Username {
+		return nil, false
+	}
+	return c, true
 }
 
 func (srv *SessionService) CreateSession(usr *user.User) (*Session, error) {
@@ -55,8 +177,14 @@ func (srv *SessionService) CreateSession(usr *user.User) (*Session, error) {
 		Token:    token,
 		Role:     usr.Role,
 		Username: usr.Username,
+		Tenant:   usr.Tenant,
+		Home:     usr.Tenant,
 		ExpireAt: time.Now().Add(time.Hour).Unix(),
 	}
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
 	srv.sessions[token] = s
 	return s, nil
 }
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/model"
+	"github.com/Oxynger/JournalApp/model/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Errors godoc
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrTenantInvalid  = errors.New("tenant id must be 2-32 lowercase latin letters, digits or _ and start with a letter")
+	ErrTenantDisabled = errors.New("tenant is disabled")
+)
+
+type TenantService struct {
+	collection *mongo.Collection
+}
+
+func NewTenantService() *TenantService {
+	return &TenantService{
+		collection: db.Global("Tenants"),
+	}
+}
+
+// Prepare создает площадку по умолчанию, переносит в нее данные без площадки,
+// переводит удаленные журналы и схемы на deleted_at и создает индексы всех площадок
+func (srv *TenantService) Prepare() error {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: db.DefaultTenant}}
+	insert := bson.D{{Key: "$setOnInsert", Value: tenant.Tenant{
+		ID:        db.DefaultTenant,
+		Title:     "Default",
+		CreatedAt: time.Now(),
+	}}}
+	if _, err := srv.collection.UpdateOne(timeout, filter, insert, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+
+	if err := model.AssignDefaultTenant(); err != nil {
+		return err
+	}
+
+	tenants, err := srv.List()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tenants {
+		if err := model.MigrateDeletedFlags(t.ID); err != nil {
+			return err
+		}
+		if err := model.MigrateSearchText(t.ID); err != nil {
+			return err
+		}
+		if err := model.CreateIndexes(t.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List возвращает все площадки
+func (srv *TenantService) List() ([]tenant.Tenant, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := srv.collection.Find(timeout, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	tenants := []tenant.Tenant{}
+	for cur.Next(timeout) {
+		var t tenant.Tenant
+		if err := cur.Decode(&t); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+
+	return tenants, cur.Err()
+}
+
+// Find возвращает площадку по идентификатору
+func (srv *TenantService) Find(id db.Tenant) (*tenant.Tenant, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var t tenant.Tenant
+	if err := srv.collection.FindOne(timeout, bson.D{{Key: "_id", Value: id}}).Decode(&t); err != nil {
+		return nil, ErrTenantNotFound
+	}
+
+	return &t, nil
+}
+
+// Active проверяет, что площадка существует и не заблокирована
+func (srv *TenantService) Active(id db.Tenant) error {
+	t, err := srv.Find(id)
+	if err != nil {
+		return err
+	}
+	if t.Disabled {
+		return ErrTenantDisabled
+	}
+	return nil
+}
+
+// Create добавляет площадку и создает индексы ее коллекций
+func (srv *TenantService) Create(t tenant.Tenant) (*tenant.Tenant, error) {
+	if !t.ID.Valid() {
+		return nil, ErrTenantInvalid
+	}
+
+	t.Disabled = false
+	t.CreatedAt = time.Now()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := srv.collection.InsertOne(timeout, t); err != nil {
+		return nil, err
+	}
+
+	if err := model.CreateIndexes(t.ID); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// SetDisabled блокирует или разблокирует площадку
+func (srv *TenantService) SetDisabled(id db.Tenant, disabled bool) (*tenant.Tenant, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "disabled", Value: disabled}}}}
+	result, err := srv.collection.UpdateOne(timeout, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrTenantNotFound
+	}
+
+	return srv.Find(id)
+}
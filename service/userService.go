@@ -7,8 +7,10 @@ import (
 	"time"
 
 	"github.com/Oxynger/JournalApp/db"
+	"github.com/Oxynger/JournalApp/model"
 	"github.com/Oxynger/JournalApp/model/user"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
@@ -28,15 +30,70 @@ func NewUserService() *UserService {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	// Пользователи, созданные до разделения на площадки, относятся к площадке по умолчанию
+	filter := bson.D{{Key: "tenant", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "tenant", Value: db.DefaultTenant}}}}
+	if _, err := u.collection.UpdateMany(context.Background(), filter, update); err != nil {
+		log.Fatal(err)
+	}
 	return &u
 }
 
 func userCollection() *mongo.Collection {
-	client := db.Client()
-	return client.Database("test").Collection("Users")
+	return db.Global("Users")
+}
+
+// ErrUserNotFound godoc
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUsernameInvalid godoc
+var ErrUsernameInvalid = errors.New("username is empty")
+
+// ErrUsernameTaken godoc
+var ErrUsernameTaken = errors.New("username is taken")
+
+// ErrSelfChange godoc
+var ErrSelfChange = errors.New("users cannot disable, delete or demote themselves")
+
+// ErrSuperAdminTarget godoc
+var ErrSuperAdminTarget = errors.New("only super administrator can change a super administrator")
+
+// ErrLastAdministrator godoc
+var ErrLastAdministrator = errors.New("the last active administrator of the tenant cannot be disabled, deleted or demoted")
+
+// isAdministrator роль управляет площадкой
+func isAdministrator(role user.Role) bool {
+	return role == user.Administrator || role == user.SuperAdmin
+}
+
+// duplicateKey ошибка нарушения уникального индекса
+func duplicateKey(err error) bool {
+	if writeErr, ok := err.(mongo.WriteException); ok {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (srv *UserService) Create(u user.User) error {
+	if len(u.Username) == 0 {
+		return ErrUsernameInvalid
+	}
+	if err := model.CheckPasswordPolicy(u.Password); err != nil {
+		return err
+	}
+	if len(u.Tenant) == 0 {
+		u.Tenant = db.DefaultTenant
+	}
+
+	u.ID = nil
+	u.Disabled = false
+	u.DeletedAt = nil
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
@@ -46,17 +103,21 @@ func (srv *UserService) Create(u user.User) error {
 
 	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
 	_, err = srv.collection.InsertOne(timeout, u)
+	if duplicateKey(err) {
+		return ErrUsernameTaken
+	}
 	return err
 }
 
 func (srv *UserService) findByUsername(username string) (*user.User, bool) {
 	var result *user.User
 	timeout, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	filter := bson.D{{Key: "username", Value: username}}
+	filter := bson.D{
+		{Key: "username", Value: username},
+		{Key: "deleted_at", Value: nil},
+	}
 	withoutFields := bson.D{
-		{Key: "_id", Value: 0},
 		{Key: "deleted_at", Value: 0},
-		{Key: "Password", Value: 0},
 	}
 	findOneOptions := options.FindOne().SetProjection(withoutFields)
 
@@ -68,7 +129,7 @@ func (srv *UserService) findByUsername(username string) (*user.User, bool) {
 
 func (srv *UserService) Authenticate(creds user.Credentials) (*user.User, error) {
 	usr, ok := srv.findByUsername(creds.Username)
-	if !ok || comparePasswords(usr.Password, creds.Password) {
+	if !ok || usr.Disabled || !comparePasswords(usr.Password, creds.Password) {
 		return nil, errors.New("Wrong username or password")
 	}
 	return usr, nil
@@ -80,3 +141,184 @@ func comparePasswords(expectedPassword, givenPassword string) bool {
 	}
 	return true
 }
+
+// List возвращает все неудаленные учетные записи площадки
+func (srv *UserService) List(tenant db.Tenant) ([]user.User, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "tenant", Value: tenant}, {Key: "deleted_at", Value: nil}}
+	findOptions := options.Find().SetSort(bson.D{{Key: "username", Value: 1}})
+
+	cur, err := srv.collection.Find(timeout, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(timeout)
+
+	users := []user.User{}
+	for cur.Next(timeout) {
+		var usr user.User
+		if err := cur.Decode(&usr); err != nil {
+			return nil, err
+		}
+		users = append(users, usr)
+	}
+
+	return users, cur.Err()
+}
+
+// FindByID возвращает неудаленную учетную запись площадки по id
+func (srv *UserService) FindByID(tenant db.Tenant, id string) (*user.User, error) {
+	userID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var usr user.User
+	filter := bson.D{{Key: "_id", Value: userID}, {Key: "tenant", Value: tenant}, {Key: "deleted_at", Value: nil}}
+	if err := srv.collection.FindOne(timeout, filter).Decode(&usr); err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	return &usr, nil
+}
+
+func (srv *UserService) set(tenant db.Tenant, id string, set bson.D) (*user.User, error) {
+	userID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: userID}, {Key: "tenant", Value: tenant}, {Key: "deleted_at", Value: nil}}
+	result, err := srv.collection.UpdateOne(timeout, filter, bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	return srv.FindByID(tenant, id)
+}
+
+// checkChange проверяет, что сессия by может менять учетную запись id. Суперадминистратора меняет только суперадминистратор
+func (srv *UserService) checkChange(tenant db.Tenant, id string, by *Session) (*user.User, error) {
+	usr, err := srv.FindByID(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if usr.Role == user.SuperAdmin && (by == nil || by.Role != user.SuperAdmin) {
+		return nil, ErrSuperAdminTarget
+	}
+	return usr, nil
+}
+
+// checkRevoke проверяет, что сессия by может лишить учетную запись id прав: заблокировать, удалить
+// или понизить. Себя лишить прав нельзя, как и последнего действующего администратора площадки
+func (srv *UserService) checkRevoke(tenant db.Tenant, id string, by *Session) (*user.User, error) {
+	usr, err := srv.checkChange(tenant, id, by)
+	if err != nil {
+		return nil, err
+	}
+	if by != nil && usr.Username == by.Username {
+		return nil, ErrSelfChange
+	}
+	if !isAdministrator(usr.Role) || usr.Disabled {
+		return usr, nil
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$ne", Value: usr.ID}}},
+		{Key: "tenant", Value: tenant},
+		{Key: "role", Value: bson.D{{Key: "$in", Value: bson.A{user.Administrator, user.SuperAdmin}}}},
+		{Key: "disabled", Value: bson.D{{Key: "$ne", Value: true}}},
+		{Key: "deleted_at", Value: nil},
+	}
+	others, err := srv.collection.CountDocuments(timeout, filter)
+	if err != nil {
+		return nil, err
+	}
+	if others == 0 {
+		return nil, ErrLastAdministrator
+	}
+	return usr, nil
+}
+
+// UpdateRole меняет роль учетной записи. by - сессия, которая меняет роль: понизить себя или последнего администратора нельзя
+func (srv *UserService) UpdateRole(tenant db.Tenant, id string, role user.Role, by *Session) (*user.User, error) {
+	if role < user.Operator || role > user.SuperAdmin {
+		return nil, errors.New("unknown role " + role.String())
+	}
+	check := srv.checkChange
+	if !isAdministrator(role) {
+		check = srv.checkRevoke
+	}
+	if _, err := check(tenant, id, by); err != nil {
+		return nil, err
+	}
+	return srv.set(tenant, id, bson.D{{Key: "role", Value: role}})
+}
+
+// SetDisabled блокирует или разблокирует учетную запись. by - сессия, которая блокирует: себя или последнего администратора заблокировать нельзя
+func (srv *UserService) SetDisabled(tenant db.Tenant, id string, disabled bool, by *Session) (*user.User, error) {
+	check := srv.checkChange
+	if disabled {
+		check = srv.checkRevoke
+	}
+	if _, err := check(tenant, id, by); err != nil {
+		return nil, err
+	}
+	return srv.set(tenant, id, bson.D{{Key: "disabled", Value: disabled}})
+}
+
+// Delete помечает учетную запись удаленной. by - сессия, которая удаляет: себя или последнего администратора удалить нельзя
+func (srv *UserService) Delete(tenant db.Tenant, id string, by *Session) (*user.User, error) {
+	usr, err := srv.checkRevoke(tenant, id, by)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := srv.set(tenant, id, bson.D{{Key: "deleted_at", Value: time.Now()}}); err != nil && err != ErrUserNotFound {
+		return nil, err
+	}
+
+	return usr, nil
+}
+
+// Bootstrap создает первого суперадминистратора на площадке по умолчанию, если в базе еще нет ни одного.
+// Пустые username или password означают, что создавать администратора не нужно
+func (srv *UserService) Bootstrap(username, password string) error {
+	if len(username) == 0 || len(password) == 0 {
+		return nil
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "role", Value: user.SuperAdmin},
+		{Key: "deleted_at", Value: nil},
+	}
+	count, err := srv.collection.CountDocuments(timeout, filter)
+	if err != nil || count != 0 {
+		return err
+	}
+
+	log.Println("Creating initial super administrator ", username)
+	return srv.Create(user.User{
+		Username: username,
+		Password: password,
+		Role:     user.SuperAdmin,
+		Tenant:   db.DefaultTenant,
+	})
+}